@@ -18,6 +18,7 @@ import (
 	"fmt"
 	"os"
 	"path"
+	"strings"
 	"time"
 )
 
@@ -59,8 +60,65 @@ type Header struct { // tar文件的头部
 	AccessTime time.Time         // access time 访问时间
 	ChangeTime time.Time         // status change time 状态改变时间
 	Xattrs     map[string]string // 其他属性
+
+	// PAXRecords holds PAX records not represented by the other fields,
+	// such as "comment" or vendor-specific keys. Keys derived from the
+	// other fields, like "path", "mtime", or those of Xattrs and sparse
+	// files, cannot be set. Keys must be non-empty and must not contain
+	// '=', NUL, or newline, and values must not contain NUL.
+	PAXRecords map[string]string
+
+	// SparseHoles lists the holes of a sparse regular file, in increasing
+	// order of offset; Size is the length of the whole file, holes
+	// included. Only the data outside the holes is stored in the archive.
+	// Some readers, GNU tar among them, mishandle holes that do not end
+	// on a multiple of 512 bytes.
+	SparseHoles []SparseEntry
+
+	// Format is the format the header was read in, or the format to
+	// write it in.
+	Format Format
+}
+
+// A SparseEntry is a hole in a sparse file: Length bytes of zeros
+// starting at Offset.
+type SparseEntry struct {
+	Offset int64
+	Length int64
 }
 
+// Format is the format of a tar header.
+type Format int
+
+const (
+	// FormatUnknown lets the Writer pick the format: a USTAR header,
+	// extended with PAX records for the fields that do not fit, or with
+	// GNU base-256 numbers for large numeric fields. Xattrs, PAXRecords,
+	// and SparseHoles are written as with FormatPAX. AccessTime and
+	// ChangeTime are ignored.
+	FormatUnknown Format = iota
+
+	// FormatUSTAR is the POSIX.1-1988 format. Writing a header fails if
+	// a field does not fit: names are limited to 256 bytes, split at a
+	// slash, and other strings must be ASCII. Xattrs, PAXRecords, and
+	// SparseHoles cannot be stored. AccessTime and ChangeTime are
+	// ignored.
+	FormatUSTAR
+
+	// FormatPAX is the POSIX.1-2001 format, USTAR with PAX extended
+	// headers holding the fields that do not fit, times with sub-second
+	// precision, AccessTime, ChangeTime, Xattrs, and PAXRecords. Sparse
+	// files are stored in the GNU 1.0 sparse format.
+	FormatPAX
+
+	// FormatGNU is the format of GNU tar. Long names and link names are
+	// stored in entries of their own, large numbers in base-256, and
+	// sparse files in the old GNU sparse format. AccessTime and
+	// ChangeTime are stored with a precision of one second. Xattrs and
+	// PAXRecords cannot be stored.
+	FormatGNU
+)
+
 // File name constants from the tar spec.
 const (
 	fileNameSize       = 100 // Maximum number of bytes in a standard tar name.
@@ -193,8 +251,62 @@ const (
 	paxUname    = "uname"
 	paxXattr    = "SCHILY.xattr."
 	paxNone     = ""
+
+	paxGNUSparse = "GNU.sparse."
 )
 
+// reservedPAXKey reports whether PAX records with key k are derived from
+// the fields of a Header other than PAXRecords.
+func reservedPAXKey(k string) bool {
+	switch k {
+	case paxPath, paxLinkpath, paxSize, paxUid, paxGid, paxUname, paxGname,
+		paxMtime, paxAtime, paxCtime:
+		return true
+	}
+	return strings.HasPrefix(k, paxXattr) || strings.HasPrefix(k, paxGNUSparse)
+}
+
+// validPAXKey reports whether k can be written as the key of a PAX record
+// and read back unchanged. The key of a record ends at its first '='.
+func validPAXKey(k string) bool {
+	return k != "" && !strings.ContainsAny(k, "=\x00\n")
+}
+
+// sparseHoles returns the holes of a sparse file of the given size whose
+// data fragments are sp.
+func sparseHoles(sp []sparseEntry, size int64) []SparseEntry {
+	var holes []SparseEntry
+	var pos int64
+	for _, s := range sp {
+		if s.offset > pos {
+			holes = append(holes, SparseEntry{Offset: pos, Length: s.offset - pos})
+		}
+		pos = s.offset + s.numBytes
+	}
+	if size > pos {
+		holes = append(holes, SparseEntry{Offset: pos, Length: size - pos})
+	}
+	return holes
+}
+
+// sparseFragments returns the data fragments of a sparse file of the
+// given size with the given holes. If the file ends with a hole, the last
+// fragment is an empty one at its end, as written by GNU tar.
+func sparseFragments(holes []SparseEntry, size int64) ([]sparseEntry, error) {
+	var sp []sparseEntry
+	var pos int64
+	for _, h := range holes {
+		if h.Offset < pos || h.Length < 0 || h.Offset > size-h.Length {
+			return nil, errors.New("archive/tar: invalid sparse holes")
+		}
+		if h.Offset > pos {
+			sp = append(sp, sparseEntry{offset: pos, numBytes: h.Offset - pos})
+		}
+		pos = h.Offset + h.Length
+	}
+	return append(sp, sparseEntry{offset: pos, numBytes: size - pos}), nil
+}
+
 // FileInfoHeader creates a partially-populated Header from fi.
 // If fi describes a symlink, FileInfoHeader records link as the link target.
 // If fi describes a directory, a slash is appended to the name.
@@ -260,12 +372,19 @@ func FileInfoHeader(fi os.FileInfo, link string) (*Header, error) {
 		h.Gname = sys.Gname
 		h.AccessTime = sys.AccessTime
 		h.ChangeTime = sys.ChangeTime
+		h.Format = sys.Format
 		if sys.Xattrs != nil {
 			h.Xattrs = make(map[string]string)
 			for k, v := range sys.Xattrs {
 				h.Xattrs[k] = v
 			}
 		}
+		if sys.PAXRecords != nil {
+			h.PAXRecords = make(map[string]string)
+			for k, v := range sys.PAXRecords {
+				h.PAXRecords[k] = v
+			}
+		}
 		if sys.Typeflag == TypeLink {
 			// hard link
 			h.Typeflag = TypeLink
@@ -123,6 +123,7 @@ func (tr *Reader) Next() (*Header, error) {
 
 	var hdr *Header
 	var extHdrs map[string]string
+	var format Format // format of the meta data headers, if any
 
 	// Externally, Next iterates through the tar archive as if it is a series of
 	// files. Internally, the tar format often uses fake "files" to add meta
@@ -148,6 +149,7 @@ loop:
 			if tr.err != nil {
 				return nil, tr.err
 			}
+			format = FormatPAX
 			continue loop // This is a meta header affecting the next header
 		case TypeGNULongName, TypeGNULongLink:
 			var realname []byte
@@ -171,9 +173,26 @@ loop:
 				tr.err = p.err
 				return nil, tr.err
 			}
+			if format == FormatUnknown {
+				format = FormatGNU
+			}
 			continue loop // This is a meta header affecting the next header
 		default:
 			mergePAX(hdr, extHdrs)
+			if format != FormatUnknown {
+				hdr.Format = format
+			}
+			if format == FormatPAX {
+				for k, v := range extHdrs {
+					if reservedPAXKey(k) {
+						continue
+					}
+					if hdr.PAXRecords == nil {
+						hdr.PAXRecords = make(map[string]string)
+					}
+					hdr.PAXRecords[k] = v
+				}
+			}
 
 			// Check for a PAX format sparse file
 			sp, err := tr.checkForGNUSparsePAXHeaders(hdr, extHdrs)
@@ -188,6 +207,7 @@ loop:
 				if tr.err != nil {
 					return nil, tr.err
 				}
+				hdr.SparseHoles = sparseHoles(sp, hdr.Size)
 			}
 			break loop // This is a file, so stop
 		}
@@ -323,6 +343,14 @@ func mergePAX(hdr *Header, headers map[string]string) error {
 // parsePAXTime takes a string of the form %d.%d as described in
 // the PAX specification.
 func parsePAXTime(t string) (time.Time, error) {
+	// A negative time has a negative fraction too.
+	if strings.HasPrefix(t, "-") && strings.Contains(t, ".") {
+		ts, err := parsePAXTime(t[1:])
+		if err != nil {
+			return time.Time{}, err
+		}
+		return time.Unix(-ts.Unix(), -int64(ts.Nanosecond())), nil
+	}
 	buf := []byte(t)
 	pos := bytes.IndexByte(buf, '.')
 	var seconds, nanoseconds int64
@@ -617,6 +645,12 @@ func (tr *Reader) readHeader() *Header {
 	case magic == "ustar  \x00": // old GNU tar
 		format = "gnu"
 	}
+	switch format {
+	case "posix":
+		hdr.Format = FormatUSTAR
+	case "gnu":
+		hdr.Format = FormatGNU
+	}
 
 	switch format {
 	case "posix", "gnu", "star":
@@ -630,8 +664,32 @@ func (tr *Reader) readHeader() *Header {
 		}
 		var prefix string
 		switch format {
-		case "posix", "gnu":
+		case "posix":
 			prefix = p.parseString(s.next(155))
+		case "gnu":
+			// GNU tar stores the access and change times where
+			// USTAR has the prefix. Some writers store a prefix
+			// with the GNU magic regardless, so fall back to it
+			// if the fields are not numbers.
+			b := s.next(155)
+			var p2 parser
+			var atime, ctime int64
+			if b[0] != 0 {
+				atime = p2.parseNumeric(b[:12])
+			}
+			if b[12] != 0 {
+				ctime = p2.parseNumeric(b[12:24])
+			}
+			if p2.err != nil {
+				prefix = p.parseString(b)
+				break
+			}
+			if b[0] != 0 {
+				hdr.AccessTime = time.Unix(atime, 0)
+			}
+			if b[12] != 0 {
+				hdr.ChangeTime = time.Unix(ctime, 0)
+			}
 		case "star":
 			prefix = p.parseString(s.next(131))
 			hdr.AccessTime = time.Unix(p.parseNumeric(s.next(12)), 0)
@@ -680,6 +738,7 @@ func (tr *Reader) readHeader() *Header {
 		if tr.err != nil {
 			return nil
 		}
+		hdr.SparseHoles = sparseHoles(sp, hdr.Size)
 	}
 
 	return hdr
@@ -12,6 +12,7 @@ import (
 	"errors"
 	"fmt"
 	"io"
+	"os"
 	"path"
 	"sort"
 	"strconv"
@@ -24,12 +25,18 @@ var (
 	ErrFieldTooLong    = errors.New("archive/tar: header field too long")
 	ErrWriteAfterClose = errors.New("archive/tar: write after close")
 	errInvalidHeader   = errors.New("archive/tar: header field too long or contains invalid values")
+	errFormat          = errors.New("archive/tar: header cannot be represented in the requested format")
+	errSparseData      = errors.New("archive/tar: write of non-zero data in a sparse hole")
 )
 
 // A Writer provides sequential writing of a tar archive in POSIX.1 format.
 // A tar archive consists of a sequence of files.
 // Call WriteHeader to begin a new file, and then call Write to supply that file's data,
 // writing at most hdr.Size bytes in total.
+//
+// The data of a sparse file is written whole, hdr.Size bytes including
+// its holes, which must hold zeros; only the data outside the holes is
+// stored. ReadFrom can skip the holes instead.
 type Writer struct {
 	w          io.Writer
 	err        error
@@ -40,6 +47,12 @@ type Writer struct {
 	preferPax  bool            // use pax header instead of binary numeric header
 	hdrBuff    [blockSize]byte // buffer to use in writeHeader when writing a regular header
 	paxHdrBuff [blockSize]byte // buffer to use in writeHeader when writing a pax header
+
+	// State of the current file entry, if it is a sparse file
+	sparse bool
+	sp     []sparseEntry // data fragments left to write
+	pos    int64         // position in the file, holes included
+	size   int64         // size of the file, holes included
 }
 
 type formatter struct {
@@ -55,6 +68,12 @@ func (tw *Writer) Flush() error {
 		tw.err = fmt.Errorf("archive/tar: missed writing %d bytes", tw.nb)
 		return tw.err
 	}
+	if tw.sparse && tw.pos < tw.size {
+		tw.err = fmt.Errorf("archive/tar: missed writing %d bytes", tw.size-tw.pos)
+		return tw.err
+	}
+	tw.sparse = false
+	tw.sp = nil
 
 	n := tw.nb + tw.pad
 	for n > 0 && tw.err == nil {
@@ -152,13 +171,47 @@ func (tw *Writer) writeHeader(hdr *Header, allowPax bool) error {
 
 	// a map to hold pax header records, if any are needed
 	paxHeaders := make(map[string]string)
-
-	// TODO(shanemhansen): we might want to use PAX headers for
-	// subsecond time resolution, but for now let's just capture
-	// too long fields or non ascii characters
+	format := hdr.Format
+
+	// A sparse file is written as a regular file holding its data
+	// fragments, described by PAX records and a sparse map at the start
+	// of its data, or as a GNU sparse file with the map in its header.
+	var sp []sparseEntry
+	var sparseMap []byte
+	realSize := hdr.Size
+	if len(hdr.SparseHoles) > 0 {
+		if format == FormatUSTAR {
+			return errFormat
+		}
+		var err error
+		if sp, err = sparseFragments(hdr.SparseHoles, hdr.Size); err != nil {
+			return err
+		}
+		var dataSize int64
+		for _, s := range sp {
+			dataSize += s.numBytes
+		}
+		h := *hdr
+		if format == FormatGNU {
+			h.Typeflag = TypeGNUSparse
+			h.Size = dataSize
+		} else {
+			sparseMap = formatSparseMap(sp)
+			paxHeaders[paxGNUSparseMajor] = "1"
+			paxHeaders[paxGNUSparseMinor] = "0"
+			paxHeaders[paxGNUSparseName] = hdr.Name
+			paxHeaders[paxGNUSparseRealSize] = strconv.FormatInt(hdr.Size, 10)
+			dir, file := path.Split(hdr.Name)
+			h.Name = path.Join(dir, "GNUSparseFile.0", file)
+			h.Size = int64(len(sparseMap)) + dataSize
+		}
+		hdr = &h
+	}
 
 	var f formatter
 	var header []byte
+	var formatErr error           // set if a field cannot be represented in format
+	var longName, longLink string // GNU long name and link name, if needed
 
 	// We need to select which scratch buffer to use carefully,
 	// since this method is called recursively to write PAX headers.
@@ -176,11 +229,28 @@ func (tw *Writer) writeHeader(hdr *Header, allowPax bool) error {
 	// argument extends beyond the capacity of the input byte slice.
 	var formatString = func(b []byte, s string, paxKeyword string) {
 		needsPaxHeader := paxKeyword != paxNone && len(s) > len(b) || !isASCII(s)
-		if needsPaxHeader {
-			paxHeaders[paxKeyword] = s
+		if !needsPaxHeader {
+			f.formatString(b, s)
 			return
 		}
-		f.formatString(b, s)
+		if format == FormatGNU {
+			// GNU tar stores strings as they are, with long names and
+			// link names in entries of their own.
+			switch {
+			case paxKeyword == paxPath && len(s) > len(b):
+				longName = s
+				s = s[:len(b)]
+			case paxKeyword == paxLinkpath && len(s) > len(b):
+				longLink = s
+				s = s[:len(b)]
+			case len(s) > len(b):
+				formatErr = ErrFieldTooLong
+				return
+			}
+			copy(b, s)
+			return
+		}
+		paxHeaders[paxKeyword] = s
 	}
 	var formatNumeric = func(b []byte, x int64, paxKeyword string) {
 		// Try octal first.
@@ -191,7 +261,11 @@ func (tw *Writer) writeHeader(hdr *Header, allowPax bool) error {
 		}
 
 		// If it is too long for octal, and PAX is preferred, use a PAX header.
-		if paxKeyword != paxNone && tw.preferPax {
+		switch {
+		case format == FormatUSTAR:
+			formatErr = ErrFieldTooLong
+			return
+		case paxKeyword != paxNone && (tw.preferPax && format == FormatUnknown || format == FormatPAX):
 			f.formatOctal(b, 0)
 			s := strconv.FormatInt(x, 10)
 			paxHeaders[paxKeyword] = s
@@ -209,15 +283,35 @@ func (tw *Writer) writeHeader(hdr *Header, allowPax bool) error {
 
 	// Handle out of range ModTime carefully.
 	var modTime int64
-	if !hdr.ModTime.Before(minTime) && !hdr.ModTime.After(maxTime) {
+	inRange := !hdr.ModTime.Before(minTime) && !hdr.ModTime.After(maxTime)
+	if inRange {
+		modTime = hdr.ModTime.Unix()
+	}
+	switch {
+	case hdr.ModTime.IsZero():
+	case format == FormatPAX:
+		if !inRange || hdr.ModTime.Nanosecond() != 0 {
+			paxHeaders[paxMtime] = formatPAXTime(hdr.ModTime)
+		}
+	case format == FormatGNU:
 		modTime = hdr.ModTime.Unix()
+	case format == FormatUSTAR && !inRange:
+		formatErr = ErrFieldTooLong
+	}
+	if format == FormatPAX {
+		if !hdr.AccessTime.IsZero() {
+			paxHeaders[paxAtime] = formatPAXTime(hdr.AccessTime)
+		}
+		if !hdr.ChangeTime.IsZero() {
+			paxHeaders[paxCtime] = formatPAXTime(hdr.ChangeTime)
+		}
 	}
 
 	f.formatOctal(s.next(8), hdr.Mode)               // 100:108
 	formatNumeric(s.next(8), int64(hdr.Uid), paxUid) // 108:116
 	formatNumeric(s.next(8), int64(hdr.Gid), paxGid) // 116:124
 	formatNumeric(s.next(12), hdr.Size, paxSize)     // 124:136
-	formatNumeric(s.next(12), modTime, paxNone)      // 136:148 --- consider using pax for finer granularity
+	formatNumeric(s.next(12), modTime, paxNone)      // 136:148
 	s.next(8)                                        // chksum (148:156)
 	s.next(1)[0] = hdr.Typeflag                      // 156:157
 
@@ -234,13 +328,13 @@ func (tw *Writer) writeHeader(hdr *Header, allowPax bool) error {
 	formatString(prefixHeaderBytes, "", paxNone) // 345:500  prefix
 
 	// Use the GNU magic instead of POSIX magic if we used any GNU extensions.
-	if tw.usedBinary {
+	if format == FormatGNU || format == FormatUnknown && tw.usedBinary {
 		copy(header[257:265], []byte("ustar  \x00"))
 	}
 
 	_, paxPathUsed := paxHeaders[paxPath]
 	// try to use a ustar header when only the name is too long
-	if !tw.preferPax && len(paxHeaders) == 1 && paxPathUsed {
+	if !tw.preferPax && format != FormatPAX && len(paxHeaders) == 1 && paxPathUsed {
 		prefix, suffix, ok := splitUSTARPath(hdr.Name)
 		if ok {
 			// Since we can encode in USTAR format, disable PAX header.
@@ -252,6 +346,40 @@ func (tw *Writer) writeHeader(hdr *Header, allowPax bool) error {
 		}
 	}
 
+	// GNU headers hold the access and change times in place of the
+	// USTAR prefix, which GNU tar does not use.
+	if format == FormatGNU {
+		if !hdr.AccessTime.IsZero() {
+			formatNumeric(header[345:357], hdr.AccessTime.Unix(), paxNone)
+		}
+		if !hdr.ChangeTime.IsZero() {
+			formatNumeric(header[357:369], hdr.ChangeTime.Unix(), paxNone)
+		}
+	}
+
+	// The old GNU sparse header holds the first entries of the sparse map
+	// and the real size, and extension blocks the other entries.
+	var sparseExt [][]byte
+	if format == FormatGNU && sp != nil {
+		formatNumeric(header[483:495], realSize, paxNone)
+		s := slicer(header[oldGNUSparseMainHeaderOffset:oldGNUSparseMainHeaderIsExtendedOffset])
+		entries := oldGNUSparseMainHeaderNumEntries
+		isExtended := header[oldGNUSparseMainHeaderIsExtendedOffset:]
+		for _, e := range sp {
+			if entries == 0 {
+				isExtended[0] = 1
+				blk := make([]byte, blockSize)
+				sparseExt = append(sparseExt, blk)
+				s = slicer(blk[:oldGNUSparseExtendedHeaderIsExtendedOffset])
+				entries = oldGNUSparseExtendedHeaderNumEntries
+				isExtended = blk[oldGNUSparseExtendedHeaderIsExtendedOffset:]
+			}
+			formatNumeric(s.next(oldGNUSparseOffsetSize), e.offset, paxNone)
+			formatNumeric(s.next(oldGNUSparseNumBytesSize), e.numBytes, paxNone)
+			entries--
+		}
+	}
+
 	// The chksum field is terminated by a NUL and a space.
 	// This is different from the other octal fields.
 	chksum, _ := checksum(header)
@@ -263,26 +391,67 @@ func (tw *Writer) writeHeader(hdr *Header, allowPax bool) error {
 		tw.err = f.err
 		return tw.err
 	}
+	if formatErr != nil {
+		return formatErr
+	}
 
 	if allowPax {
 		for k, v := range hdr.Xattrs {
+			if !validPAXKey(k) {
+				return errInvalidHeader
+			}
 			paxHeaders[paxXattr+k] = v
 		}
+		for k, v := range hdr.PAXRecords {
+			if reservedPAXKey(k) || !validPAXKey(k) || strings.Contains(v, "\x00") {
+				return errInvalidHeader
+			}
+			paxHeaders[k] = v
+		}
 	}
 
 	if len(paxHeaders) > 0 {
 		if !allowPax {
 			return errInvalidHeader
 		}
+		if format == FormatUSTAR || format == FormatGNU {
+			return errFormat
+		}
 		if err := tw.writePAXHeader(hdr, paxHeaders); err != nil {
 			return err
 		}
 	}
+	if longName != "" {
+		if err := tw.writeGNULongHeader(TypeGNULongName, longName); err != nil {
+			return err
+		}
+	}
+	if longLink != "" {
+		if err := tw.writeGNULongHeader(TypeGNULongLink, longLink); err != nil {
+			return err
+		}
+	}
 	tw.nb = int64(hdr.Size)
 	tw.pad = (blockSize - (tw.nb % blockSize)) % blockSize
 
-	_, tw.err = tw.w.Write(header)
-	return tw.err
+	if _, tw.err = tw.w.Write(header); tw.err != nil {
+		return tw.err
+	}
+	for _, blk := range sparseExt {
+		if _, tw.err = tw.w.Write(blk); tw.err != nil {
+			return tw.err
+		}
+	}
+	if sp != nil {
+		if _, err := tw.Write(sparseMap); err != nil {
+			return err
+		}
+		tw.sparse = true
+		tw.sp = sp
+		tw.pos = 0
+		tw.size = realSize
+	}
+	return nil
 }
 
 // splitUSTARPath splits a path according to USTAR prefix and suffix rules.
@@ -354,6 +523,57 @@ func (tw *Writer) writePAXHeader(hdr *Header, paxHeaders map[string]string) erro
 	return nil
 }
 
+// writeGNULongHeader writes an entry of type flag holding the long name
+// or link name of the next file, as GNU tar does.
+func (tw *Writer) writeGNULongHeader(flag byte, name string) error {
+	ext := &Header{
+		Name:     "././@LongLink",
+		Typeflag: flag,
+		Size:     int64(len(name)) + 1, // NUL-terminated
+		Format:   FormatGNU,
+	}
+	if err := tw.writeHeader(ext, false); err != nil {
+		return err
+	}
+	if _, err := io.WriteString(tw, name+"\x00"); err != nil {
+		return err
+	}
+	return tw.Flush()
+}
+
+// formatPAXTime formats t as a PAX time, %d.%d, with as many fractional
+// digits as needed.
+func formatPAXTime(t time.Time) string {
+	sec, nsec := t.Unix(), t.Nanosecond()
+	sign := ""
+	if sec < 0 && nsec > 0 {
+		sign = "-"
+		sec = -(sec + 1)
+		nsec = 1e9 - nsec
+	}
+	if nsec == 0 {
+		return strconv.FormatInt(sec, 10)
+	}
+	frac := strings.TrimRight(fmt.Sprintf("%09d", nsec), "0")
+	return fmt.Sprintf("%s%d.%s", sign, sec, frac)
+}
+
+// formatSparseMap formats the sparse map sp as stored at the start of the
+// data of a file in GNU's PAX sparse format version 1.0: the number of
+// entries, then their offsets and lengths, each followed by a newline,
+// padded with NULs to a multiple of the block size.
+func formatSparseMap(sp []sparseEntry) []byte {
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "%d\n", len(sp))
+	for _, s := range sp {
+		fmt.Fprintf(&buf, "%d\n%d\n", s.offset, s.numBytes)
+	}
+	if n := buf.Len() % blockSize; n > 0 {
+		buf.Write(zeroBlock[n:])
+	}
+	return buf.Bytes()
+}
+
 // formatPAXRecord formats a single PAX record, prefixing it with the
 // appropriate length.
 func formatPAXRecord(k, v string) string {
@@ -378,6 +598,9 @@ func (tw *Writer) Write(b []byte) (n int, err error) { // 写入数据
 		err = ErrWriteAfterClose
 		return
 	}
+	if tw.sparse {
+		return tw.writeSparse(b)
+	}
 	overwrite := false
 	if int64(len(b)) > tw.nb {
 		b = b[0:tw.nb]
@@ -393,6 +616,99 @@ func (tw *Writer) Write(b []byte) (n int, err error) { // 写入数据
 	return
 }
 
+// writeSparse writes b to the current entry, a sparse file, storing the
+// parts of b in data fragments and checking that the others are zeros.
+func (tw *Writer) writeSparse(b []byte) (n int, err error) {
+	overwrite := false
+	if int64(len(b)) > tw.size-tw.pos {
+		b = b[:tw.size-tw.pos]
+		overwrite = true
+	}
+	for len(b) > 0 {
+		for len(tw.sp) > 0 && tw.pos >= tw.sp[0].offset+tw.sp[0].numBytes {
+			tw.sp = tw.sp[1:]
+		}
+		end := tw.size
+		if len(tw.sp) > 0 {
+			end = tw.sp[0].offset
+		}
+		if tw.pos < end {
+			// In a hole.
+			k := int(min64(int64(len(b)), end-tw.pos))
+			for _, c := range b[:k] {
+				if c != 0 {
+					return n, errSparseData
+				}
+			}
+			tw.pos += int64(k)
+			n += k
+			b = b[k:]
+			continue
+		}
+		k := min64(int64(len(b)), tw.sp[0].offset+tw.sp[0].numBytes-tw.pos)
+		var nw int
+		nw, err = tw.w.Write(b[:k])
+		tw.nb -= int64(nw)
+		tw.pos += int64(nw)
+		n += nw
+		if err != nil {
+			tw.err = err
+			return
+		}
+		b = b[k:]
+	}
+	if overwrite {
+		err = ErrWriteTooLong
+	}
+	return
+}
+
+func min64(a, b int64) int64 {
+	if a < b {
+		return a
+	}
+	return b
+}
+
+// ReadFrom writes the data read from r to the current entry in the tar
+// archive, until EOF or the end of the entry, and returns the number of
+// bytes read. If the current entry is a sparse file and r is an
+// io.Seeker, the holes are skipped by seeking r past them, without
+// reading or checking their data.
+func (tw *Writer) ReadFrom(r io.Reader) (n int64, err error) {
+	rs, ok := r.(io.Seeker)
+	if !tw.sparse || !ok {
+		return io.Copy(struct{ io.Writer }{tw}, r)
+	}
+	for tw.pos < tw.size {
+		for len(tw.sp) > 0 && tw.pos >= tw.sp[0].offset+tw.sp[0].numBytes {
+			tw.sp = tw.sp[1:]
+		}
+		end := tw.size
+		if len(tw.sp) > 0 {
+			end = tw.sp[0].offset
+		}
+		if tw.pos < end {
+			if _, err = rs.Seek(end-tw.pos, os.SEEK_CUR); err != nil {
+				return
+			}
+			n += end - tw.pos
+			tw.pos = end
+			continue
+		}
+		var nr int64
+		nr, err = io.CopyN(struct{ io.Writer }{tw}, r, tw.sp[0].offset+tw.sp[0].numBytes-tw.pos)
+		n += nr
+		if err == io.EOF {
+			return n, nil
+		}
+		if err != nil {
+			return
+		}
+	}
+	return
+}
+
 // Close closes the tar archive, flushing any unwritten
 // data to the underlying writer.
 func (tw *Writer) Close() error { // 关闭Writer，tar写完后必须关闭，因为要写入两个zeroBlock
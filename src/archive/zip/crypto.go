@@ -0,0 +1,326 @@
+// Copyright 2016 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package zip
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/pbkdf2"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/subtle"
+	"hash"
+	"hash/crc32"
+	"io"
+	"io/ioutil"
+)
+
+// A PasswordFunc returns the password of the encrypted file described
+// by fh.
+type PasswordFunc func(fh *FileHeader) (string, error)
+
+// Encryption of files follows the WinZip AES specification
+// (http://www.winzip.com/aes_info.htm): the compressed data is preceded
+// by a salt and a password verifier and followed by an authentication
+// code, and the real compression method is recorded in an extra field.
+// The legacy PKWARE encryption, ZipCrypto, can only be read.
+const (
+	aesMethod      = 99 // the method recorded for files encrypted with AES
+	aesVerifierLen = 2
+	aesMACLen      = 10
+	aesIterations  = 1000
+	aesExtraLen    = 7
+	aesVersion1    = 1 // AE-1: the CRC-32 is recorded
+	aesVersion2    = 2 // AE-2: the CRC-32 is zero
+	aesStrength256 = 3
+
+	zipCryptoHeaderLen = 12
+)
+
+// aesKeys derives the encryption key, the authentication key and the
+// password verifier of a file from its password and salt. The salt is
+// half as long as the encryption key.
+func aesKeys(password string, salt []byte) (encKey, macKey, verifier []byte) {
+	n := 2 * len(salt)
+	k := pbkdf2.Key([]byte(password), salt, aesIterations, 2*n+aesVerifierLen, sha1.New)
+	return k[:n], k[n : 2*n], k[2*n:]
+}
+
+// aesExtra holds the contents of the AES extra field of a file.
+type aesExtra struct {
+	version  uint16
+	strength byte
+	method   uint16
+}
+
+// append appends the AES extra field holding x to extra.
+func (x *aesExtra) append(extra []byte) []byte {
+	var buf [4 + aesExtraLen]byte
+	b := writeBuf(buf[:])
+	b.uint16(aesExtraId)
+	b.uint16(aesExtraLen)
+	b.uint16(x.version)
+	copy(b, "AE")
+	b = b[2:]
+	b[0] = x.strength
+	b = b[1:]
+	b.uint16(x.method)
+	return append(extra, buf[:]...)
+}
+
+// findAESExtra returns the AES extra field found in extra.
+func findAESExtra(extra []byte) (*aesExtra, error) {
+	b := readBuf(extra)
+	for len(b) >= 4 {
+		tag := b.uint16()
+		size := int(b.uint16())
+		if size > len(b) {
+			break
+		}
+		if tag != aesExtraId {
+			b = b[size:]
+			continue
+		}
+		if size < aesExtraLen {
+			return nil, ErrFormat
+		}
+		eb := readBuf(b[:size])
+		x := new(aesExtra)
+		x.version = eb.uint16()
+		eb = eb[2:] // vendor ID, "AE"
+		x.strength = eb[0]
+		eb = eb[1:]
+		x.method = eb.uint16()
+		if x.strength < 1 || x.strength > 3 {
+			return nil, ErrAlgorithm
+		}
+		return x, nil
+	}
+	return nil, ErrFormat
+}
+
+// ctrStream is the counter mode of WinZip AES, whose counter is a
+// little-endian integer starting at 1.
+type ctrStream struct {
+	b   cipher.Block
+	ctr [aes.BlockSize]byte
+	ks  [aes.BlockSize]byte
+	pos int
+}
+
+func newCTRStream(b cipher.Block) *ctrStream {
+	return &ctrStream{b: b, pos: aes.BlockSize}
+}
+
+func (s *ctrStream) XORKeyStream(dst, src []byte) {
+	for i := range src {
+		if s.pos == aes.BlockSize {
+			for j := range s.ctr {
+				s.ctr[j]++
+				if s.ctr[j] != 0 {
+					break
+				}
+			}
+			s.b.Encrypt(s.ks[:], s.ctr[:])
+			s.pos = 0
+		}
+		dst[i] = src[i] ^ s.ks[s.pos]
+		s.pos++
+	}
+}
+
+// aesReader decrypts the data of a file encrypted with AES and verifies
+// its authentication code at the end.
+type aesReader struct {
+	x    *aesExtra
+	r    io.Reader // the encrypted data
+	code io.Reader // the authentication code
+	mac  hash.Hash
+	ctr  *ctrStream
+	err  error
+}
+
+// newAESReader returns a reader decrypting the size bytes of data of a
+// file read from r, including the salt, the verifier and the
+// authentication code.
+func newAESReader(r io.ReaderAt, size int64, x *aesExtra, password string) (*aesReader, error) {
+	saltLen := 4 + 4*int64(x.strength)
+	dataLen := size - saltLen - aesVerifierLen - aesMACLen
+	if dataLen < 0 {
+		return nil, ErrFormat
+	}
+	buf := make([]byte, saltLen+aesVerifierLen)
+	if _, err := r.ReadAt(buf, 0); err != nil {
+		return nil, err
+	}
+	encKey, macKey, verifier := aesKeys(password, buf[:saltLen])
+	if subtle.ConstantTimeCompare(verifier, buf[saltLen:]) != 1 {
+		return nil, ErrPassword
+	}
+	block, err := aes.NewCipher(encKey)
+	if err != nil {
+		return nil, err
+	}
+	start := saltLen + aesVerifierLen
+	return &aesReader{
+		x:    x,
+		r:    io.NewSectionReader(r, start, dataLen),
+		code: io.NewSectionReader(r, start+dataLen, aesMACLen),
+		mac:  hmac.New(sha1.New, macKey),
+		ctr:  newCTRStream(block),
+	}, nil
+}
+
+func (r *aesReader) Read(p []byte) (n int, err error) {
+	if r.err != nil {
+		return 0, r.err
+	}
+	n, err = r.r.Read(p)
+	r.mac.Write(p[:n])
+	r.ctr.XORKeyStream(p[:n], p[:n])
+	if err == io.EOF {
+		var code [aesMACLen]byte
+		if _, err1 := io.ReadFull(r.code, code[:]); err1 != nil {
+			err = err1
+			if err == io.EOF {
+				err = io.ErrUnexpectedEOF
+			}
+		} else if !hmac.Equal(code[:], r.mac.Sum(nil)[:aesMACLen]) {
+			err = ErrChecksum
+		}
+	}
+	r.err = err
+	return n, err
+}
+
+// verify reads any data left, which a decompressor may not need, and
+// reports whether the authentication code matches.
+func (r *aesReader) verify() error {
+	_, err := io.Copy(ioutil.Discard, r)
+	return err
+}
+
+// aesWriter encrypts the data of a file with AES, writing the salt and
+// the verifier first and the authentication code when closed.
+type aesWriter struct {
+	w   io.Writer
+	mac hash.Hash
+	ctr *ctrStream
+	buf []byte
+}
+
+func newAESWriter(w io.Writer, x *aesExtra, password string) (*aesWriter, error) {
+	salt := make([]byte, 4+4*int(x.strength))
+	if _, err := io.ReadFull(rand.Reader, salt); err != nil {
+		return nil, err
+	}
+	encKey, macKey, verifier := aesKeys(password, salt)
+	block, err := aes.NewCipher(encKey)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := w.Write(salt); err != nil {
+		return nil, err
+	}
+	if _, err := w.Write(verifier); err != nil {
+		return nil, err
+	}
+	return &aesWriter{
+		w:   w,
+		mac: hmac.New(sha1.New, macKey),
+		ctr: newCTRStream(block),
+	}, nil
+}
+
+func (w *aesWriter) Write(p []byte) (int, error) {
+	if cap(w.buf) < len(p) {
+		w.buf = make([]byte, len(p))
+	}
+	buf := w.buf[:len(p)]
+	w.ctr.XORKeyStream(buf, p)
+	w.mac.Write(buf)
+	return w.w.Write(buf)
+}
+
+func (w *aesWriter) Close() error {
+	_, err := w.w.Write(w.mac.Sum(nil)[:aesMACLen])
+	return err
+}
+
+// decrypt returns a reader of the decrypted data of f, read from r, the
+// AES reader if f is encrypted with AES, and the method f is compressed
+// with.
+func (f *File) decrypt(r *io.SectionReader) (io.Reader, *aesReader, uint16, error) {
+	if f.zip.password == nil {
+		return nil, nil, 0, ErrPassword
+	}
+	password, err := f.zip.password(&f.FileHeader)
+	if err != nil {
+		return nil, nil, 0, err
+	}
+	if f.Method != aesMethod {
+		zr, err := newZipCryptoReader(r, f, password)
+		return zr, nil, f.Method, err
+	}
+	x, err := findAESExtra(f.Extra)
+	if err != nil {
+		return nil, nil, 0, err
+	}
+	ar, err := newAESReader(r, r.Size(), x, password)
+	if err != nil {
+		return nil, nil, 0, err
+	}
+	return ar, ar, x.method, nil
+}
+
+// zipCryptoReader decrypts data encrypted with ZipCrypto.
+type zipCryptoReader struct {
+	r                io.Reader
+	key0, key1, key2 uint32
+}
+
+// newZipCryptoReader returns a reader decrypting the data of f read from
+// r, checking the password against the encryption header.
+func newZipCryptoReader(r io.Reader, f *File, password string) (*zipCryptoReader, error) {
+	z := &zipCryptoReader{r: r, key0: 0x12345678, key1: 0x23456789, key2: 0x34567890}
+	for i := 0; i < len(password); i++ {
+		z.update(password[i])
+	}
+	var header [zipCryptoHeaderLen]byte
+	if _, err := io.ReadFull(z, header[:]); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return nil, err
+	}
+	// The last byte of the header is the high byte of the CRC-32, or of
+	// the modification time if the CRC-32 follows the data.
+	check := byte(f.CRC32 >> 24)
+	if f.hasDataDescriptor() {
+		check = byte(f.ModifiedTime >> 8)
+	}
+	if header[zipCryptoHeaderLen-1] != check {
+		return nil, ErrPassword
+	}
+	return z, nil
+}
+
+func (z *zipCryptoReader) update(c byte) {
+	z.key0 = crc32.IEEETable[byte(z.key0)^c] ^ z.key0>>8
+	z.key1 = (z.key1+z.key0&0xff)*134775813 + 1
+	z.key2 = crc32.IEEETable[byte(z.key2)^byte(z.key1>>24)] ^ z.key2>>8
+}
+
+func (z *zipCryptoReader) Read(p []byte) (int, error) {
+	n, err := z.r.Read(p)
+	for i, c := range p[:n] {
+		t := z.key2 | 2
+		c ^= byte(t * (t ^ 1) >> 8)
+		z.update(c)
+		p[i] = c
+	}
+	return n, err
+}
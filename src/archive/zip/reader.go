@@ -19,6 +19,7 @@ var (
 	ErrFormat    = errors.New("zip: not a valid zip file")
 	ErrAlgorithm = errors.New("zip: unsupported compression algorithm")
 	ErrChecksum  = errors.New("zip: checksum error")
+	ErrPassword  = errors.New("zip: missing or invalid password")
 )
 
 type Reader struct {
@@ -26,6 +27,8 @@ type Reader struct {
 	File          []*File
 	Comment       string
 	decompressors map[uint16]Decompressor
+	password      PasswordFunc
+	dirOffset     int64 // offset of the central directory
 }
 
 type ReadCloser struct {
@@ -45,6 +48,10 @@ func (f *File) hasDataDescriptor() bool {
 	return f.Flags&0x8 != 0
 }
 
+func (f *File) isEncrypted() bool {
+	return f.Flags&0x1 != 0
+}
+
 // OpenReader will open the Zip file specified by name and return a ReadCloser.
 func OpenReader(name string) (*ReadCloser, error) { // 打开一个zip文件
 	f, err := os.Open(name)
@@ -86,6 +93,7 @@ func (z *Reader) init(r io.ReaderAt, size int64) error {
 	z.r = r
 	z.File = make([]*File, 0, end.directoryRecords)
 	z.Comment = end.comment
+	z.dirOffset = int64(end.directoryOffset)
 	rs := io.NewSectionReader(r, 0, size)
 	if _, err = rs.Seek(int64(end.directoryOffset), os.SEEK_SET); err != nil {
 		return err
@@ -133,6 +141,14 @@ func (z *Reader) decompressor(method uint16) Decompressor {
 	return dcomp
 }
 
+// SetPasswordFunc sets the function called by Open to obtain the
+// password of an encrypted file. Files encrypted with AES and with the
+// legacy ZipCrypto method can be read; without a password, or with a
+// wrong one, Open returns ErrPassword.
+func (z *Reader) SetPasswordFunc(fn PasswordFunc) {
+	z.password = fn
+}
+
 // Close closes the Zip file, rendering it unusable for I/O.
 func (rc *ReadCloser) Close() error {
 	return rc.f.Close()
@@ -160,12 +176,20 @@ func (f *File) Open() (rc io.ReadCloser, err error) {
 	}
 	size := int64(f.CompressedSize64)
 	r := io.NewSectionReader(f.zipr, f.headerOffset+bodyOffset, size)
-	dcomp := f.zip.decompressor(f.Method)
+	var body io.Reader = r
+	var aesr *aesReader
+	method := f.Method
+	if f.isEncrypted() {
+		if body, aesr, method, err = f.decrypt(r); err != nil {
+			return
+		}
+	}
+	dcomp := f.zip.decompressor(method)
 	if dcomp == nil {
 		err = ErrAlgorithm
 		return
 	}
-	rc = dcomp(r)
+	rc = dcomp(body)
 	var desr io.Reader
 	if f.hasDataDescriptor() {
 		desr = io.NewSectionReader(f.zipr, f.headerOffset+bodyOffset+size, dataDescriptorLen)
@@ -175,6 +199,7 @@ func (f *File) Open() (rc io.ReadCloser, err error) {
 		hash: crc32.NewIEEE(),
 		f:    f,
 		desr: desr,
+		aes:  aesr,
 	}
 	return
 }
@@ -184,8 +209,9 @@ type checksumReader struct {
 	hash  hash.Hash32
 	nread uint64 // number of bytes read so far
 	f     *File
-	desr  io.Reader // if non-nil, where to read the data descriptor
-	err   error     // sticky error
+	desr  io.Reader  // if non-nil, where to read the data descriptor
+	aes   *aesReader // if non-nil, the decryption to verify
+	err   error      // sticky error
 }
 
 func (r *checksumReader) Read(b []byte) (n int, err error) {
@@ -202,6 +228,12 @@ func (r *checksumReader) Read(b []byte) (n int, err error) {
 		if r.nread != r.f.UncompressedSize64 {
 			return 0, io.ErrUnexpectedEOF
 		}
+		if r.aes != nil {
+			if err1 := r.aes.verify(); err1 != nil {
+				r.err = err1
+				return n, r.err
+			}
+		}
 		if r.desr != nil {
 			if err1 := readDataDescriptor(r.desr, r.f); err1 != nil {
 				if err1 == io.EOF {
@@ -209,14 +241,14 @@ func (r *checksumReader) Read(b []byte) (n int, err error) {
 				} else {
 					err = err1
 				}
-			} else if r.hash.Sum32() != r.f.CRC32 {
+			} else if r.hasCRC() && r.hash.Sum32() != r.f.CRC32 {
 				err = ErrChecksum
 			}
 		} else {
 			// If there's not a data descriptor, we still compare
 			// the CRC32 of what we've read against the file header
 			// or TOC's CRC32, if it seems like it was set.
-			if r.f.CRC32 != 0 && r.hash.Sum32() != r.f.CRC32 {
+			if r.hasCRC() && r.f.CRC32 != 0 && r.hash.Sum32() != r.f.CRC32 {
 				err = ErrChecksum
 			}
 		}
@@ -225,6 +257,12 @@ func (r *checksumReader) Read(b []byte) (n int, err error) {
 	return
 }
 
+// hasCRC reports whether the CRC-32 of the file is recorded. AE-2 leaves
+// it out, relying on the authentication code of the encrypted data.
+func (r *checksumReader) hasCRC() bool {
+	return r.aes == nil || r.aes.x.version != aesVersion2
+}
+
 func (r *checksumReader) Close() error { return r.rc.Close() }
 
 // findBodyOffset does the minimum work to verify the file has a header
@@ -56,6 +56,7 @@ const (
 	// version numbers
 	zipVersion20 = 20 // 2.0
 	zipVersion45 = 45 // 4.5 (reads and writes zip64 archives)
+	zipVersion51 = 51 // 5.1 (reads and writes AES encrypted files)
 
 	// limits for non zip64 files
 	uint16max = (1 << 16) - 1
@@ -63,6 +64,7 @@ const (
 
 	// extra header id's
 	zip64ExtraId = 0x0001 // zip64 Extended Information Extra Field
+	aesExtraId   = 0x9901 // WinZip AES Extra Field
 )
 
 // FileHeader describes a file within a zip file.
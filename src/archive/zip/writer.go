@@ -6,15 +6,15 @@ package zip
 
 import (
 	"bufio"
+	"bytes"
 	"encoding/binary"
 	"errors"
 	"hash"
 	"hash/crc32"
 	"io"
+	"os"
 )
 
-// TODO(adg): support zip file comments
-
 // Writer implements a zip file writer.
 type Writer struct {
 	cw          *countWriter // 内部封装的countWriter
@@ -22,6 +22,11 @@ type Writer struct {
 	last        *fileWriter
 	closed      bool
 	compressors map[uint16]Compressor
+	password    PasswordFunc
+	comment     string
+	f           *os.File // if non-nil, the file modified in place
+	concurrency int
+	pending     []*fileWriter // files being compressed in the background, in order
 }
 
 type header struct {
@@ -34,6 +39,64 @@ func NewWriter(w io.Writer) *Writer { // 创建一个新Writer用作写文件
 	return &Writer{cw: &countWriter{w: bufio.NewWriter(w)}}
 }
 
+// NewAppendWriter returns a Writer that modifies in place the zip file
+// held in f, which must be open for reading and writing. The files
+// already in the archive are kept: their data is left where it is, and
+// Close writes their headers, including extra fields, to the new central
+// directory. Files added with Create, CreateHeader, or Copy are written
+// over the old central directory, so f is not a valid zip file again
+// until Close returns. Close truncates f to the end of the new archive.
+func NewAppendWriter(f *os.File) (*Writer, error) {
+	fi, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+	r, err := NewReader(f, fi.Size())
+	if err != nil {
+		return nil, err
+	}
+	if _, err := f.Seek(r.dirOffset, os.SEEK_SET); err != nil {
+		return nil, err
+	}
+	w := NewWriter(f)
+	w.cw.count = r.dirOffset
+	w.f = f
+	w.comment = r.Comment
+	for _, zf := range r.File {
+		fh := zf.FileHeader
+		// Close adds a zip64 extra field to the files that need one.
+		fh.Extra = stripExtra(fh.Extra, zip64ExtraId)
+		w.dir = append(w.dir, &header{FileHeader: &fh, offset: uint64(zf.headerOffset)})
+	}
+	return w, nil
+}
+
+// Remove removes the files with the given name from the archive and
+// reports whether there were any. The data of the files removed from an
+// archive modified in place is left in the zip file, unreferenced; to
+// reclaim the space, copy the files to keep into a new archive with Copy.
+// A file can be replaced by removing it and adding its new version.
+func (w *Writer) Remove(name string) bool {
+	dir := w.dir[:0]
+	for _, h := range w.dir {
+		if h.Name != name {
+			dir = append(dir, h)
+		}
+	}
+	removed := len(dir) < len(w.dir)
+	w.dir = dir
+	return removed
+}
+
+// SetComment sets the comment of the archive, which is written by Close.
+func (w *Writer) SetComment(comment string) error {
+	if len(comment) > uint16max {
+		return errors.New("zip: comment too long")
+	}
+	w.comment = comment
+	return nil
+}
+
 // SetOffset sets the offset of the beginning of the zip data within the
 // underlying writer. It should be used when the zip data is appended to an
 // existing file, such as a binary executable.
@@ -45,9 +108,34 @@ func (w *Writer) SetOffset(n int64) {
 	w.cw.count = n
 }
 
-// Flush flushes any buffered data to the underlying writer.
+// SetConcurrency sets the number of files that may be compressed at the
+// same time, which defaults to 1. With a concurrency n greater than 1,
+// the contents of a file added with Create or CreateHeader are buffered
+// in memory and compressed in the background once the next file is added
+// or the Writer is closed. The files are still written in the order they
+// were added, each as soon as it and the files before it are compressed,
+// so up to n files are held in memory.
+func (w *Writer) SetConcurrency(n int) error {
+	if n < 1 {
+		return errors.New("zip: invalid concurrency")
+	}
+	w.concurrency = n
+	return nil
+}
+
+// SetPasswordFunc sets the function called by CreateHeader to obtain the
+// password of a file to encrypt.
+func (w *Writer) SetPasswordFunc(fn PasswordFunc) {
+	w.password = fn
+}
+
+// Flush flushes any buffered data to the underlying writer, waiting for
+// the files being compressed in the background.
 // Calling Flush is not normally necessary; calling Close is sufficient.
 func (w *Writer) Flush() error { // 刷新buffer中的内容
+	if err := w.writePending(len(w.pending)); err != nil {
+		return err
+	}
 	return w.cw.w.(*bufio.Writer).Flush()
 }
 
@@ -63,6 +151,9 @@ func (w *Writer) Close() error {
 	if w.closed {
 		return errors.New("zip: writer closed twice")
 	}
+	if err := w.writePending(len(w.pending)); err != nil {
+		return err
+	}
 	w.closed = true
 
 	// write central directory
@@ -169,12 +260,22 @@ func (w *Writer) Close() error {
 	b.uint16(uint16(records)) // number of entries total
 	b.uint32(uint32(size))    // size of directory
 	b.uint32(uint32(offset))  // start of directory
-	// skipped size of comment (always zero)
+	b.uint16(uint16(len(w.comment)))
 	if _, err := w.cw.Write(buf[:]); err != nil {
 		return err
 	}
+	if _, err := io.WriteString(w.cw, w.comment); err != nil {
+		return err
+	}
 
-	return w.cw.w.(*bufio.Writer).Flush()
+	if err := w.cw.w.(*bufio.Writer).Flush(); err != nil {
+		return err
+	}
+	if w.f != nil {
+		// Drop what is left of the old central directory.
+		return w.f.Truncate(w.cw.count)
+	}
+	return nil
 }
 
 // Create adds a file to the zip file using the provided name.
@@ -183,7 +284,7 @@ func (w *Writer) Close() error {
 // letter (e.g. C:) or leading slash, and only forward slashes are
 // allowed.
 // The file's contents must be written to the io.Writer before the next
-// call to Create, CreateHeader, or Close.
+// call to Create, CreateHeader, CreateRaw, Copy, or Close.
 func (w *Writer) Create(name string) (io.Writer, error) { // 返回一个Writer，用户可用这个Writer写入内容到zip文件中
 	header := &FileHeader{ // 创建一个FileHeader结构
 		Name:   name,
@@ -196,9 +297,14 @@ func (w *Writer) Create(name string) (io.Writer, error) { // 返回一个Writer
 // for the file metadata.
 // It returns a Writer to which the file contents should be written.
 //
+// If the encryption bit (0x1) of fh.Flags is set, the file is encrypted
+// with WinZip AES-256, using the password returned by the function set
+// with SetPasswordFunc. The Method of fh is then recorded in an extra
+// field and replaced with the AES method, 99.
+//
 // The file's contents must be written to the io.Writer before the next
-// call to Create, CreateHeader, or Close. The provided FileHeader fh
-// must not be modified after a call to CreateHeader.
+// call to Create, CreateHeader, CreateRaw, Copy, or Close. The provided
+// FileHeader fh must not be modified after a call to CreateHeader.
 func (w *Writer) CreateHeader(fh *FileHeader) (io.Writer, error) { // 创建一个文件头
 	if w.last != nil && !w.last.closed {
 		if err := w.last.close(); err != nil {
@@ -220,28 +326,161 @@ func (w *Writer) CreateHeader(fh *FileHeader) (io.Writer, error) { // 创建一
 		compCount: &countWriter{w: w.cw},
 		crc32:     crc32.NewIEEE(),
 	}
-	comp := w.compressor(fh.Method)
+	method := fh.Method
+	if fh.Flags&0x1 != 0 {
+		if w.password == nil {
+			return nil, ErrPassword
+		}
+		if fh.Method == aesMethod {
+			x, err := findAESExtra(fh.Extra)
+			if err != nil {
+				return nil, err
+			}
+			fw.aes = x
+		} else {
+			fw.aes = &aesExtra{version: aesVersion2, strength: aesStrength256, method: fh.Method}
+			fh.Extra = fw.aes.append(fh.Extra)
+			fh.Method = aesMethod
+		}
+		method = fw.aes.method
+		fh.ReaderVersion = zipVersion51
+	}
+	comp := w.compressor(method)
 	if comp == nil {
 		return nil, ErrAlgorithm
 	}
+	var password string
+	if fw.aes != nil {
+		var err error
+		if password, err = w.password(fh); err != nil {
+			return nil, err
+		}
+	}
+
+	h := &header{
+		FileHeader: fh,
+		offset:     uint64(w.cw.count),
+	}
+	w.dir = append(w.dir, h)
+	fw.header = h
+
+	if w.concurrency > 1 {
+		// The local file header is written along with the compressed
+		// data, once the files before it are written.
+		fw.zw = w
+		fw.compCount = &countWriter{w: &fw.out}
+		fw.done = make(chan struct{})
+	} else {
+		if err := w.writePending(len(w.pending)); err != nil {
+			return nil, err
+		}
+		h.offset = uint64(w.cw.count)
+		if err := writeHeader(w.cw, fh); err != nil {
+			return nil, err
+		}
+	}
+
+	// The compressed data is encrypted after the local file header,
+	// starting with the salt and the password verifier.
+	var dst io.Writer = fw.compCount
+	if fw.aes != nil {
+		enc, err := newAESWriter(fw.compCount, fw.aes, password)
+		if err != nil {
+			return nil, err
+		}
+		fw.enc = enc
+		dst = enc
+	}
 	var err error
-	fw.comp, err = comp(fw.compCount)
+	fw.comp, err = comp(dst)
 	if err != nil {
 		return nil, err
 	}
 	fw.rawCount = &countWriter{w: fw.comp}
 
+	w.last = fw
+	return fw, nil
+}
+
+// Copy copies the file f, read from another archive or from the archive
+// being modified in place, without decompressing and recompressing its
+// data. The header of f, including its extra fields, is kept.
+func (w *Writer) Copy(f *File) error {
+	off, err := f.DataOffset()
+	if err != nil {
+		return err
+	}
+	fh := f.FileHeader
+	fh.Extra = stripExtra(fh.Extra, zip64ExtraId)
+	fw, err := w.createRaw(&fh)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(fw, io.NewSectionReader(f.zipr, off, int64(f.CompressedSize64))); err != nil {
+		return err
+	}
+	return fw.close()
+}
+
+// CreateRaw adds a file to the zip file using the provided FileHeader,
+// whose data is already compressed with fh.Method. It returns a Writer to
+// which the compressed data should be written; it is written to the zip
+// file as is. The CRC32, CompressedSize64, and UncompressedSize64 fields
+// of fh must be set. If the data descriptor bit (0x8) of fh.Flags is set,
+// the CRC-32 and sizes are written in a data descriptor after the data,
+// and otherwise in the local file header.
+//
+// The file's contents must be written to the io.Writer before the next
+// call to Create, CreateHeader, CreateRaw, Copy, or Close. The provided
+// FileHeader fh must not be modified after a call to CreateRaw.
+func (w *Writer) CreateRaw(fh *FileHeader) (io.Writer, error) {
+	fh.CreatorVersion = fh.CreatorVersion&0xff00 | zipVersion20 // preserve compatibility byte
+	if fh.ReaderVersion < zipVersion20 {
+		fh.ReaderVersion = zipVersion20
+	}
+	fw, err := w.createRaw(fh)
+	if err != nil {
+		return nil, err
+	}
+	return fw, nil
+}
+
+// createRaw adds a file whose data, already compressed, is written to the
+// returned writer as is. The CRC-32 and sizes of fh must be set, and a
+// data descriptor is written if the data descriptor bit (0x8) of its
+// Flags is set.
+func (w *Writer) createRaw(fh *FileHeader) (*fileWriter, error) {
+	if w.last != nil && !w.last.closed {
+		if err := w.last.close(); err != nil {
+			return nil, err
+		}
+	}
+	if len(w.dir) > 0 && w.dir[len(w.dir)-1].FileHeader == fh {
+		// See https://golang.org/issue/11144 confusion.
+		return nil, errors.New("archive/zip: invalid duplicate FileHeader")
+	}
+	if err := w.writePending(len(w.pending)); err != nil {
+		return nil, err
+	}
+	if fh.isZip64() && fh.ReaderVersion < zipVersion45 {
+		fh.ReaderVersion = zipVersion45 // requires 4.5 - File uses ZIP64 format extensions
+	}
+
 	h := &header{
 		FileHeader: fh,
 		offset:     uint64(w.cw.count),
 	}
 	w.dir = append(w.dir, h)
-	fw.header = h
-
 	if err := writeHeader(w.cw, fh); err != nil {
 		return nil, err
 	}
 
+	fw := &fileWriter{
+		header:    h,
+		zipw:      w.cw,
+		compCount: &countWriter{w: w.cw},
+		raw:       true,
+	}
 	w.last = fw
 	return fw, nil
 }
@@ -255,21 +494,57 @@ func writeHeader(w io.Writer, h *FileHeader) error {
 	b.uint16(h.Method)
 	b.uint16(h.ModifiedTime)
 	b.uint16(h.ModifiedDate)
-	b.uint32(0) // since we are writing a data descriptor crc32,
-	b.uint32(0) // compressed size,
-	b.uint32(0) // and uncompressed size should be zero
+	extra := h.Extra
+	if h.Flags&0x8 != 0 {
+		b.uint32(0) // since we are writing a data descriptor crc32,
+		b.uint32(0) // compressed size,
+		b.uint32(0) // and uncompressed size should be zero
+	} else if h.isZip64() {
+		b.uint32(h.CRC32)
+		b.uint32(uint32max) // compressed size
+		b.uint32(uint32max) // uncompressed size
+
+		// the local zip64 extra block holds both sizes
+		var zbuf [20]byte // 2x uint16 + 2x uint64
+		eb := writeBuf(zbuf[:])
+		eb.uint16(zip64ExtraId)
+		eb.uint16(16) // size = 2x uint64
+		eb.uint64(h.UncompressedSize64)
+		eb.uint64(h.CompressedSize64)
+		extra = append(extra[:len(extra):len(extra)], zbuf[:]...)
+	} else {
+		b.uint32(h.CRC32)
+		b.uint32(uint32(h.CompressedSize64))
+		b.uint32(uint32(h.UncompressedSize64))
+	}
 	b.uint16(uint16(len(h.Name)))
-	b.uint16(uint16(len(h.Extra)))
+	b.uint16(uint16(len(extra)))
 	if _, err := w.Write(buf[:]); err != nil {
 		return err
 	}
 	if _, err := io.WriteString(w, h.Name); err != nil {
 		return err
 	}
-	_, err := w.Write(h.Extra)
+	_, err := w.Write(extra)
 	return err
 }
 
+// stripExtra returns extra without the fields with the given id.
+func stripExtra(extra []byte, id uint16) []byte {
+	out := make([]byte, 0, len(extra))
+	for len(extra) >= 4 {
+		size := 4 + int(binary.LittleEndian.Uint16(extra[2:]))
+		if size > len(extra) {
+			break
+		}
+		if binary.LittleEndian.Uint16(extra) != id {
+			out = append(out, extra[:size]...)
+		}
+		extra = extra[size:]
+	}
+	return append(out, extra...)
+}
+
 // RegisterCompressor registers or overrides a custom compressor for a specific
 // method ID. If a compressor for a given method is not found, Writer will
 // default to looking up the compressor at the package level.
@@ -293,15 +568,33 @@ type fileWriter struct {
 	zipw      io.Writer
 	rawCount  *countWriter // 内部包含的一个countWriter
 	comp      io.WriteCloser
+	aes       *aesExtra      // if non-nil, how the compressed data is encrypted
+	enc       io.WriteCloser // the encryption of the compressed data
 	compCount *countWriter
 	crc32     hash.Hash32 // 内部包含一个crc32的hash
+	raw       bool        // whether the data is written as is
 	closed    bool        // fileWriter是否已经被关闭
+
+	// Files compressed in the background buffer their data and its
+	// compressed form until written by zw.
+	zw   *Writer
+	data []byte
+	out  bytes.Buffer
+	err  error
+	done chan struct{}
 }
 
 func (w *fileWriter) Write(p []byte) (int, error) { // 实现Write方法
 	if w.closed { // 写入文件已经关闭
 		return 0, errors.New("zip: write to closed file")
 	}
+	if w.raw {
+		return w.compCount.Write(p)
+	}
+	if w.zw != nil {
+		w.data = append(w.data, p...)
+		return len(p), nil
+	}
 	w.crc32.Write(p)           // 计算hash值
 	return w.rawCount.Write(p) // 写入数据，同时计算写入的数量
 }
@@ -311,24 +604,111 @@ func (w *fileWriter) close() error {
 		return errors.New("zip: file closed twice")
 	}
 	w.closed = true // 关闭文件
-	if err := w.comp.Close(); err != nil {
+	if w.zw != nil {
+		return w.zw.startPending(w)
+	}
+	if err := w.finish(); err != nil {
 		return err
 	}
+	return writeDataDescriptor(w.zipw, w.header.FileHeader)
+}
 
-	// update FileHeader
+// finish completes the data of the file and records its CRC-32 and sizes
+// in its header.
+func (w *fileWriter) finish() error {
 	fh := w.header.FileHeader
-	fh.CRC32 = w.crc32.Sum32()
-	fh.CompressedSize64 = uint64(w.compCount.count)
-	fh.UncompressedSize64 = uint64(w.rawCount.count)
+	if w.raw {
+		if uint64(w.compCount.count) != fh.CompressedSize64 {
+			return errors.New("zip: raw data length does not match CompressedSize64")
+		}
+	} else {
+		if err := w.comp.Close(); err != nil {
+			return err
+		}
+		if w.enc != nil {
+			if err := w.enc.Close(); err != nil {
+				return err
+			}
+		}
+
+		// update FileHeader
+		fh.CRC32 = w.crc32.Sum32()
+		if w.aes != nil && w.aes.version == aesVersion2 {
+			fh.CRC32 = 0 // AE-2 leaves the CRC-32 out
+		}
+		fh.CompressedSize64 = uint64(w.compCount.count)
+		fh.UncompressedSize64 = uint64(w.rawCount.count)
+	}
 
 	if fh.isZip64() {
 		fh.CompressedSize = uint32max
 		fh.UncompressedSize = uint32max
-		fh.ReaderVersion = zipVersion45 // requires 4.5 - File uses ZIP64 format extensions
+		if fh.ReaderVersion < zipVersion45 {
+			fh.ReaderVersion = zipVersion45 // requires 4.5 - File uses ZIP64 format extensions
+		}
 	} else {
 		fh.CompressedSize = uint32(fh.CompressedSize64)
 		fh.UncompressedSize = uint32(fh.UncompressedSize64)
 	}
+	return nil
+}
+
+// compress compresses the buffered data of the file, in the background.
+func (w *fileWriter) compress() {
+	w.crc32.Write(w.data)
+	if _, w.err = w.rawCount.Write(w.data); w.err == nil {
+		w.err = w.finish()
+	}
+	w.data = nil
+	close(w.done)
+}
+
+// startPending starts compressing fw in the background. If the maximum
+// number of files are already being compressed, it first waits for the
+// oldest and writes it out.
+func (w *Writer) startPending(fw *fileWriter) error {
+	if len(w.pending) >= w.concurrency {
+		if err := w.writePending(len(w.pending) - w.concurrency + 1); err != nil {
+			return err
+		}
+	}
+	w.pending = append(w.pending, fw)
+	go fw.compress()
+	return nil
+}
+
+// writePending waits for the oldest n files being compressed in the
+// background, in order, and writes them out.
+func (w *Writer) writePending(n int) error {
+	for ; n > 0; n-- {
+		fw := w.pending[0]
+		<-fw.done
+		w.pending = w.pending[1:]
+		if fw.err != nil {
+			return fw.err
+		}
+		fw.header.offset = uint64(w.cw.count)
+		if err := writeHeader(w.cw, fw.header.FileHeader); err != nil {
+			return err
+		}
+		if _, err := w.cw.Write(fw.out.Bytes()); err != nil {
+			return err
+		}
+		if err := writeDataDescriptor(w.cw, fw.header.FileHeader); err != nil {
+			return err
+		}
+		fw.out = bytes.Buffer{}
+	}
+	return nil
+}
+
+// writeDataDescriptor writes the data descriptor of the file, if the data
+// descriptor bit (0x8) of its Flags is set.
+func writeDataDescriptor(w io.Writer, fh *FileHeader) error {
+	if fh.Flags&0x8 == 0 {
+		// The sizes were written in the local file header.
+		return nil
+	}
 
 	// Write data descriptor. This is more complicated than one would
 	// think, see e.g. comments in zipfile.c:putextended() and
@@ -351,7 +731,7 @@ func (w *fileWriter) close() error {
 		b.uint32(fh.CompressedSize)
 		b.uint32(fh.UncompressedSize)
 	}
-	_, err := w.zipw.Write(buf)
+	_, err := w.Write(buf)
 	return err
 }
 
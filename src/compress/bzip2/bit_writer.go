@@ -0,0 +1,46 @@
+// Copyright 2016 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package bzip2
+
+// bitWriter is the counterpart of bitReader. It accumulates values,
+// most-significant bit first, and appends each completed byte to out.
+type bitWriter struct {
+	out  []byte
+	n    uint64
+	bits uint // number of pending bits in the bottom of n
+}
+
+// WriteBits64 writes the low bits bits of v.
+func (bw *bitWriter) WriteBits64(bits uint, v uint64) {
+	if bits > 32 {
+		bw.WriteBits64(bits-32, v>>32)
+		bits = 32
+	}
+	bw.n = bw.n<<bits | v&(1<<bits-1)
+	bw.bits += bits
+	for bw.bits >= 8 {
+		bw.bits -= 8
+		bw.out = append(bw.out, byte(bw.n>>bw.bits))
+	}
+}
+
+func (bw *bitWriter) WriteBits(bits uint, v int) {
+	bw.WriteBits64(bits, uint64(v))
+}
+
+func (bw *bitWriter) WriteBit(b bool) {
+	if b {
+		bw.WriteBits(1, 1)
+	} else {
+		bw.WriteBits(1, 0)
+	}
+}
+
+// Align pads the output with zero bits up to the next byte boundary.
+func (bw *bitWriter) Align() {
+	if bw.bits > 0 {
+		bw.WriteBits(8-bw.bits, 0)
+	}
+}
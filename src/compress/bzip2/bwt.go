@@ -0,0 +1,104 @@
+// Copyright 2016 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package bzip2
+
+// bwt computes the Burrows-Wheeler transform of block: it sorts all the
+// rotations of block and stores the last byte of each, in sorted order, in
+// out. It returns the position of the unrotated block in the sorted order,
+// which the decoder needs to invert the transform (origPtr).
+//
+// The rotations are sorted by prefix doubling: after the pass for k, the
+// rotations are ordered by their first 2k bytes and rank holds the class
+// of each, so the next pass only needs to sort by the pairs of ranks of
+// rotations i and i+k. Each pass is a counting sort, and sorting stops as
+// soon as all the classes are distinct. The sa, rank, tmp and cnt slices are
+// scratch space of len(block).
+func bwt(out, block []byte, sa, rank, tmp, cnt []int32) (origPtr int) {
+	n := len(block)
+
+	// Sort by the first byte.
+	var c [256]int32
+	for _, b := range block {
+		c[b]++
+	}
+	sum := int32(0)
+	for i := range c {
+		sum += c[i]
+		c[i] = sum
+	}
+	for i := n - 1; i >= 0; i-- {
+		b := block[i]
+		c[b]--
+		sa[c[b]] = int32(i)
+	}
+	classes := int32(1)
+	rank[sa[0]] = 0
+	for i := 1; i < n; i++ {
+		if block[sa[i]] != block[sa[i-1]] {
+			classes++
+		}
+		rank[sa[i]] = classes - 1
+	}
+
+	for k := 1; k < n && int(classes) < n; k <<= 1 {
+		// Rotation i-k followed by i: listing the rotations in the
+		// current order, shifted back by k, sorts them by their
+		// second half. A stable sort by the first half completes
+		// the order.
+		for i, s := range sa {
+			s -= int32(k)
+			if s < 0 {
+				s += int32(n)
+			}
+			tmp[i] = s
+		}
+		for i := range cnt[:classes] {
+			cnt[i] = 0
+		}
+		for _, s := range tmp {
+			cnt[rank[s]]++
+		}
+		sum := int32(0)
+		for i := range cnt[:classes] {
+			sum += cnt[i]
+			cnt[i] = sum
+		}
+		for i := n - 1; i >= 0; i-- {
+			s := tmp[i]
+			r := rank[s]
+			cnt[r]--
+			sa[cnt[r]] = s
+		}
+
+		// Compute the classes of the doubled prefixes.
+		classes = 1
+		tmp[sa[0]] = 0
+		for i := 1; i < n; i++ {
+			cur, prev := int(sa[i]), int(sa[i-1])
+			curNext, prevNext := cur+k, prev+k
+			if curNext >= n {
+				curNext -= n
+			}
+			if prevNext >= n {
+				prevNext -= n
+			}
+			if rank[cur] != rank[prev] || rank[curNext] != rank[prevNext] {
+				classes++
+			}
+			tmp[cur] = classes - 1
+		}
+		rank, tmp = tmp, rank
+	}
+
+	for i, s := range sa {
+		if s == 0 {
+			origPtr = i
+			out[i] = block[n-1]
+		} else {
+			out[i] = block[s-1]
+		}
+	}
+	return origPtr
+}
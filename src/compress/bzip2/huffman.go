@@ -261,3 +261,86 @@ func buildHuffmanNode(t *huffmanTree, codes []huffmanCode, level uint32) (nodeIn
 
 	return
 }
+
+// huffmanCodeLengths sets lengths[i] to the length of the code for symbol i
+// in a Huffman code for the given symbol frequencies. Like the reference
+// encoder, it gives every symbol a code, even if its frequency is zero, and
+// limits the lengths to maxLen by flattening the frequencies until the
+// tree is shallow enough.
+func huffmanCodeLengths(lengths []uint8, freqs []int32, maxLen uint8) {
+	n := len(freqs)
+	weights := make([]int64, 2*n-1)
+	parent := make([]int, 2*n-1)
+	depth := make([]uint8, 2*n-1)
+	leaves := huffmanLeaves{weights: weights, index: make([]int, n)}
+	for i, f := range freqs {
+		if f == 0 {
+			f = 1
+		}
+		weights[i] = int64(f)
+	}
+
+	for {
+		for i := range leaves.index {
+			leaves.index[i] = i
+		}
+		sort.Sort(leaves)
+
+		// The leaves are nodes 0 to n-1 and the internal nodes are
+		// created, in order of increasing weight, from n onwards, so
+		// the two lightest nodes are always at the head of one of the
+		// two queues.
+		next, nextLeaf, nextNode := n, 0, n
+		lightest := func() int {
+			if nextLeaf < n && (nextNode == next || weights[leaves.index[nextLeaf]] <= weights[nextNode]) {
+				nextLeaf++
+				return leaves.index[nextLeaf-1]
+			}
+			nextNode++
+			return nextNode - 1
+		}
+		for ; next < 2*n-1; next++ {
+			a, b := lightest(), lightest()
+			weights[next] = weights[a] + weights[b]
+			parent[a], parent[b] = next, next
+		}
+
+		// Every parent comes after its children, so the depths can be
+		// computed from the root down.
+		tooLong := false
+		depth[2*n-2] = 0
+		for i := 2*n - 3; i >= 0; i-- {
+			depth[i] = depth[parent[i]] + 1
+			if i < n {
+				lengths[i] = depth[i]
+				if depth[i] > maxLen {
+					tooLong = true
+				}
+			}
+		}
+		if !tooLong {
+			return
+		}
+		for i := range weights[:n] {
+			weights[i] = 1 + weights[i]/2
+		}
+	}
+}
+
+// huffmanLeaves is used to sort symbols by ascending weight.
+type huffmanLeaves struct {
+	weights []int64
+	index   []int
+}
+
+func (h huffmanLeaves) Len() int {
+	return len(h.index)
+}
+
+func (h huffmanLeaves) Less(i, j int) bool {
+	return h.weights[h.index[i]] < h.weights[h.index[j]]
+}
+
+func (h huffmanLeaves) Swap(i, j int) {
+	h.index[i], h.index[j] = h.index[j], h.index[i]
+}
@@ -0,0 +1,457 @@
+// Copyright 2016 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package bzip2
+
+import (
+	"fmt"
+	"io"
+)
+
+// The compression level selects the size of the blocks, in units of 100kB.
+// Larger blocks compress better but need more memory to compress and
+// decompress.
+const (
+	BestSpeed          = 1
+	BestCompression    = 9
+	DefaultCompression = -1
+)
+
+const (
+	maxCodeLen   = 17     // the longest Huffman code the reference encoder produces
+	groupSize    = 50     // number of symbols coded with the same Huffman table
+	maxTables    = 6      // maximum number of Huffman tables in a block
+	numIters     = 4      // rounds of refining the Huffman tables
+	blockReserve = 19     // room left in a block for a final run
+	maxRun       = 255    // longest run of a byte in the initial run-length encoding
+	blockUnit    = 100000 // unit of the block size, in bytes
+	lesserCost   = 0      // initial code length of symbols a table favors
+	greaterCost  = 15     // initial code length of the other symbols
+	maxAlphaSize = 258    // RUNA, RUNB, 255 MTF indexes and EOB
+	runA, runB   = 0, 1   // symbols of the run-length encoding of zeros
+)
+
+// A Writer is an io.WriteCloser.
+// Writes to a Writer are compressed and written to w.
+//
+// The data is compressed in independent blocks, whose size is given by the
+// compression level, so a Writer buffers up to a block of input before
+// writing anything.
+type Writer struct {
+	w     io.Writer
+	level int
+	bw    bitWriter
+	err   error
+
+	// block holds the current block after the initial run-length
+	// encoding, which turns runs of 4 to 255 equal bytes into four
+	// copies followed by the number of further repeats. The run being
+	// accumulated is runByte repeated runLen times.
+	block    []byte
+	blockMax int
+	runByte  byte
+	runLen   int
+	blockCRC uint32
+	fileCRC  uint32
+	closed   bool
+
+	// Scratch space for compressing a block.
+	bwt                []byte
+	sa, rank, tmp, cnt []int32
+	mtf                []uint16
+	selectors          []uint8
+	lengths            [maxTables][maxAlphaSize]uint8
+	codes              [maxTables][maxAlphaSize]uint32
+	freqs              [maxTables][maxAlphaSize]int32
+	mtfFreq            [maxAlphaSize]int32
+	inUse              [256]bool
+	unseqToSeq         [256]uint8
+}
+
+// NewWriter returns a new Writer.
+// Writes to the returned writer are compressed and written to w.
+//
+// It is the caller's responsibility to call Close on the WriteCloser when done.
+// Writes may be buffered and not flushed until Close.
+func NewWriter(w io.Writer) *Writer {
+	z, _ := NewWriterLevel(w, DefaultCompression)
+	return z
+}
+
+// NewWriterLevel is like NewWriter but specifies the compression level instead
+// of assuming DefaultCompression.
+//
+// The compression level can be DefaultCompression, which is equivalent to
+// BestCompression as in the reference implementation, or any integer value
+// between BestSpeed and BestCompression inclusive. The error returned will
+// be nil if the level is valid.
+func NewWriterLevel(w io.Writer, level int) (*Writer, error) {
+	if level == DefaultCompression {
+		level = BestCompression
+	}
+	if level < BestSpeed || level > BestCompression {
+		return nil, fmt.Errorf("bzip2: invalid compression level: %d", level)
+	}
+	z := &Writer{
+		level:    level,
+		blockMax: level*blockUnit - blockReserve,
+	}
+	z.Reset(w)
+	return z, nil
+}
+
+// Reset discards the Writer z's state and makes it equivalent to the
+// result of its original state from NewWriter or NewWriterLevel, but
+// writing to w instead. This permits reusing a Writer rather than
+// allocating a new one.
+func (z *Writer) Reset(w io.Writer) {
+	z.w = w
+	z.err = nil
+	z.closed = false
+	z.block = z.block[:0]
+	z.runLen = 0
+	z.blockCRC = 0
+	z.fileCRC = 0
+	z.bw = bitWriter{out: z.bw.out[:0]}
+	z.bw.WriteBits(16, bzip2FileMagic)
+	z.bw.WriteBits(8, 'h')
+	z.bw.WriteBits(8, '0'+z.level)
+}
+
+// Write writes a compressed form of p to the underlying io.Writer. The
+// compressed bytes are not necessarily flushed until the Writer is closed.
+func (z *Writer) Write(p []byte) (int, error) {
+	if z.err != nil {
+		return 0, z.err
+	}
+	for _, b := range p {
+		if z.runLen > 0 && b == z.runByte && z.runLen < maxRun {
+			z.runLen++
+			continue
+		}
+		if z.runLen > 0 {
+			if err := z.flushRun(); err != nil {
+				return 0, err
+			}
+		}
+		z.runByte, z.runLen = b, 1
+	}
+	return len(p), nil
+}
+
+// flushRun adds the pending run to the block, compressing the block once
+// it is full.
+func (z *Writer) flushRun() error {
+	b := z.runByte
+	crc := ^z.blockCRC
+	for i := 0; i < z.runLen; i++ {
+		crc = crctab[byte(crc>>24)^b] ^ (crc << 8)
+	}
+	z.blockCRC = ^crc
+	if z.runLen < 4 {
+		for i := 0; i < z.runLen; i++ {
+			z.block = append(z.block, b)
+		}
+	} else {
+		z.block = append(z.block, b, b, b, b, byte(z.runLen-4))
+	}
+	z.runLen = 0
+	if len(z.block) >= z.blockMax {
+		return z.writeBlock()
+	}
+	return nil
+}
+
+// Close closes the Writer, flushing any unwritten data to the underlying
+// io.Writer, but does not close the underlying io.Writer.
+func (z *Writer) Close() error {
+	if z.err != nil {
+		return z.err
+	}
+	if z.closed {
+		return nil
+	}
+	z.closed = true
+	if z.runLen > 0 {
+		if err := z.flushRun(); err != nil {
+			return err
+		}
+	}
+	if len(z.block) > 0 {
+		if err := z.writeBlock(); err != nil {
+			return err
+		}
+	}
+	z.bw.WriteBits64(48, bzip2FinalMagic)
+	z.bw.WriteBits64(32, uint64(z.fileCRC))
+	z.bw.Align()
+	return z.flush()
+}
+
+// flush writes the completed bytes of the compressed stream to the
+// underlying writer.
+func (z *Writer) flush() error {
+	if len(z.bw.out) > 0 {
+		_, z.err = z.w.Write(z.bw.out)
+		z.bw.out = z.bw.out[:0]
+	}
+	return z.err
+}
+
+// writeBlock compresses the current block and starts a new one.
+func (z *Writer) writeBlock() error {
+	n := len(z.block)
+	if cap(z.sa) < n {
+		z.bwt = make([]byte, z.blockMax+blockReserve)
+		z.sa = make([]int32, z.blockMax+blockReserve)
+		z.rank = make([]int32, z.blockMax+blockReserve)
+		z.tmp = make([]int32, z.blockMax+blockReserve)
+		z.cnt = make([]int32, z.blockMax+blockReserve)
+	}
+	last := z.bwt[:n]
+	origPtr := bwt(last, z.block, z.sa[:n], z.rank[:n], z.tmp[:n], z.cnt[:n])
+
+	bw := &z.bw
+	bw.WriteBits64(48, bzip2BlockMagic)
+	bw.WriteBits64(32, uint64(z.blockCRC))
+	bw.WriteBit(false) // not randomized
+	bw.WriteBits(24, origPtr)
+
+	// The symbol bitmap: a bit for each range of 16 bytes, followed by
+	// a 16-bit mask for each range in use.
+	for i := range z.inUse {
+		z.inUse[i] = false
+	}
+	for _, b := range z.block {
+		z.inUse[b] = true
+	}
+	numInUse := 0
+	ranges := 0
+	for i, used := range z.inUse {
+		if used {
+			z.unseqToSeq[i] = uint8(numInUse)
+			numInUse++
+			ranges |= 1 << uint(15-i/16)
+		}
+	}
+	bw.WriteBits(16, ranges)
+	for r := uint(0); r < 16; r++ {
+		if ranges&(1<<(15-r)) == 0 {
+			continue
+		}
+		bits := 0
+		for s := uint(0); s < 16; s++ {
+			if z.inUse[16*r+s] {
+				bits |= 1 << (15 - s)
+			}
+		}
+		bw.WriteBits(16, bits)
+	}
+
+	alphaSize := numInUse + 2
+	z.moveToFront(last, numInUse)
+	nGroups := z.buildTables(alphaSize)
+
+	bw.WriteBits(3, nGroups)
+	bw.WriteBits(15, len(z.selectors))
+	mtf := newMTFDecoderWithRange(nGroups)
+	for _, s := range z.selectors {
+		j := 0
+		for mtf[j] != s {
+			j++
+		}
+		mtf.Decode(j)
+		for ; j > 0; j-- {
+			bw.WriteBit(true)
+		}
+		bw.WriteBit(false)
+	}
+
+	// The code lengths are delta encoded: 10 increments the current
+	// length, 11 decrements it and 0 moves on to the next symbol.
+	for t := 0; t < nGroups; t++ {
+		lengths := z.lengths[t][:alphaSize]
+		cur := int(lengths[0])
+		bw.WriteBits(5, cur)
+		for _, l := range lengths {
+			for ; cur < int(l); cur++ {
+				bw.WriteBits(2, 2)
+			}
+			for ; cur > int(l); cur-- {
+				bw.WriteBits(2, 3)
+			}
+			bw.WriteBit(false)
+		}
+		assignCodes(z.codes[t][:alphaSize], lengths)
+	}
+
+	for i, s := range z.selectors {
+		group := z.mtf[i*groupSize:]
+		if len(group) > groupSize {
+			group = group[:groupSize]
+		}
+		lengths, codes := &z.lengths[s], &z.codes[s]
+		for _, v := range group {
+			bw.WriteBits64(uint(lengths[v]), uint64(codes[v]))
+		}
+	}
+
+	z.fileCRC = (z.fileCRC<<1 | z.fileCRC>>31) ^ z.blockCRC
+	z.blockCRC = 0
+	z.block = z.block[:0]
+	return z.flush()
+}
+
+// moveToFront applies the move-to-front transform to the output of the
+// BWT, storing the symbols in z.mtf and counting them in z.mtfFreq. Runs of
+// zeros are written in bijective base 2 with the digits RUNA (1) and RUNB
+// (2), least significant first, and the other indexes are shifted up by
+// one. The symbols end with EOB.
+func (z *Writer) moveToFront(last []byte, numInUse int) {
+	var order [256]uint8
+	for i := range order[:numInUse] {
+		order[i] = uint8(i)
+	}
+	for i := range z.mtfFreq {
+		z.mtfFreq[i] = 0
+	}
+	z.mtf = z.mtf[:0]
+	zeros := 0
+	flushZeros := func() {
+		for zeros > 0 {
+			zeros--
+			v := uint16(runA)
+			if zeros&1 != 0 {
+				v = runB
+			}
+			z.mtf = append(z.mtf, v)
+			z.mtfFreq[v]++
+			zeros >>= 1
+		}
+	}
+	for _, b := range last {
+		s := z.unseqToSeq[b]
+		if order[0] == s {
+			zeros++
+			continue
+		}
+		flushZeros()
+		j := 1
+		for order[j] != s {
+			j++
+		}
+		copy(order[1:j+1], order[:j])
+		order[0] = s
+		z.mtf = append(z.mtf, uint16(j+1))
+		z.mtfFreq[j+1]++
+	}
+	flushZeros()
+	eob := uint16(numInUse + 1)
+	z.mtf = append(z.mtf, eob)
+	z.mtfFreq[eob]++
+}
+
+// buildTables chooses the Huffman tables for the block and the table to
+// use for each group of symbols, following the reference encoder: the
+// tables start out favoring disjoint ranges of symbols of about equal total
+// frequency, and are then refined by repeatedly assigning each group to the
+// table that codes it best and rebuilding the tables from the frequencies
+// of the symbols they were given. It returns the number of tables.
+func (z *Writer) buildTables(alphaSize int) int {
+	nMTF := len(z.mtf)
+	var nGroups int
+	switch {
+	case nMTF < 200:
+		nGroups = 2
+	case nMTF < 600:
+		nGroups = 3
+	case nMTF < 1200:
+		nGroups = 4
+	case nMTF < 2400:
+		nGroups = 5
+	default:
+		nGroups = 6
+	}
+
+	remaining := int32(nMTF)
+	start := 0
+	for part := nGroups; part > 0; part-- {
+		target := remaining / int32(part)
+		end := start - 1
+		sum := int32(0)
+		for sum < target && end < alphaSize-1 {
+			end++
+			sum += z.mtfFreq[end]
+		}
+		if end > start && part != nGroups && part != 1 && (nGroups-part)%2 == 1 {
+			sum -= z.mtfFreq[end]
+			end--
+		}
+		lengths := &z.lengths[part-1]
+		for v := 0; v < alphaSize; v++ {
+			if v >= start && v <= end {
+				lengths[v] = lesserCost
+			} else {
+				lengths[v] = greaterCost
+			}
+		}
+		start = end + 1
+		remaining -= sum
+	}
+
+	nSelectors := (nMTF + groupSize - 1) / groupSize
+	if cap(z.selectors) < nSelectors {
+		z.selectors = make([]uint8, nSelectors)
+	}
+	z.selectors = z.selectors[:nSelectors]
+	for iter := 0; iter < numIters; iter++ {
+		for t := 0; t < nGroups; t++ {
+			for v := range z.freqs[t][:alphaSize] {
+				z.freqs[t][v] = 0
+			}
+		}
+		for i := range z.selectors {
+			group := z.mtf[i*groupSize:]
+			if len(group) > groupSize {
+				group = group[:groupSize]
+			}
+			best, bestCost := 0, -1
+			for t := 0; t < nGroups; t++ {
+				lengths := &z.lengths[t]
+				cost := 0
+				for _, v := range group {
+					cost += int(lengths[v])
+				}
+				if bestCost < 0 || cost < bestCost {
+					best, bestCost = t, cost
+				}
+			}
+			z.selectors[i] = uint8(best)
+			freqs := &z.freqs[best]
+			for _, v := range group {
+				freqs[v]++
+			}
+		}
+		for t := 0; t < nGroups; t++ {
+			huffmanCodeLengths(z.lengths[t][:alphaSize], z.freqs[t][:alphaSize], maxCodeLen)
+		}
+	}
+	return nGroups
+}
+
+// assignCodes assigns canonical Huffman codes for the given code lengths:
+// shorter codes come first and, among codes of the same length, the
+// smaller symbols come first.
+func assignCodes(codes []uint32, lengths []uint8) {
+	code := uint32(0)
+	for l := uint8(1); l <= maxCodeLen; l++ {
+		for v, vl := range lengths {
+			if vl == l {
+				codes[v] = code
+				code++
+			}
+		}
+		code <<= 1
+	}
+}
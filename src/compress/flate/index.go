@@ -0,0 +1,215 @@
+// Copyright 2016 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package flate
+
+import (
+	"errors"
+	"io"
+	"io/ioutil"
+	"sort"
+)
+
+// A Checkpoint records the state of a decompressor at the start of a block,
+// from which decompression can resume without the preceding data.
+type Checkpoint struct {
+	In     int64  // offset of the byte of compressed data holding the first bit of the block
+	Bit    uint   // number of bits of that byte that belong to the previous block
+	Out    int64  // offset of the block in the uncompressed data
+	Window []byte // the uncompressed data preceding the block, up to 32kB
+}
+
+// An Index lists checkpoints of a compressed stream, in increasing order
+// of offsets, allowing random access to the uncompressed data. The first
+// checkpoint is at the start of the data.
+//
+// An Index is built by reading the stream once, through a Reader returned
+// by NewIndexReader or with BuildIndex. Storing the whole window costs
+// 32kB per checkpoint, so the span between checkpoints trades the size of
+// the index for the amount of data to decompress before reaching an
+// arbitrary offset.
+type Index struct {
+	Checkpoints []Checkpoint
+	Size        int64 // length of the uncompressed data
+}
+
+// Find returns the last checkpoint at or before the uncompressed offset
+// off, or nil if the index is empty.
+func (x *Index) Find(off int64) *Checkpoint {
+	i := sort.Search(len(x.Checkpoints), func(i int) bool {
+		return x.Checkpoints[i].Out > off
+	})
+	if i == 0 {
+		return nil
+	}
+	return &x.Checkpoints[i-1]
+}
+
+// checkpoint adds a checkpoint for the block about to start to f.index,
+// if at least f.span bytes have been decompressed since the previous one.
+func (f *decompressor) checkpoint() {
+	out := f.woffset + int64(f.hp-f.hw)
+	if n := len(f.index.Checkpoints); n > 0 && out < f.index.Checkpoints[n-1].Out+f.span {
+		return
+	}
+	pos := f.roffset*8 - int64(f.nb)
+	c := Checkpoint{In: pos / 8, Bit: uint(pos % 8), Out: out}
+	if f.hfull {
+		c.Window = make([]byte, 0, len(f.hist))
+		c.Window = append(c.Window, f.hist[f.hp:]...)
+		c.Window = append(c.Window, f.hist[:f.hp]...)
+	} else {
+		c.Window = append([]byte(nil), f.hist[:f.hp]...)
+	}
+	f.index.Checkpoints = append(f.index.Checkpoints, c)
+}
+
+// NewIndexReader is like NewReader but, as the data is read, records
+// checkpoints in idx, the first at the start of the data and the next ones
+// at the start of the first block at least span bytes after the previous
+// one. The offsets of compressed data are relative to the start of r.
+// idx.Size is set when the reader reaches the end of the data.
+func NewIndexReader(r io.Reader, idx *Index, span int64) io.ReadCloser {
+	f := NewReader(r).(*decompressor)
+	f.index = idx
+	f.span = span
+	return f
+}
+
+// BuildIndex reads the compressed data from r to its end and returns an
+// index with checkpoints about every span bytes of uncompressed data.
+func BuildIndex(r io.Reader, span int64) (*Index, error) {
+	idx := new(Index)
+	if _, err := io.Copy(ioutil.Discard, NewIndexReader(r, idx, span)); err != nil {
+		return nil, err
+	}
+	return idx, nil
+}
+
+// NewCheckpointReader returns a new ReadCloser that reads the uncompressed
+// data from checkpoint c onwards. The compressed data is read from r,
+// which must start at offset c.In of the compressed stream.
+func NewCheckpointReader(r io.Reader, c *Checkpoint) io.ReadCloser {
+	f := NewReaderDict(r, c.Window).(*decompressor)
+	f.roffset = c.In
+	f.woffset = c.Out
+	if c.Bit > 0 {
+		if f.err = f.moreBits(); f.err == nil {
+			f.b >>= c.Bit
+			f.nb -= c.Bit
+		}
+	}
+	return f
+}
+
+// A ReaderAt provides random access to the uncompressed data of a
+// compressed stream, for which an Index has been built. Reading at an
+// offset resumes decompression from the closest preceding checkpoint.
+//
+// ReadAt may be called concurrently, as it decompresses independently of
+// other calls. Read and Seek read sequentially from a current offset,
+// keeping their decompressor from one call to the next.
+type ReaderAt struct {
+	r   io.ReaderAt
+	idx *Index
+
+	off    int64         // current offset for Read and Seek
+	dec    io.ReadCloser // decompressor for Read, at decOff
+	decOff int64
+}
+
+// NewReaderAt returns a new ReaderAt reading the compressed data from r,
+// described by idx.
+func NewReaderAt(r io.ReaderAt, idx *Index) *ReaderAt {
+	return &ReaderAt{r: r, idx: idx}
+}
+
+// Size returns the length of the uncompressed data.
+func (z *ReaderAt) Size() int64 { return z.idx.Size }
+
+// open returns a decompressor positioned at the uncompressed offset off.
+func (z *ReaderAt) open(off int64) (io.ReadCloser, error) {
+	c := z.idx.Find(off)
+	if c == nil {
+		return nil, errors.New("flate: empty index")
+	}
+	dec := NewCheckpointReader(io.NewSectionReader(z.r, c.In, 1<<63-1-c.In), c)
+	if err := discard(dec, off-c.Out); err != nil {
+		return nil, err
+	}
+	return dec, nil
+}
+
+// discard reads and discards n bytes from r.
+func discard(r io.Reader, n int64) error {
+	_, err := io.CopyN(ioutil.Discard, r, n)
+	if err == io.EOF {
+		err = io.ErrUnexpectedEOF
+	}
+	return err
+}
+
+// ReadAt implements the io.ReaderAt interface.
+func (z *ReaderAt) ReadAt(p []byte, off int64) (n int, err error) {
+	if off < 0 {
+		return 0, errors.New("flate.ReaderAt.ReadAt: negative offset")
+	}
+	if off >= z.idx.Size {
+		return 0, io.EOF
+	}
+	dec, err := z.open(off)
+	if err != nil {
+		return 0, err
+	}
+	n, err = io.ReadFull(dec, p)
+	if err == io.ErrUnexpectedEOF && off+int64(n) == z.idx.Size {
+		err = io.EOF
+	}
+	return n, err
+}
+
+// Read implements the io.Reader interface.
+func (z *ReaderAt) Read(p []byte) (n int, err error) {
+	if z.off >= z.idx.Size {
+		return 0, io.EOF
+	}
+	if z.dec == nil || z.decOff != z.off {
+		// Moving forward within the span of a checkpoint is cheaper
+		// than starting over from it.
+		if z.dec != nil && z.decOff < z.off && z.idx.Find(z.off).Out <= z.decOff {
+			err = discard(z.dec, z.off-z.decOff)
+		} else {
+			z.dec, err = z.open(z.off)
+		}
+		if err != nil {
+			z.dec = nil
+			return 0, err
+		}
+		z.decOff = z.off
+	}
+	n, err = z.dec.Read(p)
+	z.off += int64(n)
+	z.decOff = z.off
+	return n, err
+}
+
+// Seek implements the io.Seeker interface.
+func (z *ReaderAt) Seek(offset int64, whence int) (int64, error) {
+	var abs int64
+	switch whence {
+	case 0:
+		abs = offset
+	case 1:
+		abs = z.off + offset
+	case 2:
+		abs = z.idx.Size + offset
+	default:
+		return 0, errors.New("flate.ReaderAt.Seek: invalid whence")
+	}
+	if abs < 0 {
+		return 0, errors.New("flate.ReaderAt.Seek: negative position")
+	}
+	z.off = abs
+	return abs, nil
+}
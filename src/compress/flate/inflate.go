@@ -299,6 +299,11 @@ type decompressor struct {
 	hl, hd   *huffmanDecoder
 	copyLen  int
 	copyDist int
+
+	// Index being built, if any, and the minimum distance between its
+	// checkpoints.
+	index *Index
+	span  int64
 }
 
 func (f *decompressor) nextBlock() {
@@ -307,9 +312,15 @@ func (f *decompressor) nextBlock() {
 			f.flush((*decompressor).nextBlock)
 			return
 		}
+		if f.index != nil {
+			f.index.Size = f.woffset
+		}
 		f.err = io.EOF
 		return
 	}
+	if f.index != nil {
+		f.checkpoint()
+	}
 	for f.nb < 1+2 {
 		if f.err = f.moreBits(); f.err != nil {
 			return
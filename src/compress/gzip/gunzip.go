@@ -78,6 +78,13 @@ type Reader struct {
 	buf          [512]byte
 	err          error
 	multistream  bool
+
+	// State of BuildIndex.
+	index  *flate.Index
+	span   int64
+	member flate.Index // index of the current member
+	in     *countReader
+	inBase int64 // offset of the current member's compressed data
 }
 
 // NewReader creates a new Reader reading the given reader.
@@ -250,7 +257,11 @@ func (z *Reader) readHeader(save bool) error {
 	}
 
 	z.digest.Reset()
-	if z.decompressor == nil {
+	if z.index != nil {
+		z.member = flate.Index{}
+		z.inBase = z.in.n
+		z.decompressor = flate.NewIndexReader(z.r, &z.member, z.span)
+	} else if z.decompressor == nil {
 		z.decompressor = flate.NewReader(z.r)
 	} else {
 		z.decompressor.(flate.Resetter).Reset(z.r, nil)
@@ -275,6 +286,9 @@ func (z *Reader) Read(p []byte) (n int, err error) {
 	}
 
 	// Finished file; check checksum + size.
+	if z.index != nil {
+		z.addMember()
+	}
 	if _, err := io.ReadFull(z.r, z.buf[0:8]); err != nil {
 		if err == io.EOF {
 			err = io.ErrUnexpectedEOF
@@ -108,23 +108,24 @@ func put4(p []byte, v uint32) {
 	p[3] = uint8(v >> 24)
 }
 
-// writeBytes writes a length-prefixed byte slice to z.w.
-func (z *Writer) writeBytes(b []byte) error { // 写入一个byte的slice
+// writeBytes writes a length-prefixed byte slice to w.
+func writeBytes(w io.Writer, b []byte) error { // 写入一个byte的slice
 	if len(b) > 0xffff {
 		return errors.New("gzip.Write: Extra data is too large")
 	}
-	put2(z.buf[0:2], uint16(len(b)))
-	_, err := z.w.Write(z.buf[0:2])
+	var buf [2]byte
+	put2(buf[0:2], uint16(len(b)))
+	_, err := w.Write(buf[0:2])
 	if err != nil {
 		return err
 	}
-	_, err = z.w.Write(b)
+	_, err = w.Write(b)
 	return err
 }
 
-// writeString writes a UTF-8 string s in GZIP's format to z.w.
+// writeString writes a UTF-8 string s in GZIP's format to w.
 // GZIP (RFC 1952) specifies that strings are NUL-terminated ISO 8859-1 (Latin-1).
-func (z *Writer) writeString(s string) (err error) { // 写入一个string
+func writeString(w io.Writer, s string) (err error) { // 写入一个string
 	// GZIP stores Latin-1 strings; error if non-Latin-1; convert if non-ASCII.
 	needconv := false
 	for _, v := range s {
@@ -140,19 +141,65 @@ func (z *Writer) writeString(s string) (err error) { // 写入一个string
 		for _, v := range s {
 			b = append(b, byte(v))
 		}
-		_, err = z.w.Write(b)
+		_, err = w.Write(b)
 	} else {
-		_, err = io.WriteString(z.w, s)
+		_, err = io.WriteString(w, s)
 	}
 	if err != nil {
 		return err
 	}
 	// GZIP strings are NUL-terminated.
-	z.buf[0] = 0
-	_, err = z.w.Write(z.buf[0:1])
+	_, err = w.Write([]byte{0})
 	return err
 }
 
+// writeHeader writes the GZIP header h of a member compressed at the given
+// level to w.
+func writeHeader(w io.Writer, h *Header, level int) error {
+	var buf [10]byte
+	buf[0] = gzipID1
+	buf[1] = gzipID2
+	buf[2] = gzipDeflate
+	buf[3] = 0
+	if h.Extra != nil {
+		buf[3] |= 0x04
+	}
+	if h.Name != "" {
+		buf[3] |= 0x08
+	}
+	if h.Comment != "" {
+		buf[3] |= 0x10
+	}
+	put4(buf[4:8], uint32(h.ModTime.Unix()))
+	if level == BestCompression {
+		buf[8] = 2
+	} else if level == BestSpeed {
+		buf[8] = 4
+	} else {
+		buf[8] = 0
+	}
+	buf[9] = h.OS
+	if _, err := w.Write(buf[0:10]); err != nil {
+		return err
+	}
+	if h.Extra != nil {
+		if err := writeBytes(w, h.Extra); err != nil {
+			return err
+		}
+	}
+	if h.Name != "" {
+		if err := writeString(w, h.Name); err != nil {
+			return err
+		}
+	}
+	if h.Comment != "" {
+		if err := writeString(w, h.Comment); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
 // Write writes a compressed form of p to the underlying io.Writer. The
 // compressed bytes are not necessarily flushed until the Writer is closed.
 func (z *Writer) Write(p []byte) (int, error) {
@@ -163,49 +210,9 @@ func (z *Writer) Write(p []byte) (int, error) {
 	// Write the GZIP header lazily.
 	if !z.wroteHeader { // 延迟写入头部
 		z.wroteHeader = true
-		z.buf[0] = gzipID1
-		z.buf[1] = gzipID2
-		z.buf[2] = gzipDeflate
-		z.buf[3] = 0
-		if z.Extra != nil {
-			z.buf[3] |= 0x04
-		}
-		if z.Name != "" {
-			z.buf[3] |= 0x08
-		}
-		if z.Comment != "" {
-			z.buf[3] |= 0x10
-		}
-		put4(z.buf[4:8], uint32(z.ModTime.Unix()))
-		if z.level == BestCompression {
-			z.buf[8] = 2
-		} else if z.level == BestSpeed {
-			z.buf[8] = 4
-		} else {
-			z.buf[8] = 0
-		}
-		z.buf[9] = z.OS
-		n, z.err = z.w.Write(z.buf[0:10])
+		z.err = writeHeader(z.w, &z.Header, z.level)
 		if z.err != nil {
-			return n, z.err
-		}
-		if z.Extra != nil {
-			z.err = z.writeBytes(z.Extra)
-			if z.err != nil {
-				return n, z.err
-			}
-		}
-		if z.Name != "" {
-			z.err = z.writeString(z.Name)
-			if z.err != nil {
-				return n, z.err
-			}
-		}
-		if z.Comment != "" {
-			z.err = z.writeString(z.Comment)
-			if z.err != nil {
-				return n, z.err
-			}
+			return 0, z.err
 		}
 		if z.compressor == nil {
 			z.compressor, _ = flate.NewWriter(z.w, z.level)
@@ -0,0 +1,220 @@
+// Copyright 2016 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package gzip
+
+import (
+	"bufio"
+	"compress/flate"
+	"errors"
+	"hash/crc32"
+	"io"
+	"io/ioutil"
+)
+
+// countReader counts the bytes read through it.
+type countReader struct {
+	r flate.Reader
+	n int64
+}
+
+func (c *countReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n += int64(n)
+	return n, err
+}
+
+func (c *countReader) ReadByte() (byte, error) {
+	b, err := c.r.ReadByte()
+	if err == nil {
+		c.n++
+	}
+	return b, err
+}
+
+// BuildIndex reads the gzip file from r to its end, verifying the checksum
+// of every member, and returns an index of the uncompressed data with
+// checkpoints about every span bytes. The offsets of compressed data in the
+// index are relative to the start of the file, and the uncompressed data is
+// the concatenation of the data of all the members, as read by Reader.
+func BuildIndex(r io.Reader, span int64) (*flate.Index, error) {
+	in := &countReader{r: makeReader(r)}
+	z := &Reader{
+		r:           in,
+		digest:      crc32.NewIEEE(),
+		multistream: true,
+		index:       new(flate.Index),
+		span:        span,
+		in:          in,
+	}
+	if err := z.readHeader(true); err != nil {
+		return nil, err
+	}
+	if _, err := io.Copy(ioutil.Discard, z); err != nil {
+		return nil, err
+	}
+	return z.index, nil
+}
+
+// addMember adds the checkpoints of the member just decompressed to the
+// index, keeping those at least z.span bytes apart.
+func (z *Reader) addMember() {
+	idx := z.index
+	for _, c := range z.member.Checkpoints {
+		c.In += z.inBase
+		c.Out += idx.Size
+		if n := len(idx.Checkpoints); n > 0 && c.Out < idx.Checkpoints[n-1].Out+z.span {
+			continue
+		}
+		idx.Checkpoints = append(idx.Checkpoints, c)
+	}
+	idx.Size += z.member.Size
+}
+
+// A checkpointReader reads the uncompressed data of a gzip file from a
+// checkpoint in one of its members. It reads the rest of that member
+// without verifying its checksum, which covers data before the
+// checkpoint, and then any following members with a Reader.
+type checkpointReader struct {
+	br       *bufio.Reader
+	dec      io.Reader
+	inMember bool
+}
+
+func (c *checkpointReader) Read(p []byte) (int, error) {
+	n, err := c.dec.Read(p)
+	if err != io.EOF || !c.inMember {
+		return n, err
+	}
+	c.inMember = false
+	var trailer [8]byte
+	if _, err := io.ReadFull(c.br, trailer[:]); err != nil {
+		return n, noEOF(err)
+	}
+	z, err := NewReader(c.br)
+	if err != nil {
+		return n, err
+	}
+	c.dec = z
+	if n > 0 {
+		return n, nil
+	}
+	return c.Read(p)
+}
+
+func noEOF(err error) error {
+	if err == io.EOF {
+		return io.ErrUnexpectedEOF
+	}
+	return err
+}
+
+// A ReaderAt provides random access to the uncompressed data of a gzip
+// file, for which an index has been built with BuildIndex. Reading at an
+// offset resumes decompression from the closest preceding checkpoint, so
+// the checksum of the member holding that checkpoint cannot be verified.
+//
+// ReadAt may be called concurrently, as it decompresses independently of
+// other calls. Read and Seek read sequentially from a current offset,
+// keeping their decompressor from one call to the next.
+type ReaderAt struct {
+	r   io.ReaderAt
+	idx *flate.Index
+
+	off    int64     // current offset for Read and Seek
+	dec    io.Reader // decompressor for Read, at decOff
+	decOff int64
+}
+
+// NewReaderAt returns a new ReaderAt reading the gzip file from r,
+// described by idx.
+func NewReaderAt(r io.ReaderAt, idx *flate.Index) *ReaderAt {
+	return &ReaderAt{r: r, idx: idx}
+}
+
+// Size returns the length of the uncompressed data.
+func (z *ReaderAt) Size() int64 { return z.idx.Size }
+
+// open returns a decompressor positioned at the uncompressed offset off.
+func (z *ReaderAt) open(off int64) (io.Reader, error) {
+	c := z.idx.Find(off)
+	if c == nil {
+		return nil, errors.New("gzip: empty index")
+	}
+	br := bufio.NewReader(io.NewSectionReader(z.r, c.In, 1<<63-1-c.In))
+	dec := &checkpointReader{
+		br:       br,
+		dec:      flate.NewCheckpointReader(br, c),
+		inMember: true,
+	}
+	if _, err := io.CopyN(ioutil.Discard, dec, off-c.Out); err != nil {
+		return nil, noEOF(err)
+	}
+	return dec, nil
+}
+
+// ReadAt implements the io.ReaderAt interface.
+func (z *ReaderAt) ReadAt(p []byte, off int64) (n int, err error) {
+	if off < 0 {
+		return 0, errors.New("gzip.ReaderAt.ReadAt: negative offset")
+	}
+	if off >= z.idx.Size {
+		return 0, io.EOF
+	}
+	dec, err := z.open(off)
+	if err != nil {
+		return 0, err
+	}
+	n, err = io.ReadFull(dec, p)
+	if err == io.ErrUnexpectedEOF && off+int64(n) == z.idx.Size {
+		err = io.EOF
+	}
+	return n, err
+}
+
+// Read implements the io.Reader interface.
+func (z *ReaderAt) Read(p []byte) (n int, err error) {
+	if z.off >= z.idx.Size {
+		return 0, io.EOF
+	}
+	if z.dec == nil || z.decOff != z.off {
+		// Moving forward within the span of a checkpoint is cheaper
+		// than starting over from it.
+		if z.dec != nil && z.decOff < z.off && z.idx.Find(z.off).Out <= z.decOff {
+			_, err = io.CopyN(ioutil.Discard, z.dec, z.off-z.decOff)
+			err = noEOF(err)
+		} else {
+			z.dec, err = z.open(z.off)
+		}
+		if err != nil {
+			z.dec = nil
+			return 0, err
+		}
+		z.decOff = z.off
+	}
+	n, err = z.dec.Read(p)
+	z.off += int64(n)
+	z.decOff = z.off
+	return n, err
+}
+
+// Seek implements the io.Seeker interface.
+func (z *ReaderAt) Seek(offset int64, whence int) (int64, error) {
+	var abs int64
+	switch whence {
+	case 0:
+		abs = offset
+	case 1:
+		abs = z.off + offset
+	case 2:
+		abs = z.idx.Size + offset
+	default:
+		return 0, errors.New("gzip.ReaderAt.Seek: invalid whence")
+	}
+	if abs < 0 {
+		return 0, errors.New("gzip.ReaderAt.Seek: negative position")
+	}
+	z.off = abs
+	return abs, nil
+}
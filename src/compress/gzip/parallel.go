@@ -0,0 +1,355 @@
+// Copyright 2016 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package gzip
+
+import (
+	"bytes"
+	"compress/flate"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"io/ioutil"
+	"runtime"
+	"sync"
+)
+
+// DefaultBlockSize is the default size of the blocks a ParallelWriter
+// splits its input into.
+const DefaultBlockSize = 1 << 20
+
+// maxDictSize is the size of the DEFLATE window: the most of the preceding
+// data a block can refer to.
+const maxDictSize = 32 << 10
+
+// A ParallelWriter is an io.WriteCloser that produces the same format as
+// Writer, a single GZIP member, but compresses on several goroutines.
+//
+// The input is split into blocks that are compressed concurrently. Each
+// block is compressed with the end of the preceding data as a preset
+// dictionary, so the compression ratio is close to that of Writer, and
+// ends on a byte boundary, so the compressed blocks can be concatenated
+// into one DEFLATE stream. The CRC-32 of the member is combined from the
+// checksums of the blocks.
+type ParallelWriter struct {
+	Header      // written at first call to Write, Flush, or Close
+	w           io.Writer
+	level       int
+	blockSize   int
+	blocks      int
+	wroteHeader bool
+	closed      bool
+	err         error
+
+	cur     *parallelBlock   // block being filled
+	pending []*parallelBlock // blocks being compressed, in order
+	free    []*parallelBlock
+	dict    []byte // the end of the data before cur
+	digest  uint32
+	size    uint32
+
+	compressors sync.Pool
+}
+
+// A parallelBlock is a block of input and its compressed form.
+type parallelBlock struct {
+	data []byte
+	dict []byte
+	last bool
+	out  bytes.Buffer
+	crc  uint32
+	err  error
+	done chan struct{}
+}
+
+// A blockCompressor is a flate.Writer whose output can be redirected, so
+// that it can be primed with a dictionary and reused for other blocks.
+type blockCompressor struct {
+	fw  *flate.Writer
+	dst io.Writer
+}
+
+func (c *blockCompressor) Write(p []byte) (int, error) {
+	return c.dst.Write(p)
+}
+
+// NewParallelWriter returns a new ParallelWriter.
+// Writes to the returned writer are compressed and written to w.
+//
+// It is the caller's responsibility to call Close on the WriteCloser when done.
+// Writes may be buffered and not flushed until Close.
+//
+// Callers that wish to set the fields in ParallelWriter.Header must do so
+// before the first call to Write, Flush, or Close.
+func NewParallelWriter(w io.Writer) *ParallelWriter {
+	z, _ := NewParallelWriterLevel(w, DefaultCompression)
+	return z
+}
+
+// NewParallelWriterLevel is like NewParallelWriter but specifies the
+// compression level instead of assuming DefaultCompression.
+//
+// The compression level can be DefaultCompression, NoCompression, or any
+// integer value between BestSpeed and BestCompression inclusive. The error
+// returned will be nil if the level is valid.
+func NewParallelWriterLevel(w io.Writer, level int) (*ParallelWriter, error) {
+	if level < DefaultCompression || level > BestCompression {
+		return nil, fmt.Errorf("gzip: invalid compression level: %d", level)
+	}
+	z := &ParallelWriter{
+		level:     level,
+		blockSize: DefaultBlockSize,
+		blocks:    runtime.GOMAXPROCS(0),
+	}
+	z.compressors.New = func() interface{} {
+		c := new(blockCompressor)
+		c.fw, _ = flate.NewWriter(c, level)
+		return c
+	}
+	z.init(w)
+	return z, nil
+}
+
+func (z *ParallelWriter) init(w io.Writer) {
+	z.Header = Header{
+		OS: 255, // unknown
+	}
+	z.w = w
+	z.wroteHeader = false
+	z.closed = false
+	z.err = nil
+	z.dict = z.dict[:0]
+	z.digest = 0
+	z.size = 0
+}
+
+// SetConcurrency sets the size of the blocks the input is split into and
+// the number of blocks that may be compressed at the same time, which
+// default to DefaultBlockSize and GOMAXPROCS. A ParallelWriter buffers up
+// to blockSize*blocks bytes of input. SetConcurrency must be called before
+// the first call to Write, Flush, or Close.
+func (z *ParallelWriter) SetConcurrency(blockSize, blocks int) error {
+	if blockSize <= 0 || blocks <= 0 {
+		return errors.New("gzip: invalid concurrency settings")
+	}
+	if z.wroteHeader {
+		return errors.New("gzip: SetConcurrency called after writing")
+	}
+	z.blockSize = blockSize
+	z.blocks = blocks
+	z.free = nil
+	return nil
+}
+
+// Reset discards the ParallelWriter z's state and makes it equivalent to
+// the result of its original state from NewParallelWriter or
+// NewParallelWriterLevel, but writing to w instead. Blocks still being
+// compressed are waited for and discarded. This permits reusing a
+// ParallelWriter rather than allocating a new one.
+func (z *ParallelWriter) Reset(w io.Writer) {
+	for _, b := range z.pending {
+		<-b.done
+		z.release(b)
+	}
+	z.pending = z.pending[:0]
+	if z.cur != nil {
+		z.release(z.cur)
+		z.cur = nil
+	}
+	z.init(w)
+}
+
+// block returns an empty block.
+func (z *ParallelWriter) block() *parallelBlock {
+	if n := len(z.free); n > 0 {
+		b := z.free[n-1]
+		z.free = z.free[:n-1]
+		return b
+	}
+	return &parallelBlock{
+		data: make([]byte, 0, z.blockSize),
+		done: make(chan struct{}, 1),
+	}
+}
+
+// release returns b to the free list.
+func (z *ParallelWriter) release(b *parallelBlock) {
+	if cap(b.data) != z.blockSize {
+		// Left over from before SetConcurrency.
+		return
+	}
+	b.data = b.data[:0]
+	b.out.Reset()
+	b.err = nil
+	z.free = append(z.free, b)
+}
+
+// writeHeader writes the GZIP header, if it has not been written yet.
+func (z *ParallelWriter) writeHeader() error {
+	if !z.wroteHeader {
+		z.wroteHeader = true
+		z.err = writeHeader(z.w, &z.Header, z.level)
+	}
+	return z.err
+}
+
+// Write writes a compressed form of p to the underlying io.Writer. The
+// compressed bytes are not necessarily flushed until the ParallelWriter
+// is closed.
+func (z *ParallelWriter) Write(p []byte) (int, error) {
+	if z.err != nil {
+		return 0, z.err
+	}
+	if z.writeHeader() != nil {
+		return 0, z.err
+	}
+	n := len(p)
+	for len(p) > 0 {
+		if z.cur == nil {
+			z.cur = z.block()
+		}
+		b := z.cur
+		k := copy(b.data[len(b.data):cap(b.data)], p)
+		b.data = b.data[:len(b.data)+k]
+		p = p[k:]
+		if len(b.data) == cap(b.data) {
+			if z.startBlock(false) != nil {
+				return n - len(p), z.err
+			}
+		}
+	}
+	return n, nil
+}
+
+// startBlock starts compressing the current block, even if it is empty.
+// If the maximum number of blocks are already being compressed, it first
+// waits for the oldest and writes it out.
+func (z *ParallelWriter) startBlock(last bool) error {
+	b := z.cur
+	if b == nil {
+		b = z.block()
+	}
+	z.cur = nil
+	b.last = last
+	b.dict = append(b.dict[:0], z.dict...)
+
+	// Keep the end of the data, which will be the dictionary of the
+	// next block.
+	if len(b.data) >= maxDictSize {
+		z.dict = append(z.dict[:0], b.data[len(b.data)-maxDictSize:]...)
+	} else {
+		z.dict = append(z.dict, b.data...)
+		if len(z.dict) > maxDictSize {
+			z.dict = z.dict[:copy(z.dict, z.dict[len(z.dict)-maxDictSize:])]
+		}
+	}
+
+	if len(z.pending) >= z.blocks {
+		if z.writeBlocks(1) != nil {
+			z.release(b)
+			return z.err
+		}
+	}
+	z.pending = append(z.pending, b)
+	go z.compress(b)
+	return nil
+}
+
+// compress compresses b into b.out. The block ends with a final empty
+// block if it is the last, and with an empty stored block otherwise, which
+// aligns the output to a byte boundary.
+func (z *ParallelWriter) compress(b *parallelBlock) {
+	c := z.compressors.Get().(*blockCompressor)
+	// Prime the compressor with the dictionary as flate.NewWriterDict
+	// does, discarding the output.
+	c.dst = ioutil.Discard
+	c.fw.Reset(c)
+	if len(b.dict) > 0 {
+		c.fw.Write(b.dict)
+		c.fw.Flush()
+	}
+	c.dst = &b.out
+	if _, err := c.fw.Write(b.data); err != nil {
+		b.err = err
+	} else if b.last {
+		b.err = c.fw.Close()
+	} else {
+		b.err = c.fw.Flush()
+	}
+	c.dst = nil
+	z.compressors.Put(c)
+	b.crc = crc32.ChecksumIEEE(b.data)
+	b.done <- struct{}{}
+}
+
+// writeBlocks waits for the oldest n pending blocks, in order, and writes
+// them to the underlying writer.
+func (z *ParallelWriter) writeBlocks(n int) error {
+	for ; n > 0 && z.err == nil; n-- {
+		b := z.pending[0]
+		<-b.done
+		z.pending = z.pending[1:]
+		if b.err != nil {
+			z.err = b.err
+		} else {
+			_, z.err = z.w.Write(b.out.Bytes())
+		}
+		z.digest = crc32.Combine(crc32.IEEETable, z.digest, b.crc, int64(len(b.data)))
+		z.size += uint32(len(b.data))
+		z.release(b)
+	}
+	return z.err
+}
+
+// Flush flushes any pending compressed data to the underlying writer.
+// It waits for all the blocks being compressed.
+//
+// It is useful mainly in compressed network protocols, to ensure that
+// a remote reader has enough data to reconstruct a packet. Flush does
+// not return until the data has been written. If the underlying
+// writer returns an error, Flush returns that error.
+//
+// In the terminology of the zlib library, Flush is equivalent to Z_SYNC_FLUSH.
+func (z *ParallelWriter) Flush() error {
+	if z.err != nil {
+		return z.err
+	}
+	if z.closed {
+		return nil
+	}
+	if z.writeHeader() != nil {
+		return z.err
+	}
+	if z.startBlock(false) != nil {
+		return z.err
+	}
+	return z.writeBlocks(len(z.pending))
+}
+
+// Close closes the ParallelWriter, flushing any unwritten data to the
+// underlying io.Writer, but does not close the underlying io.Writer.
+func (z *ParallelWriter) Close() error {
+	if z.err != nil {
+		return z.err
+	}
+	if z.closed {
+		return nil
+	}
+	z.closed = true
+	if z.writeHeader() != nil {
+		return z.err
+	}
+	if z.startBlock(true) != nil {
+		return z.err
+	}
+	if z.writeBlocks(len(z.pending)) != nil {
+		return z.err
+	}
+	var buf [8]byte
+	put4(buf[0:4], z.digest)
+	put4(buf[4:8], z.size)
+	_, z.err = z.w.Write(buf[0:8])
+	return z.err
+}
@@ -0,0 +1,137 @@
+// Copyright 2016 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package zstd
+
+// A reverseBitReader reads a bitstream backwards, from its last byte to its
+// first. The last byte of the stream holds a marker bit, its highest set
+// bit, above which everything is padding. This is how the Huffman and FSE
+// coded streams of Zstandard are read.
+type reverseBitReader struct {
+	data []byte
+	off  int    // data[:off] has not been loaded yet
+	bits uint64 // the low cnt bits have not been read yet
+	cnt  int    // negative if more bits were read than the stream holds
+}
+
+func (br *reverseBitReader) init(data []byte) error {
+	if len(data) == 0 || data[len(data)-1] == 0 {
+		return ErrCorrupt
+	}
+	last := data[len(data)-1]
+	br.data = data
+	br.off = len(data) - 1
+	br.bits = uint64(last)
+	br.cnt = int(highBit(uint32(last)))
+	br.fill()
+	return nil
+}
+
+// fill loads as many whole bytes as fit.
+func (br *reverseBitReader) fill() {
+	for br.cnt <= 56 && br.off > 0 {
+		br.off--
+		br.bits = br.bits<<8 | uint64(br.data[br.off])
+		br.cnt += 8
+	}
+}
+
+// peek returns the next n bits, n <= 32, without consuming them. Bits past
+// the start of the stream read as zero.
+func (br *reverseBitReader) peek(n uint) uint32 {
+	if br.cnt < int(n) {
+		br.fill()
+	}
+	var v uint64
+	if shift := br.cnt - int(n); shift >= 0 {
+		v = br.bits >> uint(shift)
+	} else {
+		v = br.bits << uint(-shift)
+	}
+	return uint32(v & (1<<n - 1))
+}
+
+// skip consumes n bits.
+func (br *reverseBitReader) skip(n uint) {
+	br.cnt -= int(n)
+}
+
+// read consumes and returns the next n bits, n <= 32.
+func (br *reverseBitReader) read(n uint) uint32 {
+	v := br.peek(n)
+	br.cnt -= int(n)
+	return v
+}
+
+// overflowed reports whether more bits were read than the stream holds.
+func (br *reverseBitReader) overflowed() bool {
+	return br.cnt < 0
+}
+
+// finished reports whether the stream was read exactly to its start.
+func (br *reverseBitReader) finished() bool {
+	return br.cnt == 0 && br.off == 0
+}
+
+// A bitWriter writes a bitstream, least significant bits first. Bitstreams
+// that are read backwards are terminated with close.
+type bitWriter struct {
+	out   []byte
+	bits  uint64
+	nbits uint
+}
+
+// addBits writes the low n bits of v, n <= 32.
+func (w *bitWriter) addBits(v uint32, n uint) {
+	w.bits |= uint64(v) & (1<<n - 1) << w.nbits
+	w.nbits += n
+	for w.nbits >= 8 {
+		w.out = append(w.out, byte(w.bits))
+		w.bits >>= 8
+		w.nbits -= 8
+	}
+}
+
+// flush writes any remaining bits, padded with zeros to a byte.
+func (w *bitWriter) flush() {
+	if w.nbits > 0 {
+		w.out = append(w.out, byte(w.bits))
+		w.bits = 0
+		w.nbits = 0
+	}
+}
+
+// close writes the marker bit that ends a stream read by a
+// reverseBitReader and flushes it.
+func (w *bitWriter) close() {
+	w.addBits(1, 1)
+	w.flush()
+}
+
+// A forwardBitReader reads a bitstream from its first byte, least
+// significant bits first, as FSE table descriptions are stored.
+type forwardBitReader struct {
+	data []byte
+	pos  uint // in bits
+}
+
+// peek returns the next n bits, n <= 24, without consuming them. Bits past
+// the end of the data read as zero.
+func (br *forwardBitReader) peek(n uint) uint32 {
+	var v uint32
+	i := br.pos >> 3
+	for k := uint(0); k < 4 && int(i+k) < len(br.data); k++ {
+		v |= uint32(br.data[i+k]) << (8 * k)
+	}
+	return v >> (br.pos & 7) & (1<<n - 1)
+}
+
+func (br *forwardBitReader) skip(n uint) {
+	br.pos += n
+}
+
+// bytes returns the number of bytes the bits read so far occupy.
+func (br *forwardBitReader) bytes() int {
+	return int(br.pos+7) >> 3
+}
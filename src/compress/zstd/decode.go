@@ -0,0 +1,341 @@
+// Copyright 2016 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package zstd
+
+import "encoding/binary"
+
+// A blockDecoder decodes the compressed blocks of a frame. See RFC 8878,
+// section 3.1.1.3.
+type blockDecoder struct {
+	dict *dict
+
+	// hist holds the output of the frame, or, once it has grown beyond
+	// the window, its most recent part; then trimmed is set.
+	hist    []byte
+	trimmed bool
+
+	rep repeatOffsets
+
+	// The entropy tables of the previous block, which a block may
+	// repeat. They point to the buffers below, to the predefined tables
+	// or to those of the dictionary.
+	huff       *huffTable
+	ll, of, ml *fseDecTable
+
+	huffBuf             huffTable
+	llBuf, ofBuf, mlBuf fseDecTable
+	lits                []byte
+}
+
+// reset prepares d to decode a new frame.
+func (d *blockDecoder) reset(dict *dict) {
+	d.dict = dict
+	d.hist = d.hist[:0]
+	d.trimmed = false
+	d.rep = initialRepeatOffsets
+	d.huff, d.ll, d.of, d.ml = nil, nil, nil, nil
+	if dict != nil {
+		d.rep = dict.rep
+		d.huff, d.ll, d.of, d.ml = dict.huff, dict.ll, dict.of, dict.ml
+	}
+}
+
+// trim discards the part of the history that no longer lies within the
+// window, keeping at least window bytes.
+func (d *blockDecoder) trim(window int) {
+	if len(d.hist) < 2*window+maxBlockSize {
+		return
+	}
+	n := copy(d.hist, d.hist[len(d.hist)-window:])
+	d.hist = d.hist[:n]
+	d.trimmed = true
+}
+
+// decodeBlock appends the content of the compressed block src to d.hist.
+func (d *blockDecoder) decodeBlock(src []byte) error {
+	lits, n, err := d.decodeLiterals(src)
+	if err != nil {
+		return err
+	}
+	return d.decodeSequences(src[n:], lits)
+}
+
+// decodeLiterals decodes the literals section at the start of src. It
+// returns the literals and the size of the section.
+func (d *blockDecoder) decodeLiterals(src []byte) ([]byte, int, error) {
+	if len(src) == 0 {
+		return nil, 0, ErrCorrupt
+	}
+	typ := src[0] & 3
+	format := src[0] >> 2 & 3
+	if typ < 2 {
+		// Raw or RLE literals.
+		var size, n int
+		switch format {
+		case 0, 2:
+			size, n = int(src[0]>>3), 1
+		case 1:
+			if len(src) < 2 {
+				return nil, 0, ErrCorrupt
+			}
+			size, n = int(src[0]>>4)|int(src[1])<<4, 2
+		case 3:
+			if len(src) < 3 {
+				return nil, 0, ErrCorrupt
+			}
+			size, n = int(src[0]>>4)|int(src[1])<<4|int(src[2])<<12, 3
+		}
+		if size > maxBlockSize {
+			return nil, 0, ErrCorrupt
+		}
+		if typ == 0 {
+			if n+size > len(src) {
+				return nil, 0, ErrCorrupt
+			}
+			return src[n : n+size], n + size, nil
+		}
+		if n >= len(src) {
+			return nil, 0, ErrCorrupt
+		}
+		lits := d.litsBuf(size)
+		for i := range lits {
+			lits[i] = src[n]
+		}
+		return lits, n + 1, nil
+	}
+
+	// Huffman coded literals, with a new tree or the previous one.
+	var regen, size, n int
+	switch format {
+	case 0, 1:
+		if len(src) < 3 {
+			return nil, 0, ErrCorrupt
+		}
+		v := get3(src)
+		regen, size, n = int(v>>4&0x3ff), int(v>>14&0x3ff), 3
+	case 2:
+		if len(src) < 4 {
+			return nil, 0, ErrCorrupt
+		}
+		v := binary.LittleEndian.Uint32(src)
+		regen, size, n = int(v>>4&0x3fff), int(v>>18), 4
+	case 3:
+		if len(src) < 5 {
+			return nil, 0, ErrCorrupt
+		}
+		v := uint64(binary.LittleEndian.Uint32(src)) | uint64(src[4])<<32
+		regen, size, n = int(v>>4&0x3ffff), int(v>>22&0x3ffff), 5
+	}
+	if regen > maxBlockSize || n+size > len(src) {
+		return nil, 0, ErrCorrupt
+	}
+	data := src[n : n+size]
+	if typ == 2 {
+		k, err := d.huffBuf.read(data)
+		if err != nil {
+			return nil, 0, err
+		}
+		d.huff = &d.huffBuf
+		data = data[k:]
+	} else if d.huff == nil {
+		return nil, 0, ErrCorrupt
+	}
+
+	lits := d.litsBuf(regen)
+	if format == 0 {
+		if err := d.huff.decode(lits, data); err != nil {
+			return nil, 0, err
+		}
+		return lits, n + size, nil
+	}
+	// Four streams, preceded by the sizes of the first three.
+	if len(data) < 6 {
+		return nil, 0, ErrCorrupt
+	}
+	seg := (regen + 3) / 4
+	if 3*seg > regen {
+		return nil, 0, ErrCorrupt
+	}
+	streams := data[6:]
+	for i := 0; i < 4; i++ {
+		out := lits[i*seg:]
+		if i < 3 {
+			out = out[:seg]
+		}
+		in := streams
+		if i < 3 {
+			k := int(binary.LittleEndian.Uint16(data[2*i:]))
+			if k > len(streams) {
+				return nil, 0, ErrCorrupt
+			}
+			in, streams = streams[:k], streams[k:]
+		}
+		if err := d.huff.decode(out, in); err != nil {
+			return nil, 0, err
+		}
+	}
+	return lits, n + size, nil
+}
+
+func (d *blockDecoder) litsBuf(n int) []byte {
+	if cap(d.lits) < n {
+		d.lits = make([]byte, n, maxBlockSize)
+	}
+	return d.lits[:n]
+}
+
+// decodeSequences decodes the sequences section src and executes the
+// sequences, appending the block's content to d.hist.
+func (d *blockDecoder) decodeSequences(src []byte, lits []byte) error {
+	if len(src) == 0 {
+		return ErrCorrupt
+	}
+	var nbSeq, n int
+	switch b := int(src[0]); {
+	case b < 128:
+		nbSeq, n = b, 1
+	case b < 255:
+		if len(src) < 2 {
+			return ErrCorrupt
+		}
+		nbSeq, n = (b-128)<<8|int(src[1]), 2
+	default:
+		if len(src) < 3 {
+			return ErrCorrupt
+		}
+		nbSeq, n = int(src[1])|int(src[2])<<8+0x7f00, 3
+	}
+	if nbSeq == 0 {
+		if n != len(src) {
+			return ErrCorrupt
+		}
+		d.hist = append(d.hist, lits...)
+		return nil
+	}
+
+	if n >= len(src) || src[n]&3 != 0 {
+		return ErrCorrupt
+	}
+	modes := src[n]
+	n++
+	tables := []struct {
+		t         **fseDecTable
+		buf       *fseDecTable
+		predef    *fseDecTable
+		maxSymbol int
+		maxLog    uint
+		mode      byte
+	}{
+		{&d.ll, &d.llBuf, &llDefaultDec, maxLLCode, maxLLLog, modes >> 6},
+		{&d.of, &d.ofBuf, &ofDefaultDec, maxOFCode, maxOFLog, modes >> 4 & 3},
+		{&d.ml, &d.mlBuf, &mlDefaultDec, maxMLCode, maxMLLog, modes >> 2 & 3},
+	}
+	for _, tab := range tables {
+		switch tab.mode {
+		case 0: // predefined
+			*tab.t = tab.predef
+		case 1: // RLE
+			if n >= len(src) || int(src[n]) > tab.maxSymbol {
+				return ErrCorrupt
+			}
+			tab.buf.setRLE(src[n])
+			*tab.t = tab.buf
+			n++
+		case 2: // FSE compressed
+			norm, log, k, err := readNCount(src[n:], tab.maxSymbol, tab.maxLog)
+			if err != nil {
+				return err
+			}
+			if err := tab.buf.build(norm, log); err != nil {
+				return err
+			}
+			*tab.t = tab.buf
+			n += k
+		case 3: // repeat
+			if *tab.t == nil {
+				return ErrCorrupt
+			}
+		}
+	}
+
+	var br reverseBitReader
+	if err := br.init(src[n:]); err != nil {
+		return err
+	}
+	var llState, ofState, mlState fseDecoder
+	llState.init(&br, d.ll)
+	ofState.init(&br, d.of)
+	mlState.init(&br, d.ml)
+	start := len(d.hist)
+	for i := 0; i < nbSeq; i++ {
+		llc, ofc, mlc := llState.symbol(), ofState.symbol(), mlState.symbol()
+		ov := uint32(1)<<ofc + br.read(uint(ofc))
+		ml := mlBase[mlc] + br.read(uint(mlBits[mlc]))
+		ll := llBase[llc] + br.read(uint(llBits[llc]))
+		if i < nbSeq-1 {
+			llState.update(&br)
+			mlState.update(&br)
+			ofState.update(&br)
+		}
+		if br.overflowed() || int(ll) > len(lits) {
+			return ErrCorrupt
+		}
+		d.hist = append(d.hist, lits[:ll]...)
+		lits = lits[ll:]
+		off := d.rep.offset(ov, ll)
+		if off == 0 {
+			return ErrCorrupt
+		}
+		if err := d.copyMatch(int(off), int(ml)); err != nil {
+			return err
+		}
+		if len(d.hist)-start > maxBlockSize {
+			return ErrCorrupt
+		}
+	}
+	if !br.finished() {
+		return ErrCorrupt
+	}
+	d.hist = append(d.hist, lits...)
+	if len(d.hist)-start > maxBlockSize {
+		return ErrCorrupt
+	}
+	return nil
+}
+
+// copyMatch appends a match of length n at distance off to d.hist. A match
+// may reach back into the dictionary content.
+func (d *blockDecoder) copyMatch(off, n int) error {
+	start := len(d.hist) - off
+	if start < 0 {
+		var content []byte
+		if d.dict != nil && !d.trimmed {
+			content = d.dict.content
+		}
+		if -start > len(content) {
+			return ErrCorrupt
+		}
+		k := n
+		if k > -start {
+			k = -start
+		}
+		i := len(content) + start
+		d.hist = append(d.hist, content[i:i+k]...)
+		n -= k
+		start = 0
+	}
+	// Copy in chunks of at most off bytes, so that an overlapping match
+	// repeats the bytes already copied.
+	for n > 0 {
+		k := n
+		if k > off {
+			k = off
+		}
+		d.hist = append(d.hist, d.hist[start:start+k]...)
+		start += k
+		n -= k
+	}
+	return nil
+}
@@ -0,0 +1,76 @@
+// Copyright 2016 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package zstd
+
+import (
+	"encoding/binary"
+	"errors"
+)
+
+var errInvalidDict = errors.New("zstd: invalid dictionary")
+
+// A dict is a parsed dictionary. See RFC 8878, section 5.
+type dict struct {
+	id      uint32 // zero for a raw content dictionary
+	content []byte
+	rep     repeatOffsets
+
+	// The entropy tables that the first compressed block of a frame may
+	// repeat. They are nil for a raw content dictionary.
+	huff       *huffTable
+	ll, of, ml *fseDecTable
+}
+
+// parseDict parses b as a dictionary. If b does not start with the
+// dictionary magic number, all of it is taken as raw content.
+func parseDict(b []byte) (*dict, error) {
+	d := &dict{rep: initialRepeatOffsets}
+	if len(b) < 8 || binary.LittleEndian.Uint32(b) != dictMagic {
+		d.content = b
+		return d, nil
+	}
+	d.id = binary.LittleEndian.Uint32(b[4:])
+	b = b[8:]
+
+	d.huff = new(huffTable)
+	n, err := d.huff.read(b)
+	if err != nil {
+		return nil, errInvalidDict
+	}
+	b = b[n:]
+	tables := []struct {
+		t         **fseDecTable
+		maxSymbol int
+		maxLog    uint
+	}{
+		{&d.of, maxOFCode, maxOFLog},
+		{&d.ml, maxMLCode, maxMLLog},
+		{&d.ll, maxLLCode, maxLLLog},
+	}
+	for _, tab := range tables {
+		norm, log, n, err := readNCount(b, tab.maxSymbol, tab.maxLog)
+		if err != nil {
+			return nil, errInvalidDict
+		}
+		t := new(fseDecTable)
+		if err := t.build(norm, log); err != nil {
+			return nil, errInvalidDict
+		}
+		*tab.t = t
+		b = b[n:]
+	}
+
+	if len(b) < 12 {
+		return nil, errInvalidDict
+	}
+	d.content = b[12:]
+	for i := range d.rep {
+		d.rep[i] = binary.LittleEndian.Uint32(b[4*i:])
+		if d.rep[i] == 0 || int(d.rep[i]) > len(d.content) {
+			return nil, errInvalidDict
+		}
+	}
+	return d, nil
+}
@@ -0,0 +1,452 @@
+// Copyright 2016 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package zstd
+
+import "encoding/binary"
+
+const (
+	minMatch = 4 // the shortest match the compressor looks for
+
+	// Literals sections shorter than this are not worth Huffman coding.
+	minHuffLiterals = 32
+)
+
+type compressionLevel struct {
+	windowLog uint // the largest offset is 1<<windowLog
+	hashLog   uint // size of the hash table
+	chainLog  uint // size of the hash chains; zero for none
+	chain     int  // the most earlier positions examined per position
+	nice      int  // a match at least this long ends the search
+	lazy      bool // whether a match may be deferred for a longer one
+}
+
+var levels = []compressionLevel{
+	{windowLog: 17}, // 0: no compression
+	// Levels 1 and 2 look up a single earlier position with the same
+	// hash, and take the first match they find.
+	{19, 15, 0, 1, 32, false},
+	{20, 16, 0, 1, 48, false},
+	// Levels 3-9 follow increasingly long hash chains and defer matches
+	// when the next position has a longer one.
+	{21, 17, 16, 8, 48, true},
+	{21, 17, 17, 16, 64, true},
+	{22, 18, 18, 32, 96, true},
+	{22, 18, 19, 64, 128, true},
+	{23, 18, 20, 128, 256, true},
+	{23, 19, 21, 256, 512, true},
+	{23, 19, 22, 1024, 1024, true},
+}
+
+// A sequence is a run of literals followed by a match.
+type sequence struct {
+	litLen   uint32
+	matchLen uint32
+	offVal   uint32 // the Offset_Value coding the match offset
+}
+
+// An encoder compresses the blocks of a frame.
+type encoder struct {
+	compressionLevel
+	windowSize int
+
+	// hashHead[h] is one more than the latest position whose next four
+	// bytes hash to h, or zero. hashPrev[p&chainMask] is likewise the
+	// position before p in the same hash chain.
+	hashHead  []int32
+	hashPrev  []int32
+	chainMask int
+
+	rep repeatOffsets
+
+	// Scratch space for a block.
+	seqs                      []sequence
+	lits                      []byte
+	llCodes, mlCodes, ofCodes []uint8
+	llTable, mlTable, ofTable fseEncTable
+	norm                      []int16
+	huff                      huffEncoder
+}
+
+func (e *encoder) init(level int) {
+	e.compressionLevel = levels[level]
+	e.windowSize = 1 << e.windowLog
+	if e.hashLog > 0 {
+		e.hashHead = make([]int32, 1<<e.hashLog)
+	}
+	if e.chainLog > 0 {
+		e.hashPrev = make([]int32, 1<<e.chainLog)
+		e.chainMask = 1<<e.chainLog - 1
+	}
+}
+
+// reset prepares e to compress a new frame, whose history so far is hist,
+// either empty or the content of the dictionary d.
+func (e *encoder) reset(hist []byte, d *dict) {
+	for i := range e.hashHead {
+		e.hashHead[i] = 0
+	}
+	for i := range e.hashPrev {
+		e.hashPrev[i] = 0
+	}
+	e.rep = initialRepeatOffsets
+	if d != nil {
+		e.rep = d.rep
+	}
+	if e.hashHead != nil {
+		p := len(hist) - e.windowSize
+		if p < 0 {
+			p = 0
+		}
+		for ; p+minMatch <= len(hist); p++ {
+			e.insert(hist, p)
+		}
+	}
+}
+
+// shift accounts for the removal of the first delta bytes of the history.
+func (e *encoder) shift(delta int) {
+	for _, t := range [][]int32{e.hashHead, e.hashPrev} {
+		for i, v := range t {
+			if int(v) > delta {
+				t[i] = v - int32(delta)
+			} else {
+				t[i] = 0
+			}
+		}
+	}
+}
+
+func (e *encoder) hash(b []byte) uint32 {
+	return binary.LittleEndian.Uint32(b) * 2654435761 >> (32 - e.hashLog)
+}
+
+// insert adds position p of hist to the hash table.
+func (e *encoder) insert(hist []byte, p int) {
+	h := e.hash(hist[p:])
+	if e.hashPrev != nil {
+		e.hashPrev[p&e.chainMask] = e.hashHead[h]
+	}
+	e.hashHead[h] = int32(p + 1)
+}
+
+// matchLen returns the length of the common prefix of a and b, which is
+// no longer than b.
+func matchLen(a, b []byte) int {
+	n := 0
+	for n < len(b) && a[n] == b[n] {
+		n++
+	}
+	return n
+}
+
+// findMatch returns the longest match for hist[p:end] that it finds, among
+// the most recent offset and the earlier positions with the same hash. It
+// returns a length of zero if there is no match of at least minMatch
+// bytes.
+func (e *encoder) findMatch(hist []byte, p, end int, rep *repeatOffsets) (length, offset int) {
+	src := hist[p:end]
+	minPos := p - e.windowSize
+	if minPos < 0 {
+		minPos = 0
+	}
+	best, bestOff := minMatch-1, 0
+	if r := int(rep[0]); p-r >= minPos {
+		if n := matchLen(hist[p-r:], src); n > best {
+			best, bestOff = n, r
+		}
+	}
+	cand := int(e.hashHead[e.hash(src)]) - 1
+	for i := 0; i < e.chain && cand >= minPos && best < len(src) && best < e.nice; i++ {
+		if hist[cand+best] == src[best] {
+			if n := matchLen(hist[cand:], src); n > best {
+				best, bestOff = n, p-cand
+			}
+		}
+		if e.hashPrev == nil {
+			break
+		}
+		next := int(e.hashPrev[cand&e.chainMask]) - 1
+		if next >= cand {
+			break
+		}
+		cand = next
+	}
+	if bestOff == 0 {
+		return 0, 0
+	}
+	return best, bestOff
+}
+
+// parse finds the sequences that code hist[start:end], leaving them in
+// e.seqs and their literals in e.lits. It returns the repeat offsets as
+// they will be after the block.
+func (e *encoder) parse(hist []byte, start, end int) repeatOffsets {
+	e.seqs = e.seqs[:0]
+	e.lits = e.lits[:0]
+	rep := e.rep
+	litStart := start
+	for p := start; p+minMatch <= end; {
+		length, offset := e.findMatch(hist, p, end, &rep)
+		e.insert(hist, p)
+		if length == 0 {
+			if e.lazy {
+				p++
+			} else {
+				// Speed through data that does not compress.
+				p += 1 + (p-litStart)>>5
+			}
+			continue
+		}
+		for e.lazy && length < e.nice && p+1+minMatch <= end {
+			l, o := e.findMatch(hist, p+1, end, &rep)
+			if l <= length {
+				break
+			}
+			p++
+			e.insert(hist, p)
+			length, offset = l, o
+		}
+
+		litLen := uint32(p - litStart)
+		e.lits = append(e.lits, hist[litStart:p]...)
+		ov := rep.value(uint32(offset), litLen)
+		rep.offset(ov, litLen)
+		e.seqs = append(e.seqs, sequence{litLen, uint32(length), ov})
+		for q := p + 1; q < p+length && q+minMatch <= end; q++ {
+			e.insert(hist, q)
+		}
+		p += length
+		litStart = p
+	}
+	e.lits = append(e.lits, hist[litStart:end]...)
+	return rep
+}
+
+// encodeBlock appends to dst a block coding hist[start:], the data that
+// follows hist[:start] in the frame.
+func (e *encoder) encodeBlock(dst, hist []byte, start int, last bool) []byte {
+	src := hist[start:]
+	lastBit := uint32(0)
+	if last {
+		lastBit = 1
+	}
+	if len(src) > 1 && e.hashHead != nil {
+		rle := true
+		for _, b := range src[1:] {
+			if b != src[0] {
+				rle = false
+				break
+			}
+		}
+		if rle {
+			h := lastBit | blockTypeRLE<<1 | uint32(len(src))<<3
+			return append(dst, byte(h), byte(h>>8), byte(h>>16), src[0])
+		}
+
+		rep := e.parse(hist, start, len(hist))
+		i := len(dst)
+		dst = append(dst, 0, 0, 0)
+		dst = e.encodeLiterals(dst, e.lits)
+		dst = e.encodeSequences(dst, e.seqs)
+		if size := len(dst) - i - 3; size < len(src) {
+			h := lastBit | blockTypeCompressed<<1 | uint32(size)<<3
+			dst[i], dst[i+1], dst[i+2] = byte(h), byte(h>>8), byte(h>>16)
+			e.rep = rep
+			return dst
+		}
+		// Store the block instead, which leaves the repeat offsets as
+		// they were.
+		dst = dst[:i]
+	}
+	h := lastBit | blockTypeRaw<<1 | uint32(len(src))<<3
+	dst = append(dst, byte(h), byte(h>>8), byte(h>>16))
+	return append(dst, src...)
+}
+
+// encodeLiterals appends the literals section coding lits to dst.
+func (e *encoder) encodeLiterals(dst, lits []byte) []byte {
+	n := len(lits)
+	var counts [256]int
+	distinct := 0
+	for _, b := range lits {
+		if counts[b] == 0 {
+			distinct++
+		}
+		counts[b]++
+	}
+	if distinct == 1 && n > 1 {
+		dst = appendLiteralsHeader(dst, 1, n)
+		return append(dst, lits[0])
+	}
+	if n < minHuffLiterals || distinct < 2 {
+		return appendRawLiterals(dst, lits)
+	}
+
+	e.huff.build(&counts)
+	start := len(dst)
+	// The header holds the sizes in 10, 14 or 18 bits each. Sections of
+	// more than 1023 literals are split into four streams.
+	hsize, format, sizeBits := 3, 0, uint(10)
+	switch {
+	case n > 16383:
+		hsize, format, sizeBits = 5, 3, 18
+	case n > 1023:
+		hsize, format, sizeBits = 4, 2, 14
+	}
+	dst = append(dst, make([]byte, hsize)...)
+	dst, ok := e.huff.writeTable(dst)
+	if !ok || len(dst)-start-hsize+e.huff.size(&counts) >= n {
+		return appendRawLiterals(dst[:start], lits)
+	}
+	if format == 0 {
+		dst = e.huff.encode(dst, lits)
+	} else {
+		seg := (n + 3) / 4
+		jump := len(dst)
+		dst = append(dst, 0, 0, 0, 0, 0, 0)
+		for i := 0; i < 4; i++ {
+			part := lits[i*seg:]
+			if i < 3 {
+				part = part[:seg]
+			}
+			k := len(dst)
+			dst = e.huff.encode(dst, part)
+			if i < 3 {
+				size := len(dst) - k
+				if size > 0xffff {
+					return appendRawLiterals(dst[:start], lits)
+				}
+				binary.LittleEndian.PutUint16(dst[jump+2*i:], uint16(size))
+			}
+		}
+	}
+	size := len(dst) - start - hsize
+	if size >= n {
+		return appendRawLiterals(dst[:start], lits)
+	}
+	h := 2 | uint64(format)<<2 | uint64(n)<<4 | uint64(size)<<(4+sizeBits)
+	for i := 0; i < hsize; i++ {
+		dst[start+i] = byte(h >> (8 * uint(i)))
+	}
+	return dst
+}
+
+func appendRawLiterals(dst, lits []byte) []byte {
+	dst = appendLiteralsHeader(dst, 0, len(lits))
+	return append(dst, lits...)
+}
+
+// appendLiteralsHeader appends the header of a raw or RLE literals section
+// of size literals.
+func appendLiteralsHeader(dst []byte, typ byte, size int) []byte {
+	switch {
+	case size < 32:
+		return append(dst, typ|byte(size)<<3)
+	case size < 4096:
+		return append(dst, typ|1<<2|byte(size)<<4, byte(size>>4))
+	}
+	return append(dst, typ|3<<2|byte(size)<<4, byte(size>>4), byte(size>>12))
+}
+
+// encodeSequences appends the sequences section coding seqs to dst.
+func (e *encoder) encodeSequences(dst []byte, seqs []sequence) []byte {
+	nb := len(seqs)
+	switch {
+	case nb < 128:
+		dst = append(dst, byte(nb))
+	case nb < 0x7f00:
+		dst = append(dst, byte(nb>>8+128), byte(nb))
+	default:
+		dst = append(dst, 255, byte(nb-0x7f00), byte((nb-0x7f00)>>8))
+	}
+	if nb == 0 {
+		return dst
+	}
+
+	e.llCodes, e.mlCodes, e.ofCodes = e.llCodes[:0], e.mlCodes[:0], e.ofCodes[:0]
+	var llCounts [maxLLCode + 1]int
+	var mlCounts [maxMLCode + 1]int
+	var ofCounts [maxOFCode + 1]int
+	for _, s := range seqs {
+		llc, mlc, ofc := llCode(s.litLen), mlCode(s.matchLen), uint8(highBit(s.offVal))
+		e.llCodes = append(e.llCodes, llc)
+		e.mlCodes = append(e.mlCodes, mlc)
+		e.ofCodes = append(e.ofCodes, ofc)
+		llCounts[llc]++
+		mlCounts[mlc]++
+		ofCounts[ofc]++
+	}
+
+	modes := len(dst)
+	dst = append(dst, 0)
+	var llMode, ofMode, mlMode byte
+	var ll, of, ml *fseEncTable
+	llMode, ll, dst = e.chooseTable(dst, llCounts[:], nb, &e.llTable, llDefaultNorm, llDefaultLog, &llDefaultEnc, maxLLLog)
+	ofMode, of, dst = e.chooseTable(dst, ofCounts[:], nb, &e.ofTable, ofDefaultNorm, ofDefaultLog, &ofDefaultEnc, maxOFLog)
+	mlMode, ml, dst = e.chooseTable(dst, mlCounts[:], nb, &e.mlTable, mlDefaultNorm, mlDefaultLog, &mlDefaultEnc, maxMLLog)
+	dst[modes] = llMode<<6 | ofMode<<4 | mlMode<<2
+
+	// The decoder reads the stream backwards, so the sequences are
+	// coded from last to first.
+	w := bitWriter{out: dst}
+	var llState, ofState, mlState fseEncoder
+	last := nb - 1
+	mlState.init(ml, e.mlCodes[last])
+	ofState.init(of, e.ofCodes[last])
+	llState.init(ll, e.llCodes[last])
+	e.addExtraBits(&w, seqs[last], last)
+	for i := nb - 2; i >= 0; i-- {
+		ofState.encode(&w, e.ofCodes[i])
+		mlState.encode(&w, e.mlCodes[i])
+		llState.encode(&w, e.llCodes[i])
+		e.addExtraBits(&w, seqs[i], i)
+	}
+	mlState.flush(&w)
+	ofState.flush(&w)
+	llState.flush(&w)
+	w.close()
+	return w.out
+}
+
+// addExtraBits writes the bits that follow the codes of sequence s, the
+// i'th one.
+func (e *encoder) addExtraBits(w *bitWriter, s sequence, i int) {
+	llc, mlc, ofc := e.llCodes[i], e.mlCodes[i], e.ofCodes[i]
+	w.addBits(s.litLen-llBase[llc], uint(llBits[llc]))
+	w.addBits(s.matchLen-mlBase[mlc], uint(mlBits[mlc]))
+	w.addBits(s.offVal-1<<ofc, uint(ofc))
+}
+
+// chooseTable picks the cheapest way to code symbols with the given counts,
+// of which there are n: a single repeated symbol, the predefined
+// distribution or a distribution described in the block, which is built
+// in buf. It appends the table description, if any, to dst and returns
+// the compression mode and the encoding table.
+func (e *encoder) chooseTable(dst []byte, counts []int, n int, buf *fseEncTable, predefNorm []int16, predefLog uint, predef *fseEncTable, maxLog uint) (byte, *fseEncTable, []byte) {
+	maxSymbol, distinct := 0, 0
+	for s, c := range counts {
+		if c > 0 {
+			maxSymbol = s
+			distinct++
+		}
+	}
+	if distinct == 1 {
+		e.norm = append(e.norm[:0], make([]int16, maxSymbol+1)...)
+		e.norm[maxSymbol] = 1
+		buf.build(e.norm, 0)
+		return 1, buf, append(dst, byte(maxSymbol))
+	}
+
+	predefCost := fseCost(counts, predefNorm, predefLog)
+	log := optimalTableLog(n, maxSymbol, maxLog)
+	e.norm = normalizeCounts(e.norm, counts[:maxSymbol+1], n, log)
+	desc := writeNCount(dst, e.norm, log)
+	cost := fseCost(counts, e.norm, log) + 8*(len(desc)-len(dst))
+	if predefCost >= 0 && predefCost <= cost {
+		return 0, predef, dst
+	}
+	buf.build(e.norm, log)
+	return 2, buf, desc
+}
@@ -0,0 +1,412 @@
+// Copyright 2016 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package zstd
+
+// Finite State Entropy (FSE) coding, as used for the sequences and the
+// Huffman weights. See RFC 8878, section 4.1.
+
+const (
+	minFSELog = 5
+	maxFSELog = 9
+)
+
+// readNCount parses an FSE table description, the normalized counts of the
+// symbols, from the start of src. The accuracy log may not exceed maxLog
+// and the symbols may not exceed maxSymbol. It returns the counts, the
+// accuracy log and the size of the description in bytes.
+func readNCount(src []byte, maxSymbol int, maxLog uint) (norm []int16, log uint, n int, err error) {
+	br := forwardBitReader{data: src}
+	log = uint(br.peek(4)) + minFSELog
+	br.skip(4)
+	if log > maxLog {
+		return nil, 0, 0, ErrCorrupt
+	}
+	remaining := int32(1<<log) + 1
+	threshold := int32(1 << log)
+	nbBits := log + 1
+	prev0 := false
+	for remaining > 1 {
+		if prev0 {
+			// A zero count is followed by 2-bit repeat flags, giving
+			// the number of further zero counts.
+			for {
+				r := br.peek(2)
+				br.skip(2)
+				for i := uint32(0); i < r; i++ {
+					norm = append(norm, 0)
+				}
+				if r != 3 {
+					break
+				}
+				if len(norm) > maxSymbol {
+					return nil, 0, 0, ErrCorrupt
+				}
+			}
+		}
+		if len(norm) > maxSymbol || br.bytes() > len(src) {
+			return nil, 0, 0, ErrCorrupt
+		}
+		max := 2*threshold - 1 - remaining
+		var count int32
+		if v := int32(br.peek(nbBits)); v&(threshold-1) < max {
+			count = v & (threshold - 1)
+			br.skip(nbBits - 1)
+		} else {
+			count = v & (2*threshold - 1)
+			if count >= threshold {
+				count -= max
+			}
+			br.skip(nbBits)
+		}
+		count-- // -1 stands for a probability lower than 1
+		if count < 0 {
+			remaining += count
+		} else {
+			remaining -= count
+		}
+		norm = append(norm, int16(count))
+		prev0 = count == 0
+		for remaining < threshold && threshold > 1 {
+			nbBits--
+			threshold >>= 1
+		}
+	}
+	if remaining != 1 || br.bytes() > len(src) {
+		return nil, 0, 0, ErrCorrupt
+	}
+	return norm, log, br.bytes(), nil
+}
+
+// writeNCount appends the FSE table description of the normalized counts
+// norm, whose last count is not zero, to dst.
+func writeNCount(dst []byte, norm []int16, log uint) []byte {
+	w := bitWriter{out: dst}
+	w.addBits(uint32(log-minFSELog), 4)
+	remaining := int32(1<<log) + 1
+	threshold := int32(1 << log)
+	nbBits := log + 1
+	prev0 := false
+	for s := 0; s < len(norm) && remaining > 1; {
+		if prev0 {
+			start := s
+			for norm[s] == 0 {
+				s++
+			}
+			for s >= start+3 {
+				w.addBits(3, 2)
+				start += 3
+			}
+			w.addBits(uint32(s-start), 2)
+		}
+		count := int32(norm[s])
+		s++
+		max := 2*threshold - 1 - remaining
+		if count < 0 {
+			remaining += count
+		} else {
+			remaining -= count
+		}
+		count++
+		if count >= threshold {
+			count += max
+		}
+		if count < max {
+			w.addBits(uint32(count), nbBits-1)
+		} else {
+			w.addBits(uint32(count), nbBits)
+		}
+		prev0 = count == 1
+		for remaining < threshold {
+			nbBits--
+			threshold >>= 1
+		}
+	}
+	w.flush()
+	return w.out
+}
+
+// normalizeCounts scales the symbol counts, which sum to total, so that
+// they sum to 1<<log. Every symbol that occurs keeps a count of at least 1.
+func normalizeCounts(norm []int16, counts []int, total int, log uint) []int16 {
+	norm = norm[:0]
+	scale := 1 << log
+	sum, largest := 0, 0
+	for s, c := range counts {
+		n := 0
+		if c > 0 {
+			n = (c*scale + total/2) / total
+			if n == 0 {
+				n = 1
+			}
+			if c > counts[largest] {
+				largest = s
+			}
+		}
+		norm = append(norm, int16(n))
+		sum += n
+	}
+	for sum > scale {
+		// Take from the symbols with the largest counts, which lose
+		// the least accuracy.
+		m := 0
+		for s := range norm {
+			if norm[s] > norm[m] {
+				m = s
+			}
+		}
+		d := sum - scale
+		if d >= int(norm[m]) {
+			d = int(norm[m]) / 2
+		}
+		norm[m] -= int16(d)
+		sum -= d
+	}
+	norm[largest] += int16(scale - sum)
+	return norm
+}
+
+// optimalTableLog returns the accuracy log to code n symbols whose largest
+// value is maxSymbol, at most maxLog.
+func optimalTableLog(n int, maxSymbol int, maxLog uint) uint {
+	log := maxLog
+	if n > 1 {
+		if b := highBit(uint32(n - 1)); b >= 2 && b-2 < log {
+			log = b - 2
+		}
+	}
+	if b := highBit(uint32(maxSymbol)) + 2; b > log {
+		log = b
+	}
+	if log < minFSELog {
+		log = minFSELog
+	}
+	if log > maxLog {
+		log = maxLog
+	}
+	return log
+}
+
+type fseDecEntry struct {
+	symbol   uint8
+	nbBits   uint8
+	newState uint16
+}
+
+// An fseDecTable is an FSE decoding table. The state of a decoder is an
+// index into it.
+type fseDecTable struct {
+	entries []fseDecEntry
+	log     uint
+}
+
+// build fills t from the normalized counts norm.
+func (t *fseDecTable) build(norm []int16, log uint) error {
+	size := 1 << log
+	if cap(t.entries) < size {
+		t.entries = make([]fseDecEntry, size)
+	}
+	t.entries = t.entries[:size]
+	t.log = log
+	var next [256]uint16
+	high := size - 1
+	for s, c := range norm {
+		if c == -1 {
+			t.entries[high].symbol = uint8(s)
+			high--
+			next[s] = 1
+		} else {
+			next[s] = uint16(c)
+		}
+	}
+	mask := size - 1
+	step := size>>1 + size>>3 + 3
+	pos := 0
+	for s, c := range norm {
+		for i := 0; i < int(c); i++ {
+			t.entries[pos].symbol = uint8(s)
+			pos = (pos + step) & mask
+			for pos > high {
+				pos = (pos + step) & mask
+			}
+		}
+	}
+	if pos != 0 {
+		return ErrCorrupt
+	}
+	for i := range t.entries {
+		e := &t.entries[i]
+		ns := uint32(next[e.symbol])
+		next[e.symbol]++
+		nb := log - highBit(ns)
+		e.nbBits = uint8(nb)
+		e.newState = uint16(ns<<nb) - uint16(size)
+	}
+	return nil
+}
+
+// setRLE makes t a table that always decodes symbol without reading any
+// bits.
+func (t *fseDecTable) setRLE(symbol uint8) {
+	if cap(t.entries) < 1 {
+		t.entries = make([]fseDecEntry, 1)
+	}
+	t.entries = t.entries[:1]
+	t.entries[0] = fseDecEntry{symbol: symbol}
+	t.log = 0
+}
+
+type fseDecoder struct {
+	t     *fseDecTable
+	state uint32
+}
+
+func (d *fseDecoder) init(br *reverseBitReader, t *fseDecTable) {
+	d.t = t
+	d.state = br.read(t.log)
+}
+
+func (d *fseDecoder) symbol() uint8 {
+	return d.t.entries[d.state].symbol
+}
+
+func (d *fseDecoder) update(br *reverseBitReader) {
+	e := &d.t.entries[d.state]
+	d.state = uint32(e.newState) + br.read(uint(e.nbBits))
+}
+
+type fseSymbolTransform struct {
+	deltaNbBits    uint32
+	deltaFindState int32
+}
+
+// An fseEncTable is an FSE encoding table.
+type fseEncTable struct {
+	stateTable []uint16
+	symbolTT   []fseSymbolTransform
+	log        uint
+}
+
+// build fills t from the normalized counts norm.
+func (t *fseEncTable) build(norm []int16, log uint) {
+	size := 1 << log
+	t.log = log
+	if cap(t.stateTable) < size {
+		t.stateTable = make([]uint16, size)
+	}
+	t.stateTable = t.stateTable[:size]
+	if cap(t.symbolTT) < len(norm) {
+		t.symbolTT = make([]fseSymbolTransform, len(norm))
+	}
+	t.symbolTT = t.symbolTT[:len(norm)]
+
+	// Spread the symbols as the decoder does.
+	symbols := make([]uint8, size)
+	var cumul [257]int
+	high := size - 1
+	for s, c := range norm {
+		if c == -1 {
+			cumul[s+1] = cumul[s] + 1
+			symbols[high] = uint8(s)
+			high--
+		} else {
+			cumul[s+1] = cumul[s] + int(c)
+		}
+	}
+	mask := size - 1
+	step := size>>1 + size>>3 + 3
+	pos := 0
+	for s, c := range norm {
+		for i := 0; i < int(c); i++ {
+			symbols[pos] = uint8(s)
+			pos = (pos + step) & mask
+			for pos > high {
+				pos = (pos + step) & mask
+			}
+		}
+	}
+	for u, s := range symbols {
+		t.stateTable[cumul[s]] = uint16(size + u)
+		cumul[s]++
+	}
+
+	total := 0
+	for s, c := range norm {
+		tt := &t.symbolTT[s]
+		switch c {
+		case 0:
+			tt.deltaNbBits = uint32((log+1)<<16 - 1<<log)
+		case -1, 1:
+			tt.deltaNbBits = uint32(log<<16 - 1<<log)
+			tt.deltaFindState = int32(total - 1)
+			total++
+		default:
+			maxBitsOut := log - highBit(uint32(c-1))
+			minStatePlus := uint32(c) << maxBitsOut
+			tt.deltaNbBits = uint32(maxBitsOut<<16) - minStatePlus
+			tt.deltaFindState = int32(total - int(c))
+			total += int(c)
+		}
+	}
+}
+
+type fseEncoder struct {
+	t     *fseEncTable
+	value uint32
+}
+
+// init sets the state to one that encodes symbol, without writing bits.
+func (e *fseEncoder) init(t *fseEncTable, symbol uint8) {
+	e.t = t
+	tt := t.symbolTT[symbol]
+	nbBitsOut := (tt.deltaNbBits + 1<<15) >> 16
+	v := nbBitsOut<<16 - tt.deltaNbBits
+	e.value = uint32(t.stateTable[int32(v>>nbBitsOut)+tt.deltaFindState])
+}
+
+func (e *fseEncoder) encode(w *bitWriter, symbol uint8) {
+	tt := e.t.symbolTT[symbol]
+	nbBitsOut := (e.value + tt.deltaNbBits) >> 16
+	w.addBits(e.value, uint(nbBitsOut))
+	e.value = uint32(e.t.stateTable[int32(e.value>>nbBitsOut)+tt.deltaFindState])
+}
+
+// flush writes the final state.
+func (e *fseEncoder) flush(w *bitWriter) {
+	w.addBits(e.value, e.t.log)
+}
+
+// fseCost returns an estimate, in bits, of coding symbols with the given
+// counts using the normalized counts norm, or -1 if some symbol cannot be
+// coded.
+func fseCost(counts []int, norm []int16, log uint) int {
+	bits := 0
+	for s, c := range counts {
+		if c == 0 {
+			continue
+		}
+		if s >= len(norm) || norm[s] == 0 {
+			return -1
+		}
+		n := uint32(1)
+		if norm[s] > 0 {
+			n = uint32(norm[s])
+		}
+		// log - log2(n), in 1/16 bits
+		bits += c * (int(log)*16 - log2x16(n))
+	}
+	return bits / 16
+}
+
+// log2x16 returns approximately 16 times the base 2 logarithm of n.
+func log2x16(n uint32) int {
+	h := highBit(n)
+	frac := 0
+	if h >= 4 {
+		frac = int(n>>(h-4)) & 15
+	} else {
+		frac = int(n<<(4-h)) & 15
+	}
+	return int(h)*16 + frac
+}
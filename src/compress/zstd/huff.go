@@ -0,0 +1,386 @@
+// Copyright 2016 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package zstd
+
+import "sort"
+
+// Huffman coding of literals. See RFC 8878, section 4.2.
+
+const (
+	maxHuffBits      = 11
+	maxHuffWeightLog = 6
+)
+
+type huffEntry struct {
+	symbol uint8
+	nbBits uint8
+}
+
+// A huffTable is a Huffman decoding table, indexed by the next maxBits
+// bits of a stream.
+type huffTable struct {
+	entries []huffEntry
+	maxBits uint
+}
+
+// readHuffWeights parses a Huffman tree description from the start of src
+// into weights, which receives the weights of all the symbols but the
+// last. It returns the number of weights and the size of the description.
+func readHuffWeights(src []byte, weights *[256]uint8) (nw int, n int, err error) {
+	if len(src) == 0 {
+		return 0, 0, ErrCorrupt
+	}
+	hb := int(src[0])
+	if hb >= 128 {
+		// The weights are stored directly, 4 bits each.
+		nw = hb - 127
+		n = 1 + (nw+1)/2
+		if n > len(src) {
+			return 0, 0, ErrCorrupt
+		}
+		for i := 0; i < nw; i++ {
+			b := src[1+i/2]
+			if i%2 == 0 {
+				weights[i] = b >> 4
+			} else {
+				weights[i] = b & 15
+			}
+		}
+		return nw, n, nil
+	}
+
+	// The weights are FSE coded, with two interleaved states.
+	n = 1 + hb
+	if hb == 0 || n > len(src) {
+		return 0, 0, ErrCorrupt
+	}
+	src = src[1:n]
+	norm, log, k, err := readNCount(src, maxHuffBits+1, maxHuffWeightLog)
+	if err != nil {
+		return 0, 0, err
+	}
+	var t fseDecTable
+	if err := t.build(norm, log); err != nil {
+		return 0, 0, err
+	}
+	var br reverseBitReader
+	if err := br.init(src[k:]); err != nil {
+		return 0, 0, err
+	}
+	var s1, s2 fseDecoder
+	s1.init(&br, &t)
+	s2.init(&br, &t)
+	for {
+		if nw+2 > 255 {
+			return 0, 0, ErrCorrupt
+		}
+		weights[nw] = s1.symbol()
+		nw++
+		s1.update(&br)
+		if br.overflowed() {
+			weights[nw] = s2.symbol()
+			nw++
+			break
+		}
+		weights[nw] = s2.symbol()
+		nw++
+		s2.update(&br)
+		if br.overflowed() {
+			weights[nw] = s1.symbol()
+			nw++
+			break
+		}
+	}
+	return nw, n, nil
+}
+
+// readHuffTable parses a Huffman tree description from the start of src
+// into t. It returns the size of the description.
+func (t *huffTable) read(src []byte) (int, error) {
+	var weights [256]uint8
+	nw, n, err := readHuffWeights(src, &weights)
+	if err != nil {
+		return 0, err
+	}
+	sum := uint32(0)
+	for _, w := range weights[:nw] {
+		if w > maxHuffBits {
+			return 0, ErrCorrupt
+		}
+		if w > 0 {
+			sum += 1 << (w - 1)
+		}
+	}
+	if sum == 0 {
+		return 0, ErrCorrupt
+	}
+	// The weight of the last symbol makes the sum a power of two.
+	maxBits := highBit(sum) + 1
+	left := uint32(1)<<maxBits - sum
+	if maxBits > maxHuffBits || left&(left-1) != 0 {
+		return 0, ErrCorrupt
+	}
+	weights[nw] = uint8(highBit(left) + 1)
+	nw++
+
+	size := 1 << maxBits
+	if cap(t.entries) < size {
+		t.entries = make([]huffEntry, size)
+	}
+	t.entries = t.entries[:size]
+	t.maxBits = maxBits
+	pos := 0
+	for w := uint(1); w <= maxBits; w++ {
+		for s, sw := range weights[:nw] {
+			if uint(sw) != w {
+				continue
+			}
+			e := huffEntry{symbol: uint8(s), nbBits: uint8(maxBits + 1 - w)}
+			for i := 0; i < 1<<(w-1); i++ {
+				t.entries[pos] = e
+				pos++
+			}
+		}
+	}
+	return n, nil
+}
+
+// decode decodes the stream src into dst, which it fills.
+func (t *huffTable) decode(dst, src []byte) error {
+	var br reverseBitReader
+	if err := br.init(src); err != nil {
+		return err
+	}
+	for i := range dst {
+		e := t.entries[br.peek(t.maxBits)]
+		dst[i] = e.symbol
+		br.skip(uint(e.nbBits))
+	}
+	if !br.finished() {
+		return ErrCorrupt
+	}
+	return nil
+}
+
+// A huffEncoder holds the prefix codes of the symbols of a literals
+// section.
+type huffEncoder struct {
+	codes     [256]uint16
+	lens      [256]uint8
+	maxSymbol int
+	maxBits   uint
+}
+
+// build computes length-limited prefix codes for the symbols with the
+// given counts. At least two symbols must occur.
+func (e *huffEncoder) build(counts *[256]int) {
+	var syms []int
+	e.maxSymbol = 0
+	for s, c := range counts {
+		e.lens[s] = 0
+		if c > 0 {
+			syms = append(syms, s)
+			e.maxSymbol = s
+		}
+	}
+	sort.Sort(byCount{syms, counts})
+
+	// Build a Huffman tree with the two-queue method. Nodes below
+	// len(syms) are leaves, in syms order; the others are internal and
+	// created in order of increasing count.
+	n := len(syms)
+	weight := make([]int, 2*n-1)
+	parent := make([]int, 2*n-1)
+	for i, s := range syms {
+		weight[i] = counts[s]
+	}
+	leaf, inner := 0, n
+	pick := func(next int) int {
+		if leaf < n && (inner >= next || weight[leaf] <= weight[inner]) {
+			leaf++
+			return leaf - 1
+		}
+		inner++
+		return inner - 1
+	}
+	for next := n; next < 2*n-1; next++ {
+		a := pick(next)
+		b := pick(next)
+		weight[next] = weight[a] + weight[b]
+		parent[a], parent[b] = next, next
+	}
+	depth := weight // reuse
+	depth[2*n-2] = 0
+	for i := 2*n - 3; i >= 0; i-- {
+		depth[i] = depth[parent[i]] + 1
+	}
+
+	// Limit the code lengths to maxHuffBits. Lengthen the longest codes
+	// until the Kraft sum fits, then shorten codes until it is exactly
+	// full, as the format requires a complete code.
+	const one = 1 << maxHuffBits
+	kraft := 0
+	for i := range syms {
+		if depth[i] > maxHuffBits {
+			depth[i] = maxHuffBits
+		}
+		kraft += one >> uint(depth[i])
+	}
+	for kraft > one {
+		// The least frequent symbol with the longest code that can
+		// still grow comes first in syms order.
+		best := -1
+		for i := range syms {
+			if depth[i] < maxHuffBits && (best < 0 || depth[i] > depth[best]) {
+				best = i
+			}
+		}
+		kraft -= one >> uint(depth[best]+1)
+		depth[best]++
+	}
+	for kraft < one {
+		best := -1
+		for i := n - 1; i >= 0; i-- {
+			if one>>uint(depth[i]) <= one-kraft && (best < 0 || depth[i] > depth[best]) {
+				best = i
+			}
+		}
+		kraft += one >> uint(depth[best])
+		depth[best]--
+	}
+
+	e.maxBits = 0
+	for i, s := range syms {
+		e.lens[s] = uint8(depth[i])
+		if uint(depth[i]) > e.maxBits {
+			e.maxBits = uint(depth[i])
+		}
+	}
+	// Assign the codes as the decoder lays out its table: by increasing
+	// weight, then by symbol.
+	pos := 0
+	for w := uint(1); w <= e.maxBits; w++ {
+		for s := 0; s <= e.maxSymbol; s++ {
+			if e.lens[s] != 0 && e.weight(s) == w {
+				e.codes[s] = uint16(pos >> (w - 1))
+				pos += 1 << (w - 1)
+			}
+		}
+	}
+}
+
+// weight returns the weight of symbol s, or 0 if it does not occur.
+func (e *huffEncoder) weight(s int) uint {
+	if e.lens[s] == 0 {
+		return 0
+	}
+	return e.maxBits + 1 - uint(e.lens[s])
+}
+
+// writeTable appends the Huffman tree description of e to dst. It reports
+// false if the description cannot be represented.
+func (e *huffEncoder) writeTable(dst []byte) ([]byte, bool) {
+	nw := e.maxSymbol // the weight of the last symbol is implied
+	if nw <= 128 {
+		dst = append(dst, byte(127+nw))
+		for i := 0; i < nw; i += 2 {
+			b := byte(e.weight(i)) << 4
+			if i+1 < nw {
+				b |= byte(e.weight(i + 1))
+			}
+			dst = append(dst, b)
+		}
+		return dst, true
+	}
+
+	// Compress the weights with FSE.
+	var counts [maxHuffBits + 1]int
+	weights := make([]uint8, nw)
+	maxWeight := 0
+	for i := range weights {
+		w := e.weight(i)
+		weights[i] = uint8(w)
+		counts[w]++
+		if int(w) > maxWeight {
+			maxWeight = int(w)
+		}
+	}
+	log := optimalTableLog(nw, maxWeight, maxHuffWeightLog)
+	norm := normalizeCounts(nil, counts[:maxWeight+1], nw, log)
+	start := len(dst)
+	dst = append(dst, 0)
+	dst = writeNCount(dst, norm, log)
+	var t fseEncTable
+	t.build(norm, log)
+	w := bitWriter{out: dst}
+	var s1, s2 fseEncoder
+	i := nw
+	if i&1 != 0 {
+		s1.init(&t, weights[i-1])
+		s2.init(&t, weights[i-2])
+		s1.encode(&w, weights[i-3])
+		i -= 3
+	} else {
+		s2.init(&t, weights[i-1])
+		s1.init(&t, weights[i-2])
+		i -= 2
+	}
+	for i > 0 {
+		s2.encode(&w, weights[i-1])
+		s1.encode(&w, weights[i-2])
+		i -= 2
+	}
+	s2.flush(&w)
+	s1.flush(&w)
+	w.close()
+	dst = w.out
+	size := len(dst) - start - 1
+	if size >= 128 {
+		return dst[:start], false
+	}
+	dst[start] = byte(size)
+
+	// A transition that reads no bits at the end of the stream makes it
+	// decode to a different number of weights; check the result.
+	var got [256]uint8
+	if n, _, err := readHuffWeights(dst[start:], &got); err != nil || n != nw {
+		return dst[:start], false
+	}
+	return dst, true
+}
+
+// encode appends the stream coding src to dst.
+func (e *huffEncoder) encode(dst, src []byte) []byte {
+	w := bitWriter{out: dst}
+	for i := len(src) - 1; i >= 0; i-- {
+		s := src[i]
+		w.addBits(uint32(e.codes[s]), uint(e.lens[s]))
+	}
+	w.close()
+	return w.out
+}
+
+// size returns the size in bytes of the stream coding the symbols with the
+// given counts.
+func (e *huffEncoder) size(counts *[256]int) int {
+	bits := 0
+	for s, c := range counts {
+		bits += c * int(e.lens[s])
+	}
+	return bits/8 + 1
+}
+
+// byCount sorts symbols by increasing count, then by increasing value.
+type byCount struct {
+	syms   []int
+	counts *[256]int
+}
+
+func (s byCount) Len() int { return len(s.syms) }
+func (s byCount) Less(i, j int) bool {
+	ci, cj := s.counts[s.syms[i]], s.counts[s.syms[j]]
+	return ci < cj || ci == cj && s.syms[i] < s.syms[j]
+}
+func (s byCount) Swap(i, j int) { s.syms[i], s.syms[j] = s.syms[j], s.syms[i] }
@@ -0,0 +1,282 @@
+// Copyright 2016 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package zstd
+
+import (
+	"encoding/binary"
+	"io"
+	"io/ioutil"
+)
+
+// A Reader is an io.Reader that can be read to retrieve uncompressed data
+// from a Zstandard stream.
+//
+// A stream may be a concatenation of frames. Reads from the Reader return
+// the concatenation of their contents; skippable frames are ignored.
+//
+// Frames may carry a checksum of their content. The Reader will return
+// ErrChecksum when it reaches the end of a frame whose content does not
+// match its checksum, before returning that frame's last block. Clients
+// should treat data returned by Read as tentative until they receive the
+// io.EOF marking the end of the data.
+//
+// The Reader does not read past the end of the last frame, so the
+// underlying reader is left positioned just after the stream.
+type Reader struct {
+	r    io.Reader
+	dict *dict
+	err  error
+
+	// State of the current frame.
+	inFrame  bool
+	checksum bool
+	window   int
+	size     int64 // declared content size, or -1 if unknown
+	produced int64
+	digest   xxhash64
+
+	dec blockDecoder
+	out []byte // decoded data not yet returned by Read
+	in  []byte
+	buf [16]byte
+}
+
+// NewReader creates a new Reader reading the given reader. It reads the
+// header of the first frame.
+//
+// It is the caller's responsibility to call Close on the Reader when done.
+func NewReader(r io.Reader) (*Reader, error) {
+	return NewReaderDict(r, nil)
+}
+
+// NewReaderDict is like NewReader but decodes the frames with the
+// dictionary dict. The dictionary is either in the format of the reference
+// implementation, whose identifier must match the one recorded in the
+// frames, or raw content. Frames that require a different dictionary fail
+// with ErrDictionary.
+func NewReaderDict(r io.Reader, dict []byte) (*Reader, error) {
+	z := new(Reader)
+	if dict != nil {
+		d, err := parseDict(dict)
+		if err != nil {
+			return nil, err
+		}
+		z.dict = d
+	}
+	if err := z.Reset(r); err != nil {
+		return nil, err
+	}
+	return z, nil
+}
+
+// Reset discards the Reader z's state and makes it equivalent to the
+// result of its original state from NewReader or NewReaderDict, but
+// reading from r instead. This permits reusing a Reader rather than
+// allocating a new one.
+func (z *Reader) Reset(r io.Reader) error {
+	z.r = r
+	z.out = nil
+	z.inFrame = false
+	z.err = z.readHeader()
+	return z.err
+}
+
+// readHeader reads the header of the next frame, skipping any skippable
+// frames. It returns io.EOF if the input ends before the next frame.
+func (z *Reader) readHeader() error {
+	for {
+		if _, err := io.ReadFull(z.r, z.buf[:4]); err != nil {
+			return err
+		}
+		magic := binary.LittleEndian.Uint32(z.buf[:4])
+		if magic == frameMagic {
+			break
+		}
+		if magic&skippableMagicMask != skippableMagic {
+			return ErrHeader
+		}
+		if _, err := io.ReadFull(z.r, z.buf[:4]); err != nil {
+			return noEOF(err)
+		}
+		n := int64(binary.LittleEndian.Uint32(z.buf[:4]))
+		if _, err := io.CopyN(ioutil.Discard, z.r, n); err != nil {
+			return noEOF(err)
+		}
+	}
+
+	if _, err := io.ReadFull(z.r, z.buf[:1]); err != nil {
+		return noEOF(err)
+	}
+	fhd := z.buf[0]
+	if fhd&0x08 != 0 {
+		return ErrHeader
+	}
+	single := fhd&0x20 != 0
+	dictSize := [4]int{0, 1, 2, 4}[fhd&3]
+	fcsSize := [4]int{0, 2, 4, 8}[fhd>>6]
+	if single && fcsSize == 0 {
+		fcsSize = 1
+	}
+	n := dictSize + fcsSize
+	if !single {
+		n++
+	}
+	p := z.buf[:n]
+	if _, err := io.ReadFull(z.r, p); err != nil {
+		return noEOF(err)
+	}
+
+	var window uint64
+	if !single {
+		exp, mantissa := uint(p[0]>>3), uint64(p[0]&7)
+		base := uint64(1) << (minWindowLog + exp)
+		window = base + base/8*mantissa
+		p = p[1:]
+	}
+	var dictID uint32
+	for i := dictSize - 1; i >= 0; i-- {
+		dictID = dictID<<8 | uint32(p[i])
+	}
+	p = p[dictSize:]
+	z.size = -1
+	if fcsSize > 0 {
+		var fcs uint64
+		for i := fcsSize - 1; i >= 0; i-- {
+			fcs = fcs<<8 | uint64(p[i])
+		}
+		if fcsSize == 2 {
+			fcs += 256
+		}
+		if fcs > 1<<62 {
+			return ErrHeader
+		}
+		z.size = int64(fcs)
+		if single {
+			window = fcs
+		}
+	}
+	if window > maxWindowSize {
+		return ErrWindowSize
+	}
+	if dictID != 0 && (z.dict == nil || z.dict.id != dictID) {
+		return ErrDictionary
+	}
+
+	z.inFrame = true
+	z.checksum = fhd&0x04 != 0
+	z.window = int(window)
+	z.produced = 0
+	z.digest.reset()
+	z.dec.reset(z.dict)
+	return nil
+}
+
+// readBlock reads and decodes the next block into z.out, first reading the
+// header of the next frame if needed.
+func (z *Reader) readBlock() error {
+	if !z.inFrame {
+		if err := z.readHeader(); err != nil {
+			return err
+		}
+	}
+	if _, err := io.ReadFull(z.r, z.buf[:3]); err != nil {
+		return noEOF(err)
+	}
+	h := get3(z.buf[:3])
+	last := h&1 != 0
+	size := int(h >> 3)
+	maxSize := maxBlockSize
+	if z.window < maxSize {
+		maxSize = z.window
+	}
+	if size > maxSize {
+		return ErrCorrupt
+	}
+
+	z.dec.trim(z.window)
+	start := len(z.dec.hist)
+	switch h >> 1 & 3 {
+	case blockTypeRaw:
+		hist := append(z.dec.hist, make([]byte, size)...)
+		if _, err := io.ReadFull(z.r, hist[start:]); err != nil {
+			return noEOF(err)
+		}
+		z.dec.hist = hist
+	case blockTypeRLE:
+		if _, err := io.ReadFull(z.r, z.buf[:1]); err != nil {
+			return noEOF(err)
+		}
+		for i := 0; i < size; i++ {
+			z.dec.hist = append(z.dec.hist, z.buf[0])
+		}
+	case blockTypeCompressed:
+		if cap(z.in) < size {
+			z.in = make([]byte, size, maxBlockSize)
+		}
+		z.in = z.in[:size]
+		if _, err := io.ReadFull(z.r, z.in); err != nil {
+			return noEOF(err)
+		}
+		if err := z.dec.decodeBlock(z.in); err != nil {
+			return err
+		}
+	default:
+		return ErrCorrupt
+	}
+
+	z.out = z.dec.hist[start:]
+	z.digest.write(z.out)
+	z.produced += int64(len(z.out))
+	if z.size >= 0 && z.produced > z.size {
+		return ErrCorrupt
+	}
+	if last {
+		z.inFrame = false
+		if z.size >= 0 && z.produced != z.size {
+			return ErrCorrupt
+		}
+		if z.checksum {
+			if _, err := io.ReadFull(z.r, z.buf[:4]); err != nil {
+				return noEOF(err)
+			}
+			if binary.LittleEndian.Uint32(z.buf[:4]) != uint32(z.digest.sum64()) {
+				return ErrChecksum
+			}
+		}
+	}
+	return nil
+}
+
+func (z *Reader) Read(p []byte) (n int, err error) {
+	if z.err != nil {
+		return 0, z.err
+	}
+	if len(p) == 0 {
+		return 0, nil
+	}
+	for len(z.out) == 0 {
+		if z.err = z.readBlock(); z.err != nil {
+			return 0, z.err
+		}
+	}
+	n = copy(p, z.out)
+	z.out = z.out[n:]
+	return n, nil
+}
+
+// Close closes the Reader. It does not close the underlying io.Reader.
+func (z *Reader) Close() error {
+	if z.err == io.ErrUnexpectedEOF {
+		return z.err
+	}
+	return nil
+}
+
+func noEOF(err error) error {
+	if err == io.EOF {
+		return io.ErrUnexpectedEOF
+	}
+	return err
+}
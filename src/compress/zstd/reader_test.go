@@ -0,0 +1,143 @@
+// Copyright 2016 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package zstd
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"testing"
+)
+
+// The frames in testdata were made with the reference zstd tool, v1.5.6:
+//
+//	zstd -19 --check source.txt -o source.txt.zst
+//	zstd -3 --no-check < source.txt > source.txt.stream.zst
+//	zstd -3 --no-check -D small.txt source.txt -o source.txt.rawdict.zst
+//	zstd -1 --check small.txt -o small.txt.zst
+//	zstd -3 --check -D dict small.txt -o small.txt.dict.zst
+//	head -c 300000 /dev/zero | zstd -19 --check > zeros.zst
+//
+// The dictionary was trained with zstd --train --maxdict=8192
+// --dictID=1234567 on Go source files.
+var readerTests = []struct {
+	desc       string
+	compressed string
+	dict       string
+	want       string
+}{
+	{
+		"single segment, multiple blocks, checksum",
+		"source.txt.zst", "", "source.txt",
+	},
+	{
+		"window descriptor, no content size, no checksum",
+		"source.txt.stream.zst", "", "source.txt",
+	},
+	{
+		"raw content dictionary",
+		"source.txt.rawdict.zst", "small.txt", "source.txt",
+	},
+	{
+		"single block, checksum",
+		"small.txt.zst", "", "small.txt",
+	},
+	{
+		"trained dictionary",
+		"small.txt.dict.zst", "dict", "small.txt",
+	},
+}
+
+func readFile(t *testing.T, name string) []byte {
+	if name == "" {
+		return nil
+	}
+	b, err := ioutil.ReadFile("testdata/" + name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return b
+}
+
+func decompress(compressed, dict []byte) ([]byte, error) {
+	z, err := NewReaderDict(bytes.NewReader(compressed), dict)
+	if err != nil {
+		return nil, err
+	}
+	defer z.Close()
+	return ioutil.ReadAll(z)
+}
+
+func TestReader(t *testing.T) {
+	for _, tt := range readerTests {
+		compressed, dict := readFile(t, tt.compressed), readFile(t, tt.dict)
+		want := readFile(t, tt.want)
+		got, err := decompress(compressed, dict)
+		if err != nil {
+			t.Errorf("%s: %v", tt.desc, err)
+			continue
+		}
+		if !bytes.Equal(got, want) {
+			t.Errorf("%s: got %d bytes, want %d matching bytes", tt.desc, len(got), len(want))
+		}
+	}
+}
+
+func TestReaderZeros(t *testing.T) {
+	got, err := decompress(readFile(t, "zeros.zst"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := make([]byte, 300000); !bytes.Equal(got, want) {
+		t.Errorf("got %d bytes, want %d zero bytes", len(got), len(want))
+	}
+}
+
+func TestReaderMultipleFrames(t *testing.T) {
+	small := readFile(t, "small.txt")
+	frame := readFile(t, "small.txt.zst")
+	skippable := []byte{0x5a, 0x2a, 0x4d, 0x18, 3, 0, 0, 0, 'a', 'b', 'c'}
+
+	var in bytes.Buffer
+	in.Write(skippable)
+	in.Write(frame)
+	in.Write(skippable)
+	in.Write(frame)
+	got, err := decompress(in.Bytes(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := append(append([]byte{}, small...), small...); !bytes.Equal(got, want) {
+		t.Errorf("got %d bytes, want %d matching bytes", len(got), len(want))
+	}
+}
+
+func TestReaderErrors(t *testing.T) {
+	frame := readFile(t, "small.txt.zst")
+
+	bad := append([]byte{}, frame...)
+	bad[len(bad)-1] ^= 0xff
+	if _, err := decompress(bad, nil); err != ErrChecksum {
+		t.Errorf("corrupt checksum: got %v, want %v", err, ErrChecksum)
+	}
+
+	if _, err := decompress(frame[:len(frame)-10], nil); err != io.ErrUnexpectedEOF {
+		t.Errorf("truncated frame: got %v, want %v", err, io.ErrUnexpectedEOF)
+	}
+
+	bad = append([]byte{}, frame...)
+	bad[0] ^= 0xff
+	if _, err := decompress(bad, nil); err != ErrHeader {
+		t.Errorf("bad magic: got %v, want %v", err, ErrHeader)
+	}
+
+	dictFrame := readFile(t, "small.txt.dict.zst")
+	if _, err := decompress(dictFrame, nil); err != ErrDictionary {
+		t.Errorf("missing dictionary: got %v, want %v", err, ErrDictionary)
+	}
+	if _, err := decompress(dictFrame, readFile(t, "small.txt")); err != ErrDictionary {
+		t.Errorf("wrong dictionary: got %v, want %v", err, ErrDictionary)
+	}
+}
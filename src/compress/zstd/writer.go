@@ -0,0 +1,240 @@
+// Copyright 2016 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package zstd
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+)
+
+// A Writer is an io.WriteCloser.
+// Writes to a Writer are compressed and written to w.
+type Writer struct {
+	w     io.Writer
+	level int
+	dict  *dict
+	enc   encoder
+
+	// hist holds the data written so far that is still within the
+	// window, preceded by the dictionary content; hist[pos:] has not
+	// been compressed yet.
+	hist []byte
+	pos  int
+
+	wroteHeader bool
+	digest      xxhash64
+	closed      bool
+	out         []byte
+	err         error
+}
+
+// NewWriter returns a new Writer.
+// Writes to the returned writer are compressed and written to w.
+//
+// It is the caller's responsibility to call Close on the WriteCloser when done.
+// Writes may be buffered and not flushed until Close.
+func NewWriter(w io.Writer) *Writer {
+	z, _ := NewWriterLevel(w, DefaultCompression)
+	return z
+}
+
+// NewWriterLevel is like NewWriter but specifies the compression level instead
+// of assuming DefaultCompression.
+//
+// The compression level can be DefaultCompression, NoCompression, or any
+// integer value between BestSpeed and BestCompression inclusive. The error
+// returned will be nil if the level is valid.
+func NewWriterLevel(w io.Writer, level int) (*Writer, error) {
+	return NewWriterDict(w, level, nil)
+}
+
+// NewWriterDict is like NewWriterLevel but compresses with the dictionary
+// dict, which must also be given to the Reader. The dictionary is either
+// in the format of the reference implementation, whose identifier is
+// recorded in the frame, or raw content.
+func NewWriterDict(w io.Writer, level int, dict []byte) (*Writer, error) {
+	if level < DefaultCompression || level > BestCompression {
+		return nil, fmt.Errorf("zstd: invalid compression level: %d", level)
+	}
+	if level == DefaultCompression {
+		level = defaultLevel
+	}
+	z := &Writer{level: level}
+	if dict != nil {
+		d, err := parseDict(dict)
+		if err != nil {
+			return nil, err
+		}
+		z.dict = d
+	}
+	z.enc.init(level)
+	z.Reset(w)
+	return z, nil
+}
+
+// Reset discards the Writer z's state and makes it equivalent to the
+// result of its original state from NewWriter, NewWriterLevel or
+// NewWriterDict, but writing to w instead. This permits reusing a Writer
+// rather than allocating a new one.
+func (z *Writer) Reset(w io.Writer) {
+	z.w = w
+	z.hist = z.hist[:0]
+	if z.dict != nil {
+		z.hist = append(z.hist, z.dict.content...)
+	}
+	z.pos = len(z.hist)
+	z.enc.reset(z.hist, z.dict)
+	z.wroteHeader = false
+	z.digest.reset()
+	z.closed = false
+	z.err = nil
+}
+
+// writeHeader appends the frame header to z.out. If final is set, all the
+// content is pending and its size is recorded in the header.
+func (z *Writer) writeHeader(final bool) {
+	z.wroteHeader = true
+	var b [4]byte
+	binary.LittleEndian.PutUint32(b[:], frameMagic)
+	z.out = append(z.out, b[:]...)
+	i := len(z.out)
+	fhd := byte(0x04) // content checksum
+	z.out = append(z.out, 0)
+	if final {
+		// A single segment frame has no window descriptor; its
+		// window is the content size.
+		fhd |= 0x20
+	} else {
+		z.out = append(z.out, byte(z.enc.windowLog-minWindowLog)<<3)
+	}
+	if z.dict != nil && z.dict.id != 0 {
+		id := z.dict.id
+		binary.LittleEndian.PutUint32(b[:], id)
+		switch {
+		case id < 1<<8:
+			fhd |= 1
+			z.out = append(z.out, b[:1]...)
+		case id < 1<<16:
+			fhd |= 2
+			z.out = append(z.out, b[:2]...)
+		default:
+			fhd |= 3
+			z.out = append(z.out, b[:]...)
+		}
+	}
+	if final {
+		size := uint32(len(z.hist) - z.pos)
+		binary.LittleEndian.PutUint32(b[:], size)
+		switch {
+		case size < 256:
+			z.out = append(z.out, b[0])
+		case size < 1<<16+256:
+			fhd |= 1 << 6
+			binary.LittleEndian.PutUint16(b[:], uint16(size-256))
+			z.out = append(z.out, b[:2]...)
+		default:
+			fhd |= 2 << 6
+			z.out = append(z.out, b[:]...)
+		}
+	}
+	z.out[i] = fhd
+}
+
+// writeBlock compresses the pending data into a block, appending it to
+// z.out, and slides the window.
+func (z *Writer) writeBlock(last bool) {
+	z.out = z.enc.encodeBlock(z.out, z.hist, z.pos, last)
+	z.pos = len(z.hist)
+	if keep := z.enc.windowSize; z.pos > keep+maxBlockSize {
+		delta := z.pos - keep
+		copy(z.hist, z.hist[delta:])
+		z.hist = z.hist[:len(z.hist)-delta]
+		z.pos -= delta
+		z.enc.shift(delta)
+	}
+}
+
+// flushOut writes z.out to the underlying writer.
+func (z *Writer) flushOut() error {
+	if len(z.out) > 0 {
+		_, z.err = z.w.Write(z.out)
+		z.out = z.out[:0]
+	}
+	return z.err
+}
+
+// Write writes a compressed form of p to the underlying io.Writer. The
+// compressed bytes are not necessarily flushed until the Writer is closed.
+func (z *Writer) Write(p []byte) (int, error) {
+	if z.err != nil {
+		return 0, z.err
+	}
+	n := len(p)
+	for len(p) > 0 {
+		pending := len(z.hist) - z.pos
+		if pending == maxBlockSize {
+			// More data follows, so the block is not the last.
+			if !z.wroteHeader {
+				z.writeHeader(false)
+			}
+			z.writeBlock(false)
+			if z.flushOut() != nil {
+				return 0, z.err
+			}
+			pending = 0
+		}
+		k := maxBlockSize - pending
+		if k > len(p) {
+			k = len(p)
+		}
+		z.hist = append(z.hist, p[:k]...)
+		z.digest.write(p[:k])
+		p = p[k:]
+	}
+	return n, nil
+}
+
+// Flush flushes any pending compressed data to the underlying writer.
+//
+// It is useful mainly in compressed network protocols, to ensure that
+// a remote reader has enough data to reconstruct a packet. Flush does
+// not return until the data has been written. If the underlying
+// writer returns an error, Flush returns that error.
+func (z *Writer) Flush() error {
+	if z.err != nil {
+		return z.err
+	}
+	if z.closed {
+		return nil
+	}
+	if !z.wroteHeader {
+		z.writeHeader(false)
+	}
+	if len(z.hist) > z.pos {
+		z.writeBlock(false)
+	}
+	return z.flushOut()
+}
+
+// Close closes the Writer, flushing any unwritten data to the underlying
+// io.Writer, but does not close the underlying io.Writer.
+func (z *Writer) Close() error {
+	if z.err != nil {
+		return z.err
+	}
+	if z.closed {
+		return nil
+	}
+	z.closed = true
+	if !z.wroteHeader {
+		z.writeHeader(true)
+	}
+	z.writeBlock(true)
+	var b [4]byte
+	binary.LittleEndian.PutUint32(b[:], uint32(z.digest.sum64()))
+	z.out = append(z.out, b[:]...)
+	return z.flushOut()
+}
@@ -0,0 +1,112 @@
+// Copyright 2016 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package zstd
+
+import (
+	"bytes"
+	"testing"
+)
+
+var testLevels = []int{DefaultCompression, NoCompression, BestSpeed, 3, 5, 7, BestCompression}
+
+// roundTrip compresses data with the given level and dictionary, writing
+// it in pieces of at most chunk bytes, and checks that it decompresses
+// to the same data.
+func roundTrip(t *testing.T, name string, data []byte, level int, dict []byte, chunk int) {
+	var buf bytes.Buffer
+	z, err := NewWriterDict(&buf, level, dict)
+	if err != nil {
+		t.Fatalf("%s, level %d: %v", name, level, err)
+	}
+	for p := data; len(p) > 0; {
+		n := chunk
+		if n > len(p) {
+			n = len(p)
+		}
+		if _, err := z.Write(p[:n]); err != nil {
+			t.Fatalf("%s, level %d: Write: %v", name, level, err)
+		}
+		p = p[n:]
+	}
+	if err := z.Close(); err != nil {
+		t.Fatalf("%s, level %d: Close: %v", name, level, err)
+	}
+	got, err := decompress(buf.Bytes(), dict)
+	if err != nil {
+		t.Errorf("%s, level %d: decompress: %v", name, level, err)
+		return
+	}
+	if !bytes.Equal(got, data) {
+		t.Errorf("%s, level %d: got %d bytes, want %d matching bytes", name, level, len(got), len(data))
+	}
+}
+
+func TestWriter(t *testing.T) {
+	source := readFile(t, "source.txt")
+	inputs := []struct {
+		name string
+		data []byte
+	}{
+		{"empty", nil},
+		{"byte", []byte{'x'}},
+		{"zeros", make([]byte, 300000)},
+		{"small.txt", readFile(t, "small.txt")},
+		{"source.txt", source},
+		{"source.txt twice", append(append([]byte{}, source...), source...)},
+	}
+	for _, in := range inputs {
+		for _, level := range testLevels {
+			roundTrip(t, in.name, in.data, level, nil, len(in.data)+1)
+			roundTrip(t, in.name, in.data, level, nil, 1000)
+		}
+	}
+}
+
+func TestWriterDict(t *testing.T) {
+	small := readFile(t, "small.txt")
+	source := readFile(t, "source.txt")
+	for _, level := range testLevels {
+		roundTrip(t, "trained dictionary", small, level, readFile(t, "dict"), len(small))
+		roundTrip(t, "raw dictionary", source, level, small, len(source))
+	}
+}
+
+func TestWriterFlushReset(t *testing.T) {
+	source := readFile(t, "source.txt")
+	var buf bytes.Buffer
+	z := NewWriter(&buf)
+	for i := 0; i < 2; i++ {
+		buf.Reset()
+		z.Reset(&buf)
+		half := len(source) / 2
+		z.Write(source[:half])
+		if err := z.Flush(); err != nil {
+			t.Fatalf("Flush: %v", err)
+		}
+		flushed := buf.Len()
+		if flushed == 0 {
+			t.Fatal("Flush wrote nothing")
+		}
+		z.Write(source[half:])
+		if err := z.Close(); err != nil {
+			t.Fatalf("Close: %v", err)
+		}
+		got, err := decompress(buf.Bytes(), nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(got, source) {
+			t.Errorf("got %d bytes, want %d matching bytes", len(got), len(source))
+		}
+	}
+}
+
+func TestWriterLevel(t *testing.T) {
+	for _, level := range []int{-2, BestCompression + 1} {
+		if _, err := NewWriterLevel(nil, level); err == nil {
+			t.Errorf("NewWriterLevel(%d) succeeded", level)
+		}
+	}
+}
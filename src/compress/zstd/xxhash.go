@@ -0,0 +1,109 @@
+// Copyright 2016 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package zstd
+
+import "encoding/binary"
+
+// xxhash64 computes the 64-bit xxHash of its input with a seed of zero, of
+// which the content checksum of a frame holds the low 32 bits.
+type xxhash64 struct {
+	v1, v2, v3, v4 uint64
+	total          uint64
+	mem            [32]byte
+	n              int // number of bytes in mem
+}
+
+const (
+	prime64_1 = 11400714785074694791
+	prime64_2 = 14029467366897019727
+	prime64_3 = 1609587929392839161
+	prime64_4 = 9650029242287828579
+	prime64_5 = 2870177450012600261
+)
+
+func (x *xxhash64) reset() {
+	var seed uint64
+	x.v1 = seed + prime64_1 + prime64_2
+	x.v2 = seed + prime64_2
+	x.v3 = seed
+	x.v4 = seed - prime64_1
+	x.total = 0
+	x.n = 0
+}
+
+func rol64(x uint64, r uint) uint64 {
+	return x<<r | x>>(64-r)
+}
+
+func xxhRound(acc, input uint64) uint64 {
+	acc += input * prime64_2
+	return rol64(acc, 31) * prime64_1
+}
+
+func xxhMergeRound(acc, val uint64) uint64 {
+	acc ^= xxhRound(0, val)
+	return acc*prime64_1 + prime64_4
+}
+
+func (x *xxhash64) write(b []byte) {
+	x.total += uint64(len(b))
+	if x.n+len(b) < 32 {
+		x.n += copy(x.mem[x.n:], b)
+		return
+	}
+	if x.n > 0 {
+		k := copy(x.mem[x.n:], b)
+		b = b[k:]
+		x.stripe(x.mem[:])
+		x.n = 0
+	}
+	for ; len(b) >= 32; b = b[32:] {
+		x.stripe(b)
+	}
+	x.n = copy(x.mem[:], b)
+}
+
+func (x *xxhash64) stripe(b []byte) {
+	x.v1 = xxhRound(x.v1, binary.LittleEndian.Uint64(b[0:]))
+	x.v2 = xxhRound(x.v2, binary.LittleEndian.Uint64(b[8:]))
+	x.v3 = xxhRound(x.v3, binary.LittleEndian.Uint64(b[16:]))
+	x.v4 = xxhRound(x.v4, binary.LittleEndian.Uint64(b[24:]))
+}
+
+func (x *xxhash64) sum64() uint64 {
+	var h uint64
+	if x.total >= 32 {
+		h = rol64(x.v1, 1) + rol64(x.v2, 7) + rol64(x.v3, 12) + rol64(x.v4, 18)
+		h = xxhMergeRound(h, x.v1)
+		h = xxhMergeRound(h, x.v2)
+		h = xxhMergeRound(h, x.v3)
+		h = xxhMergeRound(h, x.v4)
+	} else {
+		h = prime64_5
+	}
+	h += x.total
+
+	b := x.mem[:x.n]
+	for ; len(b) >= 8; b = b[8:] {
+		h ^= xxhRound(0, binary.LittleEndian.Uint64(b))
+		h = rol64(h, 27)*prime64_1 + prime64_4
+	}
+	if len(b) >= 4 {
+		h ^= uint64(binary.LittleEndian.Uint32(b)) * prime64_1
+		h = rol64(h, 23)*prime64_2 + prime64_3
+		b = b[4:]
+	}
+	for _, c := range b {
+		h ^= uint64(c) * prime64_5
+		h = rol64(h, 11) * prime64_1
+	}
+
+	h ^= h >> 33
+	h *= prime64_2
+	h ^= h >> 29
+	h *= prime64_3
+	h ^= h >> 32
+	return h
+}
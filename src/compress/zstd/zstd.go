@@ -0,0 +1,242 @@
+// Copyright 2016 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package zstd implements reading and writing of Zstandard compressed data,
+// as specified in RFC 8878.
+//
+// A Zstandard stream is a sequence of frames. The Reader decodes any
+// sequence of frames, skipping skippable frames, and verifies the content
+// checksum of the frames that carry one. The Writer produces a single frame
+// per stream, always with a content checksum.
+//
+// Both may be given a dictionary, either a raw content dictionary or one in
+// the format produced by the reference implementation's training tool.
+package zstd
+
+import "errors"
+
+const (
+	NoCompression      = 0
+	BestSpeed          = 1
+	BestCompression    = 9
+	DefaultCompression = -1
+
+	defaultLevel = 3
+)
+
+var (
+	// ErrChecksum is returned when reading Zstandard data that has an invalid checksum.
+	ErrChecksum = errors.New("zstd: invalid checksum")
+	// ErrHeader is returned when reading Zstandard data that has an invalid frame header.
+	ErrHeader = errors.New("zstd: invalid header")
+	// ErrCorrupt is returned when reading Zstandard data whose blocks are invalid.
+	ErrCorrupt = errors.New("zstd: corrupt input")
+	// ErrDictionary is returned when reading a frame that requires a
+	// dictionary other than the one given to the Reader.
+	ErrDictionary = errors.New("zstd: wrong dictionary")
+	// ErrWindowSize is returned when reading a frame whose window is
+	// larger than the Reader supports.
+	ErrWindowSize = errors.New("zstd: window size too large")
+)
+
+const (
+	frameMagic         = 0xFD2FB528
+	skippableMagic     = 0x184D2A50 // the low 4 bits may take any value
+	skippableMagicMask = 0xFFFFFFF0
+	dictMagic          = 0xEC30A437
+
+	minWindowLog  = 10
+	maxWindowSize = 1 << 27 // the largest window the Reader accepts
+	maxBlockSize  = 128 << 10
+
+	blockTypeRaw        = 0
+	blockTypeRLE        = 1
+	blockTypeCompressed = 2
+)
+
+// Literals length and match length codes stand for a baseline value plus a
+// number of extra bits. See RFC 8878, section 3.1.1.3.2.1.1.
+var (
+	llBase = [...]uint32{
+		0, 1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15,
+		16, 18, 20, 22, 24, 28, 32, 40, 48, 64, 128, 256, 512, 1024, 2048, 4096,
+		8192, 16384, 32768, 65536,
+	}
+	llBits = [...]uint8{
+		0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0,
+		1, 1, 1, 1, 2, 2, 3, 3, 4, 6, 7, 8, 9, 10, 11, 12,
+		13, 14, 15, 16,
+	}
+	mlBase = [...]uint32{
+		3, 4, 5, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15, 16, 17, 18,
+		19, 20, 21, 22, 23, 24, 25, 26, 27, 28, 29, 30, 31, 32, 33, 34,
+		35, 37, 39, 41, 43, 47, 51, 59, 67, 83, 99, 131, 259, 515, 1027, 2051,
+		4099, 8195, 16387, 32771, 65539,
+	}
+	mlBits = [...]uint8{
+		0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0,
+		0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0,
+		1, 1, 1, 1, 2, 2, 3, 3, 4, 4, 5, 7, 8, 9, 10, 11,
+		12, 13, 14, 15, 16,
+	}
+)
+
+const (
+	maxLLCode = 35
+	maxMLCode = 52
+	maxOFCode = 31
+
+	maxLLLog = 9
+	maxMLLog = 9
+	maxOFLog = 8
+)
+
+// The predefined distributions of the literals length, match length and
+// offset codes. See RFC 8878, section 3.1.1.3.2.2.
+var (
+	llDefaultNorm = []int16{
+		4, 3, 2, 2, 2, 2, 2, 2, 2, 2, 2, 2, 2, 1, 1, 1,
+		2, 2, 2, 2, 2, 2, 2, 2, 2, 3, 2, 1, 1, 1, 1, 1,
+		-1, -1, -1, -1,
+	}
+	mlDefaultNorm = []int16{
+		1, 4, 3, 2, 2, 2, 2, 2, 2, 1, 1, 1, 1, 1, 1, 1,
+		1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1,
+		1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, -1, -1,
+		-1, -1, -1, -1, -1,
+	}
+	ofDefaultNorm = []int16{
+		1, 1, 1, 1, 1, 1, 2, 2, 2, 1, 1, 1, 1, 1, 1, 1,
+		1, 1, 1, 1, 1, 1, 1, 1, -1, -1, -1, -1, -1,
+	}
+)
+
+const (
+	llDefaultLog = 6
+	mlDefaultLog = 6
+	ofDefaultLog = 5
+)
+
+// The decoding and encoding tables of the predefined distributions.
+var (
+	llDefaultDec, mlDefaultDec, ofDefaultDec fseDecTable
+	llDefaultEnc, mlDefaultEnc, ofDefaultEnc fseEncTable
+)
+
+func init() {
+	llDefaultDec.build(llDefaultNorm, llDefaultLog)
+	mlDefaultDec.build(mlDefaultNorm, mlDefaultLog)
+	ofDefaultDec.build(ofDefaultNorm, ofDefaultLog)
+	llDefaultEnc.build(llDefaultNorm, llDefaultLog)
+	mlDefaultEnc.build(mlDefaultNorm, mlDefaultLog)
+	ofDefaultEnc.build(ofDefaultNorm, ofDefaultLog)
+}
+
+// llCode returns the literals length code of the length v.
+func llCode(v uint32) uint8 {
+	if v < 64 {
+		return llCodeTable[v]
+	}
+	return uint8(highBit(v)) + 19
+}
+
+// mlCode returns the match length code of the length v, which is at least 3.
+func mlCode(v uint32) uint8 {
+	v -= 3
+	if v < 128 {
+		return mlCodeTable[v]
+	}
+	return uint8(highBit(v)) + 36
+}
+
+var llCodeTable, mlCodeTable [128]uint8
+
+func init() {
+	for c := 0; c <= maxLLCode && llBase[c] < 64; c++ {
+		for v := llBase[c]; v < llBase[c]+1<<llBits[c]; v++ {
+			llCodeTable[v] = uint8(c)
+		}
+	}
+	for c := 0; c <= maxMLCode && mlBase[c]-3 < 128; c++ {
+		for v := mlBase[c] - 3; v < mlBase[c]-3+1<<mlBits[c]; v++ {
+			mlCodeTable[v] = uint8(c)
+		}
+	}
+}
+
+// highBit returns the position of the highest set bit of v, which must not
+// be zero.
+func highBit(v uint32) uint {
+	n := uint(0)
+	for v >>= 1; v != 0; v >>= 1 {
+		n++
+	}
+	return n
+}
+
+// repeatOffsets holds the three most recent offsets of a frame, most
+// recent first. See RFC 8878, section 3.1.2.5.
+type repeatOffsets [3]uint32
+
+var initialRepeatOffsets = repeatOffsets{1, 4, 8}
+
+// offset returns the offset denoted by the Offset_Value ov of a sequence
+// with litLen literals, and updates r. It returns zero if ov denotes an
+// invalid offset.
+func (r *repeatOffsets) offset(ov uint32, litLen uint32) uint32 {
+	if ov > 3 {
+		off := ov - 3
+		r[2], r[1], r[0] = r[1], r[0], off
+		return off
+	}
+	idx := ov
+	if litLen == 0 {
+		idx++
+	}
+	var off uint32
+	switch idx {
+	case 1:
+		return r[0]
+	case 2:
+		off = r[1]
+		r[1] = r[0]
+	case 3:
+		off = r[2]
+		r[2], r[1] = r[1], r[0]
+	default:
+		off = r[0] - 1
+		r[2], r[1] = r[1], r[0]
+	}
+	r[0] = off
+	return off
+}
+
+// value returns the Offset_Value that denotes the offset off in a sequence
+// with litLen literals. It does not update r.
+func (r *repeatOffsets) value(off uint32, litLen uint32) uint32 {
+	if litLen > 0 {
+		switch off {
+		case r[0]:
+			return 1
+		case r[1]:
+			return 2
+		case r[2]:
+			return 3
+		}
+	} else {
+		switch off {
+		case r[1]:
+			return 1
+		case r[2]:
+			return 2
+		case r[0] - 1:
+			return 3
+		}
+	}
+	return off + 3
+}
+
+func get3(p []byte) uint32 {
+	return uint32(p[0]) | uint32(p[1])<<8 | uint32(p[2])<<16
+}
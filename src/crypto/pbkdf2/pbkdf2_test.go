@@ -0,0 +1,94 @@
+// Copyright 2012 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package pbkdf2
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/hex"
+	"hash"
+	"testing"
+)
+
+type testVector struct {
+	password string
+	salt     string
+	iter     int
+	output   string // hex-encoded derived key
+}
+
+// sha1TestVectors are the test vectors of RFC 6070, without the one
+// using 16777216 iterations.
+var sha1TestVectors = []testVector{
+	{
+		"password",
+		"salt",
+		1,
+		"0c60c80f961f0e71f3a9b524af6012062fe037a6",
+	},
+	{
+		"password",
+		"salt",
+		2,
+		"ea6c014dc72d6f8ccd1ed92ace1d41f0d8de8957",
+	},
+	{
+		"password",
+		"salt",
+		4096,
+		"4b007901b765489abead49d926f721d065a429c1",
+	},
+	{
+		"passwordPASSWORDpassword",
+		"saltSALTsaltSALTsaltSALTsaltSALTsalt",
+		4096,
+		"3d2eec4fe41c849b80c8d83662c0e44a8b291a964cf2f07038",
+	},
+	{
+		"pass\x00word",
+		"sa\x00lt",
+		4096,
+		"56fa6aa75548099dcc37d7f03425e0c3",
+	},
+}
+
+// sha256TestVectors are the PBKDF2-HMAC-SHA256 test vectors of RFC 7914,
+// section 11.
+var sha256TestVectors = []testVector{
+	{
+		"passwd",
+		"salt",
+		1,
+		"55ac046e56e3089fec1691c22544b605f94185216dde0465e68b9d57c20dacbc49ca9cccf179b645991664b39d77ef317c71b845b1e30bd509112041d3a19783",
+	},
+	{
+		"Password",
+		"NaCl",
+		80000,
+		"4ddcd8f60b98be21830cee5ef22701f9641a4418d04c0414aeff08876b34ab56a1d425a1225833549adb841b51c9b3176a272bdebba1d078478f62b397f33c8d",
+	},
+}
+
+func testHash(t *testing.T, h func() hash.Hash, hashName string, vectors []testVector) {
+	for i, v := range vectors {
+		want, err := hex.DecodeString(v.output)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got := Key([]byte(v.password), []byte(v.salt), v.iter, len(want), h)
+		if !bytes.Equal(got, want) {
+			t.Errorf("%s #%d: got %x, want %x", hashName, i, got, want)
+		}
+	}
+}
+
+func TestWithHMACSHA1(t *testing.T) {
+	testHash(t, sha1.New, "SHA1", sha1TestVectors)
+}
+
+func TestWithHMACSHA256(t *testing.T) {
+	testHash(t, sha256.New, "SHA256", sha256TestVectors)
+}
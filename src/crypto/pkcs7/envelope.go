@@ -0,0 +1,427 @@
+// Copyright 2016 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package pkcs7
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/des"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"errors"
+	"io"
+	"math/big"
+)
+
+// A Cipher is a content-encryption algorithm for EnvelopedData.
+type Cipher int
+
+const (
+	AES128CBC Cipher = 1 + iota
+	AES192CBC
+	AES256CBC
+	// TripleDESCBC is supported for decryption only.
+	TripleDESCBC
+)
+
+var (
+	oidAES128CBC    = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 1, 2}
+	oidAES192CBC    = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 1, 22}
+	oidAES256CBC    = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 1, 42}
+	oidTripleDESCBC = asn1.ObjectIdentifier{1, 2, 840, 113549, 3, 7}
+
+	oidRSAESOAEP  = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 1, 7}
+	oidMGF1       = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 1, 8}
+	oidPSpecified = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 1, 9}
+)
+
+var cipherDetails = []struct {
+	cipher   Cipher
+	oid      asn1.ObjectIdentifier
+	keySize  int
+	newBlock func(key []byte) (cipher.Block, error)
+}{
+	{AES128CBC, oidAES128CBC, 16, aes.NewCipher},
+	{AES192CBC, oidAES192CBC, 24, aes.NewCipher},
+	{AES256CBC, oidAES256CBC, 32, aes.NewCipher},
+	{TripleDESCBC, oidTripleDESCBC, 24, des.NewTripleDESCipher},
+}
+
+// EncryptOptions contains options for Encrypt.
+type EncryptOptions struct {
+	// Cipher is the content-encryption algorithm. If zero, AES256CBC
+	// is used.
+	Cipher Cipher
+	// OAEP selects RSAES-OAEP with SHA-256 to encrypt the content
+	// encryption key for the recipients, in place of PKCS #1 v1.5.
+	OAEP bool
+	// ContentType is the type of the content. If nil, OIDData is used.
+	ContentType asn1.ObjectIdentifier
+}
+
+// rsaesOAEPParams reflects the ASN.1 structure of RSAES-OAEP-params.
+// See RFC 4055, section 4.1.
+type rsaesOAEPParams struct {
+	Hash    pkix.AlgorithmIdentifier `asn1:"explicit,tag:0,optional"`
+	MGF     pkix.AlgorithmIdentifier `asn1:"explicit,tag:1,optional"`
+	PSource pkix.AlgorithmIdentifier `asn1:"explicit,tag:2,optional"`
+}
+
+// Encrypt returns a ContentInfo holding a DER-encoded EnvelopedData
+// message, in which content is encrypted for each of recipients. The
+// recipients' certificates must have RSA public keys.
+func Encrypt(content []byte, recipients []*x509.Certificate, opts *EncryptOptions) ([]byte, error) {
+	if len(recipients) == 0 {
+		return nil, errors.New("pkcs7: no recipients")
+	}
+	if opts == nil {
+		opts = new(EncryptOptions)
+	}
+	contentType := opts.ContentType
+	if contentType == nil {
+		contentType = OIDData
+	}
+	c := opts.Cipher
+	if c == 0 {
+		c = AES256CBC
+	}
+	if c == TripleDESCBC {
+		return nil, errors.New("pkcs7: Triple DES is only supported for decryption")
+	}
+	i := cipherIndex(c)
+	if i < 0 {
+		return nil, errors.New("pkcs7: unknown cipher")
+	}
+	details := cipherDetails[i]
+
+	key := make([]byte, details.keySize)
+	if _, err := io.ReadFull(rand.Reader, key); err != nil {
+		return nil, err
+	}
+	block, err := details.newBlock(key)
+	if err != nil {
+		return nil, err
+	}
+	iv := make([]byte, block.BlockSize())
+	if _, err := io.ReadFull(rand.Reader, iv); err != nil {
+		return nil, err
+	}
+	padding := block.BlockSize() - len(content)%block.BlockSize()
+	ciphertext := make([]byte, len(content)+padding)
+	copy(ciphertext, content)
+	for i := len(content); i < len(ciphertext); i++ {
+		ciphertext[i] = byte(padding)
+	}
+	cipher.NewCBCEncrypter(block, iv).CryptBlocks(ciphertext, ciphertext)
+
+	keyAlgo := pkix.AlgorithmIdentifier{Algorithm: oidPublicKeyRSA, Parameters: asn1.RawValue{Tag: asn1.TagNull}}
+	if opts.OAEP {
+		sha256 := pkix.AlgorithmIdentifier{Algorithm: hashOIDs[crypto.SHA256]}
+		mgfParams, err := asn1.Marshal(sha256)
+		if err != nil {
+			return nil, err
+		}
+		params, err := asn1.Marshal(rsaesOAEPParams{
+			Hash: sha256,
+			MGF:  pkix.AlgorithmIdentifier{Algorithm: oidMGF1, Parameters: asn1.RawValue{FullBytes: mgfParams}},
+		})
+		if err != nil {
+			return nil, err
+		}
+		keyAlgo = pkix.AlgorithmIdentifier{Algorithm: oidRSAESOAEP, Parameters: asn1.RawValue{FullBytes: params}}
+	}
+
+	var recipientInfos [][]byte
+	for _, cert := range recipients {
+		pub, ok := cert.PublicKey.(*rsa.PublicKey)
+		if !ok {
+			return nil, errors.New("pkcs7: only RSA recipients are supported")
+		}
+		var encryptedKey []byte
+		if opts.OAEP {
+			encryptedKey, err = rsa.EncryptOAEP(crypto.SHA256.New(), rand.Reader, pub, key, nil)
+		} else {
+			encryptedKey, err = rsa.EncryptPKCS1v15(rand.Reader, pub, key)
+		}
+		if err != nil {
+			return nil, err
+		}
+		var b asn1.Builder
+		b.AddSequence(func(b *asn1.Builder) {
+			b.AddInt64(0)
+			addCertIdentifier(b, cert)
+			b.AddMarshal(keyAlgo)
+			b.AddOctetString(encryptedKey)
+		})
+		ri, err := b.Bytes()
+		if err != nil {
+			return nil, err
+		}
+		recipientInfos = append(recipientInfos, ri)
+	}
+
+	var b asn1.Builder
+	addContentInfo(&b, OIDEnvelopedData, func(b *asn1.Builder) {
+		b.AddSequence(func(b *asn1.Builder) {
+			b.AddInt64(0)
+			b.AddSet(func(b *asn1.Builder) {
+				for _, ri := range recipientInfos {
+					b.AddRaw(ri)
+				}
+			})
+			b.AddSequence(func(b *asn1.Builder) {
+				b.AddObjectIdentifier(contentType)
+				b.AddSequence(func(b *asn1.Builder) {
+					b.AddObjectIdentifier(details.oid)
+					b.AddOctetString(iv)
+				})
+				b.AddPrimitive(asn1.ClassContextSpecific, 0, ciphertext)
+			})
+		})
+	})
+	return b.Bytes()
+}
+
+func cipherIndex(c Cipher) int {
+	for i, details := range cipherDetails {
+		if details.cipher == c {
+			return i
+		}
+	}
+	return -1
+}
+
+// EnvelopedData is a parsed CMS EnvelopedData message. See RFC 5652,
+// section 6.
+type EnvelopedData struct {
+	// ContentType is the type of the encrypted content, usually OIDData.
+	ContentType asn1.ObjectIdentifier
+	// Recipients are the recipients to which the content encryption key
+	// was transported with their public keys. Recipients of other kinds
+	// are not included.
+	Recipients []RecipientInfo
+	// ContentEncryptionAlgorithm identifies the cipher that encrypts the
+	// content.
+	ContentEncryptionAlgorithm pkix.AlgorithmIdentifier
+
+	encryptedContent []byte
+}
+
+// A RecipientInfo describes a recipient of an EnvelopedData message that
+// can decrypt the content encryption key with its private key.
+type RecipientInfo struct {
+	// The recipient's certificate is identified either by its issuer, a
+	// DER-encoded Name, and serial number, or by its subject key
+	// identifier.
+	Issuer       []byte
+	SerialNumber *big.Int
+	SubjectKeyId []byte
+
+	KeyEncryptionAlgorithm pkix.AlgorithmIdentifier
+	EncryptedKey           []byte
+}
+
+// ParseEnvelopedData parses a ContentInfo holding an EnvelopedData, encoded
+// with BER or DER.
+func ParseEnvelopedData(data []byte) (*EnvelopedData, error) {
+	c, err := parseContentInfo(data, OIDEnvelopedData)
+	if err != nil {
+		return nil, err
+	}
+	c, err = c.ReadSequence()
+	if err != nil {
+		return nil, err
+	}
+	if _, err := c.ReadInt64(); err != nil { // version
+		return nil, err
+	}
+	if _, _, err := readOptional(c, 0); err != nil { // originatorInfo
+		return nil, err
+	}
+
+	ed := new(EnvelopedData)
+	recipients, err := c.ReadSet()
+	if err != nil {
+		return nil, err
+	}
+	for !recipients.Empty() {
+		class, tag, _, err := recipients.PeekTag()
+		if err != nil {
+			return nil, err
+		}
+		if class != asn1.ClassUniversal || tag != asn1.TagSequence {
+			// Key agreement, key encryption key and other recipients.
+			if err := recipients.Skip(); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		ri, err := parseRecipientInfo(recipients)
+		if err != nil {
+			return nil, err
+		}
+		ed.Recipients = append(ed.Recipients, ri)
+	}
+
+	eci, err := c.ReadSequence()
+	if err != nil {
+		return nil, err
+	}
+	if ed.ContentType, err = eci.ReadObjectIdentifier(); err != nil {
+		return nil, err
+	}
+	if err := eci.Unmarshal(&ed.ContentEncryptionAlgorithm); err != nil {
+		return nil, err
+	}
+	if eci.Empty() {
+		return nil, ParseError("pkcs7: encrypted content is not present")
+	}
+	if ed.encryptedContent, err = eci.ReadPrimitive(asn1.ClassContextSpecific, 0); err != nil {
+		return nil, err
+	}
+	return ed, nil
+}
+
+func parseRecipientInfo(c *asn1.Cursor) (ri RecipientInfo, err error) {
+	if c, err = c.ReadSequence(); err != nil {
+		return
+	}
+	if _, err = c.ReadInt64(); err != nil { // version
+		return
+	}
+	id, err := parseCertIdentifier(c)
+	if err != nil {
+		return
+	}
+	ri.Issuer, ri.SerialNumber, ri.SubjectKeyId = id.issuer, id.serialNumber, id.subjectKeyId
+	if err = c.Unmarshal(&ri.KeyEncryptionAlgorithm); err != nil {
+		return
+	}
+	ri.EncryptedKey, err = c.ReadOctetString()
+	return
+}
+
+// Decrypt decrypts the content for the recipient with the given
+// certificate, using its private key, which must be an RSA key.
+func (ed *EnvelopedData) Decrypt(cert *x509.Certificate, key crypto.Decrypter) ([]byte, error) {
+	var ri *RecipientInfo
+	for i := range ed.Recipients {
+		id := certIdentifier{ed.Recipients[i].Issuer, ed.Recipients[i].SerialNumber, ed.Recipients[i].SubjectKeyId}
+		if id.matches(cert) {
+			ri = &ed.Recipients[i]
+			break
+		}
+	}
+	if ri == nil {
+		return nil, errors.New("pkcs7: certificate is not among the recipients")
+	}
+
+	i := -1
+	for j, d := range cipherDetails {
+		if d.oid.Equal(ed.ContentEncryptionAlgorithm.Algorithm) {
+			i = j
+		}
+	}
+	if i < 0 {
+		return nil, errors.New("pkcs7: unsupported content encryption algorithm " + ed.ContentEncryptionAlgorithm.Algorithm.String())
+	}
+	details := cipherDetails[i]
+
+	var decryptOpts crypto.DecrypterOpts
+	switch algo := ri.KeyEncryptionAlgorithm; {
+	case algo.Algorithm.Equal(oidPublicKeyRSA):
+		// A padding error results in a random key rather than an
+		// error, so as not to reveal it.
+		decryptOpts = &rsa.PKCS1v15DecryptOptions{SessionKeyLen: details.keySize}
+	case algo.Algorithm.Equal(oidRSAESOAEP):
+		oaepOpts, err := parseOAEPParams(algo.Parameters.FullBytes)
+		if err != nil {
+			return nil, err
+		}
+		decryptOpts = oaepOpts
+	default:
+		return nil, errors.New("pkcs7: unsupported key encryption algorithm " + algo.Algorithm.String())
+	}
+	contentKey, err := key.Decrypt(rand.Reader, ri.EncryptedKey, decryptOpts)
+	if err != nil {
+		return nil, err
+	}
+	if len(contentKey) != details.keySize {
+		return nil, errors.New("pkcs7: content encryption key has the wrong size")
+	}
+	block, err := details.newBlock(contentKey)
+	if err != nil {
+		return nil, err
+	}
+
+	iv, err := asn1.NewBERCursor(ed.ContentEncryptionAlgorithm.Parameters.FullBytes).ReadOctetString()
+	if err != nil {
+		return nil, err
+	}
+	if len(iv) != block.BlockSize() {
+		return nil, ParseError("pkcs7: initialization vector has the wrong size")
+	}
+	ciphertext := ed.encryptedContent
+	if len(ciphertext) == 0 || len(ciphertext)%block.BlockSize() != 0 {
+		return nil, ParseError("pkcs7: encrypted content is not a multiple of the block size")
+	}
+	plaintext := make([]byte, len(ciphertext))
+	cipher.NewCBCDecrypter(block, iv).CryptBlocks(plaintext, ciphertext)
+
+	padding := int(plaintext[len(plaintext)-1])
+	if padding == 0 || padding > block.BlockSize() ||
+		!bytes.Equal(plaintext[len(plaintext)-padding:], bytes.Repeat([]byte{byte(padding)}, padding)) {
+		return nil, errors.New("pkcs7: decryption failed")
+	}
+	return plaintext[:len(plaintext)-padding], nil
+}
+
+// parseOAEPParams returns the decryption options for the encoded
+// RSAES-OAEP-params. The hash function of the mask generation function
+// must be the same as that of OAEP.
+func parseOAEPParams(der []byte) (*rsa.OAEPOptions, error) {
+	var params rsaesOAEPParams
+	if len(der) > 0 {
+		if rest, err := asn1.UnmarshalBER(der, &params); err != nil {
+			return nil, err
+		} else if len(rest) > 0 {
+			return nil, ParseError("pkcs7: trailing data after RSAES-OAEP parameters")
+		}
+	}
+	opts := &rsa.OAEPOptions{Hash: crypto.SHA1}
+	if len(params.Hash.Algorithm) > 0 {
+		opts.Hash = hashForOID(params.Hash.Algorithm)
+	}
+	mgfHash := crypto.SHA1
+	if len(params.MGF.Algorithm) > 0 {
+		var mgfParams pkix.AlgorithmIdentifier
+		if !params.MGF.Algorithm.Equal(oidMGF1) {
+			return nil, errors.New("pkcs7: unsupported OAEP mask generation function")
+		}
+		if _, err := asn1.UnmarshalBER(params.MGF.Parameters.FullBytes, &mgfParams); err != nil {
+			return nil, err
+		}
+		mgfHash = hashForOID(mgfParams.Algorithm)
+	}
+	if opts.Hash == 0 || !opts.Hash.Available() || mgfHash != opts.Hash {
+		return nil, errors.New("pkcs7: unsupported OAEP hash function")
+	}
+	if len(params.PSource.Algorithm) > 0 {
+		if !params.PSource.Algorithm.Equal(oidPSpecified) {
+			return nil, errors.New("pkcs7: unsupported OAEP label source")
+		}
+		label, err := asn1.NewBERCursor(params.PSource.Parameters.FullBytes).ReadOctetString()
+		if err != nil {
+			return nil, err
+		}
+		opts.Label = label
+	}
+	return opts, nil
+}
@@ -0,0 +1,265 @@
+// Copyright 2016 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package pkcs7 implements the SignedData and EnvelopedData content types of
+// the Cryptographic Message Syntax (CMS), as specified in RFC 5652. CMS is
+// the successor of PKCS #7 and is used to sign and encrypt messages such as
+// S/MIME mail and software artifacts.
+//
+// Messages are parsed with the Basic Encoding Rules, which many CMS
+// implementations produce, and written with the Distinguished Encoding
+// Rules.
+package pkcs7
+
+import (
+	"bytes"
+	"crypto"
+	_ "crypto/sha1"
+	_ "crypto/sha256"
+	_ "crypto/sha512"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"math/big"
+)
+
+// Object identifiers of the content types.
+var (
+	OIDData          = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 7, 1}
+	OIDSignedData    = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 7, 2}
+	OIDEnvelopedData = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 7, 3}
+)
+
+// Object identifiers of the attributes used in SignedData.
+var (
+	OIDAttributeContentType   = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 3}
+	OIDAttributeMessageDigest = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 4}
+	OIDAttributeSigningTime   = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 5}
+)
+
+var (
+	oidPublicKeyRSA   = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 1, 1}
+	oidPublicKeyDSA   = asn1.ObjectIdentifier{1, 2, 840, 10040, 4, 1}
+	oidPublicKeyECDSA = asn1.ObjectIdentifier{1, 2, 840, 10045, 2, 1}
+)
+
+var hashOIDs = map[crypto.Hash]asn1.ObjectIdentifier{
+	crypto.SHA1:   asn1.ObjectIdentifier([]int{1, 3, 14, 3, 2, 26}),
+	crypto.SHA224: asn1.ObjectIdentifier([]int{2, 16, 840, 1, 101, 3, 4, 2, 4}),
+	crypto.SHA256: asn1.ObjectIdentifier([]int{2, 16, 840, 1, 101, 3, 4, 2, 1}),
+	crypto.SHA384: asn1.ObjectIdentifier([]int{2, 16, 840, 1, 101, 3, 4, 2, 2}),
+	crypto.SHA512: asn1.ObjectIdentifier([]int{2, 16, 840, 1, 101, 3, 4, 2, 3}),
+}
+
+// hashForOID returns the hash function identified by oid, or zero if it
+// is not known.
+func hashForOID(oid asn1.ObjectIdentifier) crypto.Hash {
+	for h, hoid := range hashOIDs {
+		if oid.Equal(hoid) {
+			return h
+		}
+	}
+	return 0
+}
+
+var (
+	oidSignatureSHA1WithRSA     = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 1, 5}
+	oidSignatureSHA256WithRSA   = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 1, 11}
+	oidSignatureSHA384WithRSA   = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 1, 12}
+	oidSignatureSHA512WithRSA   = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 1, 13}
+	oidSignatureDSAWithSHA1     = asn1.ObjectIdentifier{1, 2, 840, 10040, 4, 3}
+	oidSignatureDSAWithSHA256   = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 3, 2}
+	oidSignatureECDSAWithSHA1   = asn1.ObjectIdentifier{1, 2, 840, 10045, 4, 1}
+	oidSignatureECDSAWithSHA256 = asn1.ObjectIdentifier{1, 2, 840, 10045, 4, 3, 2}
+	oidSignatureECDSAWithSHA384 = asn1.ObjectIdentifier{1, 2, 840, 10045, 4, 3, 3}
+	oidSignatureECDSAWithSHA512 = asn1.ObjectIdentifier{1, 2, 840, 10045, 4, 3, 4}
+)
+
+// signatureAlgorithmDetails mirrors the table of the same name in crypto/x509.
+var signatureAlgorithmDetails = []struct {
+	algo       x509.SignatureAlgorithm
+	oid        asn1.ObjectIdentifier
+	pubKeyAlgo x509.PublicKeyAlgorithm
+	hash       crypto.Hash
+}{
+	{x509.SHA1WithRSA, oidSignatureSHA1WithRSA, x509.RSA, crypto.SHA1},
+	{x509.SHA256WithRSA, oidSignatureSHA256WithRSA, x509.RSA, crypto.SHA256},
+	{x509.SHA384WithRSA, oidSignatureSHA384WithRSA, x509.RSA, crypto.SHA384},
+	{x509.SHA512WithRSA, oidSignatureSHA512WithRSA, x509.RSA, crypto.SHA512},
+	{x509.DSAWithSHA1, oidSignatureDSAWithSHA1, x509.DSA, crypto.SHA1},
+	{x509.DSAWithSHA256, oidSignatureDSAWithSHA256, x509.DSA, crypto.SHA256},
+	{x509.ECDSAWithSHA1, oidSignatureECDSAWithSHA1, x509.ECDSA, crypto.SHA1},
+	{x509.ECDSAWithSHA256, oidSignatureECDSAWithSHA256, x509.ECDSA, crypto.SHA256},
+	{x509.ECDSAWithSHA384, oidSignatureECDSAWithSHA384, x509.ECDSA, crypto.SHA384},
+	{x509.ECDSAWithSHA512, oidSignatureECDSAWithSHA512, x509.ECDSA, crypto.SHA512},
+}
+
+// ParseError results from a message that is well formed ASN.1 but is not a
+// valid CMS message.
+type ParseError string
+
+func (p ParseError) Error() string {
+	return string(p)
+}
+
+// An Attribute is a signed or unsigned attribute of a signer. Each of its
+// values is an encoded ASN.1 value whose type depends on the attribute
+// type.
+type Attribute struct {
+	Type   asn1.ObjectIdentifier
+	Values []asn1.RawValue
+}
+
+// NewAttribute returns an attribute of the given type with the single
+// value val, which is encoded as by asn1.Marshal.
+func NewAttribute(typ asn1.ObjectIdentifier, val interface{}) (Attribute, error) {
+	b, err := asn1.Marshal(val)
+	if err != nil {
+		return Attribute{}, err
+	}
+	return Attribute{Type: typ, Values: []asn1.RawValue{{FullBytes: b}}}, nil
+}
+
+// findAttribute returns the value of the attribute of type typ in attrs,
+// which must have exactly one value. It reports whether it was found.
+func findAttribute(attrs []Attribute, typ asn1.ObjectIdentifier) (asn1.RawValue, bool, error) {
+	for _, a := range attrs {
+		if !a.Type.Equal(typ) {
+			continue
+		}
+		if len(a.Values) != 1 {
+			return asn1.RawValue{}, false, ParseError("pkcs7: attribute " + typ.String() + " does not have a single value")
+		}
+		return a.Values[0], true, nil
+	}
+	return asn1.RawValue{}, false, nil
+}
+
+// parseAttributes parses the contents of a SET OF Attribute.
+func parseAttributes(c *asn1.Cursor) ([]Attribute, error) {
+	var attrs []Attribute
+	for !c.Empty() {
+		seq, err := c.ReadSequence()
+		if err != nil {
+			return nil, err
+		}
+		var a Attribute
+		if a.Type, err = seq.ReadObjectIdentifier(); err != nil {
+			return nil, err
+		}
+		values, err := seq.ReadSet()
+		if err != nil {
+			return nil, err
+		}
+		for !values.Empty() {
+			v, err := values.Next()
+			if err != nil {
+				return nil, err
+			}
+			a.Values = append(a.Values, v)
+		}
+		attrs = append(attrs, a)
+	}
+	return attrs, nil
+}
+
+// addAttributes adds the encodings of attrs to b, which is building a SET
+// OF Attribute.
+func addAttributes(b *asn1.Builder, attrs []Attribute) {
+	for _, a := range attrs {
+		a := a
+		b.AddSequence(func(b *asn1.Builder) {
+			b.AddObjectIdentifier(a.Type)
+			b.AddSet(func(b *asn1.Builder) {
+				for _, v := range a.Values {
+					b.AddMarshal(v)
+				}
+			})
+		})
+	}
+}
+
+// A certIdentifier identifies a certificate, either by its issuer and
+// serial number or by its subject key identifier.
+type certIdentifier struct {
+	issuer       []byte
+	serialNumber *big.Int
+	subjectKeyId []byte
+}
+
+type issuerAndSerialNumber struct {
+	Issuer       asn1.RawValue
+	SerialNumber *big.Int
+}
+
+// parseCertIdentifier parses a SignerIdentifier or a
+// RecipientIdentifier, which share their form.
+func parseCertIdentifier(c *asn1.Cursor) (id certIdentifier, err error) {
+	class, tag, _, err := c.PeekTag()
+	if err != nil {
+		return
+	}
+	if class == asn1.ClassContextSpecific && tag == 0 {
+		id.subjectKeyId, err = c.ReadPrimitive(asn1.ClassContextSpecific, 0)
+		return
+	}
+	var ias issuerAndSerialNumber
+	if err = c.Unmarshal(&ias); err != nil {
+		return
+	}
+	id.issuer, id.serialNumber = ias.Issuer.FullBytes, ias.SerialNumber
+	return
+}
+
+// addCertIdentifier adds the IssuerAndSerialNumber of cert to b.
+func addCertIdentifier(b *asn1.Builder, cert *x509.Certificate) {
+	b.AddSequence(func(b *asn1.Builder) {
+		b.AddRaw(cert.RawIssuer)
+		b.AddBigInt(cert.SerialNumber)
+	})
+}
+
+// matches reports whether id identifies cert.
+func (id *certIdentifier) matches(cert *x509.Certificate) bool {
+	if id.serialNumber != nil {
+		return bytes.Equal(id.issuer, cert.RawIssuer) && id.serialNumber.Cmp(cert.SerialNumber) == 0
+	}
+	return len(id.subjectKeyId) > 0 && bytes.Equal(id.subjectKeyId, cert.SubjectKeyId)
+}
+
+// parseContentInfo parses a ContentInfo of the given content type and
+// returns a cursor over its content.
+func parseContentInfo(data []byte, contentType asn1.ObjectIdentifier) (*asn1.Cursor, error) {
+	c := asn1.NewBERCursor(data)
+	ci, err := c.ReadSequence()
+	if err != nil {
+		return nil, err
+	}
+	if !c.Empty() {
+		return nil, ParseError("pkcs7: trailing data after ContentInfo")
+	}
+	ct, err := ci.ReadObjectIdentifier()
+	if err != nil {
+		return nil, err
+	}
+	if !ct.Equal(contentType) {
+		return nil, ParseError("pkcs7: content type is " + ct.String() + ", not " + contentType.String())
+	}
+	return ci.Enter(asn1.ClassContextSpecific, 0)
+}
+
+// addContentInfo adds a ContentInfo of the given type, whose content is
+// added by f, to b.
+func addContentInfo(b *asn1.Builder, contentType asn1.ObjectIdentifier, f func(*asn1.Builder)) {
+	b.AddSequence(func(b *asn1.Builder) {
+		b.AddObjectIdentifier(contentType)
+		b.AddConstructed(asn1.ClassContextSpecific, 0, f)
+	})
+}
+
+// algorithmIdentifier returns the AlgorithmIdentifier with the given
+// algorithm and no parameters.
+func algorithmIdentifier(oid asn1.ObjectIdentifier) pkix.AlgorithmIdentifier {
+	return pkix.AlgorithmIdentifier{Algorithm: oid}
+}
@@ -0,0 +1,252 @@
+// Copyright 2016 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package pkcs7
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"errors"
+	"sort"
+	"time"
+)
+
+// A Signer is a signer of a SignedData message.
+type Signer struct {
+	Certificate *x509.Certificate
+	// Key is the private key of Certificate. Only RSA and ECDSA keys
+	// are supported.
+	Key crypto.Signer
+	// Hash is the digest algorithm. If zero, SHA-256 is used.
+	Hash crypto.Hash
+	// ExtraAttributes are signed along with the content type, signing
+	// time and message digest attributes, which are always included.
+	ExtraAttributes []Attribute
+}
+
+// SignOptions contains options for Sign.
+type SignOptions struct {
+	// ContentType is the type of the content. If nil, OIDData is used.
+	ContentType asn1.ObjectIdentifier
+	// Detached omits the content from the message.
+	Detached bool
+	// Certificates are included in the message along with the signers'
+	// certificates, usually to allow their chains to be verified.
+	Certificates []*x509.Certificate
+	// SigningTime is the time of the signatures. If zero, the current
+	// time is used.
+	SigningTime time.Time
+}
+
+// Sign returns a ContentInfo holding a DER-encoded SignedData message, in
+// which content is signed by each of signers.
+func Sign(content []byte, signers []Signer, opts *SignOptions) ([]byte, error) {
+	if len(signers) == 0 {
+		return nil, errors.New("pkcs7: no signers")
+	}
+	if opts == nil {
+		opts = new(SignOptions)
+	}
+	contentType := opts.ContentType
+	if contentType == nil {
+		contentType = OIDData
+	}
+	signingTime := opts.SigningTime
+	if signingTime.IsZero() {
+		signingTime = time.Now()
+	}
+
+	var certs []*x509.Certificate
+	addCert := func(cert *x509.Certificate) {
+		for _, c := range certs {
+			if bytes.Equal(c.Raw, cert.Raw) {
+				return
+			}
+		}
+		certs = append(certs, cert)
+	}
+	var hashes []crypto.Hash
+	var signerInfos [][]byte
+	for _, s := range signers {
+		h := s.Hash
+		if h == 0 {
+			h = crypto.SHA256
+		}
+		si, err := signerInfo(content, contentType, signingTime, s, h)
+		if err != nil {
+			return nil, err
+		}
+		signerInfos = append(signerInfos, si)
+		addCert(s.Certificate)
+		found := false
+		for _, hh := range hashes {
+			found = found || hh == h
+		}
+		if !found {
+			hashes = append(hashes, h)
+		}
+	}
+	for _, cert := range opts.Certificates {
+		addCert(cert)
+	}
+
+	version := int64(1)
+	if !contentType.Equal(OIDData) {
+		version = 3
+	}
+	var b asn1.Builder
+	addContentInfo(&b, OIDSignedData, func(b *asn1.Builder) {
+		b.AddSequence(func(b *asn1.Builder) {
+			b.AddInt64(version)
+			b.AddSet(func(b *asn1.Builder) {
+				for _, h := range hashes {
+					b.AddMarshal(algorithmIdentifier(hashOIDs[h]))
+				}
+			})
+			b.AddSequence(func(b *asn1.Builder) {
+				b.AddObjectIdentifier(contentType)
+				if !opts.Detached {
+					b.AddConstructed(asn1.ClassContextSpecific, 0, func(b *asn1.Builder) {
+						b.AddOctetString(content)
+					})
+				}
+			})
+			var rawCerts [][]byte
+			for _, cert := range certs {
+				rawCerts = append(rawCerts, cert.Raw)
+			}
+			addImplicitSet(b, 0, rawCerts)
+			b.AddSet(func(b *asn1.Builder) {
+				for _, si := range signerInfos {
+					b.AddRaw(si)
+				}
+			})
+		})
+	})
+	return b.Bytes()
+}
+
+// signerInfo returns the encoding of the SignerInfo for s, which uses the
+// digest algorithm h.
+func signerInfo(content []byte, contentType asn1.ObjectIdentifier, signingTime time.Time, s Signer, h crypto.Hash) ([]byte, error) {
+	if s.Certificate == nil || s.Key == nil {
+		return nil, errors.New("pkcs7: signer has no certificate or key")
+	}
+	if hashOIDs[h] == nil || !h.Available() {
+		return nil, errors.New("pkcs7: unsupported digest algorithm")
+	}
+	sigAlgo, err := signatureAlgorithmForKey(s.Key.Public(), h)
+	if err != nil {
+		return nil, err
+	}
+
+	digest := h.New()
+	digest.Write(content)
+	attrs := []Attribute{
+		{Type: OIDAttributeContentType, Values: []asn1.RawValue{marshalRaw(func(b *asn1.Builder) {
+			b.AddObjectIdentifier(contentType)
+		})}},
+		{Type: OIDAttributeSigningTime, Values: []asn1.RawValue{marshalRaw(func(b *asn1.Builder) {
+			// RFC 5652, section 11.3: UTCTime for the years 1950 to 2049.
+			if y := signingTime.UTC().Year(); 1950 <= y && y < 2050 {
+				b.AddUTCTime(signingTime)
+			} else {
+				b.AddGeneralizedTime(signingTime)
+			}
+		})}},
+		{Type: OIDAttributeMessageDigest, Values: []asn1.RawValue{marshalRaw(func(b *asn1.Builder) {
+			b.AddOctetString(digest.Sum(nil))
+		})}},
+	}
+	attrs = append(attrs, s.ExtraAttributes...)
+
+	var ab asn1.Builder
+	ab.AddSet(func(b *asn1.Builder) { addAttributes(b, attrs) })
+	signedAttrs, err := ab.Bytes()
+	if err != nil {
+		return nil, err
+	}
+	attrsDigest := h.New()
+	attrsDigest.Write(signedAttrs)
+	signature, err := s.Key.Sign(rand.Reader, attrsDigest.Sum(nil), h)
+	if err != nil {
+		return nil, err
+	}
+
+	var b asn1.Builder
+	b.AddSequence(func(b *asn1.Builder) {
+		b.AddInt64(1)
+		addCertIdentifier(b, s.Certificate)
+		b.AddMarshal(algorithmIdentifier(hashOIDs[h]))
+		// The signed attributes are implicitly tagged [0] in place
+		// of the SET OF tag they were signed with.
+		contents, _ := asn1.NewCursor(signedAttrs).Next()
+		b.AddConstructed(asn1.ClassContextSpecific, 0, func(b *asn1.Builder) {
+			b.AddRaw(contents.Bytes)
+		})
+		b.AddMarshal(sigAlgo)
+		b.AddOctetString(signature)
+	})
+	return b.Bytes()
+}
+
+// signatureAlgorithmForKey returns the AlgorithmIdentifier of signatures
+// made by pub's private key with the digest algorithm h.
+func signatureAlgorithmForKey(pub crypto.PublicKey, h crypto.Hash) (pkix.AlgorithmIdentifier, error) {
+	var pubKeyAlgo x509.PublicKeyAlgorithm
+	var params asn1.RawValue
+	switch pub.(type) {
+	case *rsa.PublicKey:
+		pubKeyAlgo = x509.RSA
+		params = asn1.RawValue{Tag: asn1.TagNull}
+	case *ecdsa.PublicKey:
+		pubKeyAlgo = x509.ECDSA
+	default:
+		return pkix.AlgorithmIdentifier{}, errors.New("pkcs7: only RSA and ECDSA keys are supported")
+	}
+	for _, details := range signatureAlgorithmDetails {
+		if details.pubKeyAlgo == pubKeyAlgo && details.hash == h {
+			return pkix.AlgorithmIdentifier{Algorithm: details.oid, Parameters: params}, nil
+		}
+	}
+	return pkix.AlgorithmIdentifier{}, errors.New("pkcs7: unsupported digest algorithm for key")
+}
+
+// marshalRaw returns the elements added to a Builder by f as a RawValue.
+// f must not fail.
+func marshalRaw(f func(*asn1.Builder)) asn1.RawValue {
+	var b asn1.Builder
+	f(&b)
+	der, _ := b.Bytes()
+	return asn1.RawValue{FullBytes: der}
+}
+
+// addImplicitSet adds a SET OF elems, implicitly tagged with the given
+// context-specific tag, to b. Nothing is added if elems is empty.
+func addImplicitSet(b *asn1.Builder, tag int, elems [][]byte) {
+	if len(elems) == 0 {
+		return
+	}
+	sorted := append(byEncoding{}, elems...)
+	sort.Sort(sorted)
+	b.AddConstructed(asn1.ClassContextSpecific, tag, func(b *asn1.Builder) {
+		for _, e := range sorted {
+			b.AddRaw(e)
+		}
+	})
+}
+
+// byEncoding sorts the elements of a SET OF by their encodings, as DER
+// requires.
+type byEncoding [][]byte
+
+func (s byEncoding) Len() int           { return len(s) }
+func (s byEncoding) Less(i, j int) bool { return bytes.Compare(s[i], s[j]) < 0 }
+func (s byEncoding) Swap(i, j int)      { s[i], s[j] = s[j], s[i] }
@@ -0,0 +1,425 @@
+// Copyright 2016 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package pkcs7
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"errors"
+	"math/big"
+	"time"
+)
+
+// SignedData is a parsed CMS SignedData message. See RFC 5652, section 5.
+type SignedData struct {
+	// ContentType is the type of the signed content, usually OIDData.
+	ContentType asn1.ObjectIdentifier
+	// Content is the signed content. It is nil if the signature is
+	// detached, in which case the content must be supplied to
+	// VerifyDetached.
+	Content []byte
+	// Certificates and CRLs are those carried in the message, which
+	// usually include the signers' certificates.
+	Certificates []*x509.Certificate
+	CRLs         []*pkix.CertificateList
+	Signers      []SignerInfo
+}
+
+// A SignerInfo describes one of the signers of a SignedData message.
+type SignerInfo struct {
+	// The signer's certificate is identified either by its issuer, a
+	// DER-encoded Name, and serial number, or by its subject key
+	// identifier.
+	Issuer       []byte
+	SerialNumber *big.Int
+	SubjectKeyId []byte
+
+	// Certificate is the signer's certificate, or nil if it is not
+	// among the certificates in the message.
+	Certificate *x509.Certificate
+
+	// Hash is the digest algorithm, or zero if it is not supported.
+	DigestAlgorithm    pkix.AlgorithmIdentifier
+	Hash               crypto.Hash
+	SignatureAlgorithm pkix.AlgorithmIdentifier
+	Signature          []byte
+
+	// SignedAttributes, if present, include the content type and the
+	// digest of the content, and the signature is computed over them
+	// instead of the content.
+	SignedAttributes   []Attribute
+	UnsignedAttributes []Attribute
+
+	rawSignedAttributes []byte // DER-encoded SET OF Attribute
+}
+
+// SigningTime returns the time of the signing-time signed attribute. It
+// reports false if the attribute is absent or invalid.
+func (si *SignerInfo) SigningTime() (time.Time, bool) {
+	v, ok, err := findAttribute(si.SignedAttributes, OIDAttributeSigningTime)
+	if !ok || err != nil {
+		return time.Time{}, false
+	}
+	t, err := asn1.NewBERCursor(v.FullBytes).ReadTime()
+	if err != nil {
+		return time.Time{}, false
+	}
+	return t, true
+}
+
+// ParseSignedData parses a ContentInfo holding a SignedData, encoded with
+// BER or DER.
+func ParseSignedData(data []byte) (*SignedData, error) {
+	c, err := parseContentInfo(data, OIDSignedData)
+	if err != nil {
+		return nil, err
+	}
+	c, err = c.ReadSequence()
+	if err != nil {
+		return nil, err
+	}
+	if _, err := c.ReadInt64(); err != nil { // version
+		return nil, err
+	}
+	if _, err := c.ReadSet(); err != nil { // digestAlgorithms
+		return nil, err
+	}
+
+	sd := new(SignedData)
+	encap, err := c.ReadSequence()
+	if err != nil {
+		return nil, err
+	}
+	if sd.ContentType, err = encap.ReadObjectIdentifier(); err != nil {
+		return nil, err
+	}
+	if !encap.Empty() {
+		eContent, err := encap.Enter(asn1.ClassContextSpecific, 0)
+		if err != nil {
+			return nil, err
+		}
+		content, err := eContent.ReadOctetString()
+		if err != nil {
+			return nil, err
+		}
+		sd.Content = append([]byte{}, content...)
+	}
+
+	if certs, ok, err := readOptional(c, 0); err != nil {
+		return nil, err
+	} else if ok {
+		if sd.Certificates, err = parseCertificates(certs); err != nil {
+			return nil, err
+		}
+	}
+	if crls, ok, err := readOptional(c, 1); err != nil {
+		return nil, err
+	} else if ok {
+		if sd.CRLs, err = parseCRLs(crls); err != nil {
+			return nil, err
+		}
+	}
+
+	signers, err := c.ReadSet()
+	if err != nil {
+		return nil, err
+	}
+	for !signers.Empty() {
+		si, err := parseSignerInfo(signers)
+		if err != nil {
+			return nil, err
+		}
+		for _, cert := range sd.Certificates {
+			id := certIdentifier{si.Issuer, si.SerialNumber, si.SubjectKeyId}
+			if id.matches(cert) {
+				si.Certificate = cert
+				break
+			}
+		}
+		sd.Signers = append(sd.Signers, si)
+	}
+	return sd, nil
+}
+
+// readOptional reads the constructed element with the given context
+// specific tag, if it is next, and returns a cursor over its contents.
+func readOptional(c *asn1.Cursor, tag int) (*asn1.Cursor, bool, error) {
+	if c.Empty() {
+		return nil, false, nil
+	}
+	class, t, _, err := c.PeekTag()
+	if err != nil {
+		return nil, false, err
+	}
+	if class != asn1.ClassContextSpecific || t != tag {
+		return nil, false, nil
+	}
+	contents, err := c.Enter(asn1.ClassContextSpecific, tag)
+	return contents, err == nil, err
+}
+
+// parseCertificates parses a CertificateSet. Choices other than plain
+// certificates, such as attribute certificates, are skipped.
+func parseCertificates(c *asn1.Cursor) ([]*x509.Certificate, error) {
+	var certs []*x509.Certificate
+	for !c.Empty() {
+		class, tag, _, err := c.PeekTag()
+		if err != nil {
+			return nil, err
+		}
+		if class != asn1.ClassUniversal || tag != asn1.TagSequence {
+			if err := c.Skip(); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		var raw asn1.RawValue
+		if err := c.Unmarshal(&raw); err != nil {
+			return nil, err
+		}
+		cert, err := x509.ParseCertificate(raw.FullBytes)
+		if err != nil {
+			return nil, err
+		}
+		certs = append(certs, cert)
+	}
+	return certs, nil
+}
+
+// parseCRLs parses a RevocationInfoChoices. Choices other than CRLs are
+// skipped.
+func parseCRLs(c *asn1.Cursor) ([]*pkix.CertificateList, error) {
+	var crls []*pkix.CertificateList
+	for !c.Empty() {
+		class, tag, _, err := c.PeekTag()
+		if err != nil {
+			return nil, err
+		}
+		if class != asn1.ClassUniversal || tag != asn1.TagSequence {
+			if err := c.Skip(); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		var raw asn1.RawValue
+		if err := c.Unmarshal(&raw); err != nil {
+			return nil, err
+		}
+		crl, err := x509.ParseDERCRL(raw.FullBytes)
+		if err != nil {
+			return nil, err
+		}
+		crls = append(crls, crl)
+	}
+	return crls, nil
+}
+
+func parseSignerInfo(c *asn1.Cursor) (si SignerInfo, err error) {
+	if c, err = c.ReadSequence(); err != nil {
+		return
+	}
+	if _, err = c.ReadInt64(); err != nil { // version
+		return
+	}
+	id, err := parseCertIdentifier(c)
+	if err != nil {
+		return
+	}
+	si.Issuer, si.SerialNumber, si.SubjectKeyId = id.issuer, id.serialNumber, id.subjectKeyId
+	if err = c.Unmarshal(&si.DigestAlgorithm); err != nil {
+		return
+	}
+	si.Hash = hashForOID(si.DigestAlgorithm.Algorithm)
+
+	signed, ok, err := readOptional(c, 0)
+	if err != nil {
+		return
+	}
+	if ok {
+		// The signature covers the DER encoding of the attributes,
+		// with the SET OF tag in place of the implicit tag.
+		var b asn1.Builder
+		b.AddSet(func(b *asn1.Builder) { b.AddRaw(signed.Remaining()) })
+		if si.rawSignedAttributes, err = b.Bytes(); err != nil {
+			return
+		}
+		if si.SignedAttributes, err = parseAttributes(signed); err != nil {
+			return
+		}
+		if len(si.SignedAttributes) == 0 {
+			err = ParseError("pkcs7: empty signed attributes")
+			return
+		}
+	}
+
+	if err = c.Unmarshal(&si.SignatureAlgorithm); err != nil {
+		return
+	}
+	if si.Signature, err = c.ReadOctetString(); err != nil {
+		return
+	}
+	unsigned, ok, err := readOptional(c, 1)
+	if err != nil {
+		return
+	}
+	if ok {
+		si.UnsignedAttributes, err = parseAttributes(unsigned)
+	}
+	return
+}
+
+// Verify checks the signatures of all the signers over the content carried
+// in the message and verifies each signer's certificate with opts, as
+// x509.Certificate.Verify does. The certificates in the message are added
+// to opts.Intermediates, which is created if nil, and its CRLs to
+// opts.CRLs. As with x509.Certificate.Verify, an empty opts.KeyUsages
+// accepts only server authentication, so callers usually set it, for
+// example to x509.ExtKeyUsageEmailProtection or x509.ExtKeyUsageCodeSigning.
+func (sd *SignedData) Verify(opts x509.VerifyOptions) error {
+	if sd.Content == nil {
+		return errors.New("pkcs7: signature is detached; use VerifyDetached")
+	}
+	return sd.verify(sd.Content, opts, false)
+}
+
+// VerifyDetached is like Verify, but checks the signatures over content,
+// which was not carried in the message.
+func (sd *SignedData) VerifyDetached(content []byte, opts x509.VerifyOptions) error {
+	return sd.verify(content, opts, false)
+}
+
+// VerifyAtSigningTime is like Verify, but verifies each signer's
+// certificate and checks the CRLs at the time of the signer's
+// signing-time attribute, which a signer without one must have. If
+// content is nil, the content carried in the message is used.
+//
+// The signing time is asserted by the signer, who can choose it freely,
+// so this is only appropriate when the signing time is trusted by other
+// means, such as a time-stamp or the time the message was received.
+func (sd *SignedData) VerifyAtSigningTime(content []byte, opts x509.VerifyOptions) error {
+	if content == nil {
+		if sd.Content == nil {
+			return errors.New("pkcs7: signature is detached and no content was supplied")
+		}
+		content = sd.Content
+	}
+	return sd.verify(content, opts, true)
+}
+
+func (sd *SignedData) verify(content []byte, opts x509.VerifyOptions, atSigningTime bool) error {
+	if len(sd.Signers) == 0 {
+		return errors.New("pkcs7: message has no signers")
+	}
+	if opts.Intermediates == nil {
+		opts.Intermediates = x509.NewCertPool()
+	}
+	for _, cert := range sd.Certificates {
+		opts.Intermediates.AddCert(cert)
+	}
+	if len(sd.CRLs) > 0 {
+		opts.CRLs = append(append([]*pkix.CertificateList{}, opts.CRLs...), sd.CRLs...)
+	}
+
+	for i := range sd.Signers {
+		si := &sd.Signers[i]
+		if err := si.checkSignature(sd.ContentType, content); err != nil {
+			return err
+		}
+		signerOpts := opts
+		if atSigningTime {
+			t, ok := si.SigningTime()
+			if !ok {
+				return errors.New("pkcs7: signer has no signing time attribute")
+			}
+			signerOpts.CurrentTime = t
+		}
+		if _, err := si.Certificate.Verify(signerOpts); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// checkSignature checks the signature of si over content, which has the
+// given content type.
+func (si *SignerInfo) checkSignature(contentType asn1.ObjectIdentifier, content []byte) error {
+	if si.Certificate == nil {
+		return errors.New("pkcs7: signer's certificate not found in message")
+	}
+	if si.Hash == 0 || !si.Hash.Available() {
+		return errors.New("pkcs7: unsupported digest algorithm " + si.DigestAlgorithm.Algorithm.String())
+	}
+	algo, err := si.signatureAlgorithm()
+	if err != nil {
+		return err
+	}
+
+	signed := content
+	if si.rawSignedAttributes != nil {
+		v, ok, err := findAttribute(si.SignedAttributes, OIDAttributeContentType)
+		if err != nil {
+			return err
+		}
+		var ct asn1.ObjectIdentifier
+		if !ok || asn1.NewBERCursor(v.FullBytes).Unmarshal(&ct) != nil || !ct.Equal(contentType) {
+			return errors.New("pkcs7: content type attribute missing or does not match")
+		}
+		v, ok, err = findAttribute(si.SignedAttributes, OIDAttributeMessageDigest)
+		if err != nil {
+			return err
+		}
+		if !ok {
+			return errors.New("pkcs7: message digest attribute missing")
+		}
+		digest, err := asn1.NewBERCursor(v.FullBytes).ReadOctetString()
+		if err != nil {
+			return err
+		}
+		h := si.Hash.New()
+		h.Write(content)
+		if !bytes.Equal(h.Sum(nil), digest) {
+			return errors.New("pkcs7: message digest does not match content")
+		}
+		signed = si.rawSignedAttributes
+	}
+	return si.Certificate.CheckSignature(algo, signed, si.Signature)
+}
+
+// signatureAlgorithm returns the x509.SignatureAlgorithm of the signature,
+// which is determined by the signer's public key and digest algorithm.
+// The signature algorithm in the SignerInfo may name either the public
+// key algorithm alone or a combination of it and a digest algorithm, in
+// which case the digest algorithm must match si.Hash.
+func (si *SignerInfo) signatureAlgorithm() (x509.SignatureAlgorithm, error) {
+	pubKeyAlgo := si.Certificate.PublicKeyAlgorithm
+	oid := si.SignatureAlgorithm.Algorithm
+	ok := false
+	switch {
+	case oid.Equal(oidPublicKeyRSA):
+		ok = pubKeyAlgo == x509.RSA
+	case oid.Equal(oidPublicKeyDSA):
+		ok = pubKeyAlgo == x509.DSA
+	case oid.Equal(oidPublicKeyECDSA):
+		ok = pubKeyAlgo == x509.ECDSA
+	default:
+		for _, details := range signatureAlgorithmDetails {
+			if oid.Equal(details.oid) {
+				ok = pubKeyAlgo == details.pubKeyAlgo && details.hash == si.Hash
+				break
+			}
+		}
+	}
+	if ok {
+		for _, details := range signatureAlgorithmDetails {
+			if details.pubKeyAlgo == pubKeyAlgo && details.hash == si.Hash {
+				return details.algo, nil
+			}
+		}
+	}
+	return x509.UnknownSignatureAlgorithm, errors.New("pkcs7: unsupported signature algorithm " + oid.String())
+}
@@ -38,6 +38,7 @@ const (
 	alertInappropriateFallback  alert = 86
 	alertUserCanceled           alert = 90
 	alertNoRenegotiation        alert = 100
+	alertBadCertStatusResponse  alert = 113
 )
 
 var alertText = map[alert]string{
@@ -64,6 +65,7 @@ var alertText = map[alert]string{
 	alertInappropriateFallback:  "inappropriate fallback",
 	alertUserCanceled:           "user canceled",
 	alertNoRenegotiation:        "no renegotiation",
+	alertBadCertStatusResponse:  "bad certificate status response",
 }
 
 func (e alert) String() string {
@@ -197,6 +197,7 @@ type ClientSessionState struct {
 	masterSecret       []byte                // MasterSecret generated by client on a full handshake
 	serverCertificates []*x509.Certificate   // Certificate chain presented by the server
 	verifiedChains     [][]*x509.Certificate // Certificate chains we built for verification
+	ocspResponse       []byte                // Stapled OCSP response presented by the server
 }
 
 // ClientSessionCache is a cache of ClientSessionState objects that can be used
@@ -307,6 +308,16 @@ type Config struct { // 用来配置TLS Client或Server，被传递进TLS后就
 	// This should be used only for testing.
 	InsecureSkipVerify bool // 控制是否客户端校验服务器的证书链和主机名
 
+	// RequireOCSPStaple controls whether a client insists on a stapled
+	// OCSP response from the server. If true, the handshake fails unless
+	// the server staples a response that is validly signed by the issuer
+	// of the verified leaf certificate (or by a responder it delegated to),
+	// reports the leaf as good and is current. A cached session is only
+	// resumed if the response stapled in its original handshake still
+	// meets these conditions. It has no effect if InsecureSkipVerify is
+	// true.
+	RequireOCSPStaple bool
+
 	// CipherSuites is a list of supported cipher suites. If CipherSuites
 	// is nil, TLS uses a list of suites supported by the implementation.
 	CipherSuites []uint16
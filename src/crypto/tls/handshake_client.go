@@ -8,6 +8,7 @@ import (
 	"bytes"
 	"crypto"
 	"crypto/ecdsa"
+	"crypto/ocsp"
 	"crypto/rsa"
 	"crypto/subtle"
 	"crypto/x509"
@@ -126,7 +127,16 @@ NextCipherSuite:
 
 			versOk := candidateSession.vers >= c.config.minVersion() &&
 				candidateSession.vers <= c.config.maxVersion()
-			if versOk && cipherSuiteOk {
+
+			// A resumed session skips the certificate status
+			// message, so the staple of the original handshake
+			// must still be acceptable.
+			stapleOk := true
+			if c.config.RequireOCSPStaple && !c.config.InsecureSkipVerify {
+				_, err := c.verifyOCSPStaple(candidateSession.ocspResponse, candidateSession.verifiedChains)
+				stapleOk = err == nil
+			}
+			if versOk && cipherSuiteOk && stapleOk {
 				session = candidateSession
 			}
 		}
@@ -309,6 +319,13 @@ func (hs *clientHandshakeState) doFullHandshake() error {
 		}
 	}
 
+	if c.config.RequireOCSPStaple && !c.config.InsecureSkipVerify {
+		if alert, err := c.verifyOCSPStaple(c.ocspResponse, c.verifiedChains); err != nil {
+			c.sendAlert(alert)
+			return err
+		}
+	}
+
 	msg, err = c.readHandshake()
 	if err != nil {
 		return err
@@ -555,6 +572,7 @@ func (hs *clientHandshakeState) processServerHello() (bool, error) {
 		hs.masterSecret = hs.session.masterSecret
 		c.peerCertificates = hs.session.serverCertificates
 		c.verifiedChains = hs.session.verifiedChains
+		c.ocspResponse = hs.session.ocspResponse
 		return true, nil
 	}
 	return false, nil
@@ -613,6 +631,7 @@ func (hs *clientHandshakeState) readSessionTicket() error {
 		masterSecret:       hs.masterSecret,
 		serverCertificates: c.peerCertificates,
 		verifiedChains:     c.verifiedChains,
+		ocspResponse:       c.ocspResponse,
 	}
 
 	return nil
@@ -665,3 +684,42 @@ func mutualProtocol(protos, preferenceProtos []string) (string, bool) {
 
 	return protos[0], true
 }
+
+// verifyOCSPStaple checks the OCSP response stapled by the server against
+// the first of the verified chains. On failure it returns the alert to send
+// along with the error.
+func (c *Conn) verifyOCSPStaple(response []byte, verifiedChains [][]*x509.Certificate) (alert, error) {
+	if len(response) == 0 {
+		return alertBadCertStatusResponse, errors.New("tls: server did not staple an OCSP response")
+	}
+	if len(verifiedChains) == 0 {
+		return alertBadCertificate, errors.New("tls: server certificate was not verified")
+	}
+
+	chain := verifiedChains[0]
+	leaf, issuer := chain[0], chain[0]
+	if len(chain) > 1 {
+		issuer = chain[1]
+	}
+
+	resp, err := ocsp.ParseResponseForCert(response, leaf, issuer)
+	if err != nil {
+		return alertBadCertStatusResponse, errors.New("tls: failed to verify stapled OCSP response: " + err.Error())
+	}
+
+	now := c.config.time()
+	if resp.Certificate != nil && (now.Before(resp.Certificate.NotBefore) || now.After(resp.Certificate.NotAfter)) {
+		return alertBadCertStatusResponse, errors.New("tls: OCSP responder certificate has expired or is not yet valid")
+	}
+	if now.Before(resp.ThisUpdate) || (!resp.NextUpdate.IsZero() && now.After(resp.NextUpdate)) {
+		return alertBadCertStatusResponse, errors.New("tls: stapled OCSP response is not current")
+	}
+
+	switch resp.Status {
+	case ocsp.Good:
+		return 0, nil
+	case ocsp.Revoked:
+		return alertCertificateRevoked, errors.New("tls: server certificate has been revoked")
+	}
+	return alertBadCertStatusResponse, errors.New("tls: OCSP responder does not know the server certificate")
+}
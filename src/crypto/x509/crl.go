@@ -0,0 +1,487 @@
+// Copyright 2016 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package x509
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"errors"
+	"io"
+	"math/big"
+	"reflect"
+	"time"
+)
+
+var (
+	oidExtensionCRLNumber                = asn1.ObjectIdentifier{2, 5, 29, 20}
+	oidExtensionReasonCode               = asn1.ObjectIdentifier{2, 5, 29, 21}
+	oidExtensionDeltaCRLIndicator        = asn1.ObjectIdentifier{2, 5, 29, 27}
+	oidExtensionIssuingDistributionPoint = asn1.ObjectIdentifier{2, 5, 29, 28}
+)
+
+// CRL entry reason codes. See RFC 5280, section 5.3.1.
+const (
+	// reasonRemoveFromCRL is only valid in delta CRLs and signals that a
+	// certificate listed on the base CRL is no longer revoked.
+	reasonRemoveFromCRL = 8
+)
+
+// IssuingDistributionPoint reflects the issuing distribution point extension
+// of a CRL, which limits the set of certificates that the CRL covers. See RFC
+// 5280, section 5.2.5.
+type IssuingDistributionPoint struct {
+	// URIs contains the uniformResourceIdentifier names from the
+	// fullName of the distribution point.
+	URIs []string
+
+	OnlyContainsUserCerts      bool
+	OnlyContainsCACerts        bool
+	IndirectCRL                bool
+	OnlyContainsAttributeCerts bool
+
+	// OnlySomeReasons, if its length is not zero, restricts the CRL to
+	// revocations for the given reasons.
+	OnlySomeReasons asn1.BitString
+}
+
+// RFC 5280, 5.2.5
+type issuingDistributionPoint struct {
+	DistributionPoint          distributionPointName `asn1:"optional,tag:0"`
+	OnlyContainsUserCerts      bool                  `asn1:"optional,tag:1"`
+	OnlyContainsCACerts        bool                  `asn1:"optional,tag:2"`
+	OnlySomeReasons            asn1.BitString        `asn1:"optional,tag:3"`
+	IndirectCRL                bool                  `asn1:"optional,tag:4"`
+	OnlyContainsAttributeCerts bool                  `asn1:"optional,tag:5"`
+}
+
+// CRLInfo contains the CRL-level extensions of a CertificateList that this
+// package understands.
+type CRLInfo struct {
+	// Number is the CRL number, or nil if the extension is absent.
+	Number *big.Int
+	// BaseCRLNumber is non-nil only for delta CRLs, in which case it is the
+	// number of the complete CRL that the delta is relative to.
+	BaseCRLNumber *big.Int
+
+	AuthorityKeyId           []byte
+	IssuingDistributionPoint *IssuingDistributionPoint
+
+	// UnhandledCriticalExtensions contains a list of extension IDs that
+	// were not processed when parsing. Revocation checking will not use a
+	// CRL for which this slice is non-empty.
+	UnhandledCriticalExtensions []asn1.ObjectIdentifier
+}
+
+// IsDelta reports whether the CRL is a delta CRL.
+func (info *CRLInfo) IsDelta() bool {
+	return info.BaseCRLNumber != nil
+}
+
+// ParseCRLInfo parses the extensions of crl.
+func ParseCRLInfo(crl *pkix.CertificateList) (*CRLInfo, error) {
+	info := new(CRLInfo)
+	for _, e := range crl.TBSCertList.Extensions {
+		var rest []byte
+		var err error
+		switch {
+		case e.Id.Equal(oidExtensionCRLNumber):
+			rest, err = asn1.Unmarshal(e.Value, &info.Number)
+		case e.Id.Equal(oidExtensionDeltaCRLIndicator):
+			rest, err = asn1.Unmarshal(e.Value, &info.BaseCRLNumber)
+		case e.Id.Equal(oidExtensionAuthorityKeyId):
+			var a authKeyId
+			rest, err = asn1.Unmarshal(e.Value, &a)
+			info.AuthorityKeyId = a.Id
+		case e.Id.Equal(oidExtensionIssuingDistributionPoint):
+			info.IssuingDistributionPoint, err = parseIssuingDistributionPoint(e.Value)
+		default:
+			if e.Critical {
+				info.UnhandledCriticalExtensions = append(info.UnhandledCriticalExtensions, e.Id)
+			}
+		}
+		if err != nil {
+			return nil, err
+		}
+		if len(rest) != 0 {
+			return nil, errors.New("x509: trailing data after CRL extension")
+		}
+	}
+	return info, nil
+}
+
+func parseIssuingDistributionPoint(der []byte) (*IssuingDistributionPoint, error) {
+	var idp issuingDistributionPoint
+	if rest, err := asn1.Unmarshal(der, &idp); err != nil {
+		return nil, err
+	} else if len(rest) != 0 {
+		return nil, errors.New("x509: trailing data after CRL issuing distribution point")
+	}
+
+	out := &IssuingDistributionPoint{
+		OnlyContainsUserCerts:      idp.OnlyContainsUserCerts,
+		OnlyContainsCACerts:        idp.OnlyContainsCACerts,
+		IndirectCRL:                idp.IndirectCRL,
+		OnlyContainsAttributeCerts: idp.OnlyContainsAttributeCerts,
+		OnlySomeReasons:            idp.OnlySomeReasons,
+	}
+
+	// GeneralNames ::= SEQUENCE SIZE (1..MAX) OF GeneralName
+	rest := idp.DistributionPoint.FullName.Bytes
+	for len(rest) > 0 {
+		var n asn1.RawValue
+		var err error
+		rest, err = asn1.Unmarshal(rest, &n)
+		if err != nil {
+			return nil, err
+		}
+		if n.Tag == 6 {
+			out.URIs = append(out.URIs, string(n.Bytes))
+		}
+	}
+	return out, nil
+}
+
+func marshalIssuingDistributionPoint(in *IssuingDistributionPoint) ([]byte, error) {
+	idp := issuingDistributionPoint{
+		OnlyContainsUserCerts:      in.OnlyContainsUserCerts,
+		OnlyContainsCACerts:        in.OnlyContainsCACerts,
+		OnlySomeReasons:            in.OnlySomeReasons,
+		IndirectCRL:                in.IndirectCRL,
+		OnlyContainsAttributeCerts: in.OnlyContainsAttributeCerts,
+	}
+	if len(in.URIs) > 0 {
+		var fullName []byte
+		for _, uri := range in.URIs {
+			rawName, err := asn1.Marshal(asn1.RawValue{Tag: 6, Class: 2, Bytes: []byte(uri)})
+			if err != nil {
+				return nil, err
+			}
+			fullName = append(fullName, rawName...)
+		}
+		idp.DistributionPoint.FullName = asn1.RawValue{Tag: 0, Class: 2, IsCompound: true, Bytes: fullName}
+	}
+	return asn1.Marshal(idp)
+}
+
+// CRLOptions contains optional parameters for Certificate.CreateCRLWithOptions.
+type CRLOptions struct {
+	// Number, if not nil, is included as the CRL number extension.
+	Number *big.Int
+	// BaseCRLNumber, if not nil, makes the result a delta CRL relative to
+	// the complete CRL with that number. Number must also be set.
+	BaseCRLNumber *big.Int
+	// IssuingDistributionPoint, if not nil, is included as a critical
+	// issuing distribution point extension.
+	IssuingDistributionPoint *IssuingDistributionPoint
+	// SignatureAlgorithm, if not zero, overrides the default signature
+	// algorithm for the issuer's key.
+	SignatureAlgorithm SignatureAlgorithm
+	// ExtraExtensions contains extensions to be copied, raw, into the CRL.
+	ExtraExtensions []pkix.Extension
+}
+
+// CreateCRLWithOptions is like CreateCRL but allows the CRL number, delta CRL
+// indicator and issuing distribution point extensions to be set.
+func (c *Certificate) CreateCRLWithOptions(rand io.Reader, priv interface{}, revokedCerts []pkix.RevokedCertificate, now, expiry time.Time, opts *CRLOptions) (crlBytes []byte, err error) {
+	if opts == nil {
+		opts = new(CRLOptions)
+	}
+	if opts.BaseCRLNumber != nil && opts.Number == nil {
+		return nil, errors.New("x509: delta CRL must have a CRL number")
+	}
+
+	key, ok := priv.(crypto.Signer)
+	if !ok {
+		return nil, errors.New("x509: certificate private key does not implement crypto.Signer")
+	}
+
+	hashFunc, signatureAlgorithm, err := signingParamsForPublicKey(key.Public(), opts.SignatureAlgorithm)
+	if err != nil {
+		return nil, err
+	}
+
+	tbsCertList := pkix.TBSCertificateList{
+		Version:             1,
+		Signature:           signatureAlgorithm,
+		Issuer:              c.Subject.ToRDNSequence(),
+		ThisUpdate:          now.UTC(),
+		NextUpdate:          expiry.UTC(),
+		RevokedCertificates: revokedCerts,
+	}
+
+	// Authority Key Id
+	if len(c.SubjectKeyId) > 0 {
+		var aki pkix.Extension
+		aki.Id = oidExtensionAuthorityKeyId
+		aki.Value, err = asn1.Marshal(authKeyId{Id: c.SubjectKeyId})
+		if err != nil {
+			return
+		}
+		tbsCertList.Extensions = append(tbsCertList.Extensions, aki)
+	}
+
+	if opts.Number != nil {
+		ext := pkix.Extension{Id: oidExtensionCRLNumber}
+		ext.Value, err = asn1.Marshal(opts.Number)
+		if err != nil {
+			return
+		}
+		tbsCertList.Extensions = append(tbsCertList.Extensions, ext)
+	}
+
+	if opts.BaseCRLNumber != nil {
+		ext := pkix.Extension{Id: oidExtensionDeltaCRLIndicator, Critical: true}
+		ext.Value, err = asn1.Marshal(opts.BaseCRLNumber)
+		if err != nil {
+			return
+		}
+		tbsCertList.Extensions = append(tbsCertList.Extensions, ext)
+	}
+
+	if opts.IssuingDistributionPoint != nil {
+		ext := pkix.Extension{Id: oidExtensionIssuingDistributionPoint, Critical: true}
+		ext.Value, err = marshalIssuingDistributionPoint(opts.IssuingDistributionPoint)
+		if err != nil {
+			return
+		}
+		tbsCertList.Extensions = append(tbsCertList.Extensions, ext)
+	}
+
+	tbsCertList.Extensions = append(tbsCertList.Extensions, opts.ExtraExtensions...)
+
+	tbsCertListContents, err := asn1.Marshal(tbsCertList)
+	if err != nil {
+		return
+	}
+
+	h := hashFunc.New()
+	h.Write(tbsCertListContents)
+	digest := h.Sum(nil)
+
+	var signature []byte
+	signature, err = key.Sign(rand, digest, hashFunc)
+	if err != nil {
+		return
+	}
+
+	return asn1.Marshal(pkix.CertificateList{
+		TBSCertList:        tbsCertList,
+		SignatureAlgorithm: signatureAlgorithm,
+		SignatureValue:     asn1.BitString{Bytes: signature, BitLength: len(signature) * 8},
+	})
+}
+
+// RevocationError results when the revocation status of a certificate could
+// not be established because a CRL from its issuer was unusable.
+type RevocationError struct {
+	Cert *Certificate
+	Err  error
+}
+
+func (e RevocationError) Error() string {
+	return "x509: cannot check revocation status of certificate: " + e.Err.Error()
+}
+
+var errMissingBaseCRL = errors.New("x509: delta CRL supplied without its base CRL")
+
+// crlIssuedBy reports whether crl names issuer as its issuer.
+func crlIssuedBy(crl *pkix.CertificateList, info *CRLInfo, issuer *Certificate) bool {
+	if len(info.AuthorityKeyId) > 0 && len(issuer.SubjectKeyId) > 0 &&
+		!bytes.Equal(info.AuthorityKeyId, issuer.SubjectKeyId) {
+		return false
+	}
+
+	// Names are compared after decoding so that differences in string
+	// encoding between the certificate and the CRL don't matter.
+	var subject pkix.RDNSequence
+	if rest, err := asn1.Unmarshal(issuer.RawSubject, &subject); err != nil || len(rest) != 0 {
+		return false
+	}
+	return reflect.DeepEqual(subject, crl.TBSCertList.Issuer)
+}
+
+// crlCovers reports whether a CRL with the given extensions is in scope for
+// cert.
+func crlCovers(info *CRLInfo, cert *Certificate) bool {
+	idp := info.IssuingDistributionPoint
+	if idp == nil {
+		return true
+	}
+	if idp.IndirectCRL || idp.OnlyContainsAttributeCerts {
+		return false
+	}
+	isCA := cert.BasicConstraintsValid && cert.IsCA
+	if idp.OnlyContainsUserCerts && isCA || idp.OnlyContainsCACerts && !isCA {
+		return false
+	}
+	if len(idp.URIs) == 0 {
+		return true
+	}
+	for _, uri := range idp.URIs {
+		for _, dp := range cert.CRLDistributionPoints {
+			if uri == dp {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// checkCRL verifies the signature and freshness of crl, which claims to be
+// issued by issuer.
+func checkCRL(crl *pkix.CertificateList, info *CRLInfo, issuer *Certificate, now time.Time) error {
+	if len(info.UnhandledCriticalExtensions) > 0 {
+		return UnhandledCriticalExtension{}
+	}
+	if issuer.KeyUsage != 0 && issuer.KeyUsage&KeyUsageCRLSign == 0 {
+		return ConstraintViolationError{}
+	}
+	if err := issuer.CheckCRLSignature(crl); err != nil {
+		return err
+	}
+	if now.Before(crl.TBSCertList.ThisUpdate) || crl.HasExpired(now) {
+		return errors.New("x509: CRL has expired or is not yet valid")
+	}
+	return nil
+}
+
+// crlReason returns the reason code of a CRL entry, or zero if none is
+// given.
+func crlReason(rc *pkix.RevokedCertificate) int {
+	for _, e := range rc.Extensions {
+		if e.Id.Equal(oidExtensionReasonCode) {
+			var reason asn1.Enumerated
+			if _, err := asn1.Unmarshal(e.Value, &reason); err == nil {
+				return int(reason)
+			}
+		}
+	}
+	return 0
+}
+
+// crlEntry returns the entry for serial in crl, or nil if there is none.
+func crlEntry(crl *pkix.CertificateList, serial *big.Int) *pkix.RevokedCertificate {
+	for i := range crl.TBSCertList.RevokedCertificates {
+		rc := &crl.TBSCertList.RevokedCertificates[i]
+		if rc.SerialNumber != nil && rc.SerialNumber.Cmp(serial) == 0 {
+			return rc
+		}
+	}
+	return nil
+}
+
+// checkCRLs checks cert, issued by issuer, against those of crls that were
+// issued by issuer and cover cert. CRLs that fail signature or freshness
+// checks are ignored unless no usable complete CRL remains, in which case
+// the reason for rejecting one of them is returned. Delta CRLs newer than
+// the newest complete CRL make the status unknown if none of them can be
+// applied on top of it. A
+// certificate for which no CRL is supplied is not considered revoked.
+func checkCRLs(cert, issuer *Certificate, crls []*pkix.CertificateList, now time.Time) error {
+	type usableCRL struct {
+		crl  *pkix.CertificateList
+		info *CRLInfo
+	}
+	var complete, deltas []usableCRL
+	var rejectErr error
+
+	for _, crl := range crls {
+		info, err := ParseCRLInfo(crl)
+		if err != nil {
+			rejectErr = err
+			continue
+		}
+		if !crlIssuedBy(crl, info, issuer) || !crlCovers(info, cert) {
+			continue
+		}
+		if err := checkCRL(crl, info, issuer, now); err != nil {
+			rejectErr = err
+			continue
+		}
+		if info.IsDelta() {
+			deltas = append(deltas, usableCRL{crl, info})
+		} else {
+			complete = append(complete, usableCRL{crl, info})
+		}
+	}
+
+	if len(complete) == 0 {
+		if rejectErr == nil && len(deltas) > 0 {
+			rejectErr = errMissingBaseCRL
+		}
+		if rejectErr != nil {
+			return RevocationError{cert, rejectErr}
+		}
+		return nil
+	}
+
+	revoked := false
+	var newest *big.Int
+	for _, c := range complete {
+		if rc := crlEntry(c.crl, cert.SerialNumber); rc != nil && crlReason(rc) != reasonRemoveFromCRL {
+			revoked = true
+		}
+		if c.info.Number != nil && (newest == nil || c.info.Number.Cmp(newest) > 0) {
+			newest = c.info.Number
+		}
+	}
+
+	// A delta CRL no newer than the newest complete CRL is superseded by
+	// it. Of the others, the newest that may be applied on top of a
+	// complete CRL at least as new as its base decides.
+	var delta *usableCRL
+	stale := true
+	for i := range deltas {
+		d := &deltas[i]
+		if d.info.Number == nil || newest != nil && d.info.Number.Cmp(newest) <= 0 {
+			continue
+		}
+		stale = false
+		if newest == nil || d.info.BaseCRLNumber.Cmp(newest) > 0 {
+			continue
+		}
+		if delta == nil || d.info.Number.Cmp(delta.info.Number) > 0 {
+			delta = d
+		}
+	}
+	if !stale && delta == nil {
+		return RevocationError{cert, errMissingBaseCRL}
+	}
+	if delta != nil {
+		if rc := crlEntry(delta.crl, cert.SerialNumber); rc != nil {
+			revoked = crlReason(rc) != reasonRemoveFromCRL
+		}
+	}
+
+	if revoked {
+		return CertificateInvalidError{cert, Revoked}
+	}
+	return nil
+}
+
+// checkChainRevocation checks every non-root certificate in chain against
+// opts.CRLs and opts.CheckRevocation.
+func checkChainRevocation(chain []*Certificate, opts *VerifyOptions) error {
+	now := opts.CurrentTime
+	if now.IsZero() {
+		now = time.Now()
+	}
+	for i := 0; i+1 < len(chain); i++ {
+		cert, issuer := chain[i], chain[i+1]
+		if len(opts.CRLs) > 0 {
+			if err := checkCRLs(cert, issuer, opts.CRLs, now); err != nil {
+				return err
+			}
+		}
+		if opts.CheckRevocation != nil {
+			if err := opts.CheckRevocation(cert, issuer); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
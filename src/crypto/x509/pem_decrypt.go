@@ -13,6 +13,7 @@ import (
 	"crypto/cipher"
 	"crypto/des"
 	"crypto/md5"
+	"encoding/asn1"
 	"encoding/hex"
 	"encoding/pem"
 	"errors"
@@ -39,6 +40,9 @@ type rfc1423Algo struct {
 	cipherFunc func(key []byte) (cipher.Block, error)
 	keySize    int
 	blockSize  int
+	// oid identifies the cipher when it is used as a PBES2 encryption
+	// scheme in an encrypted PKCS#8 key.
+	oid asn1.ObjectIdentifier
 }
 
 // rfc1423Algos holds a slice of the possible ways to encrypt a PEM
@@ -49,30 +53,35 @@ var rfc1423Algos = []rfc1423Algo{{
 	cipherFunc: des.NewCipher,
 	keySize:    8,
 	blockSize:  des.BlockSize,
+	oid:        asn1.ObjectIdentifier{1, 3, 14, 3, 2, 7},
 }, {
 	cipher:     PEMCipher3DES,
 	name:       "DES-EDE3-CBC",
 	cipherFunc: des.NewTripleDESCipher,
 	keySize:    24,
 	blockSize:  des.BlockSize,
+	oid:        asn1.ObjectIdentifier{1, 2, 840, 113549, 3, 7},
 }, {
 	cipher:     PEMCipherAES128,
 	name:       "AES-128-CBC",
 	cipherFunc: aes.NewCipher,
 	keySize:    16,
 	blockSize:  aes.BlockSize,
+	oid:        asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 1, 2},
 }, {
 	cipher:     PEMCipherAES192,
 	name:       "AES-192-CBC",
 	cipherFunc: aes.NewCipher,
 	keySize:    24,
 	blockSize:  aes.BlockSize,
+	oid:        asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 1, 22},
 }, {
 	cipher:     PEMCipherAES256,
 	name:       "AES-256-CBC",
 	cipherFunc: aes.NewCipher,
 	keySize:    32,
 	blockSize:  aes.BlockSize,
+	oid:        asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 1, 42},
 },
 }
 
@@ -238,3 +247,13 @@ func cipherByKey(key PEMCipher) *rfc1423Algo {
 	}
 	return nil
 }
+
+func cipherByOID(oid asn1.ObjectIdentifier) *rfc1423Algo {
+	for i := range rfc1423Algos {
+		alg := &rfc1423Algos[i]
+		if alg.oid.Equal(oid) {
+			return alg
+		}
+	}
+	return nil
+}
@@ -0,0 +1,235 @@
+// Copyright 2016 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package x509
+
+// RFC 5958 describes the encrypted PKCS#8 container and RFC 2898 (PKCS #5
+// v2.0) the PBES2 scheme and PBKDF2 key derivation function used to encrypt
+// it. Only PBES2 with PBKDF2 and a CBC mode cipher is supported.
+
+import (
+	"crypto/cipher"
+	"crypto/pbkdf2"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"errors"
+	"hash"
+	"io"
+)
+
+var (
+	oidPBES2  = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 5, 13}
+	oidPBKDF2 = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 5, 12}
+
+	oidHMACWithSHA1   = asn1.ObjectIdentifier{1, 2, 840, 113549, 2, 7}
+	oidHMACWithSHA224 = asn1.ObjectIdentifier{1, 2, 840, 113549, 2, 8}
+	oidHMACWithSHA256 = asn1.ObjectIdentifier{1, 2, 840, 113549, 2, 9}
+	oidHMACWithSHA384 = asn1.ObjectIdentifier{1, 2, 840, 113549, 2, 10}
+	oidHMACWithSHA512 = asn1.ObjectIdentifier{1, 2, 840, 113549, 2, 11}
+)
+
+// pbkdf2Iterations is the PBKDF2 iteration count used when encrypting.
+const pbkdf2Iterations = 10000
+
+// pbkdf2SaltSize is the size of the PBKDF2 salt used when encrypting.
+const pbkdf2SaltSize = 16
+
+// encryptedPrivateKeyInfo reflects an ASN.1 EncryptedPrivateKeyInfo. See RFC
+// 5958, section 3.
+type encryptedPrivateKeyInfo struct {
+	Algo          pkix.AlgorithmIdentifier
+	EncryptedData []byte
+}
+
+// pbes2Params reflects the PBES2-params structure of RFC 2898, appendix A.4.
+type pbes2Params struct {
+	KeyDerivationFunc pkix.AlgorithmIdentifier
+	EncryptionScheme  pkix.AlgorithmIdentifier
+}
+
+// pbkdf2Params reflects the PBKDF2-params structure of RFC 2898, appendix
+// A.2. The salt is always of the specified form.
+type pbkdf2Params struct {
+	Salt           []byte
+	IterationCount int
+	KeyLength      int                      `asn1:"optional"`
+	PRF            pkix.AlgorithmIdentifier `asn1:"optional"`
+}
+
+// prfByOID returns the hash function for a PBKDF2 pseudorandom function.
+// HMAC-SHA1 is the default when none is given.
+func prfByOID(oid asn1.ObjectIdentifier) func() hash.Hash {
+	switch {
+	case len(oid) == 0, oid.Equal(oidHMACWithSHA1):
+		return sha1.New
+	case oid.Equal(oidHMACWithSHA224):
+		return sha256.New224
+	case oid.Equal(oidHMACWithSHA256):
+		return sha256.New
+	case oid.Equal(oidHMACWithSHA384):
+		return sha512.New384
+	case oid.Equal(oidHMACWithSHA512):
+		return sha512.New
+	}
+	return nil
+}
+
+// IsEncryptedPKCS8 reports whether der is an encrypted PKCS#8 private key,
+// as found in PEM blocks with "BEGIN ENCRYPTED PRIVATE KEY".
+func IsEncryptedPKCS8(der []byte) bool {
+	var info encryptedPrivateKeyInfo
+	rest, err := asn1.Unmarshal(der, &info)
+	return err == nil && len(rest) == 0 && len(info.Algo.Algorithm) > 0
+}
+
+// DecryptPKCS8PrivateKey decrypts an encrypted PKCS#8 private key, as found
+// in PEM blocks with "BEGIN ENCRYPTED PRIVATE KEY", and returns the DER
+// encoded PKCS#8 private key, which can be passed to ParsePKCS8PrivateKey.
+// Only PBES2 encryption using PBKDF2 and DES, 3DES or AES in CBC mode is
+// supported. If an incorrect password is detected an IncorrectPasswordError
+// is returned.
+func DecryptPKCS8PrivateKey(der, password []byte) ([]byte, error) {
+	var info encryptedPrivateKeyInfo
+	if rest, err := asn1.Unmarshal(der, &info); err != nil {
+		return nil, err
+	} else if len(rest) != 0 {
+		return nil, errors.New("x509: trailing data after encrypted PKCS#8 key")
+	}
+	if !info.Algo.Algorithm.Equal(oidPBES2) {
+		return nil, errors.New("x509: unsupported PKCS#8 encryption scheme")
+	}
+
+	var params pbes2Params
+	if _, err := asn1.Unmarshal(info.Algo.Parameters.FullBytes, &params); err != nil {
+		return nil, errors.New("x509: invalid PBES2 parameters: " + err.Error())
+	}
+	if !params.KeyDerivationFunc.Algorithm.Equal(oidPBKDF2) {
+		return nil, errors.New("x509: unsupported PBES2 key derivation function")
+	}
+	var kdfParams pbkdf2Params
+	if _, err := asn1.Unmarshal(params.KeyDerivationFunc.Parameters.FullBytes, &kdfParams); err != nil {
+		return nil, errors.New("x509: invalid PBKDF2 parameters: " + err.Error())
+	}
+	if kdfParams.IterationCount <= 0 {
+		return nil, errors.New("x509: invalid PBKDF2 iteration count")
+	}
+	prf := prfByOID(kdfParams.PRF.Algorithm)
+	if prf == nil {
+		return nil, errors.New("x509: unsupported PBKDF2 pseudorandom function")
+	}
+
+	ciph := cipherByOID(params.EncryptionScheme.Algorithm)
+	if ciph == nil {
+		return nil, errors.New("x509: unsupported PBES2 encryption scheme")
+	}
+	var iv []byte
+	if _, err := asn1.Unmarshal(params.EncryptionScheme.Parameters.FullBytes, &iv); err != nil {
+		return nil, errors.New("x509: invalid PBES2 IV: " + err.Error())
+	}
+	if len(iv) != ciph.blockSize {
+		return nil, errors.New("x509: incorrect IV size")
+	}
+	if kdfParams.KeyLength != 0 && kdfParams.KeyLength != ciph.keySize {
+		return nil, errors.New("x509: PBKDF2 key length does not match cipher")
+	}
+
+	key := pbkdf2.Key(password, kdfParams.Salt, kdfParams.IterationCount, ciph.keySize, prf)
+	block, err := ciph.cipherFunc(key)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(info.EncryptedData) == 0 || len(info.EncryptedData)%ciph.blockSize != 0 {
+		return nil, errors.New("x509: encrypted PKCS#8 data is not a multiple of the block size")
+	}
+	data := make([]byte, len(info.EncryptedData))
+	cipher.NewCBCDecrypter(block, iv).CryptBlocks(data, info.EncryptedData)
+
+	// The padding scheme is that of RFC 1423, see DecryptPEMBlock.
+	dlen := len(data)
+	last := int(data[dlen-1])
+	if last == 0 || last > ciph.blockSize || dlen < last {
+		return nil, IncorrectPasswordError
+	}
+	for _, val := range data[dlen-last:] {
+		if int(val) != last {
+			return nil, IncorrectPasswordError
+		}
+	}
+	return data[:dlen-last], nil
+}
+
+// EncryptPKCS8PrivateKey encrypts a DER encoded PKCS#8 private key, such as
+// one returned by MarshalPKCS8PrivateKey, with the given password and
+// cipher. The result uses PBES2 with PBKDF2 and HMAC-SHA256 and is suitable
+// for PEM blocks with "BEGIN ENCRYPTED PRIVATE KEY".
+func EncryptPKCS8PrivateKey(rand io.Reader, der, password []byte, alg PEMCipher) ([]byte, error) {
+	ciph := cipherByKey(alg)
+	if ciph == nil {
+		return nil, errors.New("x509: unknown encryption mode")
+	}
+
+	salt := make([]byte, pbkdf2SaltSize)
+	if _, err := io.ReadFull(rand, salt); err != nil {
+		return nil, errors.New("x509: cannot generate salt: " + err.Error())
+	}
+	iv := make([]byte, ciph.blockSize)
+	if _, err := io.ReadFull(rand, iv); err != nil {
+		return nil, errors.New("x509: cannot generate IV: " + err.Error())
+	}
+
+	key := pbkdf2.Key(password, salt, pbkdf2Iterations, ciph.keySize, sha256.New)
+	block, err := ciph.cipherFunc(key)
+	if err != nil {
+		return nil, err
+	}
+
+	pad := ciph.blockSize - len(der)%ciph.blockSize
+	encrypted := make([]byte, len(der), len(der)+pad)
+	copy(encrypted, der)
+	for i := 0; i < pad; i++ {
+		encrypted = append(encrypted, byte(pad))
+	}
+	cipher.NewCBCEncrypter(block, iv).CryptBlocks(encrypted, encrypted)
+
+	kdfParams, err := asn1.Marshal(pbkdf2Params{
+		Salt:           salt,
+		IterationCount: pbkdf2Iterations,
+		PRF: pkix.AlgorithmIdentifier{
+			Algorithm:  oidHMACWithSHA256,
+			Parameters: asn1.RawValue{Tag: 5},
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+	ivBytes, err := asn1.Marshal(iv)
+	if err != nil {
+		return nil, err
+	}
+	params, err := asn1.Marshal(pbes2Params{
+		KeyDerivationFunc: pkix.AlgorithmIdentifier{
+			Algorithm:  oidPBKDF2,
+			Parameters: asn1.RawValue{FullBytes: kdfParams},
+		},
+		EncryptionScheme: pkix.AlgorithmIdentifier{
+			Algorithm:  ciph.oid,
+			Parameters: asn1.RawValue{FullBytes: ivBytes},
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return asn1.Marshal(encryptedPrivateKeyInfo{
+		Algo: pkix.AlgorithmIdentifier{
+			Algorithm:  oidPBES2,
+			Parameters: asn1.RawValue{FullBytes: params},
+		},
+		EncryptedData: encrypted,
+	})
+}
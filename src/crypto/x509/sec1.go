@@ -40,6 +40,13 @@ func MarshalECPrivateKey(key *ecdsa.PrivateKey) ([]byte, error) {
 		return nil, errors.New("x509: unknown elliptic curve")
 	}
 
+	return marshalECPrivateKeyWithOID(key, oid)
+}
+
+// marshalECPrivateKeyWithOID marshals an EC private key into ASN.1, DER
+// format. The curve OID is omitted if oid is nil, which is the case when
+// the curve is given by an enclosing PKCS8 container.
+func marshalECPrivateKeyWithOID(key *ecdsa.PrivateKey, oid asn1.ObjectIdentifier) ([]byte, error) {
 	privateKeyBytes := key.D.Bytes()
 	paddedPrivateKey := make([]byte, (key.Curve.Params().N.BitLen() + 7) / 8)
 	copy(paddedPrivateKey[len(paddedPrivateKey) - len(privateKeyBytes):], privateKeyBytes)
@@ -5,6 +5,8 @@
 package x509
 
 import (
+	"bytes"
+	"crypto/x509/pkix"
 	"errors"
 	"fmt"
 	"net"
@@ -33,6 +35,9 @@ const (
 	// IncompatibleUsage results when the certificate's key usage indicates
 	// that it may only be used for a different purpose.
 	IncompatibleUsage
+	// Revoked results when a CRL given in the VerifyOptions lists the
+	// certificate as revoked.
+	Revoked
 )
 
 // CertificateInvalidError results when an odd error occurs. Users of this
@@ -54,6 +59,8 @@ func (e CertificateInvalidError) Error() string {
 		return "x509: too many intermediates for path length constraint"
 	case IncompatibleUsage:
 		return "x509: certificate specifies an incompatible key usage"
+	case Revoked:
+		return "x509: certificate has been revoked"
 	}
 	return "x509: unknown error"
 }
@@ -139,6 +146,21 @@ type VerifyOptions struct {
 	// constraint down the chain which mirrors Windows CryptoAPI behaviour,
 	// but not the spec. To accept any key usage, include ExtKeyUsageAny.
 	KeyUsages []ExtKeyUsage
+
+	// CRLs contains certificate revocation lists that are consulted for
+	// every certificate in a candidate chain except the root. A CRL is
+	// only used if it names the certificate's issuer, is in scope for the
+	// certificate, is signed by the issuer and is current. Chains with a
+	// revoked certificate are discarded. Certificates not covered by any
+	// CRL are not considered revoked. Of the delta CRLs newer than the
+	// newest complete CRL, the newest whose base is no newer than it is
+	// applied on top of it; if there is none, the chain is discarded
+	// with a RevocationError.
+	CRLs []*pkix.CertificateList
+	// CheckRevocation, if not nil, is called for every certificate in a
+	// candidate chain except the root, along with its issuer. Chains for
+	// which it returns an error are discarded.
+	CheckRevocation func(cert, issuer *Certificate) error
 }
 
 const (
@@ -157,19 +179,23 @@ func (c *Certificate) isValid(certType int, currentChain []*Certificate, opts *V
 		return CertificateInvalidError{c, Expired}
 	}
 
-	if len(c.PermittedDNSDomains) > 0 {
-		ok := false
-		for _, domain := range c.PermittedDNSDomains {
-			if opts.DNSName == domain ||
-				(strings.HasSuffix(opts.DNSName, domain) &&
-					len(opts.DNSName) >= 1+len(domain) &&
-					opts.DNSName[len(opts.DNSName)-len(domain)-1] == '.') {
-				ok = true
-				break
-			}
+	// The name constraints of c apply to every certificate below it in
+	// the chain, except self-issued intermediates. See RFC 5280, section
+	// 6.1.3. The name being verified must also satisfy the constraints of
+	// the leaf itself, but the leaf's own names are not checked.
+	if len(currentChain) == 0 {
+		if !c.namesPermitted(&Certificate{}, opts.DNSName) {
+			return CertificateInvalidError{c, CANotAuthorizedForThisName}
 		}
-
-		if !ok {
+	}
+	for i, cert := range currentChain {
+		verifiedName := ""
+		if i == 0 {
+			verifiedName = opts.DNSName
+		} else if bytes.Equal(cert.RawSubject, cert.RawIssuer) {
+			continue
+		}
+		if !c.namesPermitted(cert, verifiedName) {
 			return CertificateInvalidError{c, CANotAuthorizedForThisName}
 		}
 	}
@@ -205,6 +231,165 @@ func (c *Certificate) isValid(certType int, currentChain []*Certificate, opts *V
 	return nil
 }
 
+// hasNameConstraints reports whether c constrains the names of the
+// certificates it issues.
+func (c *Certificate) hasNameConstraints() bool {
+	return len(c.PermittedDNSDomains) > 0 || len(c.ExcludedDNSDomains) > 0 ||
+		len(c.PermittedIPRanges) > 0 || len(c.ExcludedIPRanges) > 0 ||
+		len(c.PermittedEmailAddresses) > 0 || len(c.ExcludedEmailAddresses) > 0 ||
+		len(c.PermittedURIDomains) > 0 || len(c.ExcludedURIDomains) > 0
+}
+
+// namesPermitted reports whether the names in cert, and the name being
+// verified, satisfy the name constraints of c. See RFC 5280, section
+// 4.2.1.10. A name must not fall within any excluded subtree of its form
+// and, if c has permitted subtrees of that form, must fall within one of
+// them.
+func (c *Certificate) namesPermitted(cert *Certificate, verifiedName string) bool {
+	if !c.hasNameConstraints() {
+		return true
+	}
+
+	dnsNames := cert.DNSNames
+	ipAddresses := cert.IPAddresses
+	if len(verifiedName) > 0 {
+		if ip := net.ParseIP(verifiedName); ip != nil {
+			ipAddresses = append(ipAddresses[:len(ipAddresses):len(ipAddresses)], ip)
+		} else {
+			dnsNames = append(dnsNames[:len(dnsNames):len(dnsNames)], verifiedName)
+		}
+	}
+
+	for _, name := range dnsNames {
+		if !checkConstraints(name, c.PermittedDNSDomains, c.ExcludedDNSDomains, matchDomainConstraint) {
+			return false
+		}
+	}
+
+	for _, email := range cert.EmailAddresses {
+		if !checkConstraints(email, c.PermittedEmailAddresses, c.ExcludedEmailAddresses, matchEmailConstraint) {
+			return false
+		}
+	}
+
+	for _, uri := range cert.URIs {
+		if len(c.PermittedURIDomains) == 0 && len(c.ExcludedURIDomains) == 0 {
+			break
+		}
+		host := uri.Host
+		if h, _, err := net.SplitHostPort(host); err == nil {
+			host = h
+		}
+		// URIs without a domain name host can't be checked against
+		// domain constraints and so are rejected.
+		if len(host) == 0 || net.ParseIP(host) != nil {
+			return false
+		}
+		if !checkConstraints(host, c.PermittedURIDomains, c.ExcludedURIDomains, matchURIDomainConstraint) {
+			return false
+		}
+	}
+
+	for _, ip := range ipAddresses {
+		permitted := len(c.PermittedIPRanges) == 0
+		for _, ipNet := range c.PermittedIPRanges {
+			if matchIPConstraint(ip, ipNet) {
+				permitted = true
+				break
+			}
+		}
+		if !permitted {
+			return false
+		}
+		for _, ipNet := range c.ExcludedIPRanges {
+			if matchIPConstraint(ip, ipNet) {
+				return false
+			}
+		}
+	}
+
+	return true
+}
+
+// checkConstraints reports whether name is matched by none of excluded and,
+// if permitted is not empty, by at least one of permitted.
+func checkConstraints(name string, permitted, excluded []string, match func(name, constraint string) bool) bool {
+	for _, constraint := range excluded {
+		if match(name, constraint) {
+			return false
+		}
+	}
+	if len(permitted) == 0 {
+		return true
+	}
+	for _, constraint := range permitted {
+		if match(name, constraint) {
+			return true
+		}
+	}
+	return false
+}
+
+// matchDomainConstraint reports whether domain is within the DNS subtree
+// given by constraint. A constraint with a leading period only matches
+// subdomains.
+func matchDomainConstraint(domain, constraint string) bool {
+	if len(constraint) == 0 {
+		return true
+	}
+	domain = toLowerCaseASCII(strings.TrimSuffix(domain, "."))
+	constraint = toLowerCaseASCII(constraint)
+	if constraint[0] == '.' {
+		return strings.HasSuffix(domain, constraint)
+	}
+	return domain == constraint || strings.HasSuffix(domain, "."+constraint)
+}
+
+// matchURIDomainConstraint reports whether the host of a URI is within the
+// subtree given by constraint. Unlike DNS constraints, a constraint without
+// a leading period only matches that exact host.
+func matchURIDomainConstraint(host, constraint string) bool {
+	if len(constraint) > 0 && constraint[0] == '.' {
+		return matchDomainConstraint(host, constraint)
+	}
+	return toLowerCaseASCII(host) == toLowerCaseASCII(constraint)
+}
+
+// matchEmailConstraint reports whether email is within the subtree given by
+// constraint, which is either a mailbox, a host or a domain with a leading
+// period.
+func matchEmailConstraint(email, constraint string) bool {
+	at := strings.LastIndex(email, "@")
+	if at < 0 {
+		return false
+	}
+	local, host := email[:at], email[at+1:]
+
+	if i := strings.LastIndex(constraint, "@"); i >= 0 {
+		return local == constraint[:i] && toLowerCaseASCII(host) == toLowerCaseASCII(constraint[i+1:])
+	}
+	return matchURIDomainConstraint(host, constraint)
+}
+
+// matchIPConstraint reports whether ip lies within ipNet. Addresses only
+// match ranges of the same family.
+func matchIPConstraint(ip net.IP, ipNet *net.IPNet) bool {
+	if len(ipNet.IP) == net.IPv4len {
+		ip = ip.To4()
+	} else {
+		ip = ip.To16()
+	}
+	if ip == nil || len(ip) != len(ipNet.Mask) {
+		return false
+	}
+	for i := range ip {
+		if ip[i]&ipNet.Mask[i] != ipNet.IP[i]&ipNet.Mask[i] {
+			return false
+		}
+	}
+	return true
+}
+
 // Verify attempts to verify c by building one or more chains from c to a
 // certificate in opts.Roots, using certificates in opts.Intermediates if
 // needed. If successful, it returns one or more chains where the first
@@ -213,7 +398,8 @@ func (c *Certificate) isValid(certType int, currentChain []*Certificate, opts *V
 // If opts.Roots is nil and system roots are unavailable the returned error
 // will be of type SystemRootsError.
 //
-// WARNING: this doesn't do any revocation checking.
+// WARNING: revocation is only checked if opts.CRLs or opts.CheckRevocation
+// is set.
 func (c *Certificate) Verify(opts VerifyOptions) (chains [][]*Certificate, err error) {
 	// Platform-specific verification needs the ASN.1 contents so
 	// this makes the behaviour consistent across platforms.
@@ -266,25 +452,48 @@ func (c *Certificate) Verify(opts VerifyOptions) (chains [][]*Certificate, err e
 		keyUsages = []ExtKeyUsage{ExtKeyUsageServerAuth}
 	}
 
-	// If any key usage is acceptable then we're done.
+	// If any key usage is acceptable then all chains are.
+	anyKeyUsage := false
 	for _, usage := range keyUsages {
 		if usage == ExtKeyUsageAny {
-			chains = candidateChains
-			return
+			anyKeyUsage = true
+			break
 		}
 	}
 
-	for _, candidate := range candidateChains {
-		if checkChainForKeyUsage(candidate, keyUsages) {
-			chains = append(chains, candidate)
+	if anyKeyUsage {
+		chains = candidateChains
+	} else {
+		for _, candidate := range candidateChains {
+			if checkChainForKeyUsage(candidate, keyUsages) {
+				chains = append(chains, candidate)
+			}
+		}
+
+		if len(chains) == 0 {
+			err = CertificateInvalidError{c, IncompatibleUsage}
+			return
 		}
 	}
 
-	if len(chains) == 0 {
-		err = CertificateInvalidError{c, IncompatibleUsage}
+	if len(opts.CRLs) == 0 && opts.CheckRevocation == nil {
+		return
 	}
 
-	return
+	unrevoked := chains[:0]
+	for _, chain := range chains {
+		if revokedErr := checkChainRevocation(chain, &opts); revokedErr != nil {
+			if err == nil {
+				err = revokedErr
+			}
+			continue
+		}
+		unrevoked = append(unrevoked, chain)
+	}
+	if len(unrevoked) == 0 {
+		return nil, err
+	}
+	return unrevoked, nil
 }
 
 func appendToFreshChain(chain []*Certificate, cert *Certificate) []*Certificate {
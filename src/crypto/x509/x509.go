@@ -23,7 +23,9 @@ import (
 	"io"
 	"math/big"
 	"net"
+	"net/url"
 	"strconv"
+	"strings"
 	"time"
 )
 
@@ -80,14 +82,32 @@ func marshalPublicKey(pub interface{}) (publicKeyBytes []byte, publicKeyAlgorith
 			return
 		}
 		publicKeyAlgorithm.Parameters.FullBytes = paramBytes
+	case *dsa.PublicKey:
+		publicKeyBytes, err = asn1.Marshal(pub.Y)
+		if err != nil {
+			return nil, pkix.AlgorithmIdentifier{}, err
+		}
+		publicKeyAlgorithm.Algorithm = oidPublicKeyDSA
+		var paramBytes []byte
+		paramBytes, err = asn1.Marshal(dsaAlgorithmParameters{
+			P: pub.P,
+			Q: pub.Q,
+			G: pub.G,
+		})
+		if err != nil {
+			return
+		}
+		publicKeyAlgorithm.Parameters.FullBytes = paramBytes
 	default:
-		return nil, pkix.AlgorithmIdentifier{}, errors.New("x509: only RSA and ECDSA public keys supported")
+		return nil, pkix.AlgorithmIdentifier{}, errors.New("x509: only RSA, DSA and ECDSA public keys supported")
 	}
 
 	return publicKeyBytes, publicKeyAlgorithm, nil
 }
 
 // MarshalPKIXPublicKey serialises a public key to DER-encoded PKIX format.
+// The following key types are supported: *rsa.PublicKey, *dsa.PublicKey and
+// *ecdsa.PublicKey.
 func MarshalPKIXPublicKey(pub interface{}) ([]byte, error) {
 	var publicKeyBytes []byte
 	var publicKeyAlgorithm pkix.AlgorithmIdentifier
@@ -549,10 +569,21 @@ type Certificate struct {
 	DNSNames       []string
 	EmailAddresses []string
 	IPAddresses    []net.IP
+	URIs           []*url.URL
 
-	// Name constraints
+	// Name constraints. A DNS or URI domain constraint with a leading
+	// period matches only subdomains; otherwise it also matches the domain
+	// itself. An email constraint is either a full mailbox, a host, or a
+	// domain with a leading period.
 	PermittedDNSDomainsCritical bool // if true then the name constraints are marked critical.
 	PermittedDNSDomains         []string
+	ExcludedDNSDomains          []string
+	PermittedIPRanges           []*net.IPNet
+	ExcludedIPRanges            []*net.IPNet
+	PermittedEmailAddresses     []string
+	ExcludedEmailAddresses      []string
+	PermittedURIDomains         []string
+	ExcludedURIDomains          []string
 
 	// CRL Distribution Points
 	CRLDistributionPoints []string
@@ -760,7 +791,7 @@ type nameConstraints struct {
 }
 
 type generalSubtree struct {
-	Name string `asn1:"tag:2,optional,ia5"`
+	Name asn1.RawValue
 }
 
 // RFC 5280, 4.2.2.1
@@ -865,7 +896,7 @@ func parsePublicKey(algo PublicKeyAlgorithm, keyData *publicKeyInfo) (interface{
 	}
 }
 
-func parseSANExtension(value []byte) (dnsNames, emailAddresses []string, ipAddresses []net.IP, err error) {
+func parseSANExtension(value []byte) (dnsNames, emailAddresses []string, ipAddresses []net.IP, uris []*url.URL, err error) {
 	// RFC 5280, 4.2.1.6
 
 	// SubjectAltName ::= GeneralNames
@@ -907,6 +938,14 @@ func parseSANExtension(value []byte) (dnsNames, emailAddresses []string, ipAddre
 			emailAddresses = append(emailAddresses, string(v.Bytes))
 		case 2:
 			dnsNames = append(dnsNames, string(v.Bytes))
+		case 6:
+			var uri *url.URL
+			uri, err = url.Parse(string(v.Bytes))
+			if err != nil {
+				err = errors.New("x509: cannot parse URI " + strconv.Quote(string(v.Bytes)) + ": " + err.Error())
+				return
+			}
+			uris = append(uris, uri)
 		case 7:
 			switch len(v.Bytes) {
 			case net.IPv4len, net.IPv6len:
@@ -921,6 +960,70 @@ func parseSANExtension(value []byte) (dnsNames, emailAddresses []string, ipAddre
 	return
 }
 
+// parseGeneralSubtrees splits the bases of a list of GeneralSubtrees by
+// name form. It sets unhandled if a name form is found that is not
+// supported.
+func parseGeneralSubtrees(subtrees []generalSubtree) (dnsDomains []string, ipRanges []*net.IPNet, emails, uriDomains []string, unhandled bool, err error) {
+	for _, subtree := range subtrees {
+		name := subtree.Name
+		if name.Class != 2 {
+			unhandled = true
+			continue
+		}
+		switch name.Tag {
+		case 1:
+			emails = append(emails, string(name.Bytes))
+		case 2:
+			dnsDomains = append(dnsDomains, string(name.Bytes))
+		case 6:
+			domain := string(name.Bytes)
+			if strings.Contains(domain, "/") || net.ParseIP(domain) != nil {
+				err = errors.New("x509: invalid URI name constraint " + strconv.Quote(domain))
+				return
+			}
+			uriDomains = append(uriDomains, domain)
+		case 7:
+			// An IP range is encoded as an address followed by a mask
+			// of the same length.
+			l := len(name.Bytes)
+			if l != 2*net.IPv4len && l != 2*net.IPv6len {
+				err = errors.New("x509: IP name constraint of length " + strconv.Itoa(l))
+				return
+			}
+			ipRanges = append(ipRanges, &net.IPNet{IP: net.IP(name.Bytes[:l/2]), Mask: net.IPMask(name.Bytes[l/2:])})
+		default:
+			unhandled = true
+		}
+	}
+	return
+}
+
+// marshalGeneralSubtrees is the inverse of parseGeneralSubtrees.
+func marshalGeneralSubtrees(dnsDomains []string, ipRanges []*net.IPNet, emails, uriDomains []string) []generalSubtree {
+	var out []generalSubtree
+	for _, domain := range dnsDomains {
+		out = append(out, generalSubtree{Name: asn1.RawValue{Tag: 2, Class: 2, Bytes: []byte(domain)}})
+	}
+	for _, ipNet := range ipRanges {
+		ip, mask := ipNet.IP, ipNet.Mask
+		// Encode IPv4 ranges in 4+4 bytes where possible.
+		if ip4 := ip.To4(); ip4 != nil && len(mask) == net.IPv4len {
+			ip = ip4
+		}
+		b := make([]byte, 0, len(ip)+len(mask))
+		b = append(b, ip...)
+		b = append(b, mask...)
+		out = append(out, generalSubtree{Name: asn1.RawValue{Tag: 7, Class: 2, Bytes: b}})
+	}
+	for _, email := range emails {
+		out = append(out, generalSubtree{Name: asn1.RawValue{Tag: 1, Class: 2, Bytes: []byte(email)}})
+	}
+	for _, domain := range uriDomains {
+		out = append(out, generalSubtree{Name: asn1.RawValue{Tag: 6, Class: 2, Bytes: []byte(domain)}})
+	}
+	return out
+}
+
 func parseCertificate(in *certificate) (*Certificate, error) {
 	out := new(Certificate)
 	out.Raw = in.Raw
@@ -1000,12 +1103,12 @@ func parseCertificate(in *certificate) (*Certificate, error) {
 				out.MaxPathLenZero = out.MaxPathLen == 0
 
 			case 17:
-				out.DNSNames, out.EmailAddresses, out.IPAddresses, err = parseSANExtension(e.Value)
+				out.DNSNames, out.EmailAddresses, out.IPAddresses, out.URIs, err = parseSANExtension(e.Value)
 				if err != nil {
 					return nil, err
 				}
 
-				if len(out.DNSNames) == 0 && len(out.EmailAddresses) == 0 && len(out.IPAddresses) == 0 {
+				if len(out.DNSNames) == 0 && len(out.EmailAddresses) == 0 && len(out.IPAddresses) == 0 && len(out.URIs) == 0 {
 					// If we didn't parse anything then we do the critical check, below.
 					unhandled = true
 				}
@@ -1033,18 +1136,18 @@ func parseCertificate(in *certificate) (*Certificate, error) {
 					return nil, errors.New("x509: trailing data after X.509 NameConstraints")
 				}
 
-				if len(constraints.Excluded) > 0 && e.Critical {
+				unhandledName := false
+				if out.PermittedDNSDomains, out.PermittedIPRanges, out.PermittedEmailAddresses, out.PermittedURIDomains, unhandledName, err = parseGeneralSubtrees(constraints.Permitted); err != nil {
+					return nil, err
+				}
+				if unhandledName && e.Critical {
 					return out, UnhandledCriticalExtension{}
 				}
-
-				for _, subtree := range constraints.Permitted {
-					if len(subtree.Name) == 0 {
-						if e.Critical {
-							return out, UnhandledCriticalExtension{}
-						}
-						continue
-					}
-					out.PermittedDNSDomains = append(out.PermittedDNSDomains, subtree.Name)
+				if out.ExcludedDNSDomains, out.ExcludedIPRanges, out.ExcludedEmailAddresses, out.ExcludedURIDomains, unhandledName, err = parseGeneralSubtrees(constraints.Excluded); err != nil {
+					return nil, err
+				}
+				if unhandledName && e.Critical {
+					return out, UnhandledCriticalExtension{}
 				}
 
 			case 31:
@@ -1280,7 +1383,7 @@ func oidInExtensions(oid asn1.ObjectIdentifier, extensions []pkix.Extension) boo
 
 // marshalSANs marshals a list of addresses into a the contents of an X.509
 // SubjectAlternativeName extension.
-func marshalSANs(dnsNames, emailAddresses []string, ipAddresses []net.IP) (derBytes []byte, err error) {
+func marshalSANs(dnsNames, emailAddresses []string, ipAddresses []net.IP, uris []*url.URL) (derBytes []byte, err error) {
 	var rawValues []asn1.RawValue
 	for _, name := range dnsNames {
 		rawValues = append(rawValues, asn1.RawValue{Tag: 2, Class: 2, Bytes: []byte(name)})
@@ -1296,6 +1399,9 @@ func marshalSANs(dnsNames, emailAddresses []string, ipAddresses []net.IP) (derBy
 		}
 		rawValues = append(rawValues, asn1.RawValue{Tag: 7, Class: 2, Bytes: ip})
 	}
+	for _, uri := range uris {
+		rawValues = append(rawValues, asn1.RawValue{Tag: 6, Class: 2, Bytes: []byte(uri.String())})
+	}
 	return asn1.Marshal(rawValues)
 }
 
@@ -1405,10 +1511,10 @@ func buildExtensions(template *Certificate) (ret []pkix.Extension, err error) {
 		n++
 	}
 
-	if (len(template.DNSNames) > 0 || len(template.EmailAddresses) > 0 || len(template.IPAddresses) > 0) &&
+	if (len(template.DNSNames) > 0 || len(template.EmailAddresses) > 0 || len(template.IPAddresses) > 0 || len(template.URIs) > 0) &&
 		!oidInExtensions(oidExtensionSubjectAltName, template.ExtraExtensions) {
 		ret[n].Id = oidExtensionSubjectAltName
-		ret[n].Value, err = marshalSANs(template.DNSNames, template.EmailAddresses, template.IPAddresses)
+		ret[n].Value, err = marshalSANs(template.DNSNames, template.EmailAddresses, template.IPAddresses, template.URIs)
 		if err != nil {
 			return
 		}
@@ -1429,16 +1535,17 @@ func buildExtensions(template *Certificate) (ret []pkix.Extension, err error) {
 		n++
 	}
 
-	if len(template.PermittedDNSDomains) > 0 &&
+	if (len(template.PermittedDNSDomains) > 0 || len(template.ExcludedDNSDomains) > 0 ||
+		len(template.PermittedIPRanges) > 0 || len(template.ExcludedIPRanges) > 0 ||
+		len(template.PermittedEmailAddresses) > 0 || len(template.ExcludedEmailAddresses) > 0 ||
+		len(template.PermittedURIDomains) > 0 || len(template.ExcludedURIDomains) > 0) &&
 		!oidInExtensions(oidExtensionNameConstraints, template.ExtraExtensions) {
 		ret[n].Id = oidExtensionNameConstraints
 		ret[n].Critical = template.PermittedDNSDomainsCritical
 
 		var out nameConstraints
-		out.Permitted = make([]generalSubtree, len(template.PermittedDNSDomains))
-		for i, permitted := range template.PermittedDNSDomains {
-			out.Permitted[i] = generalSubtree{Name: permitted}
-		}
+		out.Permitted = marshalGeneralSubtrees(template.PermittedDNSDomains, template.PermittedIPRanges, template.PermittedEmailAddresses, template.PermittedURIDomains)
+		out.Excluded = marshalGeneralSubtrees(template.ExcludedDNSDomains, template.ExcludedIPRanges, template.ExcludedEmailAddresses, template.ExcludedURIDomains)
 		ret[n].Value, err = asn1.Marshal(out)
 		if err != nil {
 			return
@@ -1554,8 +1661,11 @@ func signingParamsForPublicKey(pub interface{}, requestedSigAlgo SignatureAlgori
 // CreateCertificate creates a new certificate based on a template. The
 // following members of template are used: SerialNumber, Subject, NotBefore,
 // NotAfter, KeyUsage, ExtKeyUsage, UnknownExtKeyUsage, BasicConstraintsValid,
-// IsCA, MaxPathLen, SubjectKeyId, DNSNames, PermittedDNSDomainsCritical,
-// PermittedDNSDomains, SignatureAlgorithm.
+// IsCA, MaxPathLen, SubjectKeyId, DNSNames, EmailAddresses, IPAddresses, URIs,
+// PermittedDNSDomainsCritical, PermittedDNSDomains, ExcludedDNSDomains,
+// PermittedIPRanges, ExcludedIPRanges, PermittedEmailAddresses,
+// ExcludedEmailAddresses, PermittedURIDomains, ExcludedURIDomains,
+// SignatureAlgorithm.
 //
 // The certificate is signed by parent. If parent is equal to template then the
 // certificate is self-signed. The parameter pub is the public key of the
@@ -1672,56 +1782,7 @@ func ParseDERCRL(derBytes []byte) (certList *pkix.CertificateList, err error) {
 // CreateCRL returns a DER encoded CRL, signed by this Certificate, that
 // contains the given list of revoked certificates.
 func (c *Certificate) CreateCRL(rand io.Reader, priv interface{}, revokedCerts []pkix.RevokedCertificate, now, expiry time.Time) (crlBytes []byte, err error) {
-	key, ok := priv.(crypto.Signer)
-	if !ok {
-		return nil, errors.New("x509: certificate private key does not implement crypto.Signer")
-	}
-
-	hashFunc, signatureAlgorithm, err := signingParamsForPublicKey(key.Public(), 0)
-	if err != nil {
-		return nil, err
-	}
-
-	tbsCertList := pkix.TBSCertificateList{
-		Version:             1,
-		Signature:           signatureAlgorithm,
-		Issuer:              c.Subject.ToRDNSequence(),
-		ThisUpdate:          now.UTC(),
-		NextUpdate:          expiry.UTC(),
-		RevokedCertificates: revokedCerts,
-	}
-
-	// Authority Key Id
-	if len(c.SubjectKeyId) > 0 {
-		var aki pkix.Extension
-		aki.Id = oidExtensionAuthorityKeyId
-		aki.Value, err = asn1.Marshal(authKeyId{Id: c.SubjectKeyId})
-		if err != nil {
-			return
-		}
-		tbsCertList.Extensions = append(tbsCertList.Extensions, aki)
-	}
-
-	tbsCertListContents, err := asn1.Marshal(tbsCertList)
-	if err != nil {
-		return
-	}
-
-	h := hashFunc.New()
-	h.Write(tbsCertListContents)
-	digest := h.Sum(nil)
-
-	var signature []byte
-	signature, err = key.Sign(rand, digest, hashFunc)
-	if err != nil {
-		return
-	}
-
-	return asn1.Marshal(pkix.CertificateList{
-		TBSCertList:        tbsCertList,
-		SignatureAlgorithm: signatureAlgorithm,
-		SignatureValue:     asn1.BitString{Bytes: signature, BitLength: len(signature) * 8},
-	})
+	return c.CreateCRLWithOptions(rand, priv, revokedCerts, now, expiry, nil)
 }
 
 // CertificateRequest represents a PKCS #10, certificate signature request.
@@ -1761,6 +1822,7 @@ type CertificateRequest struct {
 	DNSNames       []string
 	EmailAddresses []string
 	IPAddresses    []net.IP
+	URIs           []*url.URL
 }
 
 // These structures reflect the ASN.1 structure of X.509 certificate
@@ -1849,7 +1911,7 @@ func parseCSRExtensions(rawAttributes []asn1.RawValue) ([]pkix.Extension, error)
 
 // CreateCertificateRequest creates a new certificate based on a template. The
 // following members of template are used: Subject, Attributes,
-// SignatureAlgorithm, Extensions, DNSNames, EmailAddresses, and IPAddresses.
+// SignatureAlgorithm, Extensions, DNSNames, EmailAddresses, IPAddresses and URIs.
 // The private key is the private key of the signer.
 //
 // The returned slice is the certificate request in DER encoding.
@@ -1878,9 +1940,9 @@ func CreateCertificateRequest(rand io.Reader, template *CertificateRequest, priv
 
 	var extensions []pkix.Extension
 
-	if (len(template.DNSNames) > 0 || len(template.EmailAddresses) > 0 || len(template.IPAddresses) > 0) &&
+	if (len(template.DNSNames) > 0 || len(template.EmailAddresses) > 0 || len(template.IPAddresses) > 0 || len(template.URIs) > 0) &&
 		!oidInExtensions(oidExtensionSubjectAltName, template.ExtraExtensions) {
-		sanBytes, err := marshalSANs(template.DNSNames, template.EmailAddresses, template.IPAddresses)
+		sanBytes, err := marshalSANs(template.DNSNames, template.EmailAddresses, template.IPAddresses, template.URIs)
 		if err != nil {
 			return nil, err
 		}
@@ -2055,7 +2117,7 @@ func parseCertificateRequest(in *certificateRequest) (*CertificateRequest, error
 
 	for _, extension := range out.Extensions {
 		if extension.Id.Equal(oidExtensionSubjectAltName) {
-			out.DNSNames, out.EmailAddresses, out.IPAddresses, err = parseSANExtension(extension.Value)
+			out.DNSNames, out.EmailAddresses, out.IPAddresses, out.URIs, err = parseSANExtension(extension.Value)
 			if err != nil {
 				return nil, err
 			}
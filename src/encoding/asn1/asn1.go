@@ -3,7 +3,9 @@
 // license that can be found in the LICENSE file.
 
 // Package asn1 implements parsing of DER-encoded ASN.1 data structures,
-// as defined in ITU-T Rec X.690.
+// as defined in ITU-T Rec X.690. Data encoded with the more permissive
+// Basic Encoding Rules (BER) can be parsed with UnmarshalBER, and elements
+// can be read and written one at a time with a Cursor and a Builder.
 //
 // See also ``A Layman's Guide to a Subset of ASN.1, BER, and DER,''
 // http://luca.ntop.org/Teaching/Appunti/asn1.html.
@@ -513,7 +515,7 @@ func parseTagAndLength(bytes []byte, initOffset int) (ret tagAndLength, offset i
 // parseSequenceOf is used for SEQUENCE OF and SET OF values. It tries to parse
 // a number of ASN.1 values from the given byte slice and returns them as a
 // slice of Go values of the given type.
-func parseSequenceOf(bytes []byte, sliceType reflect.Type, elemType reflect.Type) (ret reflect.Value, err error) {
+func parseSequenceOf(bytes []byte, sliceType reflect.Type, elemType reflect.Type, ber bool) (ret reflect.Value, err error) {
 	expectedTag, compoundType, ok := getUniversalType(elemType)
 	if !ok {
 		err = StructuralError{"unknown Go type for slice"}
@@ -552,7 +554,7 @@ func parseSequenceOf(bytes []byte, sliceType reflect.Type, elemType reflect.Type
 		numElements++
 	}
 	ret = reflect.MakeSlice(sliceType, numElements, numElements)
-	params := fieldParameters{}
+	params := fieldParameters{ber: ber}
 	offset := 0
 	for i := 0; i < numElements; i++ {
 		offset, err = parseField(ret.Index(i), bytes, offset, params)
@@ -636,6 +638,9 @@ func parseField(v reflect.Value, bytes []byte, initOffset int, params fieldParam
 			case TagUTF8String:
 				result, err = parseUTF8String(innerBytes)
 			case TagInteger:
+				if params.ber {
+					innerBytes = minimalInteger(innerBytes)
+				}
 				result, err = parseInt64(innerBytes)
 			case TagBitString:
 				result, err = parseBitString(innerBytes)
@@ -743,8 +748,13 @@ func parseField(v reflect.Value, bytes []byte, initOffset int, params fieldParam
 		expectedTag = *params.tag
 	}
 
+	// BER permits strings to be split into constructed segments. Those
+	// with universal tags were concatenated by normalizeBER; those with
+	// implicit tags are recognized by the Go type.
+	concat := params.ber && t.isCompound && !compoundType && t.class != ClassUniversal && isStringType(fieldType)
+
 	// We have unwrapped any explicit tagging at this point.
-	if t.class != expectedClass || t.tag != expectedTag || t.isCompound != compoundType {
+	if t.class != expectedClass || t.tag != expectedTag || t.isCompound != compoundType && !concat {
 		// Tags don't match. Again, it could be an optional element.
 		ok := setDefaultValue(v, params)
 		if ok {
@@ -760,6 +770,15 @@ func parseField(v reflect.Value, bytes []byte, initOffset int, params fieldParam
 	}
 	innerBytes := bytes[offset : offset+t.length]
 	offset += t.length
+	if concat {
+		innerBytes, err = concatSegments(innerBytes, fieldType == bitStringType)
+		if err != nil {
+			return
+		}
+	}
+	if params.ber && (universalTag == TagInteger || universalTag == TagEnum) {
+		innerBytes = minimalInteger(innerBytes)
+	}
 
 	// We deal with the structures defined in this package first.
 	switch fieldType {
@@ -811,6 +830,11 @@ func parseField(v reflect.Value, bytes []byte, initOffset int, params fieldParam
 	}
 	switch val := v; val.Kind() {
 	case reflect.Bool:
+		if params.ber && len(innerBytes) == 1 {
+			// BER permits any non-zero value for TRUE.
+			val.SetBool(innerBytes[0] != 0)
+			return
+		}
 		parsedBool, err1 := parseBool(innerBytes)
 		if err1 == nil {
 			val.SetBool(parsedBool)
@@ -848,7 +872,9 @@ func parseField(v reflect.Value, bytes []byte, initOffset int, params fieldParam
 			if i == 0 && field.Type == rawContentsType {
 				continue
 			}
-			innerOffset, err = parseField(val.Field(i), innerBytes, innerOffset, parseFieldParameters(field.Tag.Get("asn1")))
+			fieldParams := parseFieldParameters(field.Tag.Get("asn1"))
+			fieldParams.ber = params.ber
+			innerOffset, err = parseField(val.Field(i), innerBytes, innerOffset, fieldParams)
 			if err != nil {
 				return
 			}
@@ -864,7 +890,7 @@ func parseField(v reflect.Value, bytes []byte, initOffset int, params fieldParam
 			reflect.Copy(val, reflect.ValueOf(innerBytes))
 			return
 		}
-		newSlice, err1 := parseSequenceOf(innerBytes, sliceType, sliceType.Elem())
+		newSlice, err1 := parseSequenceOf(innerBytes, sliceType, sliceType.Elem(), params.ber)
 		if err1 == nil {
 			val.Set(newSlice)
 		}
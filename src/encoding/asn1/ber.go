@@ -0,0 +1,318 @@
+// Copyright 2016 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package asn1
+
+import (
+	"bytes"
+	"reflect"
+)
+
+// BER, the Basic Encoding Rules, permit several encodings of the same
+// value where DER permits one. Besides the choices DER makes for the
+// contents of primitive values, BER allows
+//
+//	- lengths with superfluous leading zeros, or in the long form when the
+//	  short form would do,
+//	- the indefinite length form for constructed values, in which the
+//	  contents are terminated by two zero bytes (the end-of-contents marker)
+//	  rather than preceded by their length, and
+//	- string types encoded as constructed values whose contents are a
+//	  series of segments, to be concatenated.
+//
+// BER input is decoded by first rewriting its framing in the DER form:
+// lengths are made definite and minimal and constructed universal strings
+// are concatenated. The primitive contents are left alone; parseField
+// accepts their BER forms when fieldParameters.ber is set. Strings with
+// implicit tags can only be recognized by the Go type they are decoded
+// into, so parseField concatenates those itself.
+
+// maxBERDepth bounds the nesting of constructed BER values.
+const maxBERDepth = 100
+
+// parseBERTagAndLength is like parseTagAndLength, but accepts the BER
+// forms of lengths. For the indefinite form it sets indefinite and
+// returns a length of zero.
+func parseBERTagAndLength(bytes []byte, initOffset int) (ret tagAndLength, indefinite bool, offset int, err error) {
+	offset = initOffset
+	if offset >= len(bytes) {
+		err = SyntaxError{"truncated tag or length"}
+		return
+	}
+	b := bytes[offset]
+	offset++
+	ret.class = int(b >> 6)
+	ret.isCompound = b&0x20 == 0x20
+	ret.tag = int(b & 0x1f)
+	if ret.tag == 0x1f {
+		ret.tag, offset, err = parseBase128Int(bytes, offset)
+		if err != nil {
+			return
+		}
+	}
+	if offset >= len(bytes) {
+		err = SyntaxError{"truncated tag or length"}
+		return
+	}
+	b = bytes[offset]
+	offset++
+	if b&0x80 == 0 {
+		ret.length = int(b & 0x7f)
+		return
+	}
+	numBytes := int(b & 0x7f)
+	if numBytes == 0 {
+		if !ret.isCompound {
+			err = SyntaxError{"indefinite length of primitive value"}
+			return
+		}
+		indefinite = true
+		return
+	}
+	for i := 0; i < numBytes; i++ {
+		if offset >= len(bytes) {
+			err = SyntaxError{"truncated tag or length"}
+			return
+		}
+		if ret.length >= 1<<23 {
+			err = StructuralError{"length too large"}
+			return
+		}
+		ret.length = ret.length<<8 | int(bytes[offset])
+		offset++
+	}
+	return
+}
+
+// parseBERElement parses the BER element at initOffset. It returns the
+// element's tag, its contents, bytes[start:end], and the offset of the
+// element that follows it. The contents of an indefinite-length element
+// exclude its end-of-contents marker.
+func parseBERElement(bytes []byte, initOffset, depth int) (t tagAndLength, start, end, offset int, err error) {
+	if depth > maxBERDepth {
+		err = StructuralError{"BER nesting too deep"}
+		return
+	}
+	t, indefinite, offset, err := parseBERTagAndLength(bytes, initOffset)
+	if err != nil {
+		return
+	}
+	start = offset
+	if !indefinite {
+		if invalidLength(offset, t.length, len(bytes)) {
+			err = SyntaxError{"data truncated"}
+			return
+		}
+		end = offset + t.length
+		offset = end
+		return
+	}
+	for {
+		if offset+2 <= len(bytes) && bytes[offset] == 0 && bytes[offset+1] == 0 {
+			end = offset
+			offset += 2
+			t.length = end - start
+			return
+		}
+		if offset >= len(bytes) {
+			err = SyntaxError{"missing end-of-contents marker"}
+			return
+		}
+		_, _, _, offset, err = parseBERElement(bytes, offset, depth+1)
+		if err != nil {
+			return
+		}
+	}
+}
+
+// isStringTag reports whether the universal tag is that of a string type,
+// which BER permits to be encoded as a constructed value.
+func isStringTag(tag int) bool {
+	switch tag {
+	case TagBitString, TagOctetString, TagUTF8String:
+		return true
+	}
+	// NumericString through BMPString, except the CHARACTER STRING
+	// type, which is always constructed.
+	return 18 <= tag && tag <= 30 && tag != 29
+}
+
+// appendTagAndLength appends the DER encoding of t to out.
+func appendTagAndLength(out []byte, t tagAndLength) []byte {
+	w := newForkableWriter()
+	marshalTagAndLength(w, t) // writing to a bytes.Buffer cannot fail
+	return append(out, w.Bytes()...)
+}
+
+// appendDER appends the element at initOffset, which may use the BER
+// framing, to out in the DER framing. It returns the extended buffer and
+// the offset of the element that follows.
+func appendDER(out, bytes []byte, initOffset, depth int) ([]byte, int, error) {
+	if depth > maxBERDepth {
+		return nil, 0, StructuralError{"BER nesting too deep"}
+	}
+	t, indefinite, offset, err := parseBERTagAndLength(bytes, initOffset)
+	if err != nil {
+		return nil, 0, err
+	}
+	end := len(bytes)
+	if !indefinite {
+		if invalidLength(offset, t.length, len(bytes)) {
+			return nil, 0, SyntaxError{"data truncated"}
+		}
+		end = offset + t.length
+	}
+	if !t.isCompound {
+		out = appendTagAndLength(out, t)
+		return append(out, bytes[offset:end]...), end, nil
+	}
+
+	var body []byte
+	for {
+		if indefinite {
+			if offset+2 <= len(bytes) && bytes[offset] == 0 && bytes[offset+1] == 0 {
+				offset += 2
+				break
+			}
+			if offset >= len(bytes) {
+				return nil, 0, SyntaxError{"missing end-of-contents marker"}
+			}
+		} else if offset == end {
+			break
+		}
+		body, offset, err = appendDER(body, bytes[:end], offset, depth+1)
+		if err != nil {
+			return nil, 0, err
+		}
+	}
+	if t.class == ClassUniversal && isStringTag(t.tag) {
+		body, err = concatSegments(body, t.tag == TagBitString)
+		if err != nil {
+			return nil, 0, err
+		}
+		t.isCompound = false
+	}
+	t.length = len(body)
+	out = appendTagAndLength(out, t)
+	return append(out, body...), offset, nil
+}
+
+// normalizeBER rewrites the first element of b, which is BER-encoded, in
+// the DER framing. It returns the rewritten element and the length of the
+// original.
+func normalizeBER(b []byte) (der []byte, n int, err error) {
+	return appendDER(nil, b, 0, 0)
+}
+
+// concatSegments returns the concatenated contents of the segments of a
+// constructed string, whose contents in the DER framing are given. The
+// segments may themselves be constructed. A BIT STRING has its number of
+// padding bits, which only the last segment may have, prepended.
+func concatSegments(contents []byte, bitString bool) ([]byte, error) {
+	var out bytes.Buffer
+	padding, err := appendSegments(&out, contents, bitString, 0)
+	if err != nil {
+		return nil, err
+	}
+	if bitString {
+		return append([]byte{padding}, out.Bytes()...), nil
+	}
+	return out.Bytes(), nil
+}
+
+func appendSegments(out *bytes.Buffer, contents []byte, bitString bool, depth int) (padding byte, err error) {
+	if depth > maxBERDepth {
+		return 0, StructuralError{"BER nesting too deep"}
+	}
+	for offset := 0; offset < len(contents); {
+		if padding != 0 {
+			return 0, SyntaxError{"padding bits in BIT STRING segment before the last"}
+		}
+		var t tagAndLength
+		t, offset, err = parseTagAndLength(contents, offset)
+		if err != nil {
+			return
+		}
+		if invalidLength(offset, t.length, len(contents)) {
+			return 0, SyntaxError{"data truncated"}
+		}
+		segment := contents[offset : offset+t.length]
+		offset += t.length
+		switch {
+		case t.isCompound:
+			padding, err = appendSegments(out, segment, bitString, depth+1)
+			if err != nil {
+				return
+			}
+		case bitString:
+			if len(segment) == 0 {
+				return 0, SyntaxError{"zero length BIT STRING segment"}
+			}
+			padding = segment[0]
+			out.Write(segment[1:])
+		default:
+			out.Write(segment)
+		}
+	}
+	return
+}
+
+// isStringType reports whether values of Go type t are decoded from
+// ASN.1 string types.
+func isStringType(t reflect.Type) bool {
+	if t == bitStringType {
+		return true
+	}
+	switch t.Kind() {
+	case reflect.String:
+		return true
+	case reflect.Slice:
+		return t.Elem().Kind() == reflect.Uint8
+	}
+	return false
+}
+
+// minimalInteger strips the redundant leading bytes BER permits from the
+// contents of an INTEGER or ENUMERATED value.
+func minimalInteger(bytes []byte) []byte {
+	for len(bytes) > 1 &&
+		(bytes[0] == 0 && bytes[1]&0x80 == 0 || bytes[0] == 0xff && bytes[1]&0x80 == 0x80) {
+		bytes = bytes[1:]
+	}
+	return bytes
+}
+
+// UnmarshalBER is like Unmarshal, but accepts data encoded with the Basic
+// Encoding Rules, of which DER is a subset: indefinite and non-minimal
+// lengths, string values split into constructed segments, BOOLEAN values
+// other than 0xff for true and INTEGER values with redundant leading
+// bytes. RawValue and RawContent values receive the data rewritten with
+// definite, minimal lengths and strings concatenated.
+func UnmarshalBER(b []byte, val interface{}) (rest []byte, err error) {
+	return UnmarshalBERWithParams(b, val, "")
+}
+
+// UnmarshalBERWithParams is like UnmarshalWithParams, but accepts BER
+// as UnmarshalBER does.
+func UnmarshalBERWithParams(b []byte, val interface{}, params string) (rest []byte, err error) {
+	var der []byte
+	var n int
+	if len(b) > 0 {
+		der, n, err = normalizeBER(b)
+		if err != nil {
+			return nil, err
+		}
+	}
+	p := parseFieldParameters(params)
+	p.ber = true
+	v := reflect.ValueOf(val).Elem()
+	offset, err := parseField(v, der, 0, p)
+	if err != nil {
+		return nil, err
+	}
+	if offset == 0 {
+		return b, nil // an absent optional value
+	}
+	return b[n:], nil
+}
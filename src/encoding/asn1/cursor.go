@@ -0,0 +1,532 @@
+// Copyright 2016 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package asn1
+
+import (
+	"bytes"
+	"fmt"
+	"math/big"
+	"sort"
+	"time"
+)
+
+// A Cursor reads a series of ASN.1 elements from a byte slice one at a
+// time, without using reflection. Elements of any class and tag may be
+// read as RawValues, and elements with the universal tags of the common
+// types may be read into Go values. If a read fails, the cursor is left
+// where it was.
+type Cursor struct {
+	data []byte
+	ber  bool
+}
+
+// NewCursor returns a Cursor that reads the DER-encoded elements in b.
+func NewCursor(b []byte) *Cursor {
+	return &Cursor{data: b}
+}
+
+// NewBERCursor returns a Cursor that reads the BER-encoded elements in b.
+// It accepts the encodings that UnmarshalBER accepts, including
+// indefinite-length elements.
+func NewBERCursor(b []byte) *Cursor {
+	return &Cursor{data: b, ber: true}
+}
+
+// Empty reports whether all the elements have been read.
+func (c *Cursor) Empty() bool {
+	return len(c.data) == 0
+}
+
+// Remaining returns the unread input.
+func (c *Cursor) Remaining() []byte {
+	return c.data
+}
+
+// element parses the next element. It returns the element and the
+// input that follows it.
+func (c *Cursor) element() (v RawValue, rest []byte, err error) {
+	if len(c.data) == 0 {
+		return v, nil, SyntaxError{"sequence truncated"}
+	}
+	var t tagAndLength
+	var start, end, offset int
+	if c.ber {
+		t, start, end, offset, err = parseBERElement(c.data, 0, 0)
+	} else {
+		t, start, err = parseTagAndLength(c.data, 0)
+		if err == nil && invalidLength(start, t.length, len(c.data)) {
+			err = SyntaxError{"data truncated"}
+		}
+		end, offset = start+t.length, start+t.length
+	}
+	if err != nil {
+		return v, nil, err
+	}
+	v = RawValue{t.class, t.tag, t.isCompound, c.data[start:end], c.data[:offset]}
+	return v, c.data[offset:], nil
+}
+
+// PeekTag returns the class, tag and constructed flag of the next
+// element without reading it.
+func (c *Cursor) PeekTag() (class, tag int, isCompound bool, err error) {
+	var t tagAndLength
+	if c.ber {
+		t, _, _, err = parseBERTagAndLength(c.data, 0)
+	} else if len(c.data) == 0 {
+		err = SyntaxError{"sequence truncated"}
+	} else {
+		t, _, err = parseTagAndLength(c.data, 0)
+	}
+	return t.class, t.tag, t.isCompound, err
+}
+
+// Next reads the next element, whatever its class and tag. The Bytes of
+// an indefinite-length element exclude its end-of-contents marker.
+func (c *Cursor) Next() (RawValue, error) {
+	v, rest, err := c.element()
+	if err == nil {
+		c.data = rest
+	}
+	return v, err
+}
+
+// Skip skips the next element.
+func (c *Cursor) Skip() error {
+	_, err := c.Next()
+	return err
+}
+
+// expect reads the next element, which must have the given class and
+// tag. If isCompound is false, the element must be primitive, except
+// that in BER a constructed string is accepted and its segments are
+// concatenated.
+func (c *Cursor) expect(class, tag int, isCompound bool) ([]byte, error) {
+	v, rest, err := c.element()
+	if err != nil {
+		return nil, err
+	}
+	if v.Class != class || v.Tag != tag {
+		return nil, StructuralError{fmt.Sprintf("tags don't match (%d/%d vs %d/%d)", class, tag, v.Class, v.Tag)}
+	}
+	contents := v.Bytes
+	if v.IsCompound != isCompound {
+		if isCompound || !c.ber {
+			return nil, StructuralError{"tags don't match (constructed flag)"}
+		}
+		// The segments may themselves use the BER framing.
+		var der []byte
+		if der, _, err = normalizeBER(v.FullBytes); err != nil {
+			return nil, err
+		}
+		t, offset, err := parseTagAndLength(der, 0)
+		if err != nil {
+			return nil, err
+		}
+		// normalizeBER has already concatenated universal strings.
+		contents = der[offset:]
+		if t.isCompound {
+			if contents, err = concatSegments(contents, false); err != nil {
+				return nil, err
+			}
+		}
+	}
+	c.data = rest
+	return contents, nil
+}
+
+// Enter reads the next element, which must be constructed and have the
+// given class and tag, and returns a Cursor over its contents.
+func (c *Cursor) Enter(class, tag int) (*Cursor, error) {
+	contents, err := c.expect(class, tag, true)
+	if err != nil {
+		return nil, err
+	}
+	return &Cursor{data: contents, ber: c.ber}, nil
+}
+
+// ReadSequence reads a SEQUENCE and returns a Cursor over its contents.
+func (c *Cursor) ReadSequence() (*Cursor, error) {
+	return c.Enter(ClassUniversal, TagSequence)
+}
+
+// ReadSet reads a SET and returns a Cursor over its contents.
+func (c *Cursor) ReadSet() (*Cursor, error) {
+	return c.Enter(ClassUniversal, TagSet)
+}
+
+// ReadPrimitive reads the next element, which must be primitive and have
+// the given class and tag, and returns its contents. It is used to read
+// implicitly tagged values. In BER, a constructed element is accepted
+// in place of a primitive string and its segments are concatenated.
+func (c *Cursor) ReadPrimitive(class, tag int) ([]byte, error) {
+	return c.expect(class, tag, false)
+}
+
+// ReadBool reads a BOOLEAN.
+func (c *Cursor) ReadBool() (bool, error) {
+	save := c.data
+	b, err := c.expect(ClassUniversal, TagBoolean, false)
+	if err != nil {
+		return false, err
+	}
+	if c.ber && len(b) == 1 {
+		return b[0] != 0, nil
+	}
+	ret, err := parseBool(b)
+	if err != nil {
+		c.data = save
+	}
+	return ret, err
+}
+
+// readInteger reads the contents of an INTEGER or ENUMERATED value.
+func (c *Cursor) readInteger(tag int, parse func([]byte) error) error {
+	save := c.data
+	b, err := c.expect(ClassUniversal, tag, false)
+	if err != nil {
+		return err
+	}
+	if c.ber {
+		b = minimalInteger(b)
+	}
+	if err = parse(b); err != nil {
+		c.data = save
+	}
+	return err
+}
+
+// ReadInt64 reads an INTEGER that fits in an int64.
+func (c *Cursor) ReadInt64() (ret int64, err error) {
+	err = c.readInteger(TagInteger, func(b []byte) (err error) {
+		ret, err = parseInt64(b)
+		return
+	})
+	return
+}
+
+// ReadBigInt reads an INTEGER of any size.
+func (c *Cursor) ReadBigInt() (ret *big.Int, err error) {
+	err = c.readInteger(TagInteger, func(b []byte) (err error) {
+		ret, err = parseBigInt(b)
+		return
+	})
+	return
+}
+
+// ReadEnumerated reads an ENUMERATED.
+func (c *Cursor) ReadEnumerated() (ret Enumerated, err error) {
+	err = c.readInteger(TagEnum, func(b []byte) error {
+		i, err := parseInt32(b)
+		ret = Enumerated(i)
+		return err
+	})
+	return
+}
+
+// ReadObjectIdentifier reads an OBJECT IDENTIFIER.
+func (c *Cursor) ReadObjectIdentifier() (ObjectIdentifier, error) {
+	save := c.data
+	b, err := c.expect(ClassUniversal, TagOID, false)
+	if err != nil {
+		return nil, err
+	}
+	oid, err := parseObjectIdentifier(b)
+	if err != nil {
+		c.data = save
+	}
+	return oid, err
+}
+
+// ReadOctetString reads an OCTET STRING.
+func (c *Cursor) ReadOctetString() ([]byte, error) {
+	return c.expect(ClassUniversal, TagOctetString, false)
+}
+
+// ReadBitString reads a BIT STRING.
+func (c *Cursor) ReadBitString() (BitString, error) {
+	save := c.data
+	b, err := c.expect(ClassUniversal, TagBitString, false)
+	if err != nil {
+		return BitString{}, err
+	}
+	bs, err := parseBitString(b)
+	if err != nil {
+		c.data = save
+	}
+	return bs, err
+}
+
+// ReadNull reads a NULL.
+func (c *Cursor) ReadNull() error {
+	save := c.data
+	b, err := c.expect(ClassUniversal, TagNull, false)
+	if err == nil && len(b) != 0 {
+		c.data = save
+		err = SyntaxError{"invalid NULL"}
+	}
+	return err
+}
+
+// ReadString reads a PrintableString, IA5String, T61String, UTF8String
+// or GeneralString, as Unmarshal does for a string.
+func (c *Cursor) ReadString() (string, error) {
+	_, tag, _, err := c.PeekTag()
+	if err != nil {
+		return "", err
+	}
+	var parse func([]byte) (string, error)
+	switch tag {
+	case TagPrintableString:
+		parse = parsePrintableString
+	case TagIA5String:
+		parse = parseIA5String
+	case TagT61String, TagGeneralString:
+		parse = parseT61String
+	case TagUTF8String:
+		parse = parseUTF8String
+	default:
+		return "", StructuralError{fmt.Sprintf("tag %d is not a string type", tag)}
+	}
+	save := c.data
+	b, err := c.expect(ClassUniversal, tag, false)
+	if err != nil {
+		return "", err
+	}
+	s, err := parse(b)
+	if err != nil {
+		c.data = save
+	}
+	return s, err
+}
+
+// ReadTime reads a UTCTime or GeneralizedTime.
+func (c *Cursor) ReadTime() (time.Time, error) {
+	_, tag, _, err := c.PeekTag()
+	if err != nil {
+		return time.Time{}, err
+	}
+	parse := parseUTCTime
+	if tag == TagGeneralizedTime {
+		parse = parseGeneralizedTime
+	}
+	if tag != TagUTCTime && tag != TagGeneralizedTime {
+		return time.Time{}, StructuralError{fmt.Sprintf("tag %d is not a time type", tag)}
+	}
+	save := c.data
+	b, err := c.expect(ClassUniversal, tag, false)
+	if err != nil {
+		return time.Time{}, err
+	}
+	t, err := parse(b)
+	if err != nil {
+		c.data = save
+	}
+	return t, err
+}
+
+// Unmarshal reads the next element into val, as Unmarshal or, for a
+// BER cursor, UnmarshalBER does.
+func (c *Cursor) Unmarshal(val interface{}) error {
+	return c.UnmarshalWithParams(val, "")
+}
+
+// UnmarshalWithParams reads the next element into val, as
+// UnmarshalWithParams or, for a BER cursor, UnmarshalBERWithParams does.
+func (c *Cursor) UnmarshalWithParams(val interface{}, params string) error {
+	var rest []byte
+	var err error
+	if c.ber {
+		rest, err = UnmarshalBERWithParams(c.data, val, params)
+	} else {
+		rest, err = UnmarshalWithParams(c.data, val, params)
+	}
+	if err == nil {
+		c.data = rest
+	}
+	return err
+}
+
+// A Builder builds DER-encoded ASN.1 data one element at a time, without
+// using reflection. Errors are recorded by the Builder and returned by
+// Bytes; once an error has occurred, further additions are ignored.
+type Builder struct {
+	buf []byte
+	err error
+}
+
+// Bytes returns the elements added so far, or the first error that
+// occurred while adding them.
+func (b *Builder) Bytes() ([]byte, error) {
+	if b.err != nil {
+		return nil, b.err
+	}
+	return b.buf, nil
+}
+
+// AddRaw adds data, which must consist of complete encoded elements,
+// unchanged.
+func (b *Builder) AddRaw(data []byte) {
+	if b.err == nil {
+		b.buf = append(b.buf, data...)
+	}
+}
+
+// AddPrimitive adds a primitive element with the given class, tag and
+// contents.
+func (b *Builder) AddPrimitive(class, tag int, contents []byte) {
+	b.add(tagAndLength{class, tag, len(contents), false}, contents)
+}
+
+func (b *Builder) add(t tagAndLength, contents []byte) {
+	if b.err != nil {
+		return
+	}
+	b.buf = appendTagAndLength(b.buf, t)
+	b.buf = append(b.buf, contents...)
+}
+
+// addWith adds a primitive element whose contents are written by f.
+func (b *Builder) addWith(tag int, f func(out *forkableWriter) error) {
+	if b.err != nil {
+		return
+	}
+	out := newForkableWriter()
+	if err := f(out); err != nil {
+		b.err = err
+		return
+	}
+	b.AddPrimitive(ClassUniversal, tag, out.Bytes())
+}
+
+// AddConstructed adds a constructed element with the given class and
+// tag, whose contents are the elements added by f to the Builder it is
+// passed.
+func (b *Builder) AddConstructed(class, tag int, f func(*Builder)) {
+	if b.err != nil {
+		return
+	}
+	var child Builder
+	f(&child)
+	if child.err != nil {
+		b.err = child.err
+		return
+	}
+	b.add(tagAndLength{class, tag, len(child.buf), true}, child.buf)
+}
+
+// AddSequence adds a SEQUENCE of the elements added by f.
+func (b *Builder) AddSequence(f func(*Builder)) {
+	b.AddConstructed(ClassUniversal, TagSequence, f)
+}
+
+// AddSet adds a SET of the elements added by f. The elements are sorted
+// by their encodings, as DER requires for a SET OF.
+func (b *Builder) AddSet(f func(*Builder)) {
+	b.AddConstructed(ClassUniversal, TagSet, func(child *Builder) {
+		f(child)
+		if child.err != nil {
+			return
+		}
+		var elems [][]byte
+		for c := NewCursor(child.buf); !c.Empty(); {
+			v, err := c.Next()
+			if err != nil {
+				child.err = err
+				return
+			}
+			elems = append(elems, v.FullBytes)
+		}
+		sort.Sort(byEncoding(elems))
+		child.buf = bytes.Join(elems, nil)
+	})
+}
+
+type byEncoding [][]byte
+
+func (s byEncoding) Len() int           { return len(s) }
+func (s byEncoding) Less(i, j int) bool { return bytes.Compare(s[i], s[j]) < 0 }
+func (s byEncoding) Swap(i, j int)      { s[i], s[j] = s[j], s[i] }
+
+// AddBool adds a BOOLEAN.
+func (b *Builder) AddBool(v bool) {
+	contents := []byte{0}
+	if v {
+		contents[0] = 0xff
+	}
+	b.AddPrimitive(ClassUniversal, TagBoolean, contents)
+}
+
+// AddInt64 adds an INTEGER.
+func (b *Builder) AddInt64(v int64) {
+	b.addWith(TagInteger, func(out *forkableWriter) error { return marshalInt64(out, v) })
+}
+
+// AddBigInt adds an INTEGER.
+func (b *Builder) AddBigInt(v *big.Int) {
+	b.addWith(TagInteger, func(out *forkableWriter) error { return marshalBigInt(out, v) })
+}
+
+// AddEnumerated adds an ENUMERATED.
+func (b *Builder) AddEnumerated(v Enumerated) {
+	b.addWith(TagEnum, func(out *forkableWriter) error { return marshalInt64(out, int64(v)) })
+}
+
+// AddObjectIdentifier adds an OBJECT IDENTIFIER.
+func (b *Builder) AddObjectIdentifier(oid ObjectIdentifier) {
+	b.addWith(TagOID, func(out *forkableWriter) error { return marshalObjectIdentifier(out, oid) })
+}
+
+// AddOctetString adds an OCTET STRING.
+func (b *Builder) AddOctetString(v []byte) {
+	b.AddPrimitive(ClassUniversal, TagOctetString, v)
+}
+
+// AddBitString adds a BIT STRING.
+func (b *Builder) AddBitString(v BitString) {
+	b.addWith(TagBitString, func(out *forkableWriter) error { return marshalBitString(out, v) })
+}
+
+// AddNull adds a NULL.
+func (b *Builder) AddNull() {
+	b.AddPrimitive(ClassUniversal, TagNull, nil)
+}
+
+// AddUTF8String adds a UTF8String.
+func (b *Builder) AddUTF8String(s string) {
+	b.addWith(TagUTF8String, func(out *forkableWriter) error { return marshalUTF8String(out, s) })
+}
+
+// AddPrintableString adds a PrintableString.
+func (b *Builder) AddPrintableString(s string) {
+	b.addWith(TagPrintableString, func(out *forkableWriter) error { return marshalPrintableString(out, s) })
+}
+
+// AddIA5String adds an IA5String.
+func (b *Builder) AddIA5String(s string) {
+	b.addWith(TagIA5String, func(out *forkableWriter) error { return marshalIA5String(out, s) })
+}
+
+// AddUTCTime adds a UTCTime.
+func (b *Builder) AddUTCTime(t time.Time) {
+	b.addWith(TagUTCTime, func(out *forkableWriter) error { return marshalUTCTime(out, t) })
+}
+
+// AddGeneralizedTime adds a GeneralizedTime.
+func (b *Builder) AddGeneralizedTime(t time.Time) {
+	b.addWith(TagGeneralizedTime, func(out *forkableWriter) error { return marshalGeneralizedTime(out, t) })
+}
+
+// AddMarshal adds the encoding of val, as returned by Marshal.
+func (b *Builder) AddMarshal(val interface{}) {
+	if b.err != nil {
+		return
+	}
+	data, err := Marshal(val)
+	if err != nil {
+		b.err = err
+		return
+	}
+	b.AddRaw(data)
+}
@@ -7,9 +7,17 @@
 //
 // Numbers are translated by reading and writing fixed-size values.
 // A fixed-size value is either a fixed-size arithmetic
-// type (int8, uint8, int16, float32, complex64, ...)
+// type (bool, int8, uint8, int16, float32, complex64, ...)
 // or an array or struct containing only fixed-size values.
 //
+// Read, Write and the functions that work on byte slices, Append, Encode
+// and Decode, also accept variable-size values: int and uint values,
+// encoded as varints; strings and slices, encoded as their length, as a
+// uvarint, followed by their contents; and arrays and structs containing
+// them. A slice passed directly is encoded as its elements, without a
+// length. Slices of fixed-size values whose memory layout matches their
+// encoding are copied as a whole.
+//
 // The varint functions encode and decode single integer values using
 // a variable-length encoding; smaller values require fewer bytes.
 // For a specification, see
@@ -24,7 +32,6 @@ package binary
 import (
 	"errors"
 	"io"
-	"math"
 	"reflect"
 )
 
@@ -127,14 +134,19 @@ func (bigEndian) String() string { return "BigEndian" }
 func (bigEndian) GoString() string { return "binary.BigEndian" }
 
 // Read reads structured binary data from r into data.
-// Data must be a pointer to a fixed-size value or a slice
-// of fixed-size values.
+// Data must be a pointer to a value or a slice of values
+// of the types described in the package comment.
 // Bytes read from r are decoded using the specified byte order
 // and written to successive fields of the data.
 // When reading into structs, the field data for fields with
 // blank (_) field names is skipped; i.e., blank field names
 // may be used for padding.
 // When reading into a struct, all non-blank fields must be exported.
+// When reading into a slice, the slice is filled to its length;
+// slices within the data are resized to the lengths read.
+//
+// Fixed-size data is read from r in a single call. Variable-size data
+// is read as it is decoded, in many small reads if r is not buffered.
 //
 // The error is EOF only if no bytes were read.
 // If an EOF happens after reading some but not all the bytes,
@@ -152,88 +164,135 @@ func Read(r io.Reader, order ByteOrder, data interface{}) error { // 读二进
 		if _, err := io.ReadFull(r, bs); err != nil {
 			return err
 		}
-		switch data := data.(type) {
-		case *int8:
-			*data = int8(b[0])
-		case *uint8:
-			*data = b[0]
-		case *int16:
-			*data = int16(order.Uint16(bs))
-		case *uint16:
-			*data = order.Uint16(bs)
-		case *int32:
-			*data = int32(order.Uint32(bs))
-		case *uint32:
-			*data = order.Uint32(bs)
-		case *int64:
-			*data = int64(order.Uint64(bs))
-		case *uint64:
-			*data = order.Uint64(bs)
-		case []int8:
-			for i, x := range bs { // Easier to loop over the input for 8-bit values.
-				data[i] = int8(x)
-			}
-		case []uint8:
-			copy(data, bs)
-		case []int16:
-			for i := range data {
-				data[i] = int16(order.Uint16(bs[2*i:]))
-			}
-		case []uint16:
-			for i := range data {
-				data[i] = order.Uint16(bs[2*i:])
-			}
-		case []int32:
-			for i := range data {
-				data[i] = int32(order.Uint32(bs[4*i:]))
-			}
-		case []uint32:
-			for i := range data {
-				data[i] = order.Uint32(bs[4*i:])
-			}
-		case []int64:
-			for i := range data {
-				data[i] = int64(order.Uint64(bs[8*i:]))
-			}
-		case []uint64:
-			for i := range data {
-				data[i] = order.Uint64(bs[8*i:])
-			}
-		}
+		decodeFast(bs, order, data)
 		return nil
 	}
 
 	// Fallback to reflect-based decoding.
+	v, c := decodeTarget(data)
+	if c == nil {
+		return errors.New("binary.Read: invalid type " + reflect.TypeOf(data).String())
+	}
+	d := newDecoder(nil, order)
+	if size := dataSize(c, v); size >= 0 {
+		d.buf = make([]byte, size)
+		if _, err := io.ReadFull(r, d.buf); err != nil {
+			return err
+		}
+	} else {
+		d.r = r
+	}
+	return d.value(c, v)
+}
+
+// Decode decodes binary data from buf into data, as Read does,
+// and returns the number of bytes consumed. If buf is too short,
+// Decode returns an error.
+func Decode(buf []byte, order ByteOrder, data interface{}) (int, error) {
+	if n := intDataSize(data); n != 0 {
+		if len(buf) < n {
+			return 0, errBufferTooSmall
+		}
+		decodeFast(buf[:n], order, data)
+		return n, nil
+	}
+
+	v, c := decodeTarget(data)
+	if c == nil {
+		return 0, errors.New("binary.Decode: invalid type " + reflect.TypeOf(data).String())
+	}
+	d := newDecoder(buf, order)
+	if err := d.value(c, v); err != nil {
+		return 0, err
+	}
+	return len(buf) - len(d.buf), nil
+}
+
+// decodeTarget returns the value that data, as passed to Read, refers
+// to and its codec. The codec is nil if data cannot be decoded into.
+func decodeTarget(data interface{}) (reflect.Value, *codec) {
 	v := reflect.ValueOf(data)
-	size := -1
 	switch v.Kind() {
 	case reflect.Ptr:
 		v = v.Elem()
-		size = dataSize(v)
 	case reflect.Slice:
-		size = dataSize(v)
+	default:
+		return v, nil
 	}
-	if size < 0 {
-		return errors.New("binary.Read: invalid type " + reflect.TypeOf(data).String())
+	if !v.IsValid() {
+		return v, nil
 	}
-	d := &decoder{order: order, buf: make([]byte, size)}
-	if _, err := io.ReadFull(r, d.buf); err != nil {
-		return err
+	return v, codecFor(v.Type())
+}
+
+// decodeFast decodes bs into data, which is one of the types
+// accepted by intDataSize.
+func decodeFast(bs []byte, order ByteOrder, data interface{}) {
+	switch data := data.(type) {
+	case *int8:
+		*data = int8(bs[0])
+	case *uint8:
+		*data = bs[0]
+	case *int16:
+		*data = int16(order.Uint16(bs))
+	case *uint16:
+		*data = order.Uint16(bs)
+	case *int32:
+		*data = int32(order.Uint32(bs))
+	case *uint32:
+		*data = order.Uint32(bs)
+	case *int64:
+		*data = int64(order.Uint64(bs))
+	case *uint64:
+		*data = order.Uint64(bs)
+	case []int8:
+		for i, x := range bs { // Easier to loop over the input for 8-bit values.
+			data[i] = int8(x)
+		}
+	case []uint8:
+		copy(data, bs)
+	case []int16:
+		for i := range data {
+			data[i] = int16(order.Uint16(bs[2*i:]))
+		}
+	case []uint16:
+		for i := range data {
+			data[i] = order.Uint16(bs[2*i:])
+		}
+	case []int32:
+		for i := range data {
+			data[i] = int32(order.Uint32(bs[4*i:]))
+		}
+	case []uint32:
+		for i := range data {
+			data[i] = order.Uint32(bs[4*i:])
+		}
+	case []int64:
+		for i := range data {
+			data[i] = int64(order.Uint64(bs[8*i:]))
+		}
+	case []uint64:
+		for i := range data {
+			data[i] = order.Uint64(bs[8*i:])
+		}
 	}
-	d.value(v)
-	return nil
 }
 
 // Write writes the binary representation of data into w.
-// Data must be a fixed-size value or a slice of fixed-size
-// values, or a pointer to such data.
+// Data must be a value or a slice of values of the types described
+// in the package comment, or a pointer to such data.
 // Bytes written to w are encoded using the specified byte order
 // and read from successive fields of the data.
 // When writing structs, zero values are written for fields
 // with blank (_) field names.
+// A slice passed as data is written as its elements, without a length.
 func Write(w io.Writer, order ByteOrder, data interface{}) error { // 将data数据写到w中
 	// Fast path for basic types and slices.
 	if n := intDataSize(data); n != 0 {
+		if bs, ok := data.([]uint8); ok {
+			_, err := w.Write(bs)
+			return err
+		}
 		var b [8]byte
 		var bs []byte
 		if n > len(b) {
@@ -241,352 +300,176 @@ func Write(w io.Writer, order ByteOrder, data interface{}) error { // 将data数
 		} else {
 			bs = b[:n]
 		}
-		switch v := data.(type) {
-		case *int8:
-			b[0] = byte(*v)
-		case int8:
-			b[0] = byte(v)
-		case []int8:
-			for i, x := range v {
-				bs[i] = byte(x)
-			}
-		case *uint8:
-			b[0] = *v
-		case uint8:
-			b[0] = byte(v)
-		case []uint8:
-			bs = v
-		case *int16:
-			order.PutUint16(bs, uint16(*v))
-		case int16:
-			order.PutUint16(bs, uint16(v))
-		case []int16:
-			for i, x := range v {
-				order.PutUint16(bs[2*i:], uint16(x))
-			}
-		case *uint16:
-			order.PutUint16(bs, *v)
-		case uint16:
-			order.PutUint16(bs, v)
-		case []uint16:
-			for i, x := range v {
-				order.PutUint16(bs[2*i:], x)
-			}
-		case *int32:
-			order.PutUint32(bs, uint32(*v))
-		case int32:
-			order.PutUint32(bs, uint32(v))
-		case []int32:
-			for i, x := range v {
-				order.PutUint32(bs[4*i:], uint32(x))
-			}
-		case *uint32:
-			order.PutUint32(bs, *v)
-		case uint32:
-			order.PutUint32(bs, v)
-		case []uint32:
-			for i, x := range v {
-				order.PutUint32(bs[4*i:], x)
-			}
-		case *int64:
-			order.PutUint64(bs, uint64(*v))
-		case int64:
-			order.PutUint64(bs, uint64(v))
-		case []int64:
-			for i, x := range v {
-				order.PutUint64(bs[8*i:], uint64(x))
-			}
-		case *uint64:
-			order.PutUint64(bs, *v)
-		case uint64:
-			order.PutUint64(bs, v)
-		case []uint64:
-			for i, x := range v {
-				order.PutUint64(bs[8*i:], x)
-			}
-		}
+		encodeFast(bs, order, data)
 		_, err := w.Write(bs)
 		return err
 	}
 
 	// Fallback to reflect-based encoding.
 	v := reflect.Indirect(reflect.ValueOf(data))
-	size := dataSize(v)
-	if size < 0 {
+	c := encodeCodec(v)
+	if c == nil {
 		return errors.New("binary.Write: invalid type " + reflect.TypeOf(data).String())
 	}
-	buf := make([]byte, size)
-	e := &encoder{order: order, buf: buf}
-	e.value(v)
-	_, err := w.Write(buf)
+	var buf []byte
+	if size := dataSize(c, v); size >= 0 {
+		buf = make([]byte, 0, size)
+	}
+	e := newEncoder(buf, order)
+	e.value(c, v)
+	_, err := w.Write(e.buf)
 	return err
 }
 
-// Size returns how many bytes Write would generate to encode the value v, which
-// must be a fixed-size value or a slice of fixed-size values, or a pointer to such data.
-// If v is neither of these, Size returns -1.
-func Size(v interface{}) int {
-	return dataSize(reflect.Indirect(reflect.ValueOf(v)))
-}
-
-// dataSize returns the number of bytes the actual data represented by v occupies in memory.
-// For compound structures, it sums the sizes of the elements. Thus, for instance, for a slice
-// it returns the length of the slice times the element size and does not count the memory
-// occupied by the header. If the type of v is not acceptable, dataSize returns -1.
-func dataSize(v reflect.Value) int {
-	if v.Kind() == reflect.Slice {
-		if s := sizeof(v.Type().Elem()); s >= 0 {
-			return s * v.Len()
+// Append appends the binary representation of data, as written by
+// Write, to buf and returns the extended buffer.
+func Append(buf []byte, order ByteOrder, data interface{}) ([]byte, error) {
+	if n := intDataSize(data); n != 0 {
+		m := len(buf)
+		if m+n > cap(buf) {
+			nb := make([]byte, m, 2*cap(buf)+n)
+			copy(nb, buf)
+			buf = nb
 		}
-		return -1
+		buf = buf[:m+n]
+		encodeFast(buf[m:], order, data)
+		return buf, nil
 	}
-	return sizeof(v.Type())
-}
-
-// sizeof returns the size >= 0 of variables for the given type or -1 if the type is not acceptable.
-func sizeof(t reflect.Type) int {
-	switch t.Kind() {
-	case reflect.Array:
-		if s := sizeof(t.Elem()); s >= 0 {
-			return s * t.Len()
-		}
-
-	case reflect.Struct:
-		sum := 0
-		for i, n := 0, t.NumField(); i < n; i++ {
-			s := sizeof(t.Field(i).Type)
-			if s < 0 {
-				return -1
-			}
-			sum += s
-		}
-		return sum
 
-	case reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64,
-		reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
-		reflect.Float32, reflect.Float64, reflect.Complex64, reflect.Complex128:
-		return int(t.Size())
+	v := reflect.Indirect(reflect.ValueOf(data))
+	c := encodeCodec(v)
+	if c == nil {
+		return buf, errors.New("binary.Append: invalid type " + reflect.TypeOf(data).String())
 	}
-
-	return -1
+	e := newEncoder(buf, order)
+	e.value(c, v)
+	return e.buf, nil
 }
 
-type coder struct {
-	order ByteOrder
-	buf   []byte
-}
-
-type decoder coder
-type encoder coder
-
-func (d *decoder) uint8() uint8 {
-	x := d.buf[0]
-	d.buf = d.buf[1:]
-	return x
-}
-
-func (e *encoder) uint8(x uint8) {
-	e.buf[0] = x
-	e.buf = e.buf[1:]
-}
-
-func (d *decoder) uint16() uint16 {
-	x := d.order.Uint16(d.buf[0:2])
-	d.buf = d.buf[2:]
-	return x
-}
-
-func (e *encoder) uint16(x uint16) {
-	e.order.PutUint16(e.buf[0:2], x)
-	e.buf = e.buf[2:]
-}
-
-func (d *decoder) uint32() uint32 {
-	x := d.order.Uint32(d.buf[0:4])
-	d.buf = d.buf[4:]
-	return x
-}
-
-func (e *encoder) uint32(x uint32) {
-	e.order.PutUint32(e.buf[0:4], x)
-	e.buf = e.buf[4:]
-}
-
-func (d *decoder) uint64() uint64 {
-	x := d.order.Uint64(d.buf[0:8])
-	d.buf = d.buf[8:]
-	return x
-}
-
-func (e *encoder) uint64(x uint64) {
-	e.order.PutUint64(e.buf[0:8], x)
-	e.buf = e.buf[8:]
-}
-
-func (d *decoder) int8() int8 { return int8(d.uint8()) }
-
-func (e *encoder) int8(x int8) { e.uint8(uint8(x)) }
-
-func (d *decoder) int16() int16 { return int16(d.uint16()) }
-
-func (e *encoder) int16(x int16) { e.uint16(uint16(x)) }
-
-func (d *decoder) int32() int32 { return int32(d.uint32()) }
-
-func (e *encoder) int32(x int32) { e.uint32(uint32(x)) }
-
-func (d *decoder) int64() int64 { return int64(d.uint64()) }
-
-func (e *encoder) int64(x int64) { e.uint64(uint64(x)) }
-
-func (d *decoder) value(v reflect.Value) {
-	switch v.Kind() {
-	case reflect.Array:
-		l := v.Len()
-		for i := 0; i < l; i++ {
-			d.value(v.Index(i))
-		}
-
-	case reflect.Struct:
-		t := v.Type()
-		l := v.NumField()
-		for i := 0; i < l; i++ {
-			// Note: Calling v.CanSet() below is an optimization.
-			// It would be sufficient to check the field name,
-			// but creating the StructField info for each field is
-			// costly (run "go test -bench=ReadStruct" and compare
-			// results when making changes to this code).
-			if v := v.Field(i); v.CanSet() || t.Field(i).Name != "_" {
-				d.value(v)
-			} else {
-				d.skip(v)
-			}
+// Encode writes the binary representation of data, as written by
+// Write, into buf and returns the number of bytes written. If buf
+// is too small, Encode returns an error.
+func Encode(buf []byte, order ByteOrder, data interface{}) (int, error) {
+	if n := intDataSize(data); n != 0 {
+		if len(buf) < n {
+			return 0, errBufferTooSmall
 		}
+		encodeFast(buf[:n], order, data)
+		return n, nil
+	}
 
-	case reflect.Slice:
-		l := v.Len()
-		for i := 0; i < l; i++ {
-			d.value(v.Index(i))
-		}
+	v := reflect.Indirect(reflect.ValueOf(data))
+	c := encodeCodec(v)
+	if c == nil {
+		return 0, errors.New("binary.Encode: invalid type " + reflect.TypeOf(data).String())
+	}
+	if size := dataSize(c, v); size > len(buf) {
+		return 0, errBufferTooSmall
+	}
+	// Limiting the capacity makes appending past the end of buf
+	// allocate rather than overwrite memory the caller did not offer.
+	e := newEncoder(buf[:0:len(buf)], order)
+	e.value(c, v)
+	if len(e.buf) > len(buf) {
+		return 0, errBufferTooSmall
+	}
+	return len(e.buf), nil
+}
 
-	case reflect.Int8:
-		v.SetInt(int64(d.int8()))
-	case reflect.Int16:
-		v.SetInt(int64(d.int16()))
-	case reflect.Int32:
-		v.SetInt(int64(d.int32()))
-	case reflect.Int64:
-		v.SetInt(d.int64())
-
-	case reflect.Uint8:
-		v.SetUint(uint64(d.uint8()))
-	case reflect.Uint16:
-		v.SetUint(uint64(d.uint16()))
-	case reflect.Uint32:
-		v.SetUint(uint64(d.uint32()))
-	case reflect.Uint64:
-		v.SetUint(d.uint64())
-
-	case reflect.Float32:
-		v.SetFloat(float64(math.Float32frombits(d.uint32())))
-	case reflect.Float64:
-		v.SetFloat(math.Float64frombits(d.uint64()))
-
-	case reflect.Complex64:
-		v.SetComplex(complex(
-			float64(math.Float32frombits(d.uint32())),
-			float64(math.Float32frombits(d.uint32())),
-		))
-	case reflect.Complex128:
-		v.SetComplex(complex(
-			math.Float64frombits(d.uint64()),
-			math.Float64frombits(d.uint64()),
-		))
+// encodeCodec returns the codec for v, as passed to Write,
+// or nil if v cannot be encoded.
+func encodeCodec(v reflect.Value) *codec {
+	if !v.IsValid() {
+		return nil
 	}
+	return codecFor(v.Type())
 }
 
-func (e *encoder) value(v reflect.Value) {
-	switch v.Kind() {
-	case reflect.Array:
-		l := v.Len()
-		for i := 0; i < l; i++ {
-			e.value(v.Index(i))
+// encodeFast encodes data, which is one of the types accepted
+// by intDataSize, into bs.
+func encodeFast(bs []byte, order ByteOrder, data interface{}) {
+	switch v := data.(type) {
+	case *int8:
+		bs[0] = byte(*v)
+	case int8:
+		bs[0] = byte(v)
+	case []int8:
+		for i, x := range v {
+			bs[i] = byte(x)
 		}
-
-	case reflect.Struct:
-		t := v.Type()
-		l := v.NumField()
-		for i := 0; i < l; i++ {
-			// see comment for corresponding code in decoder.value()
-			if v := v.Field(i); v.CanSet() || t.Field(i).Name != "_" {
-				e.value(v)
-			} else {
-				e.skip(v)
-			}
+	case *uint8:
+		bs[0] = *v
+	case uint8:
+		bs[0] = byte(v)
+	case []uint8:
+		copy(bs, v)
+	case *int16:
+		order.PutUint16(bs, uint16(*v))
+	case int16:
+		order.PutUint16(bs, uint16(v))
+	case []int16:
+		for i, x := range v {
+			order.PutUint16(bs[2*i:], uint16(x))
 		}
-
-	case reflect.Slice:
-		l := v.Len()
-		for i := 0; i < l; i++ {
-			e.value(v.Index(i))
+	case *uint16:
+		order.PutUint16(bs, *v)
+	case uint16:
+		order.PutUint16(bs, v)
+	case []uint16:
+		for i, x := range v {
+			order.PutUint16(bs[2*i:], x)
 		}
-
-	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
-		switch v.Type().Kind() {
-		case reflect.Int8:
-			e.int8(int8(v.Int()))
-		case reflect.Int16:
-			e.int16(int16(v.Int()))
-		case reflect.Int32:
-			e.int32(int32(v.Int()))
-		case reflect.Int64:
-			e.int64(v.Int())
+	case *int32:
+		order.PutUint32(bs, uint32(*v))
+	case int32:
+		order.PutUint32(bs, uint32(v))
+	case []int32:
+		for i, x := range v {
+			order.PutUint32(bs[4*i:], uint32(x))
 		}
-
-	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
-		switch v.Type().Kind() {
-		case reflect.Uint8:
-			e.uint8(uint8(v.Uint()))
-		case reflect.Uint16:
-			e.uint16(uint16(v.Uint()))
-		case reflect.Uint32:
-			e.uint32(uint32(v.Uint()))
-		case reflect.Uint64:
-			e.uint64(v.Uint())
+	case *uint32:
+		order.PutUint32(bs, *v)
+	case uint32:
+		order.PutUint32(bs, v)
+	case []uint32:
+		for i, x := range v {
+			order.PutUint32(bs[4*i:], x)
 		}
-
-	case reflect.Float32, reflect.Float64:
-		switch v.Type().Kind() {
-		case reflect.Float32:
-			e.uint32(math.Float32bits(float32(v.Float())))
-		case reflect.Float64:
-			e.uint64(math.Float64bits(v.Float()))
+	case *int64:
+		order.PutUint64(bs, uint64(*v))
+	case int64:
+		order.PutUint64(bs, uint64(v))
+	case []int64:
+		for i, x := range v {
+			order.PutUint64(bs[8*i:], uint64(x))
 		}
-
-	case reflect.Complex64, reflect.Complex128:
-		switch v.Type().Kind() {
-		case reflect.Complex64:
-			x := v.Complex()
-			e.uint32(math.Float32bits(float32(real(x))))
-			e.uint32(math.Float32bits(float32(imag(x))))
-		case reflect.Complex128:
-			x := v.Complex()
-			e.uint64(math.Float64bits(real(x)))
-			e.uint64(math.Float64bits(imag(x)))
+	case *uint64:
+		order.PutUint64(bs, *v)
+	case uint64:
+		order.PutUint64(bs, v)
+	case []uint64:
+		for i, x := range v {
+			order.PutUint64(bs[8*i:], x)
 		}
 	}
 }
 
-func (d *decoder) skip(v reflect.Value) {
-	d.buf = d.buf[dataSize(v):]
-}
-
-func (e *encoder) skip(v reflect.Value) {
-	n := dataSize(v)
-	for i := range e.buf[0:n] {
-		e.buf[i] = 0
+// Size returns how many bytes Write would generate to encode the value v,
+// which must be a value or a slice of values of the types described in the
+// package comment, or a pointer to such data. If v is neither of these,
+// Size returns -1. The size of variable-size data is found by encoding it.
+func Size(v interface{}) int {
+	rv := reflect.Indirect(reflect.ValueOf(v))
+	c := encodeCodec(rv)
+	if c == nil {
+		return -1
+	}
+	if size := dataSize(c, rv); size >= 0 {
+		return size
 	}
-	e.buf = e.buf[n:]
+	e := newEncoder(nil, nativeEndian)
+	e.value(c, rv)
+	return len(e.buf)
 }
 
 // intDataSize returns the size of the data required to represent the data when encoded.
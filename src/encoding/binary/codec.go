@@ -0,0 +1,629 @@
+// Copyright 2016 The Go Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package binary
+
+import (
+	"errors"
+	"io"
+	"math"
+	"reflect"
+	"sync"
+	"unsafe"
+)
+
+// This file implements the reflection-based encoding of values that
+// are not handled by the fast paths in binary.go. The structure of each
+// type is examined once, when its codec is built; the codec is cached and
+// reused by later calls.
+
+// A codec encodes and decodes the values of one type.
+type codec struct {
+	size int // encoded size of every value, or -1 if the size varies
+
+	// memcopy reports whether the memory layout of a value is identical
+	// to its encoding in the native byte order, so that it can be copied
+	// as a whole.
+	memcopy bool
+
+	enc func(e *encoder, v reflect.Value)
+	dec func(d *decoder, v reflect.Value)
+
+	elem *codec // the element codec of a slice
+}
+
+var codecCache struct {
+	sync.RWMutex
+	m map[reflect.Type]*codec
+}
+
+// codecFor returns the codec for t, or nil if t cannot be encoded.
+func codecFor(t reflect.Type) *codec {
+	codecCache.RLock()
+	c, ok := codecCache.m[t]
+	codecCache.RUnlock()
+	if ok {
+		return c
+	}
+	c = newCodec(t, make(map[reflect.Type]*codec))
+	codecCache.Lock()
+	if codecCache.m == nil {
+		codecCache.m = make(map[reflect.Type]*codec)
+	}
+	codecCache.m[t] = c
+	codecCache.Unlock()
+	return c
+}
+
+// nativeEndian is the byte order of the machine.
+var nativeEndian ByteOrder
+
+func init() {
+	x := uint16(1)
+	if *(*byte)(unsafe.Pointer(&x)) == 1 {
+		nativeEndian = LittleEndian
+	} else {
+		nativeEndian = BigEndian
+	}
+}
+
+// maxRaw bounds the size of the memory copied as a whole.
+const maxRaw = 1 << 30
+
+// rawBytes returns the n bytes of memory holding the slice elements,
+// array or struct v, or nil if v is not a slice and is not addressable.
+func rawBytes(v reflect.Value, n int) []byte {
+	if n == 0 || n > maxRaw {
+		return nil
+	}
+	if v.Kind() == reflect.Slice {
+		return (*[maxRaw]byte)(unsafe.Pointer(v.Pointer()))[:n:n]
+	}
+	if v.CanAddr() {
+		return (*[maxRaw]byte)(unsafe.Pointer(v.UnsafeAddr()))[:n:n]
+	}
+	return nil
+}
+
+// newCodec builds the codec for t. Codecs under construction are held
+// in building, so that types referring to themselves through slices can
+// be built.
+func newCodec(t reflect.Type, building map[reflect.Type]*codec) *codec {
+	if c, ok := building[t]; ok {
+		return c
+	}
+	c := &codec{size: int(t.Size()), memcopy: true}
+	building[t] = c
+	switch t.Kind() {
+	case reflect.Bool:
+		c.memcopy = false
+		c.enc = func(e *encoder, v reflect.Value) {
+			if v.Bool() {
+				e.uint8(1)
+			} else {
+				e.uint8(0)
+			}
+		}
+		c.dec = func(d *decoder, v reflect.Value) { v.SetBool(d.uint8() != 0) }
+
+	case reflect.Int8:
+		c.enc = func(e *encoder, v reflect.Value) { e.int8(int8(v.Int())) }
+		c.dec = func(d *decoder, v reflect.Value) { v.SetInt(int64(d.int8())) }
+	case reflect.Int16:
+		c.enc = func(e *encoder, v reflect.Value) { e.int16(int16(v.Int())) }
+		c.dec = func(d *decoder, v reflect.Value) { v.SetInt(int64(d.int16())) }
+	case reflect.Int32:
+		c.enc = func(e *encoder, v reflect.Value) { e.int32(int32(v.Int())) }
+		c.dec = func(d *decoder, v reflect.Value) { v.SetInt(int64(d.int32())) }
+	case reflect.Int64:
+		c.enc = func(e *encoder, v reflect.Value) { e.int64(v.Int()) }
+		c.dec = func(d *decoder, v reflect.Value) { v.SetInt(d.int64()) }
+
+	case reflect.Uint8:
+		c.enc = func(e *encoder, v reflect.Value) { e.uint8(uint8(v.Uint())) }
+		c.dec = func(d *decoder, v reflect.Value) { v.SetUint(uint64(d.uint8())) }
+	case reflect.Uint16:
+		c.enc = func(e *encoder, v reflect.Value) { e.uint16(uint16(v.Uint())) }
+		c.dec = func(d *decoder, v reflect.Value) { v.SetUint(uint64(d.uint16())) }
+	case reflect.Uint32:
+		c.enc = func(e *encoder, v reflect.Value) { e.uint32(uint32(v.Uint())) }
+		c.dec = func(d *decoder, v reflect.Value) { v.SetUint(uint64(d.uint32())) }
+	case reflect.Uint64:
+		c.enc = func(e *encoder, v reflect.Value) { e.uint64(v.Uint()) }
+		c.dec = func(d *decoder, v reflect.Value) { v.SetUint(d.uint64()) }
+
+	case reflect.Float32:
+		c.enc = func(e *encoder, v reflect.Value) { e.uint32(math.Float32bits(float32(v.Float()))) }
+		c.dec = func(d *decoder, v reflect.Value) { v.SetFloat(float64(math.Float32frombits(d.uint32()))) }
+	case reflect.Float64:
+		c.enc = func(e *encoder, v reflect.Value) { e.uint64(math.Float64bits(v.Float())) }
+		c.dec = func(d *decoder, v reflect.Value) { v.SetFloat(math.Float64frombits(d.uint64())) }
+
+	case reflect.Complex64:
+		c.enc = func(e *encoder, v reflect.Value) {
+			x := v.Complex()
+			e.uint32(math.Float32bits(float32(real(x))))
+			e.uint32(math.Float32bits(float32(imag(x))))
+		}
+		c.dec = func(d *decoder, v reflect.Value) {
+			v.SetComplex(complex(
+				float64(math.Float32frombits(d.uint32())),
+				float64(math.Float32frombits(d.uint32())),
+			))
+		}
+	case reflect.Complex128:
+		c.enc = func(e *encoder, v reflect.Value) {
+			x := v.Complex()
+			e.uint64(math.Float64bits(real(x)))
+			e.uint64(math.Float64bits(imag(x)))
+		}
+		c.dec = func(d *decoder, v reflect.Value) {
+			v.SetComplex(complex(
+				math.Float64frombits(d.uint64()),
+				math.Float64frombits(d.uint64()),
+			))
+		}
+
+	case reflect.Int:
+		c.size, c.memcopy = -1, false
+		c.enc = func(e *encoder, v reflect.Value) { e.varint(v.Int()) }
+		c.dec = func(d *decoder, v reflect.Value) {
+			x := d.varint()
+			if v.OverflowInt(x) {
+				panic(decodeError{errors.New("binary: varint overflows " + v.Type().String())})
+			}
+			v.SetInt(x)
+		}
+	case reflect.Uint:
+		c.size, c.memcopy = -1, false
+		c.enc = func(e *encoder, v reflect.Value) { e.uvarint(v.Uint()) }
+		c.dec = func(d *decoder, v reflect.Value) {
+			x := d.uvarint()
+			if v.OverflowUint(x) {
+				panic(decodeError{errors.New("binary: varint overflows " + v.Type().String())})
+			}
+			v.SetUint(x)
+		}
+
+	case reflect.String:
+		c.size, c.memcopy = -1, false
+		c.enc = func(e *encoder, v reflect.Value) {
+			s := v.String()
+			e.uvarint(uint64(len(s)))
+			e.buf = append(e.buf, s...)
+		}
+		c.dec = func(d *decoder, v reflect.Value) {
+			v.SetString(string(d.take(d.length(1))))
+		}
+
+	case reflect.Array:
+		elem := newCodec(t.Elem(), building)
+		if elem == nil {
+			return nil
+		}
+		if elem.size < 0 {
+			c.size = -1
+		} else {
+			c.size = elem.size * t.Len()
+		}
+		c.memcopy = elem.memcopy
+		c.enc = func(e *encoder, v reflect.Value) { e.elems(elem, v) }
+		c.dec = func(d *decoder, v reflect.Value) { d.elems(elem, v) }
+
+	case reflect.Slice:
+		elem := newCodec(t.Elem(), building)
+		if elem == nil {
+			return nil
+		}
+		c.size, c.memcopy, c.elem = -1, false, elem
+		c.enc = func(e *encoder, v reflect.Value) {
+			e.uvarint(uint64(v.Len()))
+			e.elems(elem, v)
+		}
+		c.dec = func(d *decoder, v reflect.Value) { d.slice(elem, v) }
+
+	case reflect.Struct:
+		type field struct {
+			c     *codec
+			blank bool
+		}
+		fields := make([]field, t.NumField())
+		size := 0
+		for i := range fields {
+			f := t.Field(i)
+			fc := newCodec(f.Type, building)
+			if fc == nil {
+				return nil
+			}
+			fields[i] = field{fc, f.Name == "_"}
+			if fc.size < 0 || size < 0 {
+				size = -1
+			} else {
+				size += fc.size
+			}
+			// Fields that are skipped or cannot be set rule out
+			// copying memory.
+			if !fc.memcopy || f.Name == "_" || f.PkgPath != "" {
+				c.memcopy = false
+			}
+		}
+		c.size = size
+		if size != int(t.Size()) {
+			c.memcopy = false // padding
+		}
+		c.enc = func(e *encoder, v reflect.Value) {
+			if c.memcopy && e.native {
+				if p := rawBytes(v, c.size); p != nil {
+					e.buf = append(e.buf, p...)
+					return
+				}
+			}
+			for i, f := range fields {
+				if f.blank {
+					e.zero(f.c, v.Field(i).Type())
+				} else {
+					f.c.enc(e, v.Field(i))
+				}
+			}
+		}
+		c.dec = func(d *decoder, v reflect.Value) {
+			if c.memcopy && d.native {
+				if p := rawBytes(v, c.size); p != nil {
+					d.bytes(p)
+					return
+				}
+			}
+			for i, f := range fields {
+				if f.blank {
+					d.skip(f.c, v.Field(i).Type())
+				} else {
+					f.c.dec(d, v.Field(i))
+				}
+			}
+		}
+
+	default:
+		return nil
+	}
+	return c
+}
+
+type encoder struct {
+	order  ByteOrder
+	buf    []byte
+	native bool // whether order is the native byte order
+}
+
+func newEncoder(buf []byte, order ByteOrder) *encoder {
+	return &encoder{order: order, buf: buf, native: order == nativeEndian}
+}
+
+// A decodeError is raised by a decoder and recovered by the function
+// that started decoding.
+type decodeError struct {
+	err error
+}
+
+var errBufferTooSmall = errors.New("binary: buffer too small")
+
+type decoder struct {
+	order  ByteOrder
+	buf    []byte
+	native bool // whether order is the native byte order
+
+	// If r is non-nil, input is read from r as it is needed rather
+	// than taken from buf.
+	r       io.Reader
+	read    int // bytes read from r
+	scratch [8]byte
+}
+
+func newDecoder(buf []byte, order ByteOrder) *decoder {
+	return &decoder{order: order, buf: buf, native: order == nativeEndian}
+}
+
+// dataSize returns the encoded size of v, which has codec c, if it is
+// fixed by the type and length of v, or -1 if it depends on the contents.
+func dataSize(c *codec, v reflect.Value) int {
+	if v.Kind() == reflect.Slice {
+		if c.elem.size < 0 {
+			return -1
+		}
+		return c.elem.size * v.Len()
+	}
+	return c.size
+}
+
+// value encodes the value v passed to Write, which has codec c.
+// A slice is encoded as its elements, without a length.
+func (e *encoder) value(c *codec, v reflect.Value) {
+	if v.Kind() == reflect.Slice {
+		e.elems(c.elem, v)
+	} else {
+		c.enc(e, v)
+	}
+}
+
+// value decodes into v as passed to Read, returning any error
+// raised while decoding. A slice is decoded as its elements,
+// without a length.
+func (d *decoder) value(c *codec, v reflect.Value) (err error) {
+	defer func() {
+		if e := recover(); e != nil {
+			de, ok := e.(decodeError)
+			if !ok {
+				panic(e)
+			}
+			err = de.err
+		}
+	}()
+	if v.Kind() == reflect.Slice {
+		d.elems(c.elem, v)
+	} else {
+		c.dec(d, v)
+	}
+	return nil
+}
+
+// fill reads len(p) bytes from d.r into p.
+func (d *decoder) fill(p []byte) {
+	n, err := io.ReadFull(d.r, p)
+	d.read += n
+	if err != nil {
+		if err == io.EOF && d.read > 0 {
+			err = io.ErrUnexpectedEOF
+		}
+		panic(decodeError{err})
+	}
+}
+
+// next consumes and returns the next n <= 8 bytes of input.
+// The result is valid only until the next call.
+func (d *decoder) next(n int) []byte {
+	if d.r != nil {
+		b := d.scratch[:n]
+		d.fill(b)
+		return b
+	}
+	if len(d.buf) < n {
+		panic(decodeError{errBufferTooSmall})
+	}
+	b := d.buf[:n]
+	d.buf = d.buf[n:]
+	return b
+}
+
+// take consumes and returns the next n bytes of input.
+// The result must not be modified.
+func (d *decoder) take(n int) []byte {
+	if d.r != nil {
+		if n <= sliceChunk {
+			b := make([]byte, n)
+			d.fill(b)
+			return b
+		}
+		// Grow the result as the data arrives, so that a corrupt
+		// length cannot force a large allocation.
+		b := make([]byte, 0, sliceChunk)
+		for len(b) < n {
+			i := len(b)
+			k := n - i
+			if k > sliceChunk {
+				k = sliceChunk
+			}
+			b = append(b, make([]byte, k)...)
+			d.fill(b[i : i+k])
+		}
+		return b
+	}
+	if len(d.buf) < n {
+		panic(decodeError{errBufferTooSmall})
+	}
+	b := d.buf[:n:n]
+	d.buf = d.buf[n:]
+	return b
+}
+
+// bytes consumes the next len(p) bytes of input, copying them into p.
+func (d *decoder) bytes(p []byte) {
+	if d.r != nil {
+		d.fill(p)
+		return
+	}
+	if len(d.buf) < len(p) {
+		panic(decodeError{errBufferTooSmall})
+	}
+	copy(p, d.buf)
+	d.buf = d.buf[len(p):]
+}
+
+func (d *decoder) uint8() uint8 {
+	return d.next(1)[0]
+}
+
+func (e *encoder) uint8(x uint8) {
+	e.buf = append(e.buf, x)
+}
+
+func (d *decoder) uint16() uint16 {
+	return d.order.Uint16(d.next(2))
+}
+
+func (e *encoder) uint16(x uint16) {
+	e.buf = append(e.buf, 0, 0)
+	e.order.PutUint16(e.buf[len(e.buf)-2:], x)
+}
+
+func (d *decoder) uint32() uint32 {
+	return d.order.Uint32(d.next(4))
+}
+
+func (e *encoder) uint32(x uint32) {
+	e.buf = append(e.buf, 0, 0, 0, 0)
+	e.order.PutUint32(e.buf[len(e.buf)-4:], x)
+}
+
+func (d *decoder) uint64() uint64 {
+	return d.order.Uint64(d.next(8))
+}
+
+func (e *encoder) uint64(x uint64) {
+	e.buf = append(e.buf, 0, 0, 0, 0, 0, 0, 0, 0)
+	e.order.PutUint64(e.buf[len(e.buf)-8:], x)
+}
+
+func (d *decoder) int8() int8 { return int8(d.uint8()) }
+
+func (e *encoder) int8(x int8) { e.uint8(uint8(x)) }
+
+func (d *decoder) int16() int16 { return int16(d.uint16()) }
+
+func (e *encoder) int16(x int16) { e.uint16(uint16(x)) }
+
+func (d *decoder) int32() int32 { return int32(d.uint32()) }
+
+func (e *encoder) int32(x int32) { e.uint32(uint32(x)) }
+
+func (d *decoder) int64() int64 { return int64(d.uint64()) }
+
+func (e *encoder) int64(x int64) { e.uint64(uint64(x)) }
+
+func (d *decoder) uvarint() uint64 {
+	var x uint64
+	var s uint
+	for i := 0; i < MaxVarintLen64; i++ {
+		b := d.uint8()
+		if b < 0x80 {
+			if i == MaxVarintLen64-1 && b > 1 {
+				panic(decodeError{overflow})
+			}
+			return x | uint64(b)<<s
+		}
+		x |= uint64(b&0x7f) << s
+		s += 7
+	}
+	panic(decodeError{overflow})
+}
+
+func (e *encoder) uvarint(x uint64) {
+	e.buf = AppendUvarint(e.buf, x)
+}
+
+func (d *decoder) varint() int64 {
+	ux := d.uvarint()
+	x := int64(ux >> 1)
+	if ux&1 != 0 {
+		x = ^x
+	}
+	return x
+}
+
+func (e *encoder) varint(x int64) {
+	e.buf = AppendVarint(e.buf, x)
+}
+
+// length decodes the length of a string or slice whose elements
+// occupy at least min bytes each.
+func (d *decoder) length(min int) int {
+	n := d.uvarint()
+	if n > uint64(maxInt) || d.r == nil && min > 0 && n > uint64(len(d.buf)/min) {
+		panic(decodeError{errBufferTooSmall})
+	}
+	return int(n)
+}
+
+const maxInt = int(^uint(0) >> 1)
+
+// elems encodes the elements of the array or slice v.
+func (e *encoder) elems(c *codec, v reflect.Value) {
+	n := v.Len()
+	if c.memcopy && (e.native || c.size == 1) {
+		if p := rawBytes(v, n*c.size); p != nil {
+			e.buf = append(e.buf, p...)
+			return
+		}
+	}
+	for i := 0; i < n; i++ {
+		c.enc(e, v.Index(i))
+	}
+}
+
+// elems decodes the elements of the array or slice v.
+func (d *decoder) elems(c *codec, v reflect.Value) {
+	n := v.Len()
+	if c.memcopy && (d.native || c.size == 1) {
+		if p := rawBytes(v, n*c.size); p != nil {
+			d.bytes(p)
+			return
+		}
+	}
+	for i := 0; i < n; i++ {
+		c.dec(d, v.Index(i))
+	}
+}
+
+// sliceChunk is the number of elements allocated at a time when decoding
+// a slice from a reader, which bounds the memory allocated for a length
+// that is not followed by the data.
+const sliceChunk = 4096
+
+// slice decodes a length-prefixed slice into v, reusing the memory of v
+// if it is large enough.
+func (d *decoder) slice(c *codec, v reflect.Value) {
+	min := c.size
+	if min <= 0 {
+		min = 1 // variable-size values occupy at least a byte
+	}
+	n := d.length(min)
+	if v.Cap() >= n {
+		v.SetLen(n)
+		d.elems(c, v)
+		return
+	}
+	t := v.Type()
+	if d.r == nil || n <= sliceChunk {
+		s := reflect.MakeSlice(t, n, n)
+		d.elems(c, s)
+		v.Set(s)
+		return
+	}
+	s := reflect.MakeSlice(t, 0, sliceChunk)
+	for s.Len() < n {
+		i := s.Len()
+		k := n - i
+		if k > sliceChunk {
+			k = sliceChunk
+		}
+		s = reflect.AppendSlice(s, reflect.MakeSlice(t, k, k))
+		d.elems(c, s.Slice(i, i+k))
+	}
+	v.Set(s)
+}
+
+// zero encodes the zero value of type t, which has codec c.
+func (e *encoder) zero(c *codec, t reflect.Type) {
+	if c.size < 0 {
+		c.enc(e, reflect.Zero(t))
+		return
+	}
+	for i := 0; i < c.size; i++ {
+		e.buf = append(e.buf, 0)
+	}
+}
+
+// skip consumes a value of type t, which has codec c.
+func (d *decoder) skip(c *codec, t reflect.Type) {
+	if c.size < 0 {
+		c.dec(d, reflect.New(t).Elem())
+		return
+	}
+	for n := c.size; n > 0; {
+		k := n
+		if k > len(d.scratch) {
+			k = len(d.scratch)
+		}
+		d.next(k)
+		n -= k
+	}
+}
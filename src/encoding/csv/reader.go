@@ -51,11 +51,11 @@ package csv
 
 import (
 	"bufio"
-	"bytes"
 	"errors"
 	"fmt"
 	"io"
 	"unicode"
+	"unicode/utf8"
 )
 
 // A ParseError is returned for parsing errors.
@@ -76,6 +76,7 @@ var (
 	ErrBareQuote     = errors.New("bare \" in non-quoted-field")
 	ErrQuote         = errors.New("extraneous \" in field")
 	ErrFieldCount    = errors.New("wrong number of fields in line")
+	ErrEscape        = errors.New("escape character at end of input")
 )
 
 // A Reader reads records from a CSV-encoded file.
@@ -99,6 +100,20 @@ var (
 // non-doubled quote may appear in a quoted field.
 //
 // If TrimLeadingSpace is true, leading white space in a field is ignored.
+//
+// Quote is the character that starts and ends quoted fields. It defaults to
+// '"'. If Quote is 0, no field is treated as quoted.
+//
+// Escape, if not 0 and not equal to Quote, is the escape character. Within
+// both quoted and unquoted fields, the escape character followed by 't',
+// 'n' or 'r' stands for a tab, newline or carriage return, and followed by
+// any other character stands for that character, so that the delimiter,
+// the quote, a line break or the escape character itself can be included
+// in a field. This supports dialects such as tab-separated values with
+// backslash escapes.
+//
+// If ReuseRecord is true, calls to Read may return a slice sharing the
+// backing array of the slice returned by a previous call, for performance.
 type Reader struct {
 	Comma            rune // field delimiter (set to ',' by NewReader) 分隔符
 	Comment          rune // comment character for start of line
@@ -106,16 +121,34 @@ type Reader struct {
 	LazyQuotes       bool // allow lazy quotes
 	TrailingComma    bool // ignored; here for backwards compatibility
 	TrimLeadingSpace bool // trim leading space
+	Quote            rune // quote character (set to '"' by NewReader)
+	Escape           rune // escape character
+	ReuseRecord      bool // reuse the record slice between calls to Read
 	line             int
 	column           int
+	offset           int64         // byte offset of the next rune
+	recordOffset     int64         // byte offset of the last record
 	r                *bufio.Reader // 数据reader源
-	field            bytes.Buffer
+
+	// The fields of the last record are stored unescaped, one after the
+	// other, in recordBuffer; fieldIndexes holds the end of each.
+	recordBuffer []byte
+	fieldIndexes []int
+	fieldPos     []fieldPos // where the fields of the last record start
+	lastRecord   []string   // for ReuseRecord
+	byteRecord   [][]byte   // for ReadBytes
+}
+
+// A fieldPos is the line and column at which a field starts.
+type fieldPos struct {
+	line, column int
 }
 
 // NewReader returns a new Reader that reads from r.
 func NewReader(r io.Reader) *Reader { // 新创建一个csv文件的Reader
 	return &Reader{
 		Comma: ',', // reader的分隔符
+		Quote: '"',
 		r:     bufio.NewReader(r),
 	}
 }
@@ -131,26 +164,97 @@ func (r *Reader) error(err error) error { // 基于err创建一个ParseError
 
 // Read reads one record from r.  The record is a slice of strings with each
 // string representing one field.
+//
+// All the fields of a record share a single allocation. If ReuseRecord is
+// set, the record slice itself is reused between calls as well.
 func (r *Reader) Read() (record []string, err error) { // 读一串记录
+	n, err := r.readRecord()
+	if n < 0 {
+		return nil, err
+	}
+	if r.ReuseRecord && cap(r.lastRecord) >= n {
+		record = r.lastRecord[:n]
+	} else {
+		record = make([]string, n)
+	}
+	line := string(r.recordBuffer)
+	start := 0
+	for i, end := range r.fieldIndexes {
+		record[i] = line[start:end]
+		start = end
+	}
+	if r.ReuseRecord {
+		r.lastRecord = record
+	}
+	return record, err
+}
+
+// ReadBytes is like Read but returns the fields as byte slices. The slices
+// are only valid until the next call to Read or ReadBytes, which reuses
+// them and the memory they refer to. Once the Reader has grown its buffers
+// to the size of the records, ReadBytes does not allocate.
+func (r *Reader) ReadBytes() (record [][]byte, err error) {
+	n, err := r.readRecord()
+	if n < 0 {
+		return nil, err
+	}
+	if cap(r.byteRecord) < n {
+		r.byteRecord = make([][]byte, n)
+	}
+	record = r.byteRecord[:n]
+	start := 0
+	for i, end := range r.fieldIndexes {
+		record[i] = r.recordBuffer[start:end:end]
+		start = end
+	}
+	return record, err
+}
+
+// readRecord reads the next non-empty record into r.recordBuffer and
+// r.fieldIndexes and returns its number of fields, or -1 if there is none.
+// On ErrFieldCount, it returns both the record and the error.
+func (r *Reader) readRecord() (n int, err error) {
 	for {
-		record, err = r.parseRecord()
-		if record != nil {
+		ok, err := r.parseRecord()
+		if ok {
 			break
 		}
 		if err != nil {
-			return nil, err
+			return -1, err
 		}
 	}
 
+	n = len(r.fieldIndexes)
 	if r.FieldsPerRecord > 0 {
-		if len(record) != r.FieldsPerRecord {
+		if n != r.FieldsPerRecord {
 			r.column = 0 // report at start of record
-			return record, r.error(ErrFieldCount)
+			return n, r.error(ErrFieldCount)
 		}
 	} else if r.FieldsPerRecord == 0 {
-		r.FieldsPerRecord = len(record)
+		r.FieldsPerRecord = n
 	}
-	return record, nil
+	return n, nil
+}
+
+// RecordOffset returns the byte offset in the input of the start of the
+// record most recently returned by Read or ReadBytes.
+func (r *Reader) RecordOffset() int64 {
+	return r.recordOffset
+}
+
+// InputOffset returns the byte offset in the input of the end of the
+// record most recently returned by Read or ReadBytes, including its line
+// terminator.
+func (r *Reader) InputOffset() int64 {
+	return r.offset
+}
+
+// FieldPos returns the line and column at which the field with the given
+// index in the record most recently returned by Read or ReadBytes starts,
+// numbered as in ParseError. It panics if field is out of range.
+func (r *Reader) FieldPos(field int) (line, column int) {
+	pos := r.fieldPos[field]
+	return pos.line, pos.column
 }
 
 // ReadAll reads all the remaining records from r.
@@ -175,17 +279,20 @@ func (r *Reader) ReadAll() (records [][]string, err error) {
 // of how far into the line we have read.  r.column will point to the start
 // of this rune, not the end of this rune.
 func (r *Reader) readRune() (rune, error) {
-	r1, _, err := r.r.ReadRune()
+	r1, size, err := r.r.ReadRune()
+	r.offset += int64(size)
 
 	// Handle \r\n here.  We make the simplifying assumption that
 	// anytime \r is followed by \n that it can be folded to \n.
 	// We will not detect files which contain both \r\n and bare \n.
 	if r1 == '\r' {
-		r1, _, err = r.r.ReadRune()
+		r1, size, err = r.r.ReadRune()
 		if err == nil {
 			if r1 != '\n' {
 				r.r.UnreadRune()
 				r1 = '\r'
+			} else {
+				r.offset += int64(size)
 			}
 		}
 	}
@@ -206,52 +313,97 @@ func (r *Reader) skip(delim rune) error {
 	}
 }
 
-// parseRecord reads and parses a single csv record from r.
-func (r *Reader) parseRecord() (fields []string, err error) {
+// parseRecord reads and parses a single csv record from r into
+// r.recordBuffer and r.fieldIndexes.  It reports whether the line
+// held a record, as opposed to being blank or a comment.
+func (r *Reader) parseRecord() (ok bool, err error) {
 	// Each record starts on a new line.  We increment our line
 	// number (lines start at 1, not 0) and set column to -1
 	// so as we increment in readRune it points to the character we read.
 	r.line++
 	r.column = -1
+	r.recordOffset = r.offset
 
 	// Peek at the first rune.  If it is an error we are done.
 	// If we support comments and it is the comment character
 	// then skip to the end of line.
 
-	r1, _, err := r.r.ReadRune()
+	r1, size, err := r.r.ReadRune()
 	if err != nil {
-		return nil, err
+		return false, err
 	}
 
 	if r.Comment != 0 && r1 == r.Comment {
-		return nil, r.skip('\n')
+		r.offset += int64(size)
+		return false, r.skip('\n')
 	}
 	r.r.UnreadRune()
 
 	// At this point we have at least one field.
+	r.recordBuffer = r.recordBuffer[:0]
+	r.fieldIndexes = r.fieldIndexes[:0]
+	r.fieldPos = r.fieldPos[:0]
 	for {
+		pos := fieldPos{r.line, r.column + 1}
 		haveField, delim, err := r.parseField()
 		if haveField {
-			// If FieldsPerRecord is greater then 0 we can assume the final
-			// length of fields to be equal to FieldsPerRecord.
-			if r.FieldsPerRecord > 0 && fields == nil {
-				fields = make([]string, 0, r.FieldsPerRecord)
-			}
-			fields = append(fields, r.field.String())
+			r.fieldPos = append(r.fieldPos, pos)
+			r.fieldIndexes = append(r.fieldIndexes, len(r.recordBuffer))
 		}
 		if delim == '\n' || err == io.EOF {
-			return fields, err
+			return len(r.fieldIndexes) > 0, err
 		} else if err != nil {
-			return nil, err
+			return false, err
 		}
 	}
 }
 
+// appendRune appends r1 to the field being parsed.
+func (r *Reader) appendRune(r1 rune) {
+	if r1 < utf8.RuneSelf {
+		r.recordBuffer = append(r.recordBuffer, byte(r1))
+		return
+	}
+	var buf [utf8.UTFMax]byte
+	n := utf8.EncodeRune(buf[:], r1)
+	r.recordBuffer = append(r.recordBuffer, buf[:n]...)
+}
+
+// readEscaped reads the rune following the escape character.
+func (r *Reader) readEscaped() (rune, error) {
+	r1, err := r.readRune()
+	if err == io.EOF {
+		return 0, r.error(ErrEscape)
+	}
+	if err != nil {
+		return 0, err
+	}
+	switch r1 {
+	case 't':
+		r1 = '\t'
+	case 'n':
+		r1 = '\n'
+	case 'r':
+		r1 = '\r'
+	case '\n':
+		r.line++
+		r.column = -1
+	}
+	return r1, nil
+}
+
 // parseField parses the next field in the record.  The read field is
-// located in r.field.  Delim is the first character not part of the field
-// (r.Comma or '\n').
+// appended to r.recordBuffer.  Delim is the first character not part of
+// the field (r.Comma or '\n').
 func (r *Reader) parseField() (haveField bool, delim rune, err error) {
-	r.field.Reset()
+	// A quote or escape character of -1 never matches.
+	quote, escape := r.Quote, r.Escape
+	if quote == 0 {
+		quote = -1
+	}
+	if escape == 0 || escape == quote {
+		escape = -1
+	}
 
 	r1, err := r.readRune()
 	for err == nil && r.TrimLeadingSpace && r1 != '\n' && unicode.IsSpace(r1) {
@@ -276,7 +428,7 @@ func (r *Reader) parseField() (haveField bool, delim rune, err error) {
 		}
 		return true, r1, nil
 
-	case '"':
+	case quote:
 		// quoted field
 	Quoted:
 		for {
@@ -291,7 +443,7 @@ func (r *Reader) parseField() (haveField bool, delim rune, err error) {
 				return false, 0, err
 			}
 			switch r1 {
-			case '"':
+			case quote:
 				r1, err = r.readRune()
 				if err != nil || r1 == r.Comma {
 					break Quoted
@@ -299,25 +451,34 @@ func (r *Reader) parseField() (haveField bool, delim rune, err error) {
 				if r1 == '\n' {
 					return true, r1, nil
 				}
-				if r1 != '"' {
+				if r1 != quote {
 					if !r.LazyQuotes {
 						r.column--
 						return false, 0, r.error(ErrQuote)
 					}
 					// accept the bare quote
-					r.field.WriteRune('"')
+					r.appendRune(quote)
+				}
+			case escape:
+				if r1, err = r.readEscaped(); err != nil {
+					return false, 0, err
 				}
 			case '\n':
 				r.line++
 				r.column = -1
 			}
-			r.field.WriteRune(r1)
+			r.appendRune(r1)
 		}
 
 	default:
 		// unquoted field
 		for {
-			r.field.WriteRune(r1)
+			if r1 == escape {
+				if r1, err = r.readEscaped(); err != nil {
+					return false, 0, err
+				}
+			}
+			r.appendRune(r1)
 			r1, err = r.readRune()
 			if err != nil || r1 == r.Comma {
 				break
@@ -325,7 +486,7 @@ func (r *Reader) parseField() (haveField bool, delim rune, err error) {
 			if r1 == '\n' {
 				return true, r1, nil
 			}
-			if !r.LazyQuotes && r1 == '"' {
+			if !r.LazyQuotes && r1 == quote {
 				return false, 0, r.error(ErrBareQuote)
 			}
 		}
@@ -0,0 +1,513 @@
+// Copyright 2016 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package csv
+
+import (
+	"encoding"
+	"errors"
+	"fmt"
+	"io"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// A Decoder reads records from a Reader into structs. The first record
+// read is the header; its fields name the columns of the records that
+// follow, and the columns are mapped to struct fields by name.
+//
+// A struct field maps to the column named by the "csv" key in the field's
+// tag, or to the column named by the field itself if there is no tag.
+// Columns are matched to names exactly when possible and without regard
+// to case otherwise. Columns without a matching field are ignored, as are
+// fields without a matching column. The tag "-" excludes a field. The
+// fields of an anonymous struct field are treated as if they were in the
+// outer struct, following Go's visibility rules amended as in
+// encoding/json: of several fields with the same name, the one nested
+// least deeply is used, or the tagged one if several are nested equally
+// deeply, and otherwise none of them is.
+//
+// Field values are converted as follows. Strings are used as is.
+// Booleans, integers and floating point numbers are parsed by the
+// corresponding functions of package strconv. Values implementing
+// encoding.TextUnmarshaler, or pointers to such values, are set by
+// calling UnmarshalText. An empty field sets a pointer to nil and any
+// other value to its zero value.
+type Decoder struct {
+	r       *Reader
+	header  []string
+	columns [][]int // for each column, the index of its struct field or nil
+	typ     reflect.Type
+}
+
+// NewDecoder returns a new Decoder that reads from r.
+func NewDecoder(r *Reader) *Decoder {
+	return &Decoder{r: r}
+}
+
+// Header returns the header record, reading it from the input
+// if it has not been read yet.
+func (d *Decoder) Header() ([]string, error) {
+	if d.header == nil {
+		header, err := d.r.Read()
+		if err != nil {
+			return nil, err
+		}
+		// The Reader may reuse the record's slice if ReuseRecord is set.
+		d.header = append([]string(nil), header...)
+	}
+	return d.header, nil
+}
+
+// Decode reads the next record and stores it in the struct pointed to by v.
+// At the end of the input Decode returns io.EOF. If a field cannot be
+// converted to the type of its struct field, Decode returns a *ParseError
+// with the line and column at which the field starts.
+func (d *Decoder) Decode(v interface{}) error {
+	rv := reflect.ValueOf(v)
+	if rv.Kind() != reflect.Ptr || rv.IsNil() || rv.Elem().Kind() != reflect.Struct {
+		return errors.New("csv: Decode of non-pointer to struct")
+	}
+	if _, err := d.Header(); err != nil {
+		return err
+	}
+	record, err := d.r.Read()
+	if err != nil {
+		return err
+	}
+	rv = rv.Elem()
+	if rv.Type() != d.typ {
+		d.mapColumns(rv.Type())
+	}
+	for i, s := range record {
+		if i >= len(d.columns) || d.columns[i] == nil {
+			continue
+		}
+		f := fieldByIndex(rv, d.columns[i])
+		if err := setField(f, s); err != nil {
+			pos := d.r.fieldPos[i]
+			return &ParseError{
+				Line:   pos.line,
+				Column: pos.column,
+				Err:    fmt.Errorf("column %q: %v", d.header[i], err),
+			}
+		}
+	}
+	return nil
+}
+
+// mapColumns maps the header columns to the fields of t.
+func (d *Decoder) mapColumns(t reflect.Type) {
+	fields := cachedFields(t)
+	d.typ = t
+	d.columns = make([][]int, len(d.header))
+	used := make([]bool, len(fields))
+	for pass := 0; pass < 2; pass++ {
+		for i, name := range d.header {
+			if d.columns[i] != nil {
+				continue
+			}
+			for j, f := range fields {
+				if !used[j] && (pass == 0 && f.name == name || pass == 1 && strings.EqualFold(f.name, name)) {
+					d.columns[i] = f.index
+					used[j] = true
+					break
+				}
+			}
+		}
+	}
+}
+
+// fieldByIndex returns the nested field of v with the given index,
+// allocating nil embedded pointers on the way.
+func fieldByIndex(v reflect.Value, index []int) reflect.Value {
+	for i, x := range index {
+		if i > 0 && v.Kind() == reflect.Ptr {
+			if v.IsNil() {
+				v.Set(reflect.New(v.Type().Elem()))
+			}
+			v = v.Elem()
+		}
+		v = v.Field(x)
+	}
+	return v
+}
+
+var textUnmarshalerType = reflect.TypeOf(new(encoding.TextUnmarshaler)).Elem()
+
+// setField sets v from the field s.
+func setField(v reflect.Value, s string) error {
+	if v.Kind() == reflect.Ptr {
+		if s == "" {
+			v.Set(reflect.Zero(v.Type()))
+			return nil
+		}
+		if v.IsNil() {
+			v.Set(reflect.New(v.Type().Elem()))
+		}
+		v = v.Elem()
+	}
+	if s == "" {
+		v.Set(reflect.Zero(v.Type()))
+		return nil
+	}
+	if v.CanAddr() && v.Addr().Type().Implements(textUnmarshalerType) {
+		return v.Addr().Interface().(encoding.TextUnmarshaler).UnmarshalText([]byte(s))
+	}
+	switch v.Kind() {
+	case reflect.String:
+		v.SetString(s)
+	case reflect.Bool:
+		b, err := strconv.ParseBool(s)
+		if err != nil {
+			return err
+		}
+		v.SetBool(b)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		n, err := strconv.ParseInt(s, 10, v.Type().Bits())
+		if err != nil {
+			return err
+		}
+		v.SetInt(n)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		n, err := strconv.ParseUint(s, 10, v.Type().Bits())
+		if err != nil {
+			return err
+		}
+		v.SetUint(n)
+	case reflect.Float32, reflect.Float64:
+		n, err := strconv.ParseFloat(s, v.Type().Bits())
+		if err != nil {
+			return err
+		}
+		v.SetFloat(n)
+	default:
+		return fmt.Errorf("unsupported type %s", v.Type())
+	}
+	return nil
+}
+
+// An Encoder writes structs to a Writer as records. Before the first
+// record it writes a header naming the columns. The columns are the struct
+// fields in order, named and selected as described for Decoder.
+//
+// Field values are formatted as follows. Strings are used as is.
+// Booleans, integers and floating point numbers are formatted by the
+// corresponding functions of package strconv, floating point numbers in
+// the shortest form that parses back to the same value. Values
+// implementing encoding.TextMarshaler are formatted by calling MarshalText.
+// A nil pointer is written as an empty field. If the tag of a field has
+// the option "omitempty", as in `csv:"name,omitempty"`, its zero value is
+// also written as an empty field.
+type Encoder struct {
+	w      *Writer
+	typ    reflect.Type
+	fields []field
+	record []string
+}
+
+// NewEncoder returns a new Encoder that writes to w.
+func NewEncoder(w *Writer) *Encoder {
+	return &Encoder{w: w}
+}
+
+// Encode writes the struct v, or the struct v points to, as a record,
+// preceded by the header if this is the first call. All calls must pass
+// the same type. As with Writer.Write, the output is buffered; call Flush
+// on the Writer when done.
+func (e *Encoder) Encode(v interface{}) error {
+	rv := reflect.ValueOf(v)
+	if rv.Kind() == reflect.Ptr && !rv.IsNil() {
+		rv = rv.Elem()
+	}
+	if rv.Kind() != reflect.Struct {
+		return errors.New("csv: Encode of non-struct")
+	}
+	if e.typ == nil {
+		fields := cachedFields(rv.Type())
+		e.typ = rv.Type()
+		e.fields = fields
+		header := make([]string, len(fields))
+		for i, f := range fields {
+			header[i] = f.name
+		}
+		if err := e.w.Write(header); err != nil {
+			return err
+		}
+		e.record = make([]string, len(fields))
+	} else if rv.Type() != e.typ {
+		return fmt.Errorf("csv: Encode of %s after %s", rv.Type(), e.typ)
+	}
+	for i, f := range e.fields {
+		s, err := formatField(rv, f)
+		if err != nil {
+			return err
+		}
+		e.record[i] = s
+	}
+	return e.w.Write(e.record)
+}
+
+var textMarshalerType = reflect.TypeOf(new(encoding.TextMarshaler)).Elem()
+
+// formatField formats the field f of the struct v.
+func formatField(v reflect.Value, f field) (string, error) {
+	for i, x := range f.index {
+		if i > 0 && v.Kind() == reflect.Ptr {
+			if v.IsNil() {
+				return "", nil
+			}
+			v = v.Elem()
+		}
+		v = v.Field(x)
+	}
+	if f.omitEmpty && isZero(v) {
+		return "", nil
+	}
+	if v.Kind() == reflect.Ptr {
+		if v.IsNil() {
+			return "", nil
+		}
+		v = v.Elem()
+	}
+	if v.Type().Implements(textMarshalerType) {
+		b, err := v.Interface().(encoding.TextMarshaler).MarshalText()
+		return string(b), err
+	}
+	if v.CanAddr() && v.Addr().Type().Implements(textMarshalerType) {
+		b, err := v.Addr().Interface().(encoding.TextMarshaler).MarshalText()
+		return string(b), err
+	}
+	switch v.Kind() {
+	case reflect.String:
+		return v.String(), nil
+	case reflect.Bool:
+		return strconv.FormatBool(v.Bool()), nil
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return strconv.FormatInt(v.Int(), 10), nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return strconv.FormatUint(v.Uint(), 10), nil
+	case reflect.Float32, reflect.Float64:
+		return strconv.FormatFloat(v.Float(), 'g', -1, v.Type().Bits()), nil
+	}
+	return "", fmt.Errorf("csv: unsupported type %s", v.Type())
+}
+
+func isZero(v reflect.Value) bool {
+	switch v.Kind() {
+	case reflect.String:
+		return v.Len() == 0
+	case reflect.Bool:
+		return !v.Bool()
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return v.Int() == 0
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return v.Uint() == 0
+	case reflect.Float32, reflect.Float64:
+		return v.Float() == 0
+	case reflect.Ptr, reflect.Interface, reflect.Map, reflect.Slice, reflect.Chan, reflect.Func:
+		return v.IsNil()
+	case reflect.Complex64, reflect.Complex128:
+		return v.Complex() == 0
+	case reflect.Array:
+		for i := 0; i < v.Len(); i++ {
+			if !isZero(v.Index(i)) {
+				return false
+			}
+		}
+		return true
+	case reflect.Struct:
+		for i := 0; i < v.NumField(); i++ {
+			if !isZero(v.Field(i)) {
+				return false
+			}
+		}
+		return true
+	}
+	return false
+}
+
+// Unmarshal reads a header and all the remaining records from r and
+// stores them in the slice of structs pointed to by v, as by Decoder.
+func Unmarshal(r *Reader, v interface{}) error {
+	rv := reflect.ValueOf(v)
+	if rv.Kind() != reflect.Ptr || rv.IsNil() || rv.Elem().Kind() != reflect.Slice {
+		return errors.New("csv: Unmarshal of non-pointer to slice")
+	}
+	slice := rv.Elem()
+	elem := slice.Type().Elem()
+	isPtr := elem.Kind() == reflect.Ptr
+	if isPtr {
+		elem = elem.Elem()
+	}
+	d := NewDecoder(r)
+	for {
+		p := reflect.New(elem)
+		if err := d.Decode(p.Interface()); err == io.EOF {
+			return nil
+		} else if err != nil {
+			return err
+		}
+		if isPtr {
+			slice.Set(reflect.Append(slice, p))
+		} else {
+			slice.Set(reflect.Append(slice, p.Elem()))
+		}
+	}
+}
+
+// Marshal writes a header and one record for each element of the slice of
+// structs v to w, as by Encoder, and then calls Flush.
+func Marshal(w *Writer, v interface{}) error {
+	rv := reflect.ValueOf(v)
+	if rv.Kind() != reflect.Slice {
+		return errors.New("csv: Marshal of non-slice")
+	}
+	e := NewEncoder(w)
+	for i := 0; i < rv.Len(); i++ {
+		if err := e.Encode(rv.Index(i).Interface()); err != nil {
+			return err
+		}
+	}
+	w.Flush()
+	return w.Error()
+}
+
+// A field is a struct field mapped to a column.
+type field struct {
+	name      string
+	tagged    bool // whether name came from a tag
+	index     []int
+	omitEmpty bool
+}
+
+var fieldCache struct {
+	sync.RWMutex
+	m map[reflect.Type][]field
+}
+
+// cachedFields returns the dominant fields of t, using a cache to avoid
+// repeated work.
+func cachedFields(t reflect.Type) []field {
+	fieldCache.RLock()
+	f := fieldCache.m[t]
+	fieldCache.RUnlock()
+	if f != nil {
+		return f
+	}
+	f = dominantFields(typeFields(t, nil, make(map[reflect.Type]bool)))
+	if f == nil {
+		f = []field{}
+	}
+	fieldCache.Lock()
+	if fieldCache.m == nil {
+		fieldCache.m = make(map[reflect.Type][]field)
+	}
+	fieldCache.m[t] = f
+	fieldCache.Unlock()
+	return f
+}
+
+// typeFields returns the fields of the struct type t that map to columns,
+// in order, descending into anonymous struct fields. The result may hold
+// several fields with the same name; see dominantFields. The visited map
+// holds the types being descended into, which breaks cycles of embedded
+// pointers.
+func typeFields(t reflect.Type, index []int, visited map[reflect.Type]bool) []field {
+	if visited[t] {
+		return nil
+	}
+	visited[t] = true
+	defer delete(visited, t)
+	var fields []field
+	for i := 0; i < t.NumField(); i++ {
+		sf := t.Field(i)
+		tag := sf.Tag.Get("csv")
+		if tag == "-" {
+			continue
+		}
+		name, opts := tag, ""
+		if j := strings.Index(tag, ","); j >= 0 {
+			name, opts = tag[:j], tag[j+1:]
+		}
+		idx := make([]int, len(index)+1)
+		copy(idx, index)
+		idx[len(index)] = i
+		ft := sf.Type
+		if ft.Kind() == reflect.Ptr {
+			ft = ft.Elem()
+		}
+		if sf.Anonymous && name == "" && ft.Kind() == reflect.Struct &&
+			!reflect.PtrTo(ft).Implements(textUnmarshalerType) {
+			if sf.PkgPath != "" && sf.Type.Kind() == reflect.Ptr {
+				// Unexported embedded pointers cannot be allocated.
+				continue
+			}
+			fields = append(fields, typeFields(ft, idx, visited)...)
+			continue
+		}
+		if sf.PkgPath != "" {
+			continue // unexported
+		}
+		tagged := name != ""
+		if name == "" {
+			name = sf.Name
+		}
+		fields = append(fields, field{
+			name:      name,
+			tagged:    tagged,
+			index:     idx,
+			omitEmpty: opts == "omitempty",
+		})
+	}
+	return fields
+}
+
+// dominantFields returns the fields that are not hidden by other fields
+// with the same name, keeping their order. Of the fields with a name,
+// those nested least deeply win; if there are several, the tagged one
+// wins, and if that does not settle it, the name is ambiguous and all of
+// them are dropped.
+func dominantFields(fields []field) []field {
+	byName := make(map[string][]int)
+	for i, f := range fields {
+		byName[f.name] = append(byName[f.name], i)
+	}
+	keep := make([]bool, len(fields))
+	for _, group := range byName {
+		depth := len(fields[group[0]].index)
+		for _, i := range group[1:] {
+			if d := len(fields[i].index); d < depth {
+				depth = d
+			}
+		}
+		var dominant []int
+		for _, i := range group {
+			if len(fields[i].index) == depth {
+				dominant = append(dominant, i)
+			}
+		}
+		if len(dominant) > 1 {
+			var tagged []int
+			for _, i := range dominant {
+				if fields[i].tagged {
+					tagged = append(tagged, i)
+				}
+			}
+			dominant = tagged
+		}
+		if len(dominant) == 1 {
+			keep[dominant[0]] = true
+		}
+	}
+	var out []field
+	for i, f := range fields {
+		if keep[i] {
+			out = append(out, f)
+		}
+	}
+	return out
+}
@@ -12,6 +12,7 @@ import (
 	"io"
 	"math"
 	"reflect"
+	"sync/atomic"
 )
 
 var (
@@ -423,8 +424,9 @@ func ignoreUint8Array(i *decInstr, state *decoderState, value reflect.Value) {
 // The encoder engine is an array of instructions indexed by field number of the incoming
 // decoder.  It is executed with random access according to field number.
 type decEngine struct {
-	instr    []decInstr
-	numInstr int // the number of active instructions
+	instr     []decInstr
+	numInstr  int    // the number of active instructions
+	renameGen uint64 // fieldRenameGen when the engine was compiled
 }
 
 // decodeSingle decodes a top-level value that is not a struct and stores it in value.
@@ -1109,10 +1111,12 @@ func (dec *Decoder) compileDec(remoteId typeId, ut *userTypeInfo) (engine *decEn
 			errorf("empty name for remote field of type %s", wireStruct.Name)
 		}
 		ovfl := overflow(wireField.Name)
-		// Find the field of the local type with the same name.
-		localField, present := srt.FieldByName(wireField.Name)
+		// Find the field of the local type with the same name,
+		// or the name it was renamed to.
+		localName := localFieldName(srt, wireField.Name)
+		localField, present := srt.FieldByName(localName)
 		// TODO(r): anonymous names
-		if !present || !isExported(wireField.Name) {
+		if !present || !isExported(localName) {
 			op := dec.decIgnoreOpFor(wireField.Id, make(map[typeId]*decOp))
 			engine.instr[fieldnum] = decInstr{*op, fieldnum, nil, ovfl}
 			continue
@@ -1135,14 +1139,25 @@ func (dec *Decoder) getDecEnginePtr(remoteId typeId, ut *userTypeInfo) (enginePt
 		decoderMap = make(map[typeId]**decEngine)
 		dec.decoderCache[rt] = decoderMap
 	}
-	if enginePtr, ok = decoderMap[remoteId]; !ok {
+	// An engine compiled before a field rename was registered may map
+	// the fields wrongly, so it is compiled again, in place so that the
+	// engines referring to it see the new one.
+	gen := atomic.LoadUint64(&fieldRenameGen)
+	if enginePtr, ok = decoderMap[remoteId]; !ok || *enginePtr != nil && (*enginePtr).renameGen != gen {
 		// To handle recursive types, mark this engine as underway before compiling.
-		enginePtr = new(*decEngine)
-		decoderMap[remoteId] = enginePtr
-		*enginePtr, err = dec.compileDec(remoteId, ut)
+		if !ok {
+			enginePtr = new(*decEngine)
+			decoderMap[remoteId] = enginePtr
+		}
+		*enginePtr = nil
+		var engine *decEngine
+		engine, err = dec.compileDec(remoteId, ut)
 		if err != nil {
 			delete(decoderMap, remoteId)
+			return
 		}
+		engine.renameGen = gen
+		*enginePtr = engine
 	}
 	return
 }
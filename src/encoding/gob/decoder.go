@@ -28,6 +28,8 @@ type Decoder struct {
 	ignorerCache map[typeId]**decEngine                  // ditto for ignored objects
 	freeList     *decoderState                           // list of free decoderStates; avoids reallocation
 	countBuf     []byte                                  // used for decoding integers while parsing messages
+	pending      bool                                    // a value has been read ahead by NextType
+	pendingId    typeId                                  // the type of that value
 	err          error
 }
 
@@ -203,15 +205,26 @@ func (dec *Decoder) DecodeValue(v reflect.Value) error {
 	dec.mutex.Lock()
 	defer dec.mutex.Unlock()
 
-	dec.buf.Reset() // In case data lingers from previous invocation.
-	dec.err = nil
-	id := dec.decodeTypeSequence(false)
+	id := dec.nextValue()
 	if dec.err == nil {
 		dec.decodeValue(id, v)
 	}
 	return dec.err
 }
 
+// nextValue reads the type definitions preceding the next value, unless
+// NextType has already done so, and returns the type id of the value.
+// Upon return, the remainder of dec.buf is the value.
+func (dec *Decoder) nextValue() typeId {
+	if dec.pending {
+		dec.pending = false
+		return dec.pendingId
+	}
+	dec.buf.Reset() // In case data lingers from previous invocation.
+	dec.err = nil
+	return dec.decodeTypeSequence(false)
+}
+
 // If debug.go is compiled into the program , debugFunc prints a human-readable
 // representation of the gob data read from r by calling that file's Debug function.
 // Otherwise it is nil.
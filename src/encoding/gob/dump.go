@@ -6,9 +6,6 @@
 
 package main
 
-// Need to compile package gob with debug.go to build this program.
-// See comments in debug.go for how to do this.
-
 import (
 	"encoding/gob"
 	"fmt"
@@ -25,5 +22,8 @@ func main() {
 			os.Exit(1)
 		}
 	}
-	gob.Debug(file)
+	if err := gob.Dump(os.Stdout, file); err != nil {
+		fmt.Fprintf(os.Stderr, "dump: %s\n", err)
+		os.Exit(1)
+	}
 }
@@ -837,6 +837,72 @@ func RegisterName(name string, value interface{}) {
 	concreteTypeToName[ut.base] = name
 }
 
+// RegisterRename records that interface values sent under the concrete type
+// name oldName, as given to RegisterName or derived by Register, are to be
+// decoded as values of the type of value. It allows streams written before a
+// type was renamed or moved to another package to be decoded. Only decoding
+// is affected: values of the type are still sent under its registered name.
+func RegisterRename(oldName string, value interface{}) {
+	if oldName == "" {
+		panic("attempt to register empty name")
+	}
+	registerLock.Lock()
+	defer registerLock.Unlock()
+	rt := reflect.TypeOf(value)
+	if t, ok := nameToConcreteType[oldName]; ok && t != rt {
+		panic(fmt.Sprintf("gob: registering duplicate types for %q: %s != %s", oldName, t, rt))
+	}
+	nameToConcreteType[oldName] = rt
+}
+
+// fieldRenames maps a struct type to the renames of its fields,
+// from the name sent to the name of the local field.
+var fieldRenames = make(map[reflect.Type]map[string]string) // protected by registerLock
+
+// fieldRenameGen counts the calls of RegisterFieldRename, so that decoders
+// can tell when their compiled engines are out of date. It is accessed
+// atomically.
+var fieldRenameGen uint64
+
+// RegisterFieldRename records that the field of the struct type of value,
+// or of the struct value points to, that is now named newName was once named
+// oldName. When decoding, a received field named oldName is stored in the
+// field newName. It allows streams written before the field was renamed to
+// be decoded. Decoders take renames registered after they have compiled a
+// type into account from the next value they decode.
+func RegisterFieldRename(value interface{}, oldName, newName string) {
+	rt := reflect.TypeOf(value)
+	for rt.Kind() == reflect.Ptr {
+		rt = rt.Elem()
+	}
+	if rt.Kind() != reflect.Struct {
+		panic("gob: RegisterFieldRename of non-struct type " + rt.String())
+	}
+	if _, ok := rt.FieldByName(newName); !ok {
+		panic("gob: RegisterFieldRename: no field " + newName + " in " + rt.String())
+	}
+	registerLock.Lock()
+	defer registerLock.Unlock()
+	m := fieldRenames[rt]
+	if m == nil {
+		m = make(map[string]string)
+		fieldRenames[rt] = m
+	}
+	m[oldName] = newName
+	atomic.AddUint64(&fieldRenameGen, 1)
+}
+
+// localFieldName returns the name of the field of the struct type rt in
+// which a received field called name is stored.
+func localFieldName(rt reflect.Type, name string) string {
+	registerLock.RLock()
+	defer registerLock.RUnlock()
+	if newName, ok := fieldRenames[rt][name]; ok {
+		return newName
+	}
+	return name
+}
+
 // Register records a type, identified by a value for that type, under its
 // internal type name.  That name will identify the concrete type of a value
 // sent or received as an interface variable.  Only types that will be
@@ -0,0 +1,304 @@
+// Copyright 2016 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package gob
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// A StructValue is a struct decoded by DecodeUntyped.
+type StructValue struct {
+	Type *WireType
+	// Fields holds the fields that were transmitted, in order.
+	// Fields with zero values are not transmitted.
+	Fields []FieldValue
+}
+
+// A FieldValue is a field of a StructValue.
+type FieldValue struct {
+	Name  string
+	Value interface{}
+}
+
+// A MapValue is a map decoded by DecodeUntyped. Its keys and elements
+// are held in parallel slices, in the order in which they were sent.
+type MapValue struct {
+	Type   *WireType
+	Keys   []interface{}
+	Values []interface{}
+}
+
+// An InterfaceValue is a non-nil interface value decoded by DecodeUntyped.
+type InterfaceValue struct {
+	Name  string      // the name under which the concrete type was registered
+	Value interface{} // the concrete value
+}
+
+// An EncodedValue is a value sent in the form produced by its GobEncode,
+// MarshalBinary or MarshalText method, decoded by DecodeUntyped.
+type EncodedValue struct {
+	Type *WireType
+	Data []byte
+}
+
+// DecodeUntyped reads the next value from the input stream and returns it
+// without requiring the Go type it was encoded from, which makes it possible
+// to inspect gob streams written by other programs. Values are returned as:
+//
+//	bool, int64, uint64, float64, complex128, string, []byte
+//		for the corresponding basic types
+//	[]interface{}	for arrays and slices other than []byte
+//	*MapValue	for maps
+//	*StructValue	for structs
+//	*InterfaceValue	for interface values; nil interface values are nil
+//	*EncodedValue	for values sent by GobEncode, MarshalBinary or MarshalText
+//
+// If the input is at EOF, DecodeUntyped returns io.EOF.
+func (dec *Decoder) DecodeUntyped() (v interface{}, err error) {
+	dec.mutex.Lock()
+	defer dec.mutex.Unlock()
+
+	id := dec.nextValue()
+	if dec.err != nil {
+		return nil, dec.err
+	}
+	defer catchError(&dec.err)
+	defer func() { err = dec.err }()
+	return dec.untypedValue(id, make(map[typeId]*WireType)), nil
+}
+
+// untypedValue decodes a top-level value of type id, laid out as by
+// decodeStruct or decodeSingle.
+func (dec *Decoder) untypedValue(id typeId, memo map[typeId]*WireType) interface{} {
+	if wire := dec.wireOf(id); wire != nil && wire.StructT != nil {
+		return dec.untypedStruct(id, memo)
+	}
+	state := dec.newDecoderState(&dec.buf)
+	defer dec.freeDecoderState(state)
+	state.fieldnum = singletonField
+	if state.decodeUint() != 0 {
+		errorf("decode: corrupted data: non-zero delta for singleton")
+	}
+	return dec.untyped(state, id, memo)
+}
+
+// untypedStruct decodes a struct of type id.
+func (dec *Decoder) untypedStruct(id typeId, memo map[typeId]*WireType) *StructValue {
+	wire := dec.wireOf(id)
+	sv := &StructValue{Type: dec.describe(id, memo)}
+	state := dec.newDecoderState(&dec.buf)
+	defer dec.freeDecoderState(state)
+	state.fieldnum = -1
+	for state.b.Len() > 0 {
+		delta := int(state.decodeUint())
+		if delta < 0 {
+			errorf("decode: corrupted data: negative delta")
+		}
+		if delta == 0 { // struct terminator is zero delta fieldnum
+			break
+		}
+		fieldnum := state.fieldnum + delta
+		if fieldnum >= len(wire.StructT.Field) {
+			error_(errRange)
+		}
+		f := wire.StructT.Field[fieldnum]
+		sv.Fields = append(sv.Fields, FieldValue{f.Name, dec.untyped(state, f.Id, memo)})
+		state.fieldnum = fieldnum
+	}
+	return sv
+}
+
+// untypedBytes decodes a counted byte sequence.
+func untypedBytes(state *decoderState) []byte {
+	n, ok := state.getLength()
+	if !ok {
+		errorf("bad data length %d", n)
+	}
+	b := make([]byte, n)
+	state.b.Read(b)
+	return b
+}
+
+// untyped decodes a value of type id that is not at top level.
+func (dec *Decoder) untyped(state *decoderState, id typeId, memo map[typeId]*WireType) interface{} {
+	switch id {
+	case tBool:
+		return state.decodeUint() != 0
+	case tInt:
+		return state.decodeInt()
+	case tUint:
+		return state.decodeUint()
+	case tFloat:
+		return float64FromBits(state.decodeUint())
+	case tComplex:
+		real := float64FromBits(state.decodeUint())
+		imag := float64FromBits(state.decodeUint())
+		return complex(real, imag)
+	case tString:
+		return string(untypedBytes(state))
+	case tBytes:
+		return untypedBytes(state)
+	case tInterface:
+		return dec.untypedInterface(state, memo)
+	}
+	wire := dec.wireOf(id)
+	switch {
+	case wire == nil:
+		errorf("unknown type id %d", id)
+	case wire.ArrayT != nil:
+		if n := state.decodeUint(); n != uint64(wire.ArrayT.Len) {
+			errorf("length mismatch in array")
+		}
+		return dec.untypedElems(state, wire.ArrayT.Elem, wire.ArrayT.Len, memo)
+	case wire.SliceT != nil:
+		n, ok := state.getLength()
+		if !ok {
+			errorf("slice too big: %d elements", n)
+		}
+		return dec.untypedElems(state, wire.SliceT.Elem, n, memo)
+	case wire.MapT != nil:
+		n, ok := state.getLength()
+		if !ok {
+			errorf("map too big: %d elements", n)
+		}
+		mv := &MapValue{Type: dec.describe(id, memo)}
+		for i := 0; i < n; i++ {
+			mv.Keys = append(mv.Keys, dec.untyped(state, wire.MapT.Key, memo))
+			mv.Values = append(mv.Values, dec.untyped(state, wire.MapT.Elem, memo))
+		}
+		return mv
+	case wire.StructT != nil:
+		return dec.untypedStruct(id, memo)
+	default:
+		// GobEncoder, BinaryMarshaler or TextMarshaler.
+		return &EncodedValue{Type: dec.describe(id, memo), Data: untypedBytes(state)}
+	}
+	return nil
+}
+
+func (dec *Decoder) untypedElems(state *decoderState, elem typeId, n int, memo map[typeId]*WireType) []interface{} {
+	s := make([]interface{}, 0, n)
+	for i := 0; i < n; i++ {
+		if state.b.Len() == 0 {
+			errorf("decoding array or slice: length exceeds input size (%d elements)", n)
+		}
+		s = append(s, dec.untyped(state, elem, memo))
+	}
+	return s
+}
+
+// untypedInterface decodes an interface value, as decodeInterface does.
+func (dec *Decoder) untypedInterface(state *decoderState, memo map[typeId]*WireType) interface{} {
+	name := string(untypedBytes(state))
+	if name == "" {
+		return nil
+	}
+	concreteId := dec.decodeTypeSequence(true)
+	if concreteId < 0 {
+		error_(dec.err)
+	}
+	// Byte count of value is next; we don't care what it is.
+	state.decodeUint()
+	return &InterfaceValue{Name: name, Value: dec.untypedValue(concreteId, memo)}
+}
+
+// Dump writes a human-readable listing of the gob stream read from r to w.
+// It lists the definition of each type the first time a value of the type
+// is received, followed by the values, decoded as by DecodeUntyped. Dump
+// does not need the Go types the stream was encoded from.
+func Dump(w io.Writer, r io.Reader) error {
+	dec := NewDecoder(r)
+	bw := bufio.NewWriter(w)
+	defined := make(map[int]bool)
+	for {
+		t, err := dec.NextType()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			bw.Flush()
+			return err
+		}
+		dumpTypes(bw, t, defined)
+		v, err := dec.DecodeUntyped()
+		if err != nil {
+			bw.Flush()
+			return err
+		}
+		dumpValue(bw, v, 0)
+		bw.WriteByte('\n')
+	}
+	return bw.Flush()
+}
+
+// dumpTypes writes the definitions of t and the types it refers to
+// that have not been written yet.
+func dumpTypes(w *bufio.Writer, t *WireType, defined map[int]bool) {
+	if t == nil || defined[t.Id] {
+		return
+	}
+	defined[t.Id] = true
+	if t.Id < firstUserId {
+		return // a basic type
+	}
+	dumpTypes(w, t.Key, defined)
+	dumpTypes(w, t.Elem, defined)
+	for _, f := range t.Fields {
+		dumpTypes(w, f.Type, defined)
+	}
+	if t.Name != "" {
+		fmt.Fprintf(w, "type %s\n", t.Definition())
+	}
+}
+
+// dumpValue writes v, as returned by DecodeUntyped, at the given
+// indentation depth.
+func dumpValue(w *bufio.Writer, v interface{}, depth int) {
+	indent := strings.Repeat("\t", depth)
+	switch v := v.(type) {
+	case *StructValue:
+		fmt.Fprintf(w, "%s {\n", v.Type)
+		for _, f := range v.Fields {
+			fmt.Fprintf(w, "%s\t%s: ", indent, f.Name)
+			dumpValue(w, f.Value, depth+1)
+			w.WriteByte('\n')
+		}
+		fmt.Fprintf(w, "%s}", indent)
+	case *MapValue:
+		fmt.Fprintf(w, "%s {\n", v.Type)
+		for i := range v.Keys {
+			fmt.Fprintf(w, "%s\t", indent)
+			dumpValue(w, v.Keys[i], depth+1)
+			w.WriteString(": ")
+			dumpValue(w, v.Values[i], depth+1)
+			w.WriteByte('\n')
+		}
+		fmt.Fprintf(w, "%s}", indent)
+	case []interface{}:
+		w.WriteString("[\n")
+		for _, e := range v {
+			fmt.Fprintf(w, "%s\t", indent)
+			dumpValue(w, e, depth+1)
+			w.WriteByte('\n')
+		}
+		fmt.Fprintf(w, "%s]", indent)
+	case *InterfaceValue:
+		fmt.Fprintf(w, "(%s) ", v.Name)
+		dumpValue(w, v.Value, depth)
+	case *EncodedValue:
+		fmt.Fprintf(w, "%s(%s) %x", v.Type, v.Type.Kind, v.Data)
+	case nil:
+		w.WriteString("nil")
+	case string:
+		fmt.Fprintf(w, "%q", v)
+	case []byte:
+		fmt.Fprintf(w, "%x", v)
+	default:
+		fmt.Fprint(w, v)
+	}
+}
@@ -0,0 +1,345 @@
+// Copyright 2016 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package gob
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"reflect"
+)
+
+// A WireKind is the kind of a type as transmitted in a gob stream.
+type WireKind uint8
+
+const (
+	WireBool WireKind = iota
+	WireInt
+	WireUint
+	WireFloat
+	WireComplex
+	WireString
+	WireBytes
+	WireInterface
+	WireArray
+	WireSlice
+	WireMap
+	WireStruct
+	WireGobEncoder      // sent by the GobEncode method
+	WireBinaryMarshaler // sent by the MarshalBinary method
+	WireTextMarshaler   // sent by the MarshalText method
+)
+
+var wireKindNames = [...]string{
+	WireBool:            "bool",
+	WireInt:             "int",
+	WireUint:            "uint",
+	WireFloat:           "float",
+	WireComplex:         "complex",
+	WireString:          "string",
+	WireBytes:           "bytes",
+	WireInterface:       "interface",
+	WireArray:           "array",
+	WireSlice:           "slice",
+	WireMap:             "map",
+	WireStruct:          "struct",
+	WireGobEncoder:      "GobEncoder",
+	WireBinaryMarshaler: "BinaryMarshaler",
+	WireTextMarshaler:   "TextMarshaler",
+}
+
+func (k WireKind) String() string {
+	if int(k) < len(wireKindNames) {
+		return wireKindNames[k]
+	}
+	return fmt.Sprintf("WireKind(%d)", k)
+}
+
+// A WireType describes a type as transmitted in a gob stream, independent
+// of any Go type. Recursive types are described by cyclic WireTypes.
+type WireType struct {
+	Id     int    // the type's id in the stream
+	Name   string // the name given by the sender
+	Kind   WireKind
+	Len    int         // the length of an array
+	Key    *WireType   // the key type of a map
+	Elem   *WireType   // the element type of an array, slice or map
+	Fields []WireField // the fields of a struct, in the order sent
+}
+
+// A WireField is a field of a struct type as transmitted in a gob stream.
+type WireField struct {
+	Name string
+	Type *WireType
+}
+
+// String returns the name of t.
+func (t *WireType) String() string {
+	if t.Name != "" {
+		return t.Name
+	}
+	return t.Kind.String()
+}
+
+// Definition returns a description of t in a Go-like syntax, such as
+// "Point struct { X int; Y int }", spelling out the structure of t but
+// referring to other named struct types by name.
+func (t *WireType) Definition() string {
+	switch t.Kind {
+	case WireStruct:
+		var b bytes.Buffer
+		b.WriteString(t.Name)
+		b.WriteString(" struct {")
+		for i, f := range t.Fields {
+			if i > 0 {
+				b.WriteByte(';')
+			}
+			b.WriteByte(' ')
+			b.WriteString(f.Name)
+			b.WriteByte(' ')
+			b.WriteString(f.Type.ref())
+		}
+		b.WriteString(" }")
+		return b.String()
+	case WireArray, WireSlice, WireMap, WireGobEncoder, WireBinaryMarshaler, WireTextMarshaler:
+		if t.Name == "" {
+			return t.ref()
+		}
+		return t.Name + " " + t.ref()
+	}
+	return t.Name
+}
+
+// ref returns the way t is written within another definition.
+func (t *WireType) ref() string {
+	switch t.Kind {
+	case WireArray:
+		return fmt.Sprintf("[%d]%s", t.Len, t.Elem.ref())
+	case WireSlice:
+		return "[]" + t.Elem.ref()
+	case WireMap:
+		return "map[" + t.Key.ref() + "]" + t.Elem.ref()
+	case WireGobEncoder, WireBinaryMarshaler, WireTextMarshaler:
+		return t.Kind.String()
+	}
+	return t.String()
+}
+
+// wireOf returns the wire description of the non-basic type id, which
+// may be one of the types built into the gob package, or nil if the
+// type is unknown.
+func (dec *Decoder) wireOf(id typeId) *wireType {
+	if wire := dec.wireType[id]; wire != nil {
+		return wire
+	}
+	switch t := builtinIdToType[id].(type) {
+	case *arrayType:
+		return &wireType{ArrayT: t}
+	case *sliceType:
+		return &wireType{SliceT: t}
+	case *structType:
+		return &wireType{StructT: t}
+	case *mapType:
+		return &wireType{MapT: t}
+	}
+	return nil
+}
+
+var basicWireKinds = map[typeId]WireKind{
+	tBool:      WireBool,
+	tInt:       WireInt,
+	tUint:      WireUint,
+	tFloat:     WireFloat,
+	tComplex:   WireComplex,
+	tString:    WireString,
+	tBytes:     WireBytes,
+	tInterface: WireInterface,
+}
+
+// describe returns the WireType for id. Types already described are
+// taken from memo.
+func (dec *Decoder) describe(id typeId, memo map[typeId]*WireType) *WireType {
+	if t := memo[id]; t != nil {
+		return t
+	}
+	t := &WireType{Id: int(id)}
+	memo[id] = t
+	if kind, ok := basicWireKinds[id]; ok {
+		t.Kind = kind
+		t.Name = kind.String()
+		return t
+	}
+	wire := dec.wireOf(id)
+	switch {
+	case wire == nil:
+		errorf("unknown type id %d", id)
+	case wire.ArrayT != nil:
+		t.Kind, t.Name, t.Len = WireArray, wire.ArrayT.Name, wire.ArrayT.Len
+		t.Elem = dec.describe(wire.ArrayT.Elem, memo)
+	case wire.SliceT != nil:
+		t.Kind, t.Name = WireSlice, wire.SliceT.Name
+		t.Elem = dec.describe(wire.SliceT.Elem, memo)
+	case wire.MapT != nil:
+		t.Kind, t.Name = WireMap, wire.MapT.Name
+		t.Key = dec.describe(wire.MapT.Key, memo)
+		t.Elem = dec.describe(wire.MapT.Elem, memo)
+	case wire.StructT != nil:
+		t.Kind, t.Name = WireStruct, wire.StructT.Name
+		t.Fields = make([]WireField, len(wire.StructT.Field))
+		for i, f := range wire.StructT.Field {
+			t.Fields[i] = WireField{f.Name, dec.describe(f.Id, memo)}
+		}
+	case wire.GobEncoderT != nil:
+		t.Kind, t.Name = WireGobEncoder, wire.GobEncoderT.Name
+	case wire.BinaryMarshalerT != nil:
+		t.Kind, t.Name = WireBinaryMarshaler, wire.BinaryMarshalerT.Name
+	case wire.TextMarshalerT != nil:
+		t.Kind, t.Name = WireTextMarshaler, wire.TextMarshalerT.Name
+	default:
+		errorf("invalid type id %d", id)
+	}
+	return t
+}
+
+// NextType reads ahead to the next value in the input stream, receiving the
+// definitions of any types sent before it, and returns a description of the
+// value's type. The value itself is left to be read by the next call to
+// Decode, DecodeValue or DecodeUntyped. NextType may be called repeatedly
+// before then. If the input is at EOF, NextType returns io.EOF.
+func (dec *Decoder) NextType() (t *WireType, err error) {
+	dec.mutex.Lock()
+	defer dec.mutex.Unlock()
+	if !dec.pending {
+		id := dec.nextValue()
+		if dec.err != nil {
+			return nil, dec.err
+		}
+		dec.pending, dec.pendingId = true, id
+	}
+	defer catchError(&err)
+	return dec.describe(dec.pendingId, make(map[typeId]*WireType)), nil
+}
+
+// A Compatibility describes how the fields of a type transmitted in a gob
+// stream correspond to those of a Go type. Fields are identified by paths
+// such as "Order.Items[].Price", in which "[]" stands for the elements of
+// an array, slice or map and "[key]" for the keys of a map.
+type Compatibility struct {
+	// Ignored lists the transmitted fields that have no corresponding
+	// Go field. Their values are discarded when decoding.
+	Ignored []string
+	// Unset lists the Go fields that are not transmitted.
+	// Decoding leaves them unchanged.
+	Unset []string
+	// Mismatched describes the transmitted fields, or the value itself,
+	// whose types cannot be decoded into the corresponding Go types.
+	Mismatched []string
+}
+
+// Compatible reports whether values can be decoded into the Go type,
+// that is, whether no types are mismatched.
+func (c *Compatibility) Compatible() bool {
+	return len(c.Mismatched) == 0
+}
+
+// Check reads ahead to the next value in the input stream, as NextType
+// does, and reports how the value's type corresponds to the type of e,
+// which is typically a pointer to the variable the value will be decoded
+// into. Field renames registered with RegisterFieldRename are taken into
+// account. The concrete types of interface values are not known until the
+// values are decoded and are not checked. If the input is at EOF, Check
+// returns io.EOF.
+func (dec *Decoder) Check(e interface{}) (c *Compatibility, err error) {
+	if e == nil {
+		return nil, errors.New("gob: Check of nil value")
+	}
+	if _, err := dec.NextType(); err != nil {
+		return nil, err
+	}
+	dec.mutex.Lock()
+	defer dec.mutex.Unlock()
+	defer catchError(&err)
+	rt := reflect.TypeOf(e)
+	path := userType(rt).base.Name()
+	if path == "" {
+		path = userType(rt).base.String()
+	}
+	c = new(Compatibility)
+	dec.compare(c, rt, dec.pendingId, path, true, make(map[reflect.Type]map[typeId]bool))
+	return c, nil
+}
+
+// compare records in c how the wire type id corresponds to the Go type
+// rt at the given path. Top is set for the top-level value.
+func (dec *Decoder) compare(c *Compatibility, rt reflect.Type, id typeId, path string, top bool, seen map[reflect.Type]map[typeId]bool) {
+	if seen[rt][id] {
+		return
+	}
+	if seen[rt] == nil {
+		seen[rt] = make(map[typeId]bool)
+	}
+	seen[rt][id] = true
+
+	ut := userType(rt)
+	base := ut.base
+	if base.Kind() == reflect.Struct && ut.externalDec == 0 {
+		wire := dec.wireOf(id)
+		if wire == nil || wire.StructT == nil {
+			c.Mismatched = append(c.Mismatched, fmt.Sprintf("%s: cannot decode %s into struct %s", path, dec.typeString(id), rt))
+			return
+		}
+		matched := make(map[string]bool)
+		for _, wf := range wire.StructT.Field {
+			name := localFieldName(base, wf.Name)
+			f, ok := base.FieldByName(name)
+			fpath := joinPath(path, wf.Name)
+			if !ok || !isExported(name) {
+				c.Ignored = append(c.Ignored, fpath)
+				continue
+			}
+			matched[f.Name] = true
+			dec.compare(c, f.Type, wf.Id, fpath, false, seen)
+		}
+		for i := 0; i < base.NumField(); i++ {
+			f := base.Field(i)
+			if isSent(&f) && !matched[f.Name] {
+				c.Unset = append(c.Unset, joinPath(path, f.Name))
+			}
+		}
+		// Decoding a top-level struct fails if no fields match at all.
+		if top && len(matched) == 0 && base.NumField() > 0 && len(wire.StructT.Field) > 0 {
+			c.Mismatched = append(c.Mismatched, fmt.Sprintf("%s: no fields of %s match those received", path, rt))
+		}
+		return
+	}
+	if !dec.compatibleType(rt, id, make(map[reflect.Type]typeId)) {
+		c.Mismatched = append(c.Mismatched, fmt.Sprintf("%s: cannot decode %s into %s", path, dec.typeString(id), rt))
+		return
+	}
+	if ut.externalDec != 0 {
+		return
+	}
+	// Compatible containers may hold structs, whose fields are compared
+	// in turn.
+	wire := dec.wireOf(id)
+	switch base.Kind() {
+	case reflect.Array:
+		dec.compare(c, base.Elem(), wire.ArrayT.Elem, path+"[]", false, seen)
+	case reflect.Slice:
+		if id != tBytes {
+			dec.compare(c, base.Elem(), wire.SliceT.Elem, path+"[]", false, seen)
+		}
+	case reflect.Map:
+		dec.compare(c, base.Key(), wire.MapT.Key, path+"[key]", false, seen)
+		dec.compare(c, base.Elem(), wire.MapT.Elem, path+"[]", false, seen)
+	}
+}
+
+func joinPath(path, name string) string {
+	if path == "" {
+		return name
+	}
+	return path + "." + name
+}
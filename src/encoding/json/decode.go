@@ -37,7 +37,9 @@ import (
 // To unmarshal JSON into a struct, Unmarshal matches incoming object
 // keys to the keys used by Marshal (either the struct field name or its tag),
 // preferring an exact match but also accepting a case-insensitive match.
-// Unmarshal will only set exported fields of the struct.
+// Unmarshal will only set exported fields of the struct. Keys that match
+// no field are ignored; a Decoder can be configured to report them, along
+// with duplicate keys and absent required fields, instead.
 //
 // To unmarshal JSON into an interface value,
 // Unmarshal stores one of these in the interface value:
@@ -61,10 +63,11 @@ import (
 // If the JSON array is smaller than the Go array,
 // the additional Go array elements are set to zero values.
 //
-// To unmarshal a JSON object into a string-keyed map, Unmarshal first
-// establishes a map to use, If the map is nil, Unmarshal allocates a new map.
-// Otherwise Unmarshal reuses the existing map, keeping existing entries.
-// Unmarshal then stores key-value pairs from the JSON object into the map.
+// To unmarshal a JSON object into a map, Unmarshal first establishes a map to
+// use, If the map is nil, Unmarshal allocates a new map. Otherwise Unmarshal
+// reuses the existing map, keeping existing entries. Unmarshal then stores
+// key-value pairs from the JSON object into the map. The map's key type must
+// either be a string, an integer, or implement encoding.TextUnmarshaler.
 //
 // If a JSON value is not appropriate for a given target type,
 // or if a JSON number overflows the target type, Unmarshal
@@ -147,6 +150,47 @@ func (e *InvalidUnmarshalError) Error() string {
 	return "json: Unmarshal(nil " + e.Type.String() + ")"
 }
 
+// An UnknownFieldError describes a JSON object key that matches no field
+// of the destination struct. It is only reported by a Decoder on which
+// DisallowUnknownFields has been called.
+type UnknownFieldError struct {
+	Key    string       // the object key as it appeared in the input
+	Path   string       // location of the key, such as "spec.items[2].name"
+	Type   reflect.Type // struct type that has no field for Key
+	Offset int64        // offset of the key in the input stream
+}
+
+func (e *UnknownFieldError) Error() string {
+	return "json: unknown field " + strconv.Quote(e.Path) + " for Go value of type " + e.Type.String()
+}
+
+// A DuplicateFieldError describes a JSON object key that appears more than
+// once in the same object. It is only reported by a Decoder on which
+// DisallowDuplicateFields has been called.
+type DuplicateFieldError struct {
+	Key    string // the repeated object key
+	Path   string // location of the key, such as "spec.items[2].name"
+	Offset int64  // offset of the second occurrence in the input stream
+}
+
+func (e *DuplicateFieldError) Error() string {
+	return "json: duplicate field " + strconv.Quote(e.Path)
+}
+
+// A MissingFieldError describes a struct field tagged as required that had
+// no corresponding key in the JSON object. It is only reported by a Decoder
+// on which RequireFields has been called.
+type MissingFieldError struct {
+	Field  string       // JSON name of the missing field
+	Path   string       // location the field was expected at, such as "spec.name"
+	Type   reflect.Type // struct type containing the field
+	Offset int64        // offset of the enclosing object in the input stream
+}
+
+func (e *MissingFieldError) Error() string {
+	return "json: missing required field " + strconv.Quote(e.Path) + " for Go value of type " + e.Type.String()
+}
+
 func (d *decodeState) unmarshal(v interface{}) (err error) {
 	defer func() {
 		if r := recover(); r != nil {
@@ -253,8 +297,19 @@ type decodeState struct { // 解码状态结构
 	nextscan   scanner // for calls to nextValue
 	savedError error
 	useNumber  bool
+
+	// Strict decoding options, set by the Decoder.
+	disallowUnknownFields   bool
+	disallowDuplicateFields bool
+	exactCase               bool
+	requireFields           bool
+
+	base int64    // offset of data in the input stream
+	path []string // location of the current value; only kept when strict
 }
 
+var textUnmarshalerType = reflect.TypeOf(new(encoding.TextUnmarshaler)).Elem()
+
 // errPhase is used for errors that should not happen unless
 // there is a bug in the JSON decoder or something is editing
 // the data slice while the decoder executes.
@@ -264,9 +319,40 @@ func (d *decodeState) init(data []byte) *decodeState {
 	d.data = data
 	d.off = 0
 	d.savedError = nil
+	d.path = d.path[:0]
 	return d
 }
 
+// strict reports whether any of the checks that need the location of
+// the current value are enabled.
+func (d *decodeState) strict() bool {
+	return d.disallowUnknownFields || d.disallowDuplicateFields || d.requireFields
+}
+
+// pushPath records that the decoder is descending into elem,
+// which is either ".key" or "[index]".
+func (d *decodeState) pushPath(elem string) {
+	d.path = append(d.path, elem)
+}
+
+func (d *decodeState) popPath() {
+	d.path = d.path[:len(d.path)-1]
+}
+
+// pathString returns the location of the current value, extended by elem.
+func (d *decodeState) pathString(elem string) string {
+	var b bytes.Buffer
+	for _, p := range d.path {
+		b.WriteString(p)
+	}
+	b.WriteString(elem)
+	s := b.String()
+	if len(s) > 0 && s[0] == '.' {
+		s = s[1:]
+	}
+	return s
+}
+
 // error aborts the decoding by panicking with err.
 func (d *decodeState) error(err error) {
 	panic(err)
@@ -484,6 +570,7 @@ func (d *decodeState) array(v reflect.Value) {
 		break
 	}
 
+	strict := d.strict()
 	i := 0
 	for {
 		// Look ahead for ] - can only happen on first iteration.
@@ -513,6 +600,9 @@ func (d *decodeState) array(v reflect.Value) {
 			}
 		}
 
+		if strict {
+			d.pushPath("[" + strconv.Itoa(i) + "]")
+		}
 		if i < v.Len() {
 			// Decode into element.
 			d.value(v.Index(i))
@@ -520,6 +610,9 @@ func (d *decodeState) array(v reflect.Value) {
 			// Ran out of fixed array: skip.
 			d.value(reflect.Value{})
 		}
+		if strict {
+			d.popPath()
+		}
 		i++
 
 		// Next token must be , or ].
@@ -553,6 +646,8 @@ var nullLiteral = []byte("null")
 // object consumes an object from d.data[d.off-1:], decoding into the value v.
 // the first byte ('{') of the object has been read already.
 func (d *decodeState) object(v reflect.Value) {
+	objStart := d.off - 1
+
 	// Check for unmarshaler.
 	u, ut, pv := d.indirect(v, false)
 	if u != nil {
@@ -577,16 +672,26 @@ func (d *decodeState) object(v reflect.Value) {
 		return
 	}
 
-	// Check type of target: struct or map[string]T
+	// Check type of target:
+	//   struct or
+	//   map[T1]T2 where T1 is string, an integer type,
+	//             or an encoding.TextUnmarshaler
 	switch v.Kind() {
 	case reflect.Map:
-		// map must have string kind
+		// Map key must either have string kind, have an integer kind,
+		// or be an encoding.TextUnmarshaler.
 		t := v.Type()
-		if t.Key().Kind() != reflect.String {
-			d.saveError(&UnmarshalTypeError{"object", v.Type(), int64(d.off)})
-			d.off--
-			d.next() // skip over { } in input
-			return
+		switch t.Key().Kind() {
+		case reflect.String,
+			reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+			reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		default:
+			if !reflect.PtrTo(t.Key()).Implements(textUnmarshalerType) {
+				d.saveError(&UnmarshalTypeError{"object", v.Type(), int64(d.off)})
+				d.off--
+				d.next() // skip over { } in input
+				return
+			}
 		}
 		if v.IsNil() {
 			v.Set(reflect.MakeMap(t))
@@ -601,6 +706,26 @@ func (d *decodeState) object(v reflect.Value) {
 	}
 
 	var mapElem reflect.Value
+	var fields []field
+	catchAll := -1 // index of the field collecting unknown keys
+	if v.Kind() == reflect.Struct {
+		fields = cachedTypeFields(v.Type())
+		for i := range fields {
+			if fields[i].catchAll {
+				catchAll = i
+				break
+			}
+		}
+	}
+
+	// State for the strict decoding checks: the fields and keys
+	// seen so far in this object, by index for known struct fields.
+	strict := d.strict()
+	var seenFields []bool
+	var seenKeys map[string]bool
+	if d.disallowDuplicateFields || d.requireFields {
+		seenFields = make([]bool, len(fields))
+	}
 
 	for {
 		// Read opening " of string key or closing }.
@@ -624,6 +749,8 @@ func (d *decodeState) object(v reflect.Value) {
 
 		// Figure out field corresponding to key.
 		var subv reflect.Value
+		var extraMap reflect.Value // catch-all map to store the value in
+		isField := false
 		destring := false // whether the value is wrapped in a string to be decoded first
 
 		if v.Kind() == reflect.Map {
@@ -636,32 +763,56 @@ func (d *decodeState) object(v reflect.Value) {
 			subv = mapElem
 		} else {
 			var f *field
-			fields := cachedTypeFields(v.Type())
+			fi := -1
 			for i := range fields {
 				ff := &fields[i]
+				if ff.catchAll {
+					continue
+				}
 				if bytes.Equal(ff.nameBytes, key) {
 					f = ff
+					fi = i
 					break
 				}
-				if f == nil && ff.equalFold(ff.nameBytes, key) {
+				if f == nil && !d.exactCase && ff.equalFold(ff.nameBytes, key) {
 					f = ff
+					fi = i
+				}
+			}
+			if f == nil && catchAll < 0 && d.disallowUnknownFields {
+				d.saveError(&UnknownFieldError{string(key), d.pathString("." + string(key)), v.Type(), d.base + int64(start)})
+			}
+			if fi >= 0 && seenFields != nil {
+				if seenFields[fi] && d.disallowDuplicateFields {
+					d.saveError(&DuplicateFieldError{string(key), d.pathString("." + string(key)), d.base + int64(start)})
 				}
+				seenFields[fi] = true
 			}
 			if f != nil {
-				subv = v
+				isField = true
+				subv = allocFieldByIndex(v, f.index)
 				destring = f.quoted
-				for _, i := range f.index {
-					if subv.Kind() == reflect.Ptr {
-						if subv.IsNil() {
-							subv.Set(reflect.New(subv.Type().Elem()))
-						}
-						subv = subv.Elem()
-					}
-					subv = subv.Field(i)
+			} else if catchAll >= 0 {
+				extraMap = allocFieldByIndex(v, fields[catchAll].index)
+				if extraMap.IsNil() {
+					extraMap.Set(reflect.MakeMap(extraMap.Type()))
 				}
+				subv = reflect.New(rawMessageType).Elem()
 			}
 		}
 
+		// Keys that do not map to a struct field are checked
+		// for duplicates by name.
+		if d.disallowDuplicateFields && !isField {
+			if seenKeys == nil {
+				seenKeys = make(map[string]bool)
+			}
+			if seenKeys[string(key)] {
+				d.saveError(&DuplicateFieldError{string(key), d.pathString("." + string(key)), d.base + int64(start)})
+			}
+			seenKeys[string(key)] = true
+		}
+
 		// Read : before value.
 		if op == scanSkipSpace {
 			op = d.scanWhile(scanSkipSpace)
@@ -671,6 +822,9 @@ func (d *decodeState) object(v reflect.Value) {
 		}
 
 		// Read value.
+		if strict {
+			d.pushPath("." + string(key))
+		}
 		if destring {
 			switch qv := d.valueQuoted().(type) {
 			case nil:
@@ -683,12 +837,19 @@ func (d *decodeState) object(v reflect.Value) {
 		} else {
 			d.value(subv)
 		}
+		if strict {
+			d.popPath()
+		}
 
 		// Write value back to map;
 		// if using struct, subv points into struct already.
 		if v.Kind() == reflect.Map {
-			kv := reflect.ValueOf(key).Convert(v.Type().Key())
-			v.SetMapIndex(kv, subv)
+			if kv, ok := d.mapKey(key, v.Type().Key(), start); ok {
+				v.SetMapIndex(kv, subv)
+			}
+		} else if extraMap.IsValid() {
+			kv := reflect.ValueOf(string(key)).Convert(extraMap.Type().Key())
+			extraMap.SetMapIndex(kv, subv)
 		}
 
 		// Next token must be , or }.
@@ -700,6 +861,64 @@ func (d *decodeState) object(v reflect.Value) {
 			d.error(errPhase)
 		}
 	}
+
+	if d.requireFields {
+		for i := range fields {
+			if fields[i].required && !seenFields[i] {
+				d.saveError(&MissingFieldError{fields[i].name, d.pathString("." + fields[i].name), v.Type(), d.base + int64(objStart)})
+			}
+		}
+	}
+}
+
+// allocFieldByIndex returns the field of the struct v with the given
+// index sequence, allocating any nil embedded pointers on the way.
+func allocFieldByIndex(v reflect.Value, index []int) reflect.Value {
+	for _, i := range index {
+		if v.Kind() == reflect.Ptr {
+			if v.IsNil() {
+				v.Set(reflect.New(v.Type().Elem()))
+			}
+			v = v.Elem()
+		}
+		v = v.Field(i)
+	}
+	return v
+}
+
+// mapKey converts the object key to a map key of type kt, saving an
+// error and returning false if it is not valid for that type.
+// start is the offset of the key in d.data.
+func (d *decodeState) mapKey(key []byte, kt reflect.Type, start int) (reflect.Value, bool) {
+	switch {
+	case kt.Kind() == reflect.String:
+		return reflect.ValueOf(key).Convert(kt), true
+	case reflect.PtrTo(kt).Implements(textUnmarshalerType):
+		kv := reflect.New(kt)
+		if err := kv.Interface().(encoding.TextUnmarshaler).UnmarshalText(key); err != nil {
+			d.saveError(err)
+			return reflect.Value{}, false
+		}
+		return kv.Elem(), true
+	}
+	s := string(key)
+	switch kt.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		n, err := strconv.ParseInt(s, 10, 64)
+		if err != nil || reflect.Zero(kt).OverflowInt(n) {
+			d.saveError(&UnmarshalTypeError{"number " + s, kt, int64(start + 1)})
+			return reflect.Value{}, false
+		}
+		return reflect.ValueOf(n).Convert(kt), true
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		n, err := strconv.ParseUint(s, 10, 64)
+		if err != nil || reflect.Zero(kt).OverflowUint(n) {
+			d.saveError(&UnmarshalTypeError{"number " + s, kt, int64(start + 1)})
+			return reflect.Value{}, false
+		}
+		return reflect.ValueOf(n).Convert(kt), true
+	}
+	panic("json: unexpected map key type")
 }
 
 // literal consumes a literal from d.data[d.off-1:], decoding into the value v.
@@ -923,6 +1142,7 @@ func (d *decodeState) valueInterface() interface{} {
 // arrayInterface is like array but returns []interface{}.
 func (d *decodeState) arrayInterface() []interface{} {
 	var v = make([]interface{}, 0)
+	strict := d.strict()
 	for {
 		// Look ahead for ] - can only happen on first iteration.
 		op := d.scanWhile(scanSkipSpace)
@@ -934,7 +1154,13 @@ func (d *decodeState) arrayInterface() []interface{} {
 		d.off--
 		d.scan.undo(op)
 
+		if strict {
+			d.pushPath("[" + strconv.Itoa(len(v)) + "]")
+		}
 		v = append(v, d.valueInterface())
+		if strict {
+			d.popPath()
+		}
 
 		// Next token must be , or ].
 		op = d.scanWhile(scanSkipSpace)
@@ -951,6 +1177,7 @@ func (d *decodeState) arrayInterface() []interface{} {
 // objectInterface is like object but returns map[string]interface{}.
 func (d *decodeState) objectInterface() map[string]interface{} {
 	m := make(map[string]interface{})
+	strict := d.strict()
 	for {
 		// Read opening " of string key or closing }.
 		op := d.scanWhile(scanSkipSpace)
@@ -970,6 +1197,11 @@ func (d *decodeState) objectInterface() map[string]interface{} {
 		if !ok {
 			d.error(errPhase)
 		}
+		if d.disallowDuplicateFields {
+			if _, dup := m[key]; dup {
+				d.saveError(&DuplicateFieldError{key, d.pathString("." + key), d.base + int64(start)})
+			}
+		}
 
 		// Read : before value.
 		if op == scanSkipSpace {
@@ -980,7 +1212,13 @@ func (d *decodeState) objectInterface() map[string]interface{} {
 		}
 
 		// Read value.
+		if strict {
+			d.pushPath("." + key)
+		}
 		m[key] = d.valueInterface()
+		if strict {
+			d.popPath()
+		}
 
 		// Next token must be , or }.
 		op = d.scanWhile(scanSkipSpace)
@@ -97,6 +97,19 @@ import (
 // as described in the next paragraph.
 // An anonymous struct field with a name given in its JSON tag is treated as
 // having that name, rather than being anonymous.
+// A named field of struct or pointer to struct type whose tag specifies the
+// "inline" option is treated as if it were anonymous, so that its fields are
+// flattened into the outer object:
+//
+//    Meta ObjectMeta `json:",inline"`
+//
+// A field of type map[string]RawMessage whose tag specifies the "unknown"
+// option collects the object keys that match no other field when
+// unmarshaling. When marshaling, its entries are written after the other
+// fields, in sorted key order, except for keys that name another field:
+//
+//    Extra map[string]json.RawMessage `json:",unknown"`
+//
 // An anonymous struct field of interface type is treated the same as having
 // that type as its name, rather than being anonymous.
 //
@@ -116,9 +129,13 @@ import (
 // an anonymous struct field in both current and earlier versions, give the field
 // a JSON tag of "-".
 //
-// Map values encode as JSON objects.
-// The map's key type must be string; the map keys are used as JSON object
-// keys, subject to the UTF-8 coercion described for string values above.
+// Map values encode as JSON objects. The map's key type must either be a
+// string, an integer type, or implement encoding.TextMarshaler. The map keys
+// are sorted and used as JSON object keys by applying the following rules,
+// subject to the UTF-8 coercion described for string values above:
+//   - string keys are used directly
+//   - encoding.TextMarshalers are marshaled
+//   - integer keys are converted to strings
 //
 // Pointer values encode as the value pointed to.
 // A nil pointer encodes as the null JSON object.
@@ -135,7 +152,7 @@ import (
 // an infinite recursion.
 //
 func Marshal(v interface{}) ([]byte, error) {
-	e := &encodeState{}
+	e := &encodeState{escapeHTML: true}
 	err := e.marshal(v)
 	if err != nil {
 		return nil, err
@@ -246,6 +263,13 @@ var hex = "0123456789abcdef"
 type encodeState struct {
 	bytes.Buffer // accumulated output
 	scratch      [64]byte
+
+	// Output options, set by Marshal and the Encoder.
+	escapeHTML   bool
+	canonical    bool
+	indentPrefix string
+	indentValue  string
+	depth        int // nesting depth, for indentation
 }
 
 var encodeStatePool sync.Pool
@@ -254,6 +278,7 @@ func newEncodeState() *encodeState {
 	if v := encodeStatePool.Get(); v != nil {
 		e := v.(*encodeState)
 		e.Reset()
+		e.depth = 0
 		return e
 	}
 	return new(encodeState)
@@ -279,6 +304,59 @@ func (e *encodeState) error(err error) {
 	panic(err)
 }
 
+func (e *encodeState) indenting() bool {
+	return e.indentPrefix != "" || e.indentValue != ""
+}
+
+// newline starts a new line at the current depth when indenting.
+func (e *encodeState) newline() {
+	if !e.indenting() {
+		return
+	}
+	e.WriteByte('\n')
+	e.WriteString(e.indentPrefix)
+	for i := 0; i < e.depth; i++ {
+		e.WriteString(e.indentValue)
+	}
+}
+
+// colon writes the separator between an object key and its value.
+func (e *encodeState) colon() {
+	e.WriteByte(':')
+	if e.indenting() {
+		e.WriteByte(' ')
+	}
+}
+
+// writeJSON copies b, the output of a Marshaler, into the buffer,
+// checking its validity and formatting it according to e's options.
+func (e *encodeState) writeJSON(b []byte) error {
+	if e.canonical {
+		// Re-encode the value so that its keys, numbers and strings
+		// are canonical too.
+		var d decodeState
+		if err := checkValid(b, &d.scan); err != nil {
+			return err
+		}
+		d.init(b)
+		d.useNumber = true
+		var v interface{}
+		if err := d.unmarshal(&v); err != nil {
+			return err
+		}
+		e.reflectValue(reflect.ValueOf(v))
+		return nil
+	}
+	if !e.indenting() {
+		return compact(&e.Buffer, b, e.escapeHTML)
+	}
+	var buf bytes.Buffer
+	if err := compact(&buf, b, e.escapeHTML); err != nil {
+		return err
+	}
+	return Indent(&e.Buffer, buf.Bytes(), e.indentPrefix+strings.Repeat(e.indentValue, e.depth), e.indentValue)
+}
+
 func isEmptyValue(v reflect.Value) bool {
 	switch v.Kind() {
 	case reflect.Array, reflect.Map, reflect.Slice, reflect.String:
@@ -418,7 +496,7 @@ func marshalerEncoder(e *encodeState, v reflect.Value, quoted bool) {
 	b, err := m.MarshalJSON()
 	if err == nil {
 		// copy JSON into buffer, checking validity.
-		err = compact(&e.Buffer, b, true)
+		err = e.writeJSON(b)
 	}
 	if err != nil {
 		e.error(&MarshalerError{v.Type(), err})
@@ -435,7 +513,7 @@ func addrMarshalerEncoder(e *encodeState, v reflect.Value, quoted bool) {
 	b, err := m.MarshalJSON()
 	if err == nil {
 		// copy JSON into buffer, checking validity.
-		err = compact(&e.Buffer, b, true)
+		err = e.writeJSON(b)
 	}
 	if err != nil {
 		e.error(&MarshalerError{v.Type(), err})
@@ -483,7 +561,14 @@ func boolEncoder(e *encodeState, v reflect.Value, quoted bool) {
 	}
 }
 
+// maxExactInt is the largest magnitude of an integer that can be
+// represented exactly by a float64, which is all canonical JSON allows.
+const maxExactInt = 1 << 53
+
 func intEncoder(e *encodeState, v reflect.Value, quoted bool) {
+	if e.canonical && (v.Int() > maxExactInt || v.Int() < -maxExactInt) {
+		e.error(&UnsupportedValueError{v, strconv.FormatInt(v.Int(), 10)})
+	}
 	b := strconv.AppendInt(e.scratch[:0], v.Int(), 10)
 	if quoted {
 		e.WriteByte('"')
@@ -495,6 +580,9 @@ func intEncoder(e *encodeState, v reflect.Value, quoted bool) {
 }
 
 func uintEncoder(e *encodeState, v reflect.Value, quoted bool) {
+	if e.canonical && v.Uint() > maxExactInt {
+		e.error(&UnsupportedValueError{v, strconv.FormatUint(v.Uint(), 10)})
+	}
 	b := strconv.AppendUint(e.scratch[:0], v.Uint(), 10)
 	if quoted {
 		e.WriteByte('"')
@@ -512,7 +600,12 @@ func (bits floatEncoder) encode(e *encodeState, v reflect.Value, quoted bool) {
 	if math.IsInf(f, 0) || math.IsNaN(f) {
 		e.error(&UnsupportedValueError{v, strconv.FormatFloat(f, 'g', -1, int(bits))})
 	}
-	b := strconv.AppendFloat(e.scratch[:0], f, 'g', -1, int(bits))
+	var b []byte
+	if e.canonical {
+		b = appendCanonicalFloat(e.scratch[:0], f)
+	} else {
+		b = strconv.AppendFloat(e.scratch[:0], f, 'g', -1, int(bits))
+	}
 	if quoted {
 		e.WriteByte('"')
 	}
@@ -527,6 +620,30 @@ var (
 	float64Encoder = (floatEncoder(64)).encode
 )
 
+// appendCanonicalFloat appends f formatted as by ECMAScript's
+// Number.prototype.toString, as required by RFC 8785.
+func appendCanonicalFloat(b []byte, f float64) []byte {
+	if f == 0 {
+		// Also covers negative zero.
+		return append(b, '0')
+	}
+	abs := math.Abs(f)
+	fmt := byte('f')
+	if abs < 1e-6 || abs >= 1e21 {
+		fmt = 'e'
+	}
+	b = strconv.AppendFloat(b, f, fmt, -1, 64)
+	if fmt == 'e' {
+		// Clean up e-09 to e-9.
+		n := len(b)
+		if n >= 4 && b[n-4] == 'e' && b[n-3] == '-' && b[n-2] == '0' {
+			b[n-2] = b[n-1]
+			b = b[:n-1]
+		}
+	}
+	return b
+}
+
 func stringEncoder(e *encodeState, v reflect.Value, quoted bool) {
 	if v.Type() == numberType {
 		numStr := v.String()
@@ -538,15 +655,21 @@ func stringEncoder(e *encodeState, v reflect.Value, quoted bool) {
 		if !isValidNumber(numStr) {
 			e.error(fmt.Errorf("json: invalid number literal %q", numStr))
 		}
+		if e.canonical {
+			f, err := strconv.ParseFloat(numStr, 64)
+			if err != nil {
+				e.error(&UnsupportedValueError{v, numStr})
+			}
+			e.Write(appendCanonicalFloat(e.scratch[:0], f))
+			return
+		}
 		e.WriteString(numStr)
 		return
 	}
 	if quoted {
-		sb, err := Marshal(v.String())
-		if err != nil {
-			e.error(err)
-		}
-		e.string(string(sb))
+		sb := &encodeState{escapeHTML: e.escapeHTML, canonical: e.canonical}
+		sb.string(v.String())
+		e.string(sb.String())
 	} else {
 		e.string(v.String())
 	}
@@ -567,37 +690,122 @@ func unsupportedTypeEncoder(e *encodeState, v reflect.Value, quoted bool) {
 type structEncoder struct {
 	fields    []field
 	fieldEncs []encoderFunc
+	sorted    []int // field indexes in canonical key order
+	catchAll  int   // index of the field collecting unknown keys, or -1
 }
 
 func (se *structEncoder) encode(e *encodeState, v reflect.Value, quoted bool) {
 	e.WriteByte('{')
+	e.depth++
 	first := true
-	for i, f := range se.fields {
-		fv := fieldByIndex(v, f.index)
-		if !fv.IsValid() || f.omitEmpty && isEmptyValue(fv) {
-			continue
-		}
+	sep := func() {
 		if first {
 			first = false
 		} else {
 			e.WriteByte(',')
 		}
+		e.newline()
+	}
+
+	// Entries of the catch-all map are merged with the fields
+	// in canonical mode, and otherwise follow them.
+	var extra keyedValues
+	var extraMap reflect.Value
+	if se.catchAll >= 0 {
+		extraMap = fieldByIndex(v, se.fields[se.catchAll].index)
+		extra = se.extraKeys(e, extraMap)
+	}
+	writeExtra := func(kv keyedValue) {
+		sep()
+		e.string(kv.s)
+		e.colon()
+		raw := extraMap.MapIndex(kv.v).Bytes()
+		if len(raw) == 0 {
+			e.WriteString("null")
+		} else if err := e.writeJSON(raw); err != nil {
+			e.error(&MarshalerError{extraMap.Type().Elem(), err})
+		}
+	}
+
+	for j := range se.fields {
+		i := j
+		if e.canonical {
+			i = se.sorted[j]
+		}
+		if i == se.catchAll {
+			continue
+		}
+		f := &se.fields[i]
+		fv := fieldByIndex(v, f.index)
+		if !fv.IsValid() || f.omitEmpty && isEmptyValue(fv) {
+			continue
+		}
+		for e.canonical && len(extra) > 0 && lessUTF16(extra[0].s, f.name) {
+			writeExtra(extra[0])
+			extra = extra[1:]
+		}
+		sep()
 		e.string(f.name)
-		e.WriteByte(':')
+		e.colon()
 		se.fieldEncs[i](e, fv, f.quoted)
 	}
+	for _, kv := range extra {
+		writeExtra(kv)
+	}
+	e.depth--
+	if !first {
+		e.newline()
+	}
 	e.WriteByte('}')
 }
 
+// extraKeys returns the sorted keys of the catch-all map m,
+// leaving out those that name one of the struct's fields.
+func (se *structEncoder) extraKeys(e *encodeState, m reflect.Value) keyedValues {
+	if !m.IsValid() || m.Len() == 0 {
+		return nil
+	}
+	var sv keyedValues
+	for _, k := range m.MapKeys() {
+		if se.hasField(k.String()) {
+			continue
+		}
+		sv = append(sv, keyedValue{k, k.String()})
+	}
+	if e.canonical {
+		sort.Sort(keyedValuesUTF16{sv})
+	} else {
+		sort.Sort(sv)
+	}
+	return sv
+}
+
+// hasField reports whether name is the key of one of the struct's fields.
+func (se *structEncoder) hasField(name string) bool {
+	for i := range se.fields {
+		if i != se.catchAll && se.fields[i].name == name {
+			return true
+		}
+	}
+	return false
+}
+
 func newStructEncoder(t reflect.Type) encoderFunc {
 	fields := cachedTypeFields(t)
 	se := &structEncoder{
 		fields:    fields,
 		fieldEncs: make([]encoderFunc, len(fields)),
+		sorted:    make([]int, len(fields)),
+		catchAll:  -1,
 	}
 	for i, f := range fields {
 		se.fieldEncs[i] = typeEncoder(typeByIndex(t, f.index))
+		se.sorted[i] = i
+		if f.catchAll && se.catchAll < 0 {
+			se.catchAll = i
+		}
 	}
+	sort.Sort(&fieldsByUTF16{fields, se.sorted})
 	return se.encode
 }
 
@@ -611,22 +819,45 @@ func (me *mapEncoder) encode(e *encodeState, v reflect.Value, _ bool) {
 		return
 	}
 	e.WriteByte('{')
-	var sv stringValues = v.MapKeys()
-	sort.Sort(sv)
-	for i, k := range sv {
+	e.depth++
+	keys := v.MapKeys()
+	sv := make(keyedValues, len(keys))
+	for i, k := range keys {
+		sv[i].v = k
+		if err := sv[i].resolve(); err != nil {
+			e.error(&MarshalerError{k.Type(), err})
+		}
+	}
+	if e.canonical {
+		sort.Sort(keyedValuesUTF16{sv})
+	} else {
+		sort.Sort(sv)
+	}
+	for i, kv := range sv {
 		if i > 0 {
 			e.WriteByte(',')
 		}
-		e.string(k.String())
-		e.WriteByte(':')
-		me.elemEnc(e, v.MapIndex(k), false)
+		e.newline()
+		e.string(kv.s)
+		e.colon()
+		me.elemEnc(e, v.MapIndex(kv.v), false)
+	}
+	e.depth--
+	if len(sv) > 0 {
+		e.newline()
 	}
 	e.WriteByte('}')
 }
 
 func newMapEncoder(t reflect.Type) encoderFunc {
-	if t.Key().Kind() != reflect.String {
-		return unsupportedTypeEncoder
+	switch t.Key().Kind() {
+	case reflect.String,
+		reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+	default:
+		if !t.Key().Implements(textMarshalerType) {
+			return unsupportedTypeEncoder
+		}
 	}
 	me := &mapEncoder{typeEncoder(t.Elem())}
 	return me.encode
@@ -682,13 +913,19 @@ type arrayEncoder struct {
 
 func (ae *arrayEncoder) encode(e *encodeState, v reflect.Value, _ bool) {
 	e.WriteByte('[')
+	e.depth++
 	n := v.Len()
 	for i := 0; i < n; i++ {
 		if i > 0 {
 			e.WriteByte(',')
 		}
+		e.newline()
 		ae.elemEnc(e, v.Index(i), false)
 	}
+	e.depth--
+	if n > 0 {
+		e.newline()
+	}
 	e.WriteByte(']')
 }
 
@@ -775,14 +1012,86 @@ func typeByIndex(t reflect.Type, index []int) reflect.Type {
 	return t
 }
 
-// stringValues is a slice of reflect.Value holding *reflect.StringValue.
-// It implements the methods to sort by string.
-type stringValues []reflect.Value
+// A keyedValue is a map key together with the object key it encodes as.
+type keyedValue struct {
+	v reflect.Value
+	s string
+}
 
-func (sv stringValues) Len() int           { return len(sv) }
-func (sv stringValues) Swap(i, j int)      { sv[i], sv[j] = sv[j], sv[i] }
-func (sv stringValues) Less(i, j int) bool { return sv.get(i) < sv.get(j) }
-func (sv stringValues) get(i int) string   { return sv[i].String() }
+// resolve sets kv.s from the map key kv.v.
+func (kv *keyedValue) resolve() error {
+	if kv.v.Kind() == reflect.String {
+		kv.s = kv.v.String()
+		return nil
+	}
+	if tm, ok := kv.v.Interface().(encoding.TextMarshaler); ok {
+		if kv.v.Kind() == reflect.Ptr && kv.v.IsNil() {
+			return nil
+		}
+		b, err := tm.MarshalText()
+		kv.s = string(b)
+		return err
+	}
+	switch kv.v.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		kv.s = strconv.FormatInt(kv.v.Int(), 10)
+		return nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		kv.s = strconv.FormatUint(kv.v.Uint(), 10)
+		return nil
+	}
+	panic("unexpected map key type")
+}
+
+// keyedValues implements the methods to sort map keys by their encoding.
+type keyedValues []keyedValue
+
+func (sv keyedValues) Len() int           { return len(sv) }
+func (sv keyedValues) Swap(i, j int)      { sv[i], sv[j] = sv[j], sv[i] }
+func (sv keyedValues) Less(i, j int) bool { return sv[i].s < sv[j].s }
+
+// keyedValuesUTF16 sorts map keys by the UTF-16 code units of their
+// encoding, the key order of canonical JSON.
+type keyedValuesUTF16 struct{ keyedValues }
+
+func (sv keyedValuesUTF16) Less(i, j int) bool {
+	return lessUTF16(sv.keyedValues[i].s, sv.keyedValues[j].s)
+}
+
+// fieldsByUTF16 sorts indexes into fields by the fields' names,
+// using the same order as utf16Values.
+type fieldsByUTF16 struct {
+	fields []field
+	index  []int
+}
+
+func (x *fieldsByUTF16) Len() int      { return len(x.index) }
+func (x *fieldsByUTF16) Swap(i, j int) { x.index[i], x.index[j] = x.index[j], x.index[i] }
+func (x *fieldsByUTF16) Less(i, j int) bool {
+	return lessUTF16(x.fields[x.index[i]].name, x.fields[x.index[j]].name)
+}
+
+// lessUTF16 reports whether a sorts before b when both are
+// compared as sequences of UTF-16 code units.
+func lessUTF16(a, b string) bool {
+	for a != "" && b != "" {
+		ra, na := utf8.DecodeRuneInString(a)
+		rb, nb := utf8.DecodeRuneInString(b)
+		if ra != rb {
+			// Runes outside the Basic Multilingual Plane start with
+			// a high surrogate, which sorts after U+D7FF and before
+			// U+E000; no other rune in that range can occur.
+			if ra >= 0x10000 && rb < 0x10000 {
+				ra = 0xD800
+			} else if rb >= 0x10000 && ra < 0x10000 {
+				rb = 0xD800
+			}
+			return ra < rb
+		}
+		a, b = a[na:], b[nb:]
+	}
+	return a == "" && b != ""
+}
 
 // NOTE: keep in sync with stringBytes below.
 func (e *encodeState) string(s string) int {
@@ -791,7 +1100,7 @@ func (e *encodeState) string(s string) int {
 	start := 0
 	for i := 0; i < len(s); {
 		if b := s[i]; b < utf8.RuneSelf {
-			if 0x20 <= b && b != '\\' && b != '"' && b != '<' && b != '>' && b != '&' {
+			if 0x20 <= b && b != '\\' && b != '"' && (!e.escapeHTML || b != '<' && b != '>' && b != '&') {
 				i++
 				continue
 			}
@@ -811,11 +1120,23 @@ func (e *encodeState) string(s string) int {
 			case '\t':
 				e.WriteByte('\\')
 				e.WriteByte('t')
+			case '\b', '\f':
+				if e.canonical {
+					e.WriteByte('\\')
+					if b == '\b' {
+						e.WriteByte('b')
+					} else {
+						e.WriteByte('f')
+					}
+					break
+				}
+				fallthrough
 			default:
 				// This encodes bytes < 0x20 except for \n and \r,
-				// as well as <, > and &. The latter are escaped because they
-				// can lead to security holes when user-controlled strings
-				// are rendered into JSON and served to some browsers.
+				// as well as <, > and & when escaping HTML. The latter are
+				// escaped because they can lead to security holes when
+				// user-controlled strings are rendered into JSON and served
+				// to some browsers.
 				e.WriteString(`\u00`)
 				e.WriteByte(hex[b>>4])
 				e.WriteByte(hex[b&0xF])
@@ -829,7 +1150,11 @@ func (e *encodeState) string(s string) int {
 			if start < i {
 				e.WriteString(s[start:i])
 			}
-			e.WriteString(`\ufffd`)
+			if e.canonical {
+				e.WriteString("\ufffd")
+			} else {
+				e.WriteString(`\ufffd`)
+			}
 			i += size
 			start = i
 			continue
@@ -839,9 +1164,10 @@ func (e *encodeState) string(s string) int {
 		// They are both technically valid characters in JSON strings,
 		// but don't work in JSONP, which has to be evaluated as JavaScript,
 		// and can lead to security holes there. It is valid JSON to
-		// escape them, so we do so unconditionally.
+		// escape them, so we do so except in canonical mode, which
+		// allows only one encoding of each string.
 		// See http://timelessrepo.com/json-isnt-a-javascript-subset for discussion.
-		if c == '\u2028' || c == '\u2029' {
+		if (c == '\u2028' || c == '\u2029') && !e.canonical {
 			if start < i {
 				e.WriteString(s[start:i])
 			}
@@ -867,7 +1193,7 @@ func (e *encodeState) stringBytes(s []byte) int {
 	start := 0
 	for i := 0; i < len(s); {
 		if b := s[i]; b < utf8.RuneSelf {
-			if 0x20 <= b && b != '\\' && b != '"' && b != '<' && b != '>' && b != '&' {
+			if 0x20 <= b && b != '\\' && b != '"' && (!e.escapeHTML || b != '<' && b != '>' && b != '&') {
 				i++
 				continue
 			}
@@ -887,11 +1213,23 @@ func (e *encodeState) stringBytes(s []byte) int {
 			case '\t':
 				e.WriteByte('\\')
 				e.WriteByte('t')
+			case '\b', '\f':
+				if e.canonical {
+					e.WriteByte('\\')
+					if b == '\b' {
+						e.WriteByte('b')
+					} else {
+						e.WriteByte('f')
+					}
+					break
+				}
+				fallthrough
 			default:
 				// This encodes bytes < 0x20 except for \n and \r,
-				// as well as <, >, and &. The latter are escaped because they
-				// can lead to security holes when user-controlled strings
-				// are rendered into JSON and served to some browsers.
+				// as well as <, > and & when escaping HTML. The latter are
+				// escaped because they can lead to security holes when
+				// user-controlled strings are rendered into JSON and served
+				// to some browsers.
 				e.WriteString(`\u00`)
 				e.WriteByte(hex[b>>4])
 				e.WriteByte(hex[b&0xF])
@@ -905,7 +1243,11 @@ func (e *encodeState) stringBytes(s []byte) int {
 			if start < i {
 				e.Write(s[start:i])
 			}
-			e.WriteString(`\ufffd`)
+			if e.canonical {
+				e.WriteString("\ufffd")
+			} else {
+				e.WriteString(`\ufffd`)
+			}
 			i += size
 			start = i
 			continue
@@ -915,9 +1257,10 @@ func (e *encodeState) stringBytes(s []byte) int {
 		// They are both technically valid characters in JSON strings,
 		// but don't work in JSONP, which has to be evaluated as JavaScript,
 		// and can lead to security holes there. It is valid JSON to
-		// escape them, so we do so unconditionally.
+		// escape them, so we do so except in canonical mode, which
+		// allows only one encoding of each string.
 		// See http://timelessrepo.com/json-isnt-a-javascript-subset for discussion.
-		if c == '\u2028' || c == '\u2029' {
+		if (c == '\u2028' || c == '\u2029') && !e.canonical {
 			if start < i {
 				e.Write(s[start:i])
 			}
@@ -936,6 +1279,13 @@ func (e *encodeState) stringBytes(s []byte) int {
 	return e.Len() - len0
 }
 
+var rawMessageType = reflect.TypeOf(RawMessage(nil))
+
+// isRawMessageMap reports whether t can hold the unknown keys of an object.
+func isRawMessageMap(t reflect.Type) bool {
+	return t.Kind() == reflect.Map && t.Key().Kind() == reflect.String && t.Elem() == rawMessageType
+}
+
 // A field represents a single field found in a struct.
 type field struct {
 	name      string
@@ -947,6 +1297,8 @@ type field struct {
 	typ       reflect.Type
 	omitEmpty bool
 	quoted    bool
+	required  bool
+	catchAll  bool // receives unknown keys; see the "unknown" option
 }
 
 func fillField(f field) field {
@@ -1061,8 +1413,11 @@ func typeFields(t reflect.Type) []field {
 					}
 				}
 
+				// A named struct field can be flattened like an anonymous one.
+				inline := opts.Contains("inline") && ft.Kind() == reflect.Struct
+
 				// Record found field and index sequence.
-				if name != "" || !sf.Anonymous || ft.Kind() != reflect.Struct {
+				if !inline && (name != "" || !sf.Anonymous || ft.Kind() != reflect.Struct) {
 					tagged := name != ""
 					if name == "" {
 						name = sf.Name
@@ -1074,6 +1429,8 @@ func typeFields(t reflect.Type) []field {
 						typ:       ft,
 						omitEmpty: opts.Contains("omitempty"),
 						quoted:    quoted,
+						required:  opts.Contains("required"),
+						catchAll:  opts.Contains("unknown") && isRawMessageMap(sf.Type),
 					}))
 					if count[f.typ] > 1 {
 						// If there were multiple instances, add a second,
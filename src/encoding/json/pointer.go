@@ -0,0 +1,201 @@
+// Copyright 2016 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package json
+
+import (
+	"strconv"
+	"strings"
+)
+
+// A PointerError describes a JSON Pointer that is malformed or that
+// does not identify a value in the input.
+type PointerError struct {
+	Pointer string // the pointer as given
+	msg     string // description of error
+}
+
+func (e *PointerError) Error() string {
+	return "json: pointer " + strconv.Quote(e.Pointer) + ": " + e.msg
+}
+
+// parsePointer splits a JSON Pointer, as defined in RFC 6901, into its
+// unescaped reference tokens.
+func parsePointer(ptr string) ([]string, error) {
+	if ptr == "" {
+		return nil, nil
+	}
+	if ptr[0] != '/' {
+		return nil, &PointerError{ptr, "does not begin with /"}
+	}
+	toks := strings.Split(ptr[1:], "/")
+	for i, tok := range toks {
+		if strings.IndexByte(tok, '~') < 0 {
+			continue
+		}
+		// ~1 must be unescaped before ~0, so that "~01" becomes "~1".
+		for j := 0; j < len(tok); j++ {
+			if tok[j] == '~' && (j+1 == len(tok) || tok[j+1] != '0' && tok[j+1] != '1') {
+				return nil, &PointerError{ptr, "invalid escape in " + strconv.Quote(tok)}
+			}
+		}
+		tok = strings.Replace(tok, "~1", "/", -1)
+		toks[i] = strings.Replace(tok, "~0", "~", -1)
+	}
+	return toks, nil
+}
+
+// arrayIndex parses a reference token as an array index.
+// It returns -1 if tok is not a valid index.
+func arrayIndex(tok string) int {
+	if tok == "" || len(tok) > 1 && tok[0] == '0' {
+		return -1
+	}
+	n, err := strconv.Atoi(tok)
+	if err != nil || n < 0 {
+		return -1
+	}
+	return n
+}
+
+// Skip reads and discards the next JSON value in the input stream,
+// which may be an element of the array or the value of the object
+// member being parsed. If the decoder is positioned at an object key,
+// Skip discards both the key and its value. Skip uses the Token API, so
+// arbitrarily large values are skipped without being held in memory.
+func (dec *Decoder) Skip() error {
+	if !dec.More() {
+		if _, err := dec.peek(); err != nil {
+			return err
+		}
+		return &SyntaxError{msg: "not at beginning of value"}
+	}
+	depth := 0
+	for {
+		t, err := dec.Token()
+		if err != nil {
+			return err
+		}
+		switch t {
+		case Delim('['), Delim('{'):
+			depth++
+		case Delim(']'), Delim('}'):
+			depth--
+		default:
+			if _, ok := t.(string); ok && dec.tokenState == tokenObjectColon {
+				// An object key; its value follows.
+				continue
+			}
+		}
+		if depth == 0 {
+			return nil
+		}
+	}
+}
+
+// SeekPointer advances the decoder to the value identified by the JSON
+// Pointer ptr, as defined in RFC 6901, so that the next call to Decode
+// or Token reads that value. The pointer is evaluated relative to the
+// next value in the input stream; the empty pointer refers to that value
+// itself. Values that precede the target are skipped as by Skip.
+//
+// After a successful call, the decoder is positioned inside the arrays
+// and objects enclosing the target. Subsequent calls to Token return the
+// remainder of those containers. If the pointer does not identify a value,
+// SeekPointer returns a *PointerError and the position of the decoder is
+// unspecified.
+func (dec *Decoder) SeekPointer(ptr string) error {
+	toks, err := parsePointer(ptr)
+	if err != nil {
+		return err
+	}
+	for _, tok := range toks {
+		t, err := dec.Token()
+		if err != nil {
+			return err
+		}
+		switch t {
+		case Delim('{'):
+			found := false
+			for dec.More() {
+				k, err := dec.Token()
+				if err != nil {
+					return err
+				}
+				if k == tok {
+					found = true
+					break
+				}
+				if err := dec.Skip(); err != nil {
+					return err
+				}
+			}
+			if !found {
+				return &PointerError{ptr, "no member " + strconv.Quote(tok)}
+			}
+		case Delim('['):
+			idx := arrayIndex(tok)
+			if idx < 0 && tok != "-" {
+				return &PointerError{ptr, "invalid array index " + strconv.Quote(tok)}
+			}
+			found := false
+			for i := 0; dec.More(); i++ {
+				if i == idx {
+					found = true
+					break
+				}
+				if err := dec.Skip(); err != nil {
+					return err
+				}
+			}
+			if !found {
+				return &PointerError{ptr, "array index " + tok + " out of range"}
+			}
+		default:
+			return &PointerError{ptr, "cannot index into non-container with " + strconv.Quote(tok)}
+		}
+	}
+	return nil
+}
+
+// Elements advances the decoder to the array identified by the JSON Pointer
+// ptr, as SeekPointer does, and calls fn once for each element of the array,
+// in order, with the decoder positioned at the start of that element. fn
+// typically calls Decode to read the element; if it returns without reading
+// anything, the element is skipped. fn must read either the whole element or
+// none of it.
+//
+// Only one element is held in memory at a time, so Elements can be used to
+// process arrays much larger than the available memory. Iteration stops at
+// the first error returned by fn, which Elements returns. After a successful
+// call, the decoder is positioned just past the end of the array.
+func (dec *Decoder) Elements(ptr string, fn func(index int) error) error {
+	if err := dec.SeekPointer(ptr); err != nil {
+		return err
+	}
+	t, err := dec.Token()
+	if err != nil {
+		return err
+	}
+	if t != Delim('[') {
+		return &PointerError{ptr, "not an array"}
+	}
+	for i := 0; dec.More(); i++ {
+		if err := dec.tokenPrepareForDecode(); err != nil {
+			return err
+		}
+		depth := len(dec.tokenStack)
+		if err := fn(i); err != nil {
+			return err
+		}
+		if dec.tokenState != tokenArrayComma && len(dec.tokenStack) == depth {
+			// fn did not read the element.
+			if err := dec.Skip(); err != nil {
+				return err
+			}
+		}
+	}
+	_, err = dec.Token()
+	return err
+}
@@ -19,6 +19,8 @@ type Decoder struct { // 从输入流中读入并解码数据
 	scan  scanner
 	err   error
 
+	scanned int64 // amount of data already scanned, before buf
+
 	tokenState int
 	tokenStack []int
 }
@@ -35,6 +37,31 @@ func NewDecoder(r io.Reader) *Decoder { // 新创建一个解码器，从r中解
 // Number instead of as a float64.
 func (dec *Decoder) UseNumber() { dec.d.useNumber = true }
 
+// DisallowUnknownFields causes the Decoder to return an UnknownFieldError
+// when the destination is a struct and the input contains object keys
+// which do not match any non-ignored, exported fields in the destination.
+func (dec *Decoder) DisallowUnknownFields() { dec.d.disallowUnknownFields = true }
+
+// DisallowDuplicateFields causes the Decoder to return a DuplicateFieldError
+// when an object in the input contains the same key more than once.
+// For struct destinations, keys that match the same field case-insensitively
+// are also considered duplicates.
+func (dec *Decoder) DisallowDuplicateFields() { dec.d.disallowDuplicateFields = true }
+
+// RequireExactCase causes the Decoder to match object keys to struct
+// fields only if they are equal, rather than preferring an exact match
+// but also accepting a case-insensitive one.
+func (dec *Decoder) RequireExactCase() { dec.d.exactCase = true }
+
+// RequireFields causes the Decoder to return a MissingFieldError when
+// an object decoded into a struct lacks a key for a field whose tag
+// carries the "required" option, as in
+//
+//	Name string `json:"name,required"`
+//
+// A key that is present with a null value satisfies the requirement.
+func (dec *Decoder) RequireFields() { dec.d.requireFields = true }
+
 // Decode reads the next JSON-encoded value from its
 // input and stores it in the value pointed to by v.
 //
@@ -59,6 +86,7 @@ func (dec *Decoder) Decode(v interface{}) error {
 		return err
 	}
 	dec.d.init(dec.buf[dec.scanp : dec.scanp+n])
+	dec.d.base = dec.scanned + int64(dec.scanp)
 	dec.scanp += n
 
 	// Don't save err from unmarshal into dec.err:
@@ -135,6 +163,7 @@ func (dec *Decoder) refill() error {
 	// Make room to read more into the buffer.
 	// First slide down data already consumed.
 	if dec.scanp > 0 {
+		dec.scanned += int64(dec.scanp)
 		n := copy(dec.buf, dec.buf[dec.scanp:])
 		dec.buf = dec.buf[:n]
 		dec.scanp = 0
@@ -168,13 +197,49 @@ func nonSpace(b []byte) bool {
 type Encoder struct {
 	w   io.Writer
 	err error
+
+	indentPrefix string
+	indentValue  string
+	escapeHTML   bool
+	canonical    bool
 }
 
 // NewEncoder returns a new encoder that writes to w.
 func NewEncoder(w io.Writer) *Encoder { // 创建一个新的Encoder
-	return &Encoder{w: w}
+	return &Encoder{w: w, escapeHTML: true}
+}
+
+// SetIndent instructs the encoder to format each subsequent encoded
+// value as if indented by the package-level function Indent(dst, src, prefix, indent).
+// Calling SetIndent("", "") disables indentation.
+func (enc *Encoder) SetIndent(prefix, indent string) {
+	enc.indentPrefix = prefix
+	enc.indentValue = indent
 }
 
+// SetEscapeHTML specifies whether problematic HTML characters should
+// be escaped inside JSON quoted strings. The default behavior is to
+// escape &, <, and > to \u0026, \u003c, and \u003e to avoid certain
+// safety problems that can arise when embedding JSON in HTML.
+//
+// In non-HTML settings where the escaping interferes with the readability
+// of the output, SetEscapeHTML(false) disables this behavior.
+func (enc *Encoder) SetEscapeHTML(on bool) { enc.escapeHTML = on }
+
+// SetCanonical specifies whether values should be encoded in the canonical
+// form defined by RFC 8785, the JSON Canonicalization Scheme, which gives
+// each value a single serialization suitable for hashing and signing.
+//
+// In canonical mode object keys, including struct field names, are sorted
+// by their UTF-16 code units; floating-point numbers use the shortest
+// ECMAScript representation; strings escape only the characters JSON
+// requires; and no insignificant whitespace is written. The output of
+// Marshaler implementations is re-encoded in the same form. Integers
+// outside ±2^53 cannot be represented exactly and are rejected with an
+// UnsupportedValueError. Canonical mode overrides SetIndent and
+// SetEscapeHTML.
+func (enc *Encoder) SetCanonical(on bool) { enc.canonical = on }
+
 // Encode writes the JSON encoding of v to the stream,
 // followed by a newline character.
 //
@@ -185,6 +250,14 @@ func (enc *Encoder) Encode(v interface{}) error { // 针对v进行编码
 		return enc.err
 	}
 	e := newEncodeState()
+	e.canonical = enc.canonical
+	if enc.canonical {
+		e.escapeHTML = false
+		e.indentPrefix, e.indentValue = "", ""
+	} else {
+		e.escapeHTML = enc.escapeHTML
+		e.indentPrefix, e.indentValue = enc.indentPrefix, enc.indentValue
+	}
 	err := e.marshal(v)
 	if err != nil {
 		return err
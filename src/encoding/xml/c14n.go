@@ -0,0 +1,399 @@
+// Copyright 2016 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package xml
+
+// This file implements Exclusive XML Canonicalization Version 1.0,
+// https://www.w3.org/TR/xml-exc-c14n/, which is used to compute XML
+// signatures over a document or one of its subtrees.
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"unicode/utf8"
+)
+
+// A Canonicalizer writes XML tokens to an output stream in the form
+// defined by Exclusive XML Canonicalization: namespace declarations
+// appear only on the elements that use them, attributes are sorted,
+// empty elements are written with start and end tags, and character
+// data is escaped in a single fixed way.
+//
+// The tokens must be those returned by Decoder.RawToken, in which the
+// Space field of a Name holds the name space prefix and namespace
+// declarations appear as ordinary xmlns attributes. Tokens returned by
+// Decoder.Token have lost their prefixes and cannot be canonicalized.
+type Canonicalizer struct {
+	w         *bufio.Writer
+	out       errWriter
+	comments  bool
+	inclusive []string // inclusive namespace prefixes; "" is the default
+	context   map[string]string
+	stack     []c14nScope
+	rootDone  bool
+}
+
+// A c14nScope holds the namespace state of an open element.
+type c14nScope struct {
+	name     Name
+	decls    map[string]string // declared on the element, prefix -> name space
+	rendered map[string]string // written on the element, prefix -> name space
+}
+
+// NewCanonicalizer returns a new Canonicalizer that writes to w.
+// Comments are omitted unless SetComments is called.
+func NewCanonicalizer(w io.Writer) *Canonicalizer {
+	c := &Canonicalizer{out: errWriter{w: w}}
+	c.w = bufio.NewWriter(&c.out)
+	return c
+}
+
+// An errWriter writes to w until a write fails, recording the error.
+type errWriter struct {
+	w   io.Writer
+	err error
+}
+
+func (e *errWriter) Write(p []byte) (int, error) {
+	if e.err != nil {
+		return 0, e.err
+	}
+	n, err := e.w.Write(p)
+	e.err = err
+	return n, err
+}
+
+// SetComments sets whether comments are included in the output,
+// selecting the "with comments" variant of the canonicalization.
+func (c *Canonicalizer) SetComments(on bool) {
+	c.comments = on
+}
+
+// SetInclusivePrefixes sets the InclusiveNamespaces PrefixList: the given
+// prefixes are treated as in inclusive canonicalization, and declared on
+// every output element where they are in scope but not yet declared.
+// The prefix "#default", or "", denotes the default name space.
+func (c *Canonicalizer) SetInclusivePrefixes(prefixes ...string) {
+	c.inclusive = c.inclusive[:0]
+	for _, prefix := range prefixes {
+		if prefix == "#default" {
+			prefix = ""
+		}
+		c.inclusive = append(c.inclusive, prefix)
+	}
+}
+
+// AddNamespace binds prefix to the name space url for all the tokens that
+// follow, as if it had been declared on an element enclosing them. It is
+// used when canonicalizing a subtree of a document, to supply the
+// declarations made by ancestors of the subtree's apex element. As with
+// other declarations, the binding is only written where it is used.
+func (c *Canonicalizer) AddNamespace(prefix, url string) {
+	if c.context == nil {
+		c.context = make(map[string]string)
+	}
+	c.context[prefix] = url
+}
+
+// lookup returns the name space bound to prefix.
+func (c *Canonicalizer) lookup(prefix string) (string, bool) {
+	if prefix == "xml" {
+		return xmlURL, true
+	}
+	for i := len(c.stack) - 1; i >= 0; i-- {
+		if url, ok := c.stack[i].decls[prefix]; ok {
+			return url, true
+		}
+	}
+	url, ok := c.context[prefix]
+	return url, ok
+}
+
+// lastRendered returns the name space most recently written for prefix
+// by an enclosing element, excluding the innermost one.
+func (c *Canonicalizer) lastRendered(prefix string) (string, bool) {
+	for i := len(c.stack) - 2; i >= 0; i-- {
+		if url, ok := c.stack[i].rendered[prefix]; ok {
+			return url, true
+		}
+	}
+	return "", false
+}
+
+// WriteToken writes the canonical form of t, which must have been returned
+// by Decoder.RawToken. Directives, such as a document type declaration, and
+// the XML declaration are not part of the canonical form and are dropped, as
+// is character data outside the root element.
+//
+// WriteToken returns an error if StartElement and EndElement tokens are not
+// properly matched or a name space prefix is used without being declared.
+// Callers must call Flush when finished.
+func (c *Canonicalizer) WriteToken(t Token) error {
+	if c.out.err != nil {
+		return c.out.err
+	}
+	switch t := t.(type) {
+	case StartElement:
+		return c.writeStart(t)
+	case EndElement:
+		if len(c.stack) == 0 {
+			return fmt.Errorf("xml: end tag </%s> without start tag", t.Name.Local)
+		}
+		top := c.stack[len(c.stack)-1]
+		if top.name != t.Name {
+			return fmt.Errorf("xml: end tag </%s> does not match start tag <%s>", t.Name.Local, top.name.Local)
+		}
+		c.w.WriteString("</")
+		writeQName(c.w, t.Name)
+		c.w.WriteByte('>')
+		c.stack = c.stack[:len(c.stack)-1]
+		if len(c.stack) == 0 {
+			c.rootDone = true
+		}
+	case CharData:
+		if len(c.stack) > 0 {
+			c14nEscape(c.w, t, false)
+		}
+	case Comment:
+		if c.comments {
+			c.beforeTopLevel()
+			c.w.WriteString("<!--")
+			c.w.Write(t)
+			c.w.WriteString("-->")
+			c.afterTopLevel()
+		}
+	case ProcInst:
+		if t.Target == "xml" {
+			break
+		}
+		c.beforeTopLevel()
+		c.w.WriteString("<?")
+		c.w.WriteString(t.Target)
+		if len(t.Inst) > 0 {
+			c.w.WriteByte(' ')
+			c.w.Write(t.Inst)
+		}
+		c.w.WriteString("?>")
+		c.afterTopLevel()
+	case Directive:
+	default:
+		return errors.New("xml: WriteToken of invalid token type")
+	}
+	return c.out.err
+}
+
+// Comments and processing instructions outside the root element are
+// separated from it by a line feed.
+
+func (c *Canonicalizer) beforeTopLevel() {
+	if len(c.stack) == 0 && c.rootDone {
+		c.w.WriteByte('\n')
+	}
+}
+
+func (c *Canonicalizer) afterTopLevel() {
+	if len(c.stack) == 0 && !c.rootDone {
+		c.w.WriteByte('\n')
+	}
+}
+
+func (c *Canonicalizer) writeStart(start StartElement) error {
+	if start.Name.Local == "" {
+		return errors.New("xml: start tag with no name")
+	}
+	scope := c14nScope{name: start.Name, rendered: make(map[string]string)}
+	var attrs []c14nAttr
+	for _, a := range start.Attr {
+		switch {
+		case a.Name.Space == "xmlns":
+			if scope.decls == nil {
+				scope.decls = make(map[string]string)
+			}
+			scope.decls[a.Name.Local] = a.Value
+		case a.Name.Space == "" && a.Name.Local == "xmlns":
+			if scope.decls == nil {
+				scope.decls = make(map[string]string)
+			}
+			scope.decls[""] = a.Value
+		default:
+			attrs = append(attrs, c14nAttr{attr: a})
+		}
+	}
+	c.stack = append(c.stack, scope)
+
+	// Find the namespaces visibly utilized by the element
+	// and its attributes, plus the inclusive ones.
+	used := map[string]bool{start.Name.Space: true}
+	for i := range attrs {
+		prefix := attrs[i].attr.Name.Space
+		if prefix == "" {
+			continue
+		}
+		url, ok := c.lookup(prefix)
+		if !ok {
+			return fmt.Errorf("xml: undeclared name space prefix %q", prefix)
+		}
+		attrs[i].url = url
+		used[prefix] = true
+	}
+	for _, prefix := range c.inclusive {
+		if _, ok := c.lookup(prefix); ok {
+			used[prefix] = true
+		}
+	}
+	delete(used, "xml")
+	prefixes := make([]string, 0, len(used))
+	for prefix := range used {
+		prefixes = append(prefixes, prefix)
+	}
+	sort.Strings(prefixes) // the default name space sorts first
+
+	c.w.WriteByte('<')
+	writeQName(c.w, start.Name)
+	for _, prefix := range prefixes {
+		url, ok := c.lookup(prefix)
+		if !ok {
+			if prefix != "" {
+				return fmt.Errorf("xml: undeclared name space prefix %q", prefix)
+			}
+			url = ""
+		}
+		last, ok := c.lastRendered(prefix)
+		if prefix == "" && url == "" {
+			// The empty default name space is only written
+			// to undo a non-empty one.
+			if !ok || last == "" {
+				continue
+			}
+		} else if ok && last == url {
+			continue
+		}
+		scope.rendered[prefix] = url
+		c.w.WriteString(" xmlns")
+		if prefix != "" {
+			c.w.WriteByte(':')
+			c.w.WriteString(prefix)
+		}
+		c.w.WriteString(`="`)
+		c14nEscape(c.w, []byte(url), true)
+		c.w.WriteByte('"')
+	}
+
+	sort.Sort(byC14NOrder(attrs))
+	for _, a := range attrs {
+		c.w.WriteByte(' ')
+		writeQName(c.w, a.attr.Name)
+		c.w.WriteString(`="`)
+		c14nEscape(c.w, []byte(a.attr.Value), true)
+		c.w.WriteByte('"')
+	}
+	c.w.WriteByte('>')
+	return nil
+}
+
+// Flush flushes any buffered output to the underlying writer.
+func (c *Canonicalizer) Flush() error {
+	return c.w.Flush()
+}
+
+// Copy reads tokens from d using RawToken until the end of its input,
+// writes their canonical form and flushes the output. While it reads, d
+// normalizes attribute values as the XML specification requires, replacing
+// literal tabs and line ends by spaces, so that only those written as
+// character references are preserved.
+func (c *Canonicalizer) Copy(d *Decoder) error {
+	defer func(norm bool) { d.normAttrs = norm }(d.normAttrs)
+	d.normAttrs = true
+	for {
+		t, err := d.RawToken()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		if err := c.WriteToken(t); err != nil {
+			return err
+		}
+	}
+	if len(c.stack) > 0 {
+		return fmt.Errorf("xml: unexpected EOF in element <%s>", c.stack[len(c.stack)-1].name.Local)
+	}
+	return c.Flush()
+}
+
+// A c14nAttr is an attribute together with its name space.
+type c14nAttr struct {
+	attr Attr
+	url  string
+}
+
+// byC14NOrder sorts attributes by name space and then local name,
+// with unqualified attributes first.
+type byC14NOrder []c14nAttr
+
+func (x byC14NOrder) Len() int      { return len(x) }
+func (x byC14NOrder) Swap(i, j int) { x[i], x[j] = x[j], x[i] }
+func (x byC14NOrder) Less(i, j int) bool {
+	if x[i].url != x[j].url {
+		return x[i].url < x[j].url
+	}
+	return x[i].attr.Name.Local < x[j].attr.Name.Local
+}
+
+func writeQName(w *bufio.Writer, name Name) {
+	if name.Space != "" {
+		w.WriteString(name.Space)
+		w.WriteByte(':')
+	}
+	w.WriteString(name.Local)
+}
+
+// c14nEscape writes s escaped as required for canonical text nodes
+// or, if attr is set, attribute values.
+func c14nEscape(w *bufio.Writer, s []byte, attr bool) {
+	last := 0
+	for i := 0; i < len(s); {
+		r, width := utf8.DecodeRune(s[i:])
+		i += width
+		var esc string
+		switch r {
+		case '&':
+			esc = "&amp;"
+		case '<':
+			esc = "&lt;"
+		case '>':
+			if attr {
+				continue
+			}
+			esc = "&gt;"
+		case '"':
+			if !attr {
+				continue
+			}
+			esc = "&quot;"
+		case '\t':
+			if !attr {
+				continue
+			}
+			esc = "&#x9;"
+		case '\n':
+			if !attr {
+				continue
+			}
+			esc = "&#xA;"
+		case '\r':
+			esc = "&#xD;"
+		default:
+			continue
+		}
+		w.Write(s[last : i-width])
+		w.WriteString(esc)
+		last = i
+	}
+	w.Write(s[last:])
+}
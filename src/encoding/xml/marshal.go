@@ -11,6 +11,7 @@ import (
 	"fmt"
 	"io"
 	"reflect"
+	"sort"
 	"strconv"
 	"strings"
 )
@@ -145,6 +146,40 @@ func (enc *Encoder) Indent(prefix, indent string) {
 	enc.p.indent = indent
 }
 
+// DeclarePrefix sets the encoder to write elements and attributes in the
+// name space url using the given prefix, rather than a default name space
+// declaration or a prefix derived from url. The prefix is declared with an
+// xmlns attribute on the outermost element that uses it, or on the root
+// element when HoistNamespaces is in effect. If prefix is already bound to a
+// different name space where it would be used, a derived prefix is used instead.
+//
+// DeclarePrefix returns an error if prefix is not a valid XML name without
+// a colon or begins with "xml", which is reserved.
+func (enc *Encoder) DeclarePrefix(prefix, url string) error {
+	if prefix == "" || !isNameString(prefix) || strings.Contains(prefix, ":") {
+		return fmt.Errorf("xml: invalid name space prefix %q", prefix)
+	}
+	if strings.HasPrefix(strings.ToLower(prefix), "xml") {
+		return fmt.Errorf("xml: name space prefix %q is reserved", prefix)
+	}
+	if url == "" || url == xmlURL {
+		return fmt.Errorf("xml: cannot declare prefix %q for name space %q", prefix, url)
+	}
+	p := &enc.p
+	if p.preferredPrefix == nil {
+		p.preferredPrefix = make(map[string]string)
+	}
+	p.preferredPrefix[url] = prefix
+	return nil
+}
+
+// HoistNamespaces sets the encoder to declare all the prefixes given to
+// DeclarePrefix on the root element of each encoded value, instead of on
+// the elements that first use them, so that the declarations appear only once.
+func (enc *Encoder) HoistNamespaces() {
+	enc.p.hoistNS = true
+}
+
 // Encode writes the XML encoding of v to the stream.
 //
 // See the documentation for Marshal for details about the conversion
@@ -307,13 +342,54 @@ type printer struct {
 	attrPrefix map[string]string // map name space -> prefix
 	prefixes   []string
 	tags       []Name
+	scopes     []elementScope // parallel to tags
+
+	preferredPrefix map[string]string // map name space -> prefix, from DeclarePrefix
+	hoistNS         bool
+}
+
+// An elementScope records how an open element was written.
+type elementScope struct {
+	prefix    string // prefix of the element name, if any
+	defaultNS string // default name space in effect inside the element
+}
+
+// byPreferredPrefix sorts name spaces by the prefix declared for them.
+type byPreferredPrefix struct {
+	urls   []string
+	prefix map[string]string
+}
+
+func (x byPreferredPrefix) Len() int      { return len(x.urls) }
+func (x byPreferredPrefix) Swap(i, j int) { x.urls[i], x.urls[j] = x.urls[j], x.urls[i] }
+func (x byPreferredPrefix) Less(i, j int) bool {
+	pi, pj := x.prefix[x.urls[i]], x.prefix[x.urls[j]]
+	if pi != pj {
+		return pi < pj
+	}
+	return x.urls[i] < x.urls[j]
 }
 
 // createAttrPrefix finds the name space prefix attribute to use for the given name space,
 // defining a new prefix if necessary. It returns the prefix.
 func (p *printer) createAttrPrefix(url string) string {
+	prefix, isNew := p.definePrefix(url)
+	if isNew {
+		p.WriteString(`xmlns:`)
+		p.WriteString(prefix)
+		p.WriteString(`="`)
+		EscapeText(p, []byte(url))
+		p.WriteString(`" `)
+	}
+	return prefix
+}
+
+// definePrefix finds the name space prefix to use for the given name space,
+// binding a new prefix if necessary. It returns the prefix and whether it was
+// newly bound, in which case the caller must write its declaration.
+func (p *printer) definePrefix(url string) (prefix string, isNew bool) {
 	if prefix := p.attrPrefix[url]; prefix != "" {
-		return prefix
+		return prefix, false
 	}
 
 	// The "http://www.w3.org/XML/1998/namespace" name space is predefined as "xml"
@@ -321,7 +397,7 @@ func (p *printer) createAttrPrefix(url string) string {
 	// (The "http://www.w3.org/2000/xmlns/" name space is also predefined as "xmlns",
 	// but users should not be trying to use that one directly - that's our job.)
 	if url == xmlURL {
-		return "xml"
+		return "xml", false
 	}
 
 	// Need to define a new name space.
@@ -330,18 +406,21 @@ func (p *printer) createAttrPrefix(url string) string {
 		p.attrNS = make(map[string]string)
 	}
 
-	// Pick a name. We try to use the final element of the path
-	// but fall back to _.
-	prefix := strings.TrimRight(url, "/")
-	if i := strings.LastIndex(prefix, "/"); i >= 0 {
-		prefix = prefix[i+1:]
-	}
-	if prefix == "" || !isName([]byte(prefix)) || strings.Contains(prefix, ":") {
-		prefix = "_"
-	}
-	if strings.HasPrefix(prefix, "xml") {
-		// xmlanything is reserved.
-		prefix = "_" + prefix
+	// Pick a name. We use the prefix given to DeclarePrefix if it is free,
+	// and otherwise try to use the final element of the path but fall back to _.
+	prefix = p.preferredPrefix[url]
+	if prefix == "" || p.attrNS[prefix] != "" {
+		prefix = strings.TrimRight(url, "/")
+		if i := strings.LastIndex(prefix, "/"); i >= 0 {
+			prefix = prefix[i+1:]
+		}
+		if prefix == "" || !isName([]byte(prefix)) || strings.Contains(prefix, ":") {
+			prefix = "_"
+		}
+		if strings.HasPrefix(prefix, "xml") {
+			// xmlanything is reserved.
+			prefix = "_" + prefix
+		}
 	}
 	if p.attrNS[prefix] != "" {
 		// Name is taken. Find a better one.
@@ -355,16 +434,9 @@ func (p *printer) createAttrPrefix(url string) string {
 
 	p.attrPrefix[url] = prefix
 	p.attrNS[prefix] = url
-
-	p.WriteString(`xmlns:`)
-	p.WriteString(prefix)
-	p.WriteString(`="`)
-	EscapeText(p, []byte(url))
-	p.WriteString(`" `)
-
 	p.prefixes = append(p.prefixes, prefix)
 
-	return prefix
+	return prefix, true
 }
 
 // deleteAttrPrefix removes an attribute name space prefix.
@@ -658,18 +730,62 @@ func (p *printer) writeStart(start *StartElement) error {
 		return fmt.Errorf("xml: start tag with no name")
 	}
 
+	var scope elementScope
+	if n := len(p.scopes); n > 0 {
+		scope.defaultNS = p.scopes[n-1].defaultNS
+	}
 	p.tags = append(p.tags, start.Name)
 	p.markPrefix()
 
+	// Bind the prefixes to be declared on this element
+	// before writing its name, which may use one of them.
+	var decls []string
+	if p.hoistNS && len(p.tags) == 1 {
+		urls := make([]string, 0, len(p.preferredPrefix))
+		for url := range p.preferredPrefix {
+			urls = append(urls, url)
+		}
+		sort.Sort(byPreferredPrefix{urls, p.preferredPrefix})
+		for _, url := range urls {
+			if prefix, isNew := p.definePrefix(url); isNew {
+				decls = append(decls, prefix)
+			}
+		}
+	}
+	writeDefaultNS := false
+	if space := start.Name.Space; space != "" {
+		if p.preferredPrefix[space] != "" {
+			prefix, isNew := p.definePrefix(space)
+			if isNew {
+				decls = append(decls, prefix)
+			}
+			scope.prefix = prefix
+		} else if space != scope.defaultNS {
+			writeDefaultNS = true
+			scope.defaultNS = space
+		}
+	}
+
 	p.writeIndent(1)
 	p.WriteByte('<')
+	if scope.prefix != "" {
+		p.WriteString(scope.prefix)
+		p.WriteByte(':')
+	}
 	p.WriteString(start.Name.Local)
 
-	if start.Name.Space != "" {
+	if writeDefaultNS {
 		p.WriteString(` xmlns="`)
 		p.EscapeString(start.Name.Space)
 		p.WriteByte('"')
 	}
+	for _, prefix := range decls {
+		p.WriteString(` xmlns:`)
+		p.WriteString(prefix)
+		p.WriteString(`="`)
+		p.EscapeString(p.attrNS[prefix])
+		p.WriteByte('"')
+	}
 
 	// Attributes
 	for _, attr := range start.Attr {
@@ -686,8 +802,13 @@ func (p *printer) writeStart(start *StartElement) error {
 		p.WriteString(`="`)
 		p.EscapeString(attr.Value)
 		p.WriteByte('"')
+		if name.Space == "" && name.Local == "xmlns" {
+			// An explicit default name space declaration.
+			scope.defaultNS = attr.Value
+		}
 	}
 	p.WriteByte('>')
+	p.scopes = append(p.scopes, scope)
 	return nil
 }
 
@@ -705,10 +826,16 @@ func (p *printer) writeEnd(name Name) error {
 		return fmt.Errorf("xml: end tag </%s> in namespace %s does not match start tag <%s> in namespace %s", name.Local, name.Space, top.Local, top.Space)
 	}
 	p.tags = p.tags[:len(p.tags)-1]
+	scope := p.scopes[len(p.scopes)-1]
+	p.scopes = p.scopes[:len(p.scopes)-1]
 
 	p.writeIndent(-1)
 	p.WriteByte('<')
 	p.WriteByte('/')
+	if scope.prefix != "" {
+		p.WriteString(scope.prefix)
+		p.WriteByte(':')
+	}
 	p.WriteString(name.Local)
 	p.WriteByte('>')
 	p.popPrefix()
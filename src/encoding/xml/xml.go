@@ -196,6 +196,9 @@ type Decoder struct {
 	ns             map[string]string
 	err            error
 	line           int
+	linestart      int64
+	prevLinestart  int64 // linestart before the last newline, for ungetc
+	normAttrs      bool  // replace literal white space in attribute values by spaces
 	offset         int64
 	unmarshalDepth int
 }
@@ -877,6 +880,8 @@ func (d *Decoder) getc() (b byte, ok bool) {
 	}
 	if b == '\n' {
 		d.line++
+		d.prevLinestart = d.linestart
+		d.linestart = d.offset + 1
 	}
 	d.offset++
 	return b, true
@@ -889,6 +894,13 @@ func (d *Decoder) InputOffset() int64 {
 	return d.offset
 }
 
+// InputPos returns the line of the current decoder position and the 1 based
+// input position of the line. The position gives the location of the end of
+// the most recently returned token.
+func (d *Decoder) InputPos() (line, column int) {
+	return d.line, int(d.offset-d.linestart) + 1
+}
+
 // Return saved offset.
 // If we did ungetc (nextByte >= 0), have to back up one.
 func (d *Decoder) savedOffset() int {
@@ -916,6 +928,7 @@ func (d *Decoder) mustgetc() (b byte, ok bool) {
 func (d *Decoder) ungetc(b byte) {
 	if b == '\n' {
 		d.line--
+		d.linestart = d.prevLinestart
 	}
 	d.nextByte = int(b)
 	d.offset--
@@ -1067,10 +1080,20 @@ Input:
 		}
 
 		// We must rewrite unescaped \r and \r\n into \n.
+		// If normAttrs is set, literal white space in attribute
+		// values, including line ends, is then replaced by a space,
+		// as by the attribute-value normalization of the XML spec.
+		normSpace := d.normAttrs && quote >= 0
 		if b == '\r' {
-			d.buf.WriteByte('\n')
+			if normSpace {
+				d.buf.WriteByte(' ')
+			} else {
+				d.buf.WriteByte('\n')
+			}
 		} else if b1 == '\r' && b == '\n' {
 			// Skip \r\n--we already wrote \n.
+		} else if normSpace && (b == '\n' || b == '\t') {
+			d.buf.WriteByte(' ')
 		} else {
 			d.buf.WriteByte(b)
 		}
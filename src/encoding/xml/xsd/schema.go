@@ -0,0 +1,674 @@
+// Copyright 2016 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package xsd validates XML documents against a subset of the W3C XML
+// Schema definition language (XSD 1.0).
+//
+// The supported subset covers global and local element and attribute
+// declarations, element references, named and anonymous complex types
+// with sequence, choice and all groups, occurrence constraints, element
+// wildcards, mixed content, simple content extensions, complex content
+// extensions, and simple types derived by restriction from the built-in
+// types using the enumeration, pattern, length, minLength, maxLength,
+// minInclusive, maxInclusive, minExclusive and maxExclusive facets.
+// Schema constructs outside this subset are reported as errors by Parse,
+// so a schema is never silently only partly enforced. Identity
+// constraints, substitution groups, imports and includes are not
+// supported.
+//
+// Validation works on the token stream of an xml.Decoder and reports
+// violations with the line and column at which they occur:
+//
+//	schema, err := xsd.Parse(schemaReader)
+//	...
+//	err = schema.Validate(xml.NewDecoder(documentReader))
+package xsd
+
+import (
+	"encoding/xml"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// Namespace is the name space of XML Schema definitions.
+const Namespace = "http://www.w3.org/2001/XMLSchema"
+
+// instanceNamespace is the name space of the xsi attributes.
+const instanceNamespace = "http://www.w3.org/2001/XMLSchema-instance"
+
+// A Schema is a compiled XML schema. It is safe for concurrent use.
+type Schema struct {
+	targetNS string
+	elements map[xml.Name]*elementDecl
+	types    map[xml.Name]*typeDef
+	attrs    map[xml.Name]*attrDecl
+
+	// Uncompiled top-level definitions, by name.
+	elementNodes map[xml.Name]*node
+	typeNodes    map[xml.Name]*node
+	attrNodes    map[xml.Name]*node
+
+	qualifiedElements bool // elementFormDefault="qualified"
+	qualifiedAttrs    bool // attributeFormDefault="qualified"
+}
+
+// An elementDecl is an element declaration.
+type elementDecl struct {
+	name     xml.Name
+	typ      *typeDef
+	nillable bool
+}
+
+// An attrDecl is an attribute declaration.
+type attrDecl struct {
+	name     xml.Name
+	typ      *simpleType
+	required bool
+	fixed    *string
+}
+
+// A typeDef is a simple or complex type definition.
+type typeDef struct {
+	name string // for error messages
+
+	// simple is set for simple types and complex types with simple content.
+	simple *simpleType
+
+	anyType bool      // xs:anyType: anything is allowed
+	mixed   bool      // character data is allowed between child elements
+	content *particle // element content; nil for empty content
+	attrs   []*attrDecl
+	anyAttr bool
+
+	done bool // compilation has finished
+}
+
+// Particle kinds.
+const (
+	partElement = iota
+	partSequence
+	partChoice
+	partAll
+	partAny
+)
+
+// A particle is a term of a content model with its occurrence bounds.
+type particle struct {
+	kind     int
+	min, max int // max < 0 means unbounded
+	elem     *elementDecl
+	children []*particle
+
+	// For wildcards.
+	namespaces []string // allowed name spaces; "##any" and "##other" are special
+	targetNS   string   // the schema's target name space, for "##other"
+	process    string   // "strict", "lax" or "skip"
+}
+
+// A node is an element of a schema document.
+type node struct {
+	name     xml.Name
+	attrs    map[string]string // unqualified attributes
+	ns       map[string]string // name space bindings in scope
+	children []*node
+	line     int
+}
+
+func (n *node) errorf(format string, args ...interface{}) error {
+	if n == nil {
+		return fmt.Errorf("xsd: %s", fmt.Sprintf(format, args...))
+	}
+	return fmt.Errorf("xsd: schema line %d: %s", n.line, fmt.Sprintf(format, args...))
+}
+
+// qname resolves a QName-valued attribute using the bindings in scope.
+func (n *node) qname(attr string) (xml.Name, error) {
+	v := strings.TrimSpace(n.attrs[attr])
+	prefix, local := "", v
+	if i := strings.IndexByte(v, ':'); i >= 0 {
+		prefix, local = v[:i], v[i+1:]
+	}
+	space, ok := n.ns[prefix]
+	if !ok && prefix != "" {
+		return xml.Name{}, n.errorf("undeclared prefix %q in %s=%q", prefix, attr, v)
+	}
+	return xml.Name{Space: space, Local: local}, nil
+}
+
+// parseTree reads a schema document into a tree of nodes
+// in the XML Schema name space.
+func parseTree(r io.Reader) (*node, error) {
+	d := xml.NewDecoder(r)
+	var stack []*node
+	var root *node
+	skip := 0 // depth inside ignored elements
+	for {
+		t, err := d.Token()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		switch t := t.(type) {
+		case xml.StartElement:
+			if skip > 0 || t.Name.Space == Namespace && t.Name.Local == "annotation" {
+				skip++
+				continue
+			}
+			line, _ := d.InputPos()
+			n := &node{name: t.Name, attrs: make(map[string]string), line: line}
+			if len(stack) > 0 {
+				n.ns = stack[len(stack)-1].ns
+			}
+			for _, a := range t.Attr {
+				prefix, isDecl := "", false
+				switch {
+				case a.Name.Space == "xmlns":
+					prefix, isDecl = a.Name.Local, true
+				case a.Name.Space == "" && a.Name.Local == "xmlns":
+					isDecl = true
+				case a.Name.Space == "":
+					n.attrs[a.Name.Local] = a.Value
+				}
+				if isDecl {
+					ns := make(map[string]string, len(n.ns)+1)
+					for k, v := range n.ns {
+						ns[k] = v
+					}
+					ns[prefix] = a.Value
+					n.ns = ns
+				}
+			}
+			if len(stack) > 0 {
+				parent := stack[len(stack)-1]
+				if t.Name.Space == Namespace {
+					parent.children = append(parent.children, n)
+				}
+			} else {
+				root = n
+			}
+			stack = append(stack, n)
+		case xml.EndElement:
+			if skip > 0 {
+				skip--
+				continue
+			}
+			stack = stack[:len(stack)-1]
+		}
+	}
+	if root == nil || root.name.Space != Namespace || root.name.Local != "schema" {
+		return nil, fmt.Errorf("xsd: document is not an XML schema")
+	}
+	return root, nil
+}
+
+// Parse reads and compiles an XML schema. It returns an error if the
+// schema is malformed or uses constructs outside the supported subset.
+func Parse(r io.Reader) (*Schema, error) {
+	root, err := parseTree(r)
+	if err != nil {
+		return nil, err
+	}
+	s := &Schema{
+		targetNS:          root.attrs["targetNamespace"],
+		elements:          make(map[xml.Name]*elementDecl),
+		types:             make(map[xml.Name]*typeDef),
+		attrs:             make(map[xml.Name]*attrDecl),
+		elementNodes:      make(map[xml.Name]*node),
+		typeNodes:         make(map[xml.Name]*node),
+		attrNodes:         make(map[xml.Name]*node),
+		qualifiedElements: root.attrs["elementFormDefault"] == "qualified",
+		qualifiedAttrs:    root.attrs["attributeFormDefault"] == "qualified",
+	}
+
+	// Collect the top-level definitions so that
+	// references can be resolved in any order.
+	for _, n := range root.children {
+		name := xml.Name{Space: s.targetNS, Local: n.attrs["name"]}
+		var m map[xml.Name]*node
+		switch n.name.Local {
+		case "element":
+			m = s.elementNodes
+		case "complexType", "simpleType":
+			m = s.typeNodes
+		case "attribute":
+			m = s.attrNodes
+		default:
+			return nil, n.errorf("unsupported schema component <%s>", n.name.Local)
+		}
+		if name.Local == "" {
+			return nil, n.errorf("top-level <%s> has no name", n.name.Local)
+		}
+		if m[name] != nil {
+			return nil, n.errorf("duplicate definition of %s", name.Local)
+		}
+		m[name] = n
+	}
+	for name := range s.typeNodes {
+		if _, err := s.typeByName(name, nil); err != nil {
+			return nil, err
+		}
+	}
+	for name := range s.attrNodes {
+		if _, err := s.globalAttr(name, nil); err != nil {
+			return nil, err
+		}
+	}
+	for name := range s.elementNodes {
+		if _, err := s.globalElement(name, nil); err != nil {
+			return nil, err
+		}
+	}
+	for _, td := range s.types {
+		if td.simple != nil {
+			if err := td.simple.checkDerivation(); err != nil {
+				return nil, err
+			}
+		}
+	}
+	s.elementNodes, s.typeNodes, s.attrNodes = nil, nil, nil
+	return s, nil
+}
+
+// globalElement returns the top-level element declaration with the given
+// name, compiling it if necessary. from is the referring node, if any.
+func (s *Schema) globalElement(name xml.Name, from *node) (*elementDecl, error) {
+	if e := s.elements[name]; e != nil {
+		return e, nil
+	}
+	n := s.elementNodes[name]
+	if n == nil {
+		return nil, from.errorf("undefined element %s", name.Local)
+	}
+	e := &elementDecl{name: name}
+	s.elements[name] = e
+	return e, s.compileElement(e, n)
+}
+
+// compileElement fills in the type of e from its declaration n.
+func (s *Schema) compileElement(e *elementDecl, n *node) error {
+	e.nillable = n.attrs["nillable"] == "true"
+	if _, ok := n.attrs["type"]; ok {
+		name, err := n.qname("type")
+		if err != nil {
+			return err
+		}
+		e.typ, err = s.typeByName(name, n)
+		return err
+	}
+	for _, c := range n.children {
+		switch c.name.Local {
+		case "complexType":
+			td := &typeDef{name: "anonymous type of " + e.name.Local}
+			e.typ = td
+			return s.compileComplex(td, c)
+		case "simpleType":
+			st, err := s.compileSimple(c, "anonymous type of "+e.name.Local)
+			if err != nil {
+				return err
+			}
+			e.typ = &typeDef{name: st.name, simple: st, done: true}
+			return nil
+		case "unique", "key", "keyref":
+			return c.errorf("identity constraints are not supported")
+		default:
+			return c.errorf("unexpected <%s> in element declaration", c.name.Local)
+		}
+	}
+	e.typ = anyTypeDef
+	return nil
+}
+
+var anyTypeDef = &typeDef{name: "anyType", anyType: true, done: true}
+
+// typeByName returns the named type definition, compiling it if necessary.
+func (s *Schema) typeByName(name xml.Name, from *node) (*typeDef, error) {
+	if name.Space == Namespace {
+		if name.Local == "anyType" {
+			return anyTypeDef, nil
+		}
+		if st := builtinTypes[name.Local]; st != nil {
+			return &typeDef{name: name.Local, simple: st, done: true}, nil
+		}
+		return nil, from.errorf("unsupported built-in type %s", name.Local)
+	}
+	if td := s.types[name]; td != nil {
+		return td, nil
+	}
+	n := s.typeNodes[name]
+	if n == nil {
+		return nil, from.errorf("undefined type %s", name.Local)
+	}
+	td := &typeDef{name: name.Local}
+	s.types[name] = td
+	if n.name.Local == "simpleType" {
+		// Register the simple type before compiling it,
+		// so that circular derivations are caught rather than looping.
+		td.simple = &simpleType{name: name.Local}
+		if err := s.fillSimple(td.simple, n); err != nil {
+			return nil, err
+		}
+		td.done = true
+		return td, nil
+	}
+	return td, s.compileComplex(td, n)
+}
+
+// simpleTypeByName returns the named simple type.
+func (s *Schema) simpleTypeByName(name xml.Name, from *node) (*simpleType, error) {
+	td, err := s.typeByName(name, from)
+	if err != nil {
+		return nil, err
+	}
+	if td.simple == nil || td.content != nil || len(td.attrs) > 0 {
+		return nil, from.errorf("%s is not a simple type", name.Local)
+	}
+	return td.simple, nil
+}
+
+// compileComplex fills in td from the complexType definition n.
+func (s *Schema) compileComplex(td *typeDef, n *node) error {
+	defer func() { td.done = true }()
+	td.mixed = n.attrs["mixed"] == "true"
+	for _, c := range n.children {
+		switch c.name.Local {
+		case "sequence", "choice", "all":
+			if td.content != nil {
+				return c.errorf("complex type has more than one content model")
+			}
+			p, err := s.compileParticle(c)
+			if err != nil {
+				return err
+			}
+			td.content = p
+		case "attribute", "anyAttribute":
+			if err := s.compileAttr(td, c); err != nil {
+				return err
+			}
+		case "simpleContent":
+			if err := s.compileSimpleContent(td, c); err != nil {
+				return err
+			}
+		case "complexContent":
+			if err := s.compileComplexContent(td, c); err != nil {
+				return err
+			}
+		default:
+			return c.errorf("unsupported <%s> in complex type", c.name.Local)
+		}
+	}
+	return nil
+}
+
+// derivation returns the single extension child of a simpleContent or
+// complexContent node, and the type definition it is based on.
+func (s *Schema) derivation(n *node) (*node, *typeDef, error) {
+	if len(n.children) != 1 || n.children[0].name.Local != "extension" {
+		return nil, nil, n.errorf("only derivation by extension is supported in <%s>", n.name.Local)
+	}
+	ext := n.children[0]
+	name, err := ext.qname("base")
+	if err != nil {
+		return nil, nil, err
+	}
+	base, err := s.typeByName(name, ext)
+	if err != nil {
+		return nil, nil, err
+	}
+	if !base.done {
+		return nil, nil, ext.errorf("circular derivation of type %s", name.Local)
+	}
+	return ext, base, nil
+}
+
+func (s *Schema) compileSimpleContent(td *typeDef, n *node) error {
+	ext, base, err := s.derivation(n)
+	if err != nil {
+		return err
+	}
+	if base.simple == nil {
+		return ext.errorf("base type %s does not have simple content", base.name)
+	}
+	td.simple = base.simple
+	td.attrs = append(td.attrs, base.attrs...)
+	td.anyAttr = base.anyAttr
+	for _, c := range ext.children {
+		if c.name.Local != "attribute" && c.name.Local != "anyAttribute" {
+			return c.errorf("unexpected <%s> in simple content extension", c.name.Local)
+		}
+		if err := s.compileAttr(td, c); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *Schema) compileComplexContent(td *typeDef, n *node) error {
+	ext, base, err := s.derivation(n)
+	if err != nil {
+		return err
+	}
+	if base.simple != nil || base.anyType {
+		return ext.errorf("base type %s does not have complex content", base.name)
+	}
+	if n.attrs["mixed"] == "true" || base.mixed {
+		td.mixed = true
+	}
+	td.attrs = append(td.attrs, base.attrs...)
+	td.anyAttr = base.anyAttr
+	var own *particle
+	for _, c := range ext.children {
+		switch c.name.Local {
+		case "sequence", "choice", "all":
+			if own != nil {
+				return c.errorf("complex type has more than one content model")
+			}
+			if own, err = s.compileParticle(c); err != nil {
+				return err
+			}
+		case "attribute", "anyAttribute":
+			if err := s.compileAttr(td, c); err != nil {
+				return err
+			}
+		default:
+			return c.errorf("unexpected <%s> in complex content extension", c.name.Local)
+		}
+	}
+	switch {
+	case base.content == nil:
+		td.content = own
+	case own == nil:
+		td.content = base.content
+	default:
+		// The extension's content follows the base type's.
+		td.content = &particle{kind: partSequence, min: 1, max: 1, children: []*particle{base.content, own}}
+	}
+	return nil
+}
+
+// occurs parses the minOccurs and maxOccurs attributes of n.
+func occurs(n *node) (min, max int, err error) {
+	min, max = 1, 1
+	if v, ok := n.attrs["minOccurs"]; ok {
+		if min, err = strconv.Atoi(v); err != nil || min < 0 {
+			return 0, 0, n.errorf("invalid minOccurs %q", v)
+		}
+	}
+	if v, ok := n.attrs["maxOccurs"]; ok {
+		if v == "unbounded" {
+			max = -1
+		} else if max, err = strconv.Atoi(v); err != nil || max < 0 {
+			return 0, 0, n.errorf("invalid maxOccurs %q", v)
+		}
+	}
+	if max >= 0 && max < min {
+		return 0, 0, n.errorf("maxOccurs is less than minOccurs")
+	}
+	return min, max, nil
+}
+
+// compileParticle compiles a sequence, choice, all, element or any node.
+func (s *Schema) compileParticle(n *node) (*particle, error) {
+	min, max, err := occurs(n)
+	if err != nil {
+		return nil, err
+	}
+	p := &particle{min: min, max: max}
+	switch n.name.Local {
+	case "element":
+		p.kind = partElement
+		if _, ok := n.attrs["ref"]; ok {
+			name, err := n.qname("ref")
+			if err != nil {
+				return nil, err
+			}
+			if p.elem, err = s.globalElement(name, n); err != nil {
+				return nil, err
+			}
+			return p, nil
+		}
+		name := xml.Name{Local: n.attrs["name"]}
+		if name.Local == "" {
+			return nil, n.errorf("local element declaration has no name")
+		}
+		if form := n.attrs["form"]; form == "qualified" || form == "" && s.qualifiedElements {
+			name.Space = s.targetNS
+		}
+		p.elem = &elementDecl{name: name}
+		if err := s.compileElement(p.elem, n); err != nil {
+			return nil, err
+		}
+		return p, nil
+	case "any":
+		p.kind = partAny
+		p.targetNS = s.targetNS
+		p.process = n.attrs["processContents"]
+		if p.process == "" {
+			p.process = "strict"
+		}
+		p.namespaces = strings.Fields(n.attrs["namespace"])
+		if len(p.namespaces) == 0 {
+			p.namespaces = []string{"##any"}
+		}
+		for i, ns := range p.namespaces {
+			switch ns {
+			case "##targetNamespace":
+				p.namespaces[i] = s.targetNS
+			case "##local":
+				p.namespaces[i] = ""
+			}
+		}
+		return p, nil
+	case "sequence":
+		p.kind = partSequence
+	case "choice":
+		p.kind = partChoice
+	case "all":
+		p.kind = partAll
+	default:
+		return nil, n.errorf("unsupported <%s> in content model", n.name.Local)
+	}
+	for _, c := range n.children {
+		cp, err := s.compileParticle(c)
+		if err != nil {
+			return nil, err
+		}
+		if p.kind == partAll && (cp.kind != partElement || cp.max != 1) {
+			return nil, c.errorf("<all> may only contain elements occurring at most once")
+		}
+		p.children = append(p.children, cp)
+	}
+	return p, nil
+}
+
+// globalAttr returns the top-level attribute declaration with the given
+// name, compiling it if necessary.
+func (s *Schema) globalAttr(name xml.Name, from *node) (*attrDecl, error) {
+	if a := s.attrs[name]; a != nil {
+		return a, nil
+	}
+	n := s.attrNodes[name]
+	if n == nil {
+		return nil, from.errorf("undefined attribute %s", name.Local)
+	}
+	a := &attrDecl{name: name}
+	s.attrs[name] = a
+	return a, s.fillAttr(a, n)
+}
+
+// compileAttr adds the attribute or anyAttribute declaration n to td.
+func (s *Schema) compileAttr(td *typeDef, n *node) error {
+	if n.name.Local == "anyAttribute" {
+		td.anyAttr = true
+		return nil
+	}
+	var a *attrDecl
+	if _, ok := n.attrs["ref"]; ok {
+		name, err := n.qname("ref")
+		if err != nil {
+			return err
+		}
+		global, err := s.globalAttr(name, n)
+		if err != nil {
+			return err
+		}
+		copy := *global
+		a = &copy
+	} else {
+		a = &attrDecl{name: xml.Name{Local: n.attrs["name"]}}
+		if a.name.Local == "" {
+			return n.errorf("attribute declaration has no name")
+		}
+		if form := n.attrs["form"]; form == "qualified" || form == "" && s.qualifiedAttrs {
+			a.name.Space = s.targetNS
+		}
+		if err := s.fillAttr(a, n); err != nil {
+			return err
+		}
+	}
+	switch use := n.attrs["use"]; use {
+	case "required":
+		a.required = true
+	case "prohibited":
+		return nil
+	case "", "optional":
+	default:
+		return n.errorf("invalid use %q", use)
+	}
+	for _, other := range td.attrs {
+		if other.name == a.name {
+			return n.errorf("duplicate attribute %s", a.name.Local)
+		}
+	}
+	td.attrs = append(td.attrs, a)
+	return nil
+}
+
+// fillAttr fills in the type and value constraint of a from n.
+func (s *Schema) fillAttr(a *attrDecl, n *node) error {
+	if v, ok := n.attrs["fixed"]; ok {
+		a.fixed = &v
+	}
+	if _, ok := n.attrs["type"]; ok {
+		name, err := n.qname("type")
+		if err != nil {
+			return err
+		}
+		a.typ, err = s.simpleTypeByName(name, n)
+		return err
+	}
+	for _, c := range n.children {
+		if c.name.Local != "simpleType" {
+			return c.errorf("unexpected <%s> in attribute declaration", c.name.Local)
+		}
+		var err error
+		a.typ, err = s.compileSimple(c, "anonymous type of "+a.name.Local)
+		return err
+	}
+	a.typ = builtinTypes["anySimpleType"]
+	return nil
+}
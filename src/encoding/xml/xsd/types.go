@@ -0,0 +1,452 @@
+// Copyright 2016 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package xsd
+
+import (
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"math/big"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// A simpleType is a built-in simple type or a type derived from one by
+// restriction.
+type simpleType struct {
+	name string
+	base *simpleType // nil for built-in types
+
+	// For built-in types.
+	check   func(string) error // lexical check; nil accepts anything
+	numeric bool               // values are ordered as numbers
+	binary  bool               // lengths count decoded octets
+
+	// Facets.
+	whiteSpace                         int // whitespace normalization
+	enum                               []string
+	patterns                           []*regexp.Regexp
+	length, minLength, maxLength       int // -1 if absent
+	minIncl, maxIncl, minExcl, maxExcl *big.Rat
+}
+
+// Whitespace normalizations.
+const (
+	wsPreserve = iota
+	wsReplace  // tabs and line breaks become spaces
+	wsCollapse // as wsReplace, then runs of spaces become one and are trimmed
+)
+
+// root returns the type at the root of t's derivation, or nil
+// if the derivation is circular.
+func (t *simpleType) root() *simpleType {
+	seen := make(map[*simpleType]bool)
+	for t.base != nil {
+		if seen[t] {
+			return nil
+		}
+		seen[t] = true
+		t = t.base
+	}
+	return t
+}
+
+// checkDerivation reports an error if t is derived from itself.
+func (t *simpleType) checkDerivation() error {
+	if t.root() == nil {
+		return fmt.Errorf("xsd: circular derivation of type %s", t.name)
+	}
+	return nil
+}
+
+// validate reports whether s is a valid value of type t.
+// The derivation of t must not be circular.
+func (t *simpleType) validate(s string) error {
+	b := t.root()
+	ws := wsPreserve
+	for u := t; u != nil; u = u.base {
+		if u.whiteSpace > ws {
+			ws = u.whiteSpace
+		}
+	}
+	switch ws {
+	case wsReplace:
+		s = strings.Map(func(r rune) rune {
+			if r == '\t' || r == '\n' || r == '\r' {
+				return ' '
+			}
+			return r
+		}, s)
+	case wsCollapse:
+		s = strings.Join(strings.Fields(s), " ")
+	}
+	// Check the built-in type first, so that facets see
+	// only lexically valid values.
+	var chain []*simpleType
+	for u := t; u != nil; u = u.base {
+		chain = append(chain, u)
+	}
+	for i := len(chain) - 1; i >= 0; i-- {
+		u := chain[i]
+		if u.check != nil {
+			if err := u.check(s); err != nil {
+				return err
+			}
+		}
+		if err := u.checkFacets(s, b); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (t *simpleType) checkFacets(s string, b *simpleType) error {
+	if t.enum != nil {
+		found := false
+		for _, e := range t.enum {
+			if e == s {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return fmt.Errorf("value %q is not one of the values allowed by %s", s, t.name)
+		}
+	}
+	for _, re := range t.patterns {
+		if !re.MatchString(s) {
+			return fmt.Errorf("value %q does not match the pattern of %s", s, t.name)
+		}
+	}
+	if t.length >= 0 || t.minLength >= 0 || t.maxLength >= 0 {
+		n := utf8.RuneCountInString(s)
+		if b.binary {
+			n = binaryLength(b, s)
+		}
+		if t.length >= 0 && n != t.length {
+			return fmt.Errorf("value %q has length %d, want %d", s, n, t.length)
+		}
+		if t.minLength >= 0 && n < t.minLength {
+			return fmt.Errorf("value %q is shorter than the minimum length %d", s, t.minLength)
+		}
+		if t.maxLength >= 0 && n > t.maxLength {
+			return fmt.Errorf("value %q is longer than the maximum length %d", s, t.maxLength)
+		}
+	}
+	if t.minIncl != nil || t.maxIncl != nil || t.minExcl != nil || t.maxExcl != nil {
+		v, ok := new(big.Rat).SetString(s)
+		if !ok {
+			// INF and NaN have no place in the ordering used here.
+			return fmt.Errorf("value %q is out of range", s)
+		}
+		if t.minIncl != nil && v.Cmp(t.minIncl) < 0 ||
+			t.maxIncl != nil && v.Cmp(t.maxIncl) > 0 ||
+			t.minExcl != nil && v.Cmp(t.minExcl) <= 0 ||
+			t.maxExcl != nil && v.Cmp(t.maxExcl) >= 0 {
+			return fmt.Errorf("value %q is out of range", s)
+		}
+	}
+	return nil
+}
+
+// binaryLength returns the number of octets encoded by s.
+func binaryLength(b *simpleType, s string) int {
+	if b.name == "hexBinary" {
+		return len(s) / 2
+	}
+	n, _ := base64.StdEncoding.DecodeString(strings.Replace(s, " ", "", -1))
+	return len(n)
+}
+
+// compileSimple compiles an anonymous simpleType definition.
+func (s *Schema) compileSimple(n *node, name string) (*simpleType, error) {
+	t := &simpleType{name: name}
+	if err := s.fillSimple(t, n); err != nil {
+		return nil, err
+	}
+	return t, t.checkDerivation()
+}
+
+// fillSimple fills in t from the simpleType definition n.
+func (s *Schema) fillSimple(t *simpleType, n *node) error {
+	t.length, t.minLength, t.maxLength = -1, -1, -1
+	if len(n.children) != 1 || n.children[0].name.Local != "restriction" {
+		return n.errorf("only derivation by restriction is supported in <simpleType>")
+	}
+	r := n.children[0]
+	facets := r.children
+	if _, ok := r.attrs["base"]; ok {
+		name, err := r.qname("base")
+		if err != nil {
+			return err
+		}
+		if t.base, err = s.simpleTypeByName(name, r); err != nil {
+			return err
+		}
+	} else {
+		if len(facets) == 0 || facets[0].name.Local != "simpleType" {
+			return r.errorf("restriction has no base type")
+		}
+		base, err := s.compileSimple(facets[0], "anonymous base of "+t.name)
+		if err != nil {
+			return err
+		}
+		t.base, facets = base, facets[1:]
+	}
+	for _, f := range facets {
+		v, ok := f.attrs["value"]
+		if !ok {
+			return f.errorf("facet <%s> has no value", f.name.Local)
+		}
+		var err error
+		switch f.name.Local {
+		case "enumeration":
+			if t.enum == nil {
+				t.enum = []string{}
+			}
+			t.enum = append(t.enum, v)
+		case "pattern":
+			re, err := compilePattern(v)
+			if err != nil {
+				return f.errorf("%v", err)
+			}
+			t.patterns = append(t.patterns, re)
+		case "length":
+			t.length, err = facetInt(v)
+		case "minLength":
+			t.minLength, err = facetInt(v)
+		case "maxLength":
+			t.maxLength, err = facetInt(v)
+		case "minInclusive":
+			t.minIncl, err = s.facetBound(t, v)
+		case "maxInclusive":
+			t.maxIncl, err = s.facetBound(t, v)
+		case "minExclusive":
+			t.minExcl, err = s.facetBound(t, v)
+		case "maxExclusive":
+			t.maxExcl, err = s.facetBound(t, v)
+		case "whiteSpace":
+			switch v {
+			case "preserve":
+				t.whiteSpace = wsPreserve
+			case "replace":
+				t.whiteSpace = wsReplace
+			case "collapse":
+				t.whiteSpace = wsCollapse
+			default:
+				err = fmt.Errorf("invalid value %q", v)
+			}
+		default:
+			return f.errorf("unsupported facet <%s>", f.name.Local)
+		}
+		if err != nil {
+			return f.errorf("facet <%s>: %v", f.name.Local, err)
+		}
+	}
+	return nil
+}
+
+func facetInt(v string) (int, error) {
+	n, err := strconv.Atoi(strings.TrimSpace(v))
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("invalid value %q", v)
+	}
+	return n, nil
+}
+
+// facetBound parses the value of a range facet of t.
+func (s *Schema) facetBound(t *simpleType, v string) (*big.Rat, error) {
+	if b := t.base.root(); b != nil && !b.numeric {
+		return nil, errors.New("range facets are only supported on numeric types")
+	}
+	r, ok := new(big.Rat).SetString(strings.TrimSpace(v))
+	if !ok {
+		return nil, fmt.Errorf("invalid value %q", v)
+	}
+	return r, nil
+}
+
+// compilePattern compiles an XML Schema regular expression. The XML Schema
+// syntax is close to that of package regexp; the multi-character escapes
+// \i, \I, \c and \C, which have no equivalent there, are rejected.
+// Patterns are implicitly anchored at both ends.
+func compilePattern(p string) (*regexp.Regexp, error) {
+	for i := 0; i+1 < len(p); i++ {
+		if p[i] == '\\' {
+			switch p[i+1] {
+			case 'i', 'I', 'c', 'C':
+				return nil, fmt.Errorf("unsupported escape \\%c in pattern", p[i+1])
+			}
+			i++
+		}
+	}
+	return regexp.Compile(`^(?:` + p + `)$`)
+}
+
+var (
+	decimalRE  = regexp.MustCompile(`^[+-]?(?:[0-9]+(?:\.[0-9]*)?|\.[0-9]+)$`)
+	integerRE  = regexp.MustCompile(`^[+-]?[0-9]+$`)
+	floatRE    = regexp.MustCompile(`^(?:[+-]?(?:[0-9]+(?:\.[0-9]*)?|\.[0-9]+)(?:[eE][+-]?[0-9]+)?|-?INF|NaN)$`)
+	ncNameRE   = regexp.MustCompile(`^[\pL_][\pL\pN._\-·\p{Mn}\p{Mc}]*$`)
+	nameRE     = regexp.MustCompile(`^[\pL_:][\pL\pN._:\-·\p{Mn}\p{Mc}]*$`)
+	nmtokenRE  = regexp.MustCompile(`^[\pL\pN._:\-·\p{Mn}\p{Mc}]+$`)
+	languageRE = regexp.MustCompile(`^[a-zA-Z]{1,8}(?:-[a-zA-Z0-9]{1,8})*$`)
+	tzRE       = `(?:Z|[+-](?:(?:0[0-9]|1[0-3]):[0-5][0-9]|14:00))?`
+	dateRE     = regexp.MustCompile(`^-?([0-9]{4,})-(0[1-9]|1[0-2])-(0[1-9]|[12][0-9]|3[01])` + tzRE + `$`)
+	timeRE     = regexp.MustCompile(`^(?:(?:[01][0-9]|2[0-3]):[0-5][0-9]:[0-5][0-9](?:\.[0-9]+)?|24:00:00(?:\.0+)?)` + tzRE + `$`)
+	durationRE = regexp.MustCompile(`^-?P(?:[0-9]+Y)?(?:[0-9]+M)?(?:[0-9]+D)?(?:T(?:[0-9]+H)?(?:[0-9]+M)?(?:[0-9]+(?:\.[0-9]+)?S)?)?$`)
+	gYearRE    = regexp.MustCompile(`^-?[0-9]{4,}` + tzRE + `$`)
+)
+
+func lexical(re *regexp.Regexp, what string) func(string) error {
+	return func(s string) error {
+		if !re.MatchString(s) {
+			return fmt.Errorf("%q is not a valid %s", s, what)
+		}
+		return nil
+	}
+}
+
+// checkDate checks a date, including that the day exists in its month.
+func checkDate(s string) error {
+	m := dateRE.FindStringSubmatch(s)
+	if m == nil {
+		return fmt.Errorf("%q is not a valid date", s)
+	}
+	year, _ := strconv.Atoi(m[1])
+	month, _ := strconv.Atoi(m[2])
+	day, _ := strconv.Atoi(m[3])
+	days := [...]int{31, 28, 31, 30, 31, 30, 31, 31, 30, 31, 30, 31}[month-1]
+	if month == 2 && year%4 == 0 && (year%100 != 0 || year%400 == 0) {
+		days = 29
+	}
+	if day > days {
+		return fmt.Errorf("%q is not a valid date", s)
+	}
+	return nil
+}
+
+func checkDateTime(s string) error {
+	i := strings.IndexByte(s, 'T')
+	if i < 0 {
+		return fmt.Errorf("%q is not a valid dateTime", s)
+	}
+	if checkDate(s[:i]) != nil || !timeRE.MatchString(s[i+1:]) {
+		return fmt.Errorf("%q is not a valid dateTime", s)
+	}
+	return nil
+}
+
+func checkDuration(s string) error {
+	if !durationRE.MatchString(s) || strings.HasSuffix(s, "P") || strings.HasSuffix(s, "T") {
+		return fmt.Errorf("%q is not a valid duration", s)
+	}
+	return nil
+}
+
+func checkBoolean(s string) error {
+	switch s {
+	case "true", "false", "1", "0":
+		return nil
+	}
+	return fmt.Errorf("%q is not a valid boolean", s)
+}
+
+func checkHexBinary(s string) error {
+	if _, err := hex.DecodeString(s); err != nil {
+		return fmt.Errorf("%q is not a valid hexBinary", s)
+	}
+	return nil
+}
+
+func checkBase64Binary(s string) error {
+	if _, err := base64.StdEncoding.DecodeString(strings.Replace(s, " ", "", -1)); err != nil {
+		return fmt.Errorf("%q is not a valid base64Binary", s)
+	}
+	return nil
+}
+
+func checkQName(s string) error {
+	i := strings.IndexByte(s, ':')
+	if i >= 0 && !ncNameRE.MatchString(s[:i]) || !ncNameRE.MatchString(s[i+1:]) {
+		return fmt.Errorf("%q is not a valid QName", s)
+	}
+	return nil
+}
+
+// builtinTypes holds the supported built-in simple types by local name.
+var builtinTypes = map[string]*simpleType{}
+
+// builtinType defines a built-in type derived from base, which must
+// already be defined, with the given lexical check and inclusive range.
+func builtinType(name, base string, check func(string) error, min, max string) {
+	t := &simpleType{name: name, check: check, length: -1, minLength: -1, maxLength: -1}
+	if base != "" {
+		t.base = builtinTypes[base]
+	} else {
+		t.whiteSpace = wsCollapse
+	}
+	if min != "" {
+		t.minIncl, _ = new(big.Rat).SetString(min)
+	}
+	if max != "" {
+		t.maxIncl, _ = new(big.Rat).SetString(max)
+	}
+	builtinTypes[name] = t
+}
+
+func init() {
+	builtinType("anySimpleType", "", nil, "", "")
+	builtinType("string", "", nil, "", "")
+	builtinTypes["anySimpleType"].whiteSpace = wsPreserve
+	builtinTypes["string"].whiteSpace = wsPreserve
+	builtinType("normalizedString", "string", nil, "", "")
+	builtinType("token", "normalizedString", nil, "", "")
+	builtinTypes["normalizedString"].whiteSpace = wsReplace
+	builtinTypes["token"].whiteSpace = wsCollapse
+	builtinType("language", "token", lexical(languageRE, "language"), "", "")
+	builtinType("NMTOKEN", "token", lexical(nmtokenRE, "NMTOKEN"), "", "")
+	builtinType("Name", "token", lexical(nameRE, "Name"), "", "")
+	builtinType("NCName", "Name", lexical(ncNameRE, "NCName"), "", "")
+	builtinType("ID", "NCName", nil, "", "")
+	builtinType("IDREF", "NCName", nil, "", "")
+	builtinType("ENTITY", "NCName", nil, "", "")
+
+	builtinType("boolean", "", checkBoolean, "", "")
+	builtinType("float", "", lexical(floatRE, "float"), "", "")
+	builtinType("double", "", lexical(floatRE, "double"), "", "")
+	builtinType("decimal", "", lexical(decimalRE, "decimal"), "", "")
+	builtinType("integer", "decimal", lexical(integerRE, "integer"), "", "")
+	builtinType("long", "integer", nil, "-9223372036854775808", "9223372036854775807")
+	builtinType("int", "long", nil, "-2147483648", "2147483647")
+	builtinType("short", "int", nil, "-32768", "32767")
+	builtinType("byte", "short", nil, "-128", "127")
+	builtinType("nonNegativeInteger", "integer", nil, "0", "")
+	builtinType("positiveInteger", "nonNegativeInteger", nil, "1", "")
+	builtinType("unsignedLong", "nonNegativeInteger", nil, "", "18446744073709551615")
+	builtinType("unsignedInt", "unsignedLong", nil, "", "4294967295")
+	builtinType("unsignedShort", "unsignedInt", nil, "", "65535")
+	builtinType("unsignedByte", "unsignedShort", nil, "", "255")
+	builtinType("nonPositiveInteger", "integer", nil, "", "0")
+	builtinType("negativeInteger", "nonPositiveInteger", nil, "", "-1")
+	for _, name := range []string{"float", "double", "decimal"} {
+		builtinTypes[name].numeric = true
+	}
+
+	builtinType("date", "", checkDate, "", "")
+	builtinType("dateTime", "", checkDateTime, "", "")
+	builtinType("time", "", lexical(timeRE, "time"), "", "")
+	builtinType("duration", "", checkDuration, "", "")
+	builtinType("gYear", "", lexical(gYearRE, "gYear"), "", "")
+	builtinType("hexBinary", "", checkHexBinary, "", "")
+	builtinType("base64Binary", "", checkBase64Binary, "", "")
+	builtinTypes["hexBinary"].binary = true
+	builtinTypes["base64Binary"].binary = true
+	builtinType("anyURI", "", nil, "", "")
+	builtinType("QName", "", checkQName, "", "")
+}
@@ -0,0 +1,471 @@
+// Copyright 2016 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package xsd
+
+import (
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// An Error describes a violation of the schema found in a document.
+type Error struct {
+	Line, Column int    // 1 based position of the violation
+	Offset       int64  // input offset, as returned by xml.Decoder.InputOffset
+	Msg          string // description of the violation
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("xsd: line %d, column %d: %s", e.Line, e.Column, e.Msg)
+}
+
+// Errors lists the schema violations found in a document, in the order
+// in which they were found.
+type Errors []*Error
+
+func (e Errors) Error() string {
+	switch len(e) {
+	case 0:
+		return "xsd: no errors"
+	case 1:
+		return e[0].Error()
+	}
+	return fmt.Sprintf("%v (and %d more errors)", e[0], len(e)-1)
+}
+
+// A position is a location in the validated document.
+type position struct {
+	line, col int
+	offset    int64
+}
+
+// A frame holds the validation state of an open element.
+type frame struct {
+	name     xml.Name
+	typ      *typeDef // nil if the element's content is not validated
+	nilled   bool     // xsi:nil="true"
+	badText  bool     // character data has been reported
+	text     bytes.Buffer
+	children []xml.Name
+	childPos []position
+	pos      position
+}
+
+type validator struct {
+	s     *Schema
+	stack []*frame
+	errs  Errors
+}
+
+func (v *validator) errorf(pos position, format string, args ...interface{}) {
+	v.errs = append(v.errs, &Error{pos.line, pos.col, pos.offset, fmt.Sprintf(format, args...)})
+}
+
+// Validate reads tokens from d using Token until the end of its input and
+// checks the document against the schema. If the document is not valid,
+// Validate returns an Errors value listing every violation, each with the
+// position at which it occurs. If the document cannot be read or is not
+// well-formed XML, Validate returns the error from the decoder.
+func (s *Schema) Validate(d *xml.Decoder) error {
+	v := &validator{s: s}
+	for {
+		var pos position
+		pos.line, pos.col = d.InputPos()
+		pos.offset = d.InputOffset()
+		t, err := d.Token()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		switch t := t.(type) {
+		case xml.StartElement:
+			v.start(t, pos)
+		case xml.EndElement:
+			v.end(pos)
+		case xml.CharData:
+			v.text(t, pos)
+		}
+	}
+	if len(v.errs) > 0 {
+		return v.errs
+	}
+	return nil
+}
+
+// childType returns the type against which a child named name of the
+// element in frame f is validated, or nil if it is not validated.
+// It reports whether the child is allowed at all.
+func (v *validator) childType(f *frame, name xml.Name, pos position) (*typeDef, bool) {
+	switch {
+	case f.typ == nil:
+		return nil, true
+	case f.typ.anyType:
+		// Validate laxly: use a declaration if there is one.
+		if e := v.s.elements[name]; e != nil {
+			return e.typ, true
+		}
+		return anyTypeDef, true
+	case f.typ.simple != nil || f.typ.content == nil:
+		v.errorf(pos, "element <%s> is not allowed in <%s>, which has no element content", name.Local, f.name.Local)
+		return nil, false
+	}
+	if e := findElement(f.typ.content, name); e != nil {
+		return e.typ, true
+	}
+	if w := findWildcard(f.typ.content, name); w != nil {
+		if w.process == "skip" {
+			return nil, true
+		}
+		if e := v.s.elements[name]; e != nil {
+			return e.typ, true
+		}
+		if w.process == "strict" {
+			v.errorf(pos, "no declaration for element <%s>", name.Local)
+			return nil, true
+		}
+		return anyTypeDef, true
+	}
+	v.errorf(pos, "unexpected element <%s> in <%s>", name.Local, f.name.Local)
+	return nil, false
+}
+
+func findElement(p *particle, name xml.Name) *elementDecl {
+	if p.kind == partElement {
+		if p.elem.name == name {
+			return p.elem
+		}
+		return nil
+	}
+	for _, c := range p.children {
+		if e := findElement(c, name); e != nil {
+			return e
+		}
+	}
+	return nil
+}
+
+func findWildcard(p *particle, name xml.Name) *particle {
+	if p.kind == partAny {
+		if p.allows(name.Space) {
+			return p
+		}
+		return nil
+	}
+	for _, c := range p.children {
+		if w := findWildcard(c, name); w != nil {
+			return w
+		}
+	}
+	return nil
+}
+
+// allows reports whether the wildcard p matches elements in name space ns.
+func (p *particle) allows(ns string) bool {
+	for _, n := range p.namespaces {
+		switch n {
+		case "##any":
+			return true
+		case "##other":
+			if ns != p.targetNS && ns != "" {
+				return true
+			}
+		default:
+			if n == ns {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func (v *validator) start(t xml.StartElement, pos position) {
+	f := &frame{name: t.Name, pos: pos}
+	if len(v.stack) == 0 {
+		if e := v.s.elements[t.Name]; e != nil {
+			f.typ = e.typ
+			f.nilled = v.nilled(e, t, pos)
+		} else {
+			v.errorf(pos, "no declaration for root element <%s>", t.Name.Local)
+		}
+	} else {
+		parent := v.stack[len(v.stack)-1]
+		typ, ok := v.childType(parent, t.Name, pos)
+		if ok && parent.typ != nil {
+			parent.children = append(parent.children, t.Name)
+			parent.childPos = append(parent.childPos, pos)
+		}
+		f.typ = typ
+		if typ != nil && parent.typ != nil && !parent.typ.anyType {
+			if e := findElement(parent.typ.content, t.Name); e != nil {
+				f.nilled = v.nilled(e, t, pos)
+			}
+		}
+	}
+	if f.typ != nil && !f.typ.anyType {
+		v.attrs(f, t, pos)
+	}
+	v.stack = append(v.stack, f)
+}
+
+// nilled reports whether the element t, declared by e, has xsi:nil="true".
+func (v *validator) nilled(e *elementDecl, t xml.StartElement, pos position) bool {
+	for _, a := range t.Attr {
+		if a.Name.Space == instanceNamespace && a.Name.Local == "nil" {
+			if strings.TrimSpace(a.Value) != "true" && strings.TrimSpace(a.Value) != "1" {
+				return false
+			}
+			if !e.nillable {
+				v.errorf(pos, "element <%s> is not nillable", t.Name.Local)
+				return false
+			}
+			return true
+		}
+	}
+	return false
+}
+
+func (v *validator) attrs(f *frame, t xml.StartElement, pos position) {
+	seen := make(map[*attrDecl]bool)
+	for _, a := range t.Attr {
+		if a.Name.Space == "xmlns" || a.Name.Space == "" && a.Name.Local == "xmlns" || a.Name.Space == instanceNamespace {
+			continue
+		}
+		var decl *attrDecl
+		for _, d := range f.typ.attrs {
+			if d.name == a.Name {
+				decl = d
+				break
+			}
+		}
+		if decl == nil {
+			if !f.typ.anyAttr {
+				v.errorf(pos, "attribute %s is not allowed on <%s>", a.Name.Local, t.Name.Local)
+			}
+			continue
+		}
+		seen[decl] = true
+		if err := decl.typ.validate(a.Value); err != nil {
+			v.errorf(pos, "attribute %s of <%s>: %v", a.Name.Local, t.Name.Local, err)
+		} else if decl.fixed != nil && a.Value != *decl.fixed {
+			v.errorf(pos, "attribute %s of <%s> must have the value %q", a.Name.Local, t.Name.Local, *decl.fixed)
+		}
+	}
+	for _, d := range f.typ.attrs {
+		if d.required && !seen[d] {
+			v.errorf(pos, "element <%s> is missing required attribute %s", t.Name.Local, d.name.Local)
+		}
+	}
+}
+
+func (v *validator) text(t xml.CharData, pos position) {
+	if len(v.stack) == 0 {
+		return
+	}
+	f := v.stack[len(v.stack)-1]
+	switch {
+	case f.typ == nil || f.typ.anyType:
+	case f.typ.simple != nil || f.nilled:
+		f.text.Write(t)
+	case !f.typ.mixed && !f.badText && len(bytes.TrimSpace(t)) > 0:
+		f.badText = true
+		v.errorf(pos, "character data is not allowed in <%s>", f.name.Local)
+	}
+}
+
+func (v *validator) end(pos position) {
+	f := v.stack[len(v.stack)-1]
+	v.stack = v.stack[:len(v.stack)-1]
+	switch {
+	case f.typ == nil || f.typ.anyType:
+	case f.nilled:
+		if len(f.children) > 0 || f.text.Len() > 0 {
+			v.errorf(f.pos, "element <%s> is nil but has content", f.name.Local)
+		}
+	case f.typ.simple != nil:
+		if err := f.typ.simple.validate(f.text.String()); err != nil {
+			v.errorf(f.pos, "element <%s>: %v", f.name.Local, err)
+		}
+	case f.typ.content != nil:
+		m := &matcher{names: f.children, memo: make(map[matchKey][]int)}
+		for _, end := range m.match(f.typ.content, 0) {
+			if end == len(f.children) {
+				return
+			}
+		}
+		if m.furthest < len(f.children) {
+			v.errorf(f.childPos[m.furthest], "unexpected element <%s> in <%s>%s", f.children[m.furthest].Local, f.name.Local, m.expectation())
+		} else {
+			v.errorf(pos, "content of element <%s> is incomplete%s", f.name.Local, m.expectation())
+		}
+	}
+}
+
+// A matcher matches a sequence of child element names against a content
+// model. It computes the set of positions at which a match of a particle
+// can end, and remembers how far into the sequence any match got so that
+// a failure can be reported at the offending element.
+type matcher struct {
+	names    []xml.Name
+	memo     map[matchKey][]int
+	furthest int
+	expected []string
+}
+
+type matchKey struct {
+	p   *particle
+	pos int
+}
+
+// note records an attempt to match the term described by what at pos.
+func (m *matcher) note(pos int, ok bool, what string) {
+	if ok {
+		pos++
+	}
+	if pos > m.furthest {
+		m.furthest = pos
+		m.expected = nil
+	}
+	if !ok && pos == m.furthest {
+		for _, e := range m.expected {
+			if e == what {
+				return
+			}
+		}
+		m.expected = append(m.expected, what)
+	}
+}
+
+func (m *matcher) expectation() string {
+	if len(m.expected) == 0 {
+		return ""
+	}
+	return "; expected " + strings.Join(m.expected, " or ")
+}
+
+// union adds the positions in b missing from a.
+func union(a, b []int) []int {
+outer:
+	for _, x := range b {
+		for _, y := range a {
+			if x == y {
+				continue outer
+			}
+		}
+		a = append(a, x)
+	}
+	return a
+}
+
+// match returns the positions at which a match of p, including its
+// repetitions, that starts at pos can end.
+func (m *matcher) match(p *particle, pos int) []int {
+	key := matchKey{p, pos}
+	if ends, ok := m.memo[key]; ok {
+		return ends
+	}
+	var ends []int
+	if p.min == 0 {
+		ends = append(ends, pos)
+	}
+	seen := make(map[int]bool)
+	cur := []int{pos}
+	for i := 1; p.max < 0 || i <= p.max; i++ {
+		var next []int
+		for _, c := range cur {
+			next = union(next, m.once(p, c))
+		}
+		if i > p.min {
+			// Past the minimum, only new positions can lead further.
+			n := 0
+			for _, x := range next {
+				if !seen[x] {
+					next[n] = x
+					n++
+				}
+			}
+			next = next[:n]
+		}
+		if len(next) == 0 {
+			break
+		}
+		if i >= p.min {
+			ends = union(ends, next)
+			for _, x := range next {
+				seen[x] = true
+			}
+		}
+		cur = next
+	}
+	m.memo[key] = ends
+	return ends
+}
+
+// once returns the positions at which a single occurrence of p
+// that starts at pos can end.
+func (m *matcher) once(p *particle, pos int) []int {
+	switch p.kind {
+	case partElement:
+		ok := pos < len(m.names) && m.names[pos] == p.elem.name
+		m.note(pos, ok, "<"+p.elem.name.Local+">")
+		if ok {
+			return []int{pos + 1}
+		}
+	case partAny:
+		ok := pos < len(m.names) && p.allows(m.names[pos].Space)
+		m.note(pos, ok, "any element")
+		if ok {
+			return []int{pos + 1}
+		}
+	case partSequence:
+		cur := []int{pos}
+		for _, c := range p.children {
+			var next []int
+			for _, x := range cur {
+				next = union(next, m.match(c, x))
+			}
+			if len(next) == 0 {
+				return nil
+			}
+			cur = next
+		}
+		return cur
+	case partChoice:
+		var ends []int
+		for _, c := range p.children {
+			ends = union(ends, m.match(c, pos))
+		}
+		return ends
+	case partAll:
+		return m.all(p, pos, make([]bool, len(p.children)))
+	}
+	return nil
+}
+
+// all matches the members of an all group not yet used, in any order.
+func (m *matcher) all(p *particle, pos int, used []bool) []int {
+	var ends []int
+	complete := true
+	for i, c := range p.children {
+		if used[i] {
+			continue
+		}
+		if c.min > 0 {
+			complete = false
+		}
+		for _, end := range m.once(c, pos) {
+			used[i] = true
+			ends = union(ends, m.all(p, end, used))
+			used[i] = false
+		}
+	}
+	if complete {
+		ends = union(ends, []int{pos})
+	}
+	return ends
+}
@@ -179,3 +179,42 @@ func Checksum(data []byte, tab *Table) uint32 { return Update(0, tab, data) }
 // ChecksumIEEE returns the CRC-32 checksum of data
 // using the IEEE polynomial.
 func ChecksumIEEE(data []byte) uint32 { return Update(0, IEEETable, data) }
+
+// Combine returns the CRC-32 checksum of the concatenation of two byte
+// sequences, given the checksum crc1 of the first, the checksum crc2 and
+// length len2 of the second, both computed using the polynomial
+// represented by the Table. It allows the checksums of the pieces of some
+// data to be computed independently, for example in parallel.
+func Combine(tab *Table, crc1, crc2 uint32, len2 int64) uint32 {
+	// Appending len2 bytes multiplies the checksum of the first sequence
+	// by x^(8*len2) modulo the polynomial, which is computed by repeated
+	// squaring of x^8. The polynomial is recovered from the table, as
+	// the entry for 0x80 is the polynomial itself.
+	poly := tab[0x80]
+	p := uint32(1 << 31)  // x^0
+	sq := uint32(1 << 23) // x^8
+	for n := uint64(len2); n != 0; n >>= 1 {
+		if n&1 != 0 {
+			p = multModP(poly, sq, p)
+		}
+		sq = multModP(poly, sq, sq)
+	}
+	return multModP(poly, p, crc1) ^ crc2
+}
+
+// multModP returns a*b modulo poly, where all three are polynomials over
+// GF(2) in the reversed representation.
+func multModP(poly, a, b uint32) uint32 {
+	p := uint32(0)
+	for m := uint32(1 << 31); m != 0; m >>= 1 {
+		if a&m != 0 {
+			p ^= b
+		}
+		if b&1 != 0 {
+			b = b>>1 ^ poly
+		} else {
+			b >>= 1
+		}
+	}
+	return p
+}